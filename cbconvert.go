@@ -1,71 +1,199 @@
 package cbconvert
 
 import (
+	"archive/zip"
 	"bytes"
 	"context"
 	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"image"
-	_ "image/gif"
 	"image/png"
+	"io"
+	"log/slog"
 	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 
 	pngstructure "github.com/dsoprea/go-png-image-structure"
 	"github.com/dustin/go-humanize"
 )
 
+// ErrTimeout is returned if conversion of a page or file exceeds Options.PageTimeout or Options.FileTimeout.
+var ErrTimeout = errors.New("timeout")
+
 // Options type.
 type Options struct {
-	// Image format, valid values are jpeg, png, tiff, bmp, webp, avif, jxl
+	// Image format, valid values are jpeg, png, tiff, bmp, webp, avif, jxl, auto (png for flat colors, webp otherwise)
 	Format string
-	// Archive format, valid values are zip, tar
+	// Fallback image formats tried in order if encoding of a page fails, i.e. webp,jpeg, the conversion fails if empty
+	Fallback string
+	// Archive format, valid values are zip, tar, tar.gz, tar.zst, tar.xz, 7z (requires 7z command), mobi, epub (fixed-layout
+	// EPUB3), none (pages are written to directory named after the input)
 	Archive string
+	// Right-to-left reading direction of the EPUB output (manga), also set by ComicInfo.xml Manga YesAndRightToLeft
+	RightToLeft bool
 	// JPEG image quality
 	Quality int
+	// Adaptive quality, quality of each page is chosen between QualityMin and QualityMax by the page complexity (detail)
+	AdaptiveQuality bool
+	// Minimum quality for adaptive quality, used for flat pages
+	QualityMin int
+	// Maximum quality for adaptive quality, used for detailed pages
+	QualityMax int
+	// Target average page size (in KB), quality is chosen per book from sampled pages before conversion (two-pass), 0 disables
+	TargetSize int
 	// Image width
 	Width int
 	// Image height
 	Height int
 	// Best fit for required width and height
 	Fit bool
-	// 0=NearestNeighbor, 1=Box, 2=Linear, 3=MitchellNetravali, 4=CatmullRom, 6=Gaussian, 7=Lanczos
+	// Downscale pages wider than the median page width of the file, spreads to twice the median, Width is the maximum
+	AutoWidth bool
+	// 0=NearestNeighbor, 1=Box, 2=Linear, 3=MitchellNetravali, 4=CatmullRom, 5=Gaussian, 6=Lanczos
 	Filter int
+	// Resize implementation, valid values are bild and draw (golang.org/x/image/draw separable kernels)
+	Resizer string
 	// Do not convert the cover image
 	NoCover bool
 	// Do not convert images that have RGB colorspace
 	NoRGB bool
-	// Remove non-image files from the archive
+	// Remove non-image files from the archive, ComicInfo.xml is kept unless NoComicInfo is set
 	NoNonImage bool
+	// Remove ComicInfo.xml from the archive, by default it is kept and its page table is updated for the converted pages
+	NoComicInfo bool
+	// Reproducible output, timestamps and permissions are fixed so the same input gives identical archive
+	Reproducible bool
+	// Normalize ownership, permissions and modification times in TAR headers
+	TarNormalize bool
+	// Write TAR headers in PAX format (long and UTF-8 names)
+	TarPAX bool
+	// ZIP compression rules per extension, i.e. "jpg=store,xml=deflate,*=deflate", or store/deflate for all entries,
+	// images are stored by default
+	Compression string
+	// ZIP deflate level, 1 (fastest) to 9 (best), 0 is the default level (6)
+	ZipLevel int
 	// Do not transform or convert images
 	NoConvert bool
+	// Write ComicInfo.xml with the page table and chapter bookmarks, existing file is updated
+	ComicInfo bool
+	// Write ComicInfo.xml with Series, Number, Volume and Year parsed from the input file name, i.e. "Series v02 #012 (2019)",
+	// fields of the existing file are set only if they are empty
+	ComicInfoName bool
+	// Fetch ComicInfo.xml fields from ComicVine by Series, Number and Year of the existing file or parsed from the input
+	// file name, requires ComicVineKey
+	Fetch bool
+	// ComicVine API key, used with Fetch
+	ComicVineKey string
 	// Add suffix to file baseNoExt
 	Suffix string
 	// Extract cover
 	Cover bool
 	// Extract cover thumbnail (freedesktop spec.)
 	Thumbnail bool
+	// Sharpen thumbnail and raise contrast, for low resolution covers, the converted pages are not affected
+	ThumbnailEnhance bool
+	// Document page used as cover, valid values are page number (starting from 1) and auto (first page that is not blank), first page if empty
+	CoverPage string
+	// Compose covers of all inputs into a montage image (poster wall), written to OutFile or montage.<ext> in OutDir
+	Montage bool
+	// Number of montage columns, square grid if zero
+	MontageColumns int
+	// Montage cell width, covers are fitted into the cell
+	MontageCellWidth int
+	// Montage cell height, 1.5 times the cell width if zero
+	MontageCellHeight int
+	// Draw file names under the montage covers
+	MontageLabels bool
 	// CBZ metadata
 	Meta bool
+	// Archive/document information
+	Info bool
+	// Estimate output size
+	Estimate bool
 	// Version
 	Version bool
 	// ZIP comment
 	Comment bool
 	// ZIP comment body
 	CommentBody string
+	// Record the options in the converted archive (cbconvert.json), they can be read back with ReadOptions
+	EmbedOptions bool
+	// ZIP comment template for converted files, placeholders {version}, {input}, {date} and {hash} are replaced
+	CommentTemplate string
 	// Add file
 	FileAdd string
 	// Remove file
 	FileRemove string
+	// Extract file, the entry is returned by Meta
+	FileGet string
+	// Extract files matching the glob pattern to the output directory
+	FileGetAll string
+	// Validate ComicInfo.xml against the known schema versions, unknown elements and invalid values are returned by Meta
+	Validate bool
+	// Fix common ComicInfo.xml problems found by Validate (date formats, Manga and BlackAndWhite values)
+	Fix bool
+	// Strip folder prefixes and lowercase extensions of the entry names, data is copied without re-encoding
+	NormalizeNames bool
+	// Rename images to numbers in natural order, i.e. 000.jpg, data is copied without re-encoding
+	Renumber bool
+	// Set modification time of the entries, valid values are RFC3339 time, now and reproducible (1980-01-01)
+	EntryTime string
+	// Directory with cover images named after the series and issue number (i.e. SeriesName - 012.jpg), covers of the
+	// matching archives are replaced
+	CoverDir string
+	// Insert the matching cover from CoverDir as the first page instead of replacing the cover
+	CoverInsert bool
+	// Image added as the first page (cover) of archive, named so that natural sort places it first
+	CoverSet string
+	// Set ComicInfo.xml Title, the file is created if there is none
+	SetTitle string
+	// Set ComicInfo.xml Series, the file is created if there is none
+	SetSeries string
+	// Set ComicInfo.xml Number, the file is created if there is none
+	SetNumber string
+	// Set ComicInfo.xml Writer, the file is created if there is none
+	SetWriter string
 	// Output file
 	OutFile string
+	// Append converted pages to the existing CBZ archive instead of writing new archive, pages are numbered after
+	// the existing pages
+	AppendTo string
 	// Output directory
 	OutDir string
+	// Existing output policy, valid values are overwrite, skip (input is not converted), rename (number is appended to the name)
+	Overwrite string
+	// Limit write rate to the output directory (in MB/s), i.e. on network shares, 0 disables
+	WriteLimit float64
 	// Convert images to grayscale (monochromatic)
 	Grayscale bool
-	// Rotate images, valid values are 0, 90, 180, 270
+	// Convert images to grayscale only if mean saturation (in percent) is below the threshold, 0 disables
+	GrayscaleAuto int
+	// Gray levels of grayscale pages, valid values are 8 (256 levels) and 4 (16 levels with dithering, 4-bit PNG as
+	// Kindle e-ink displays), 0 is 8
+	GrayDepth int
+	// Gamma correction, values above 1 darken midtones (1.8 for Kindle e-ink, as KCC), 0 disables
+	Gamma float64
+	// Alpha policy, valid values are preserve (flatten only for formats without alpha) and flatten
+	Alpha string
+	// Background color for flattened transparent images, i.e. ffffff
+	Background string
+	// Image metadata policy, valid values are strip, orientation (apply EXIF orientation), keep (also copy EXIF/XMP to JPEG, PNG and WEBP)
+	Metadata string
+	// Salvage damaged JPEG images, incomplete scans are dropped or the part that can not be decoded is filled with gray
+	Salvage bool
+	// Animated GIF/WEBP pages policy, valid values are first (first frame), keep (copy unmodified), expand (each frame as a page)
+	Animation string
+	// Split pages taller than height into tiles (in pixels), pages that exceed the format limit (16383 for WEBP,
+	// 65535 for JPEG) are always split, 0 splits only those
+	TileHeight int
+	// Rotate images, valid values are 0, 90, 180, 270. EPUB output records orientation of the pages rotated by 90 or 270
 	Rotate int
 	// Adjust the brightness of the images, must be in the range (-100, 100)
 	Brightness int
@@ -75,32 +203,70 @@ type Options struct {
 	Recursive bool
 	// Process only files larger than size (in MB)
 	Size int
-	// Hide console output
-	Quiet bool
+	// Password of the protected input archives, AES encrypted ZIP and RAR archives require 7z, unrar or unar command
+	Password string
+	// Read local archives through memory-mapped files (64-bit Unix only, regular reads are used elsewhere)
+	Mmap bool
+	// Log level, messages are forwarded to Converter.Logger
+	LogLevel LogLevel
+	// Timeout for decoding and converting a page, conversion of the file fails with ErrTimeout, 0 disables
+	PageTimeout time.Duration
+	// Timeout for converting a file, conversion fails with ErrTimeout, 0 disables
+	FileTimeout time.Duration
+	// Number of concurrent image conversions, number of CPUs + 1 if zero
+	Workers int
+	// Maximum number of archive entries, 0 disables
+	MaxEntries int
+	// Maximum uncompressed size of archive entry (in MB), 0 disables
+	MaxEntrySize int
+	// Maximum directory depth of archive entry names, 0 disables
+	MaxDepth int
+	// Maximum number of pixels of rendered document page (in megapixels), 0 disables
+	MaxPagePixels int
+	// Keep the workdir of canceled archive or document conversion, the next run of the same input with the same options
+	// resumes after the converted pages
+	Checkpoint bool
+	// Command executed for each output file, placeholders {input}, {output}, {name} and {dir} are replaced
+	PostCmd string
+	// Delete the input after successful conversion, it is moved to the trash (XDG Trash, Recycle Bin) unless Permanent is set
+	DeleteOriginal bool
+	// Delete the input permanently instead of moving it to the trash
+	Permanent bool
+	// Number of randomly selected pages written as before/after pairs for review
+	Sample int
+	// Review directory for sampled pages, a subdirectory is created per file, output directory is used if empty
+	SampleDir string
+	// Sidecar JSON file with page descriptions (alt text), placeholders {name} and {dir} of the input are replaced
+	AltText string
 }
 
 // Converter type.
 type Converter struct {
 	// Options struct
 	Opts Options
-	// Current working directory
-	Workdir string
-	// Number of files
+	// Number of files, set by Files
 	Nfiles int
-	// Index of current file
-	CurrFile int
-	// Number of contents in archive/document
-	Ncontents int
-	// Index of current content
-	CurrContent int32
-	// Start function
-	OnStart func()
+	// Start function, called when conversion of the job's file starts
+	OnStart func(job *Job)
 	// Progress function
-	OnProgress func()
+	OnProgress func(job *Job)
 	// Compress function
-	OnCompress func()
-	// Cancel function
+	OnCompress func(job *Job)
+	// Copy function, reports copied bytes when output is copied to another filesystem
+	OnCopy func(job *Job, copied, total int64)
+	// Cancel function, called by Cancel
 	OnCancel func()
+	// Alt text function, returns description for the page, overrides the sidecar file
+	OnAltText func(index int, name string) string
+	// Less function, sorts entries in the saved archive, natural order is used if nil
+	Less func(a, b string) bool
+	// Logger, messages are filtered with Options.LogLevel
+	Logger *slog.Logger
+
+	// Current job, see begin
+	job *Job
+	// State shared by the jobs
+	shared *shared
 }
 
 // File type.
@@ -119,27 +285,136 @@ type Image struct {
 	SizeHuman string
 }
 
+// PageInfo type.
+type PageInfo struct {
+	// Entry name, or page index for documents
+	Name string
+	// Size in bytes, zero for documents
+	Size int64
+	// Image width, only if dimensions are requested
+	Width int
+	// Image height, only if dimensions are requested
+	Height int
+}
+
+// Estimate type, projected output size.
+type Estimate struct {
+	// Number of pages
+	Pages int
+	// Number of sampled pages
+	Samples int
+	// Input size in bytes
+	InputSize int64
+	// Projected output size in bytes
+	OutputSize int64
+}
+
+// Result type, result of the conversion.
+type Result struct {
+	// Output file or directory, empty if the conversion was skipped
+	Output string
+	// Working directory, removed when Convert returns unless it is kept for checkpoint, useful only for debugging
+	Workdir string
+}
+
+// PageSource type, page supplied by the caller, either as encoded image data or as decoded image.
+type PageSource struct {
+	// Entry name, if empty, the page index is used
+	Name string
+	// Encoded image, or any other file (i.e. ComicInfo.xml)
+	Reader io.Reader
+	// Decoded image, takes precedence over Reader
+	Image image.Image
+}
+
 // NewOptions returns default options.
 func NewOptions() Options {
 	o := Options{}
 	o.Format = "jpeg"
 	o.Archive = "zip"
 	o.Quality = 75
+	o.QualityMin = 50
+	o.QualityMax = 90
 	o.Filter = 2
+	o.Resizer = "bild"
+	o.Alpha = "preserve"
+	o.Background = "ffffff"
+	o.Animation = "first"
+	o.Metadata = "strip"
+	o.Overwrite = "overwrite"
+	o.MaxEntries = 10000
+	o.MaxEntrySize = 512
+	o.MaxDepth = 32
+	o.MaxPagePixels = 100
+	o.LogLevel = LogNormal
+
+	return o
+}
+
+// runtime copies options that do not affect the converted output from src.
+// New options must be added here or to the output options in TestOptionsRuntime.
+func (o *Options) runtime(src Options) {
+	o.Cover, o.Thumbnail, o.Meta, o.Info, o.Estimate, o.Version = src.Cover, src.Thumbnail, src.Meta, src.Info, src.Estimate, src.Version
+	o.CoverPage, o.ThumbnailEnhance = src.CoverPage, src.ThumbnailEnhance
+	o.Montage, o.MontageColumns, o.MontageCellWidth, o.MontageCellHeight, o.MontageLabels = src.Montage, src.MontageColumns, src.MontageCellWidth, src.MontageCellHeight, src.MontageLabels
+	o.Comment, o.CommentBody, o.CommentTemplate = src.Comment, src.CommentBody, src.CommentTemplate
+	o.FileAdd, o.FileRemove, o.OutFile, o.OutDir = src.FileAdd, src.FileRemove, src.OutFile, src.OutDir
+	o.FileGet, o.FileGetAll, o.Validate, o.Fix = src.FileGet, src.FileGetAll, src.Validate, src.Fix
+	o.NormalizeNames, o.Renumber, o.EntryTime = src.NormalizeNames, src.Renumber, src.EntryTime
+	o.CoverDir, o.CoverInsert, o.CoverSet = src.CoverDir, src.CoverInsert, src.CoverSet
+	o.SetTitle, o.SetSeries, o.SetNumber, o.SetWriter = src.SetTitle, src.SetSeries, src.SetNumber, src.SetWriter
+	o.Recursive, o.Size, o.LogLevel, o.PostCmd = src.Recursive, src.Size, src.LogLevel, src.PostCmd
+	o.Sample, o.SampleDir, o.Workers, o.Mmap = src.Sample, src.SampleDir, src.Workers, src.Mmap
+	o.Password, o.AppendTo, o.ComicVineKey = src.Password, src.AppendTo, src.ComicVineKey
+	o.PageTimeout, o.FileTimeout = src.PageTimeout, src.FileTimeout
+	o.DeleteOriginal, o.Permanent, o.Overwrite = src.DeleteOriginal, src.Permanent, src.Overwrite
+	o.Checkpoint, o.WriteLimit = src.Checkpoint, src.WriteLimit
+	o.MaxEntries, o.MaxEntrySize, o.MaxDepth, o.MaxPagePixels = src.MaxEntries, src.MaxEntrySize, src.MaxDepth, src.MaxPagePixels
+}
+
+// output returns copy of the options with only the fields that affect the converted output.
+func (o Options) output() Options {
+	o.runtime(Options{})
 
 	return o
 }
 
+// Preset returns options that affect the converted output taken from preset (i.e. read with ReadOptions),
+// other options are kept.
+func (o Options) Preset(preset Options) Options {
+	preset.runtime(o)
+
+	return preset
+}
+
+// Hash returns hash of the options that affect the converted output.
+func (o Options) Hash() string {
+	data, _ := json.Marshal(o.output())
+	sum := sha256.Sum256(data)
+
+	return hex.EncodeToString(sum[:8])
+}
+
 // New returns new converter.
 func New(o Options) *Converter {
 	c := &Converter{}
 	c.Opts = o
+	c.shared = &shared{}
+	c.job = &Job{rate: &c.shared.rate}
 
 	return c
 }
 
-// Cancel cancels the operation.
+// Cancel cancels the running jobs, OnCancel is called.
 func (c *Converter) Cancel() {
+	c.shared.mu.Lock()
+	for _, cancel := range c.shared.jobs {
+		if cancel != nil {
+			cancel()
+		}
+	}
+	c.shared.mu.Unlock()
+
 	if c.OnCancel != nil {
 		c.OnCancel()
 	}
@@ -205,7 +480,8 @@ func (c *Converter) Files(args []string) ([]File, error) {
 		}
 
 		if !stat.IsDir() {
-			if isArchive(path) || isDocument(path) {
+			// single image is converted as one page archive
+			if isArchive(path) || isDocument(path) || isImage(path) {
 				if isSize(int64(c.Opts.Size), stat.Size()) {
 					files = append(files, toFile(path, stat))
 				}
@@ -254,32 +530,62 @@ func (c *Converter) Files(args []string) ([]File, error) {
 
 // Cover extracts cover.
 func (c *Converter) Cover(fileName string, fileInfo os.FileInfo) error {
-	c.CurrFile++
+	c = c.begin(fileName, true)
+	defer c.end()
+
+	cover, err := c.coverImage(fileName, fileInfo)
+	if err != nil {
+		return fmt.Errorf("%s: %w", fileName, err)
+	}
+
+	if err := c.coverSave(fileName, cover); err != nil {
+		return fmt.Errorf("%s: %w", fileName, err)
+	}
+
+	return nil
+}
+
+// CoverThumbnail extracts cover and thumbnail, the cover is decoded once. The thumbnail has the freedesktop
+// large size (256 pixels) and the cover is resized only if width or height is set.
+func (c *Converter) CoverThumbnail(fileName string, fileInfo os.FileInfo) error {
+	c = c.begin(fileName, true)
+	defer c.end()
 
 	cover, err := c.coverImage(fileName, fileInfo)
 	if err != nil {
 		return fmt.Errorf("%s: %w", fileName, err)
 	}
 
+	if err := c.coverSave(fileName, cover); err != nil {
+		return fmt.Errorf("%s: %w", fileName, err)
+	}
+
+	if err := c.thumbnailSave(fileName, fileInfo, resize(cover, 256, 0, c.resizer(c.Opts.Filter))); err != nil {
+		return fmt.Errorf("%s: %w", fileName, err)
+	}
+
+	return nil
+}
+
+// coverSave resizes and encodes cover to the output directory.
+func (c *Converter) coverSave(fileName string, cover image.Image) error {
 	if c.Opts.Width > 0 || c.Opts.Height > 0 {
 		if c.Opts.Fit {
-			cover = fit(cover, c.Opts.Width, c.Opts.Height, filters[c.Opts.Filter])
+			cover = fit(cover, c.Opts.Width, c.Opts.Height, c.resizer(c.Opts.Filter))
 		} else {
-			cover = resize(cover, c.Opts.Width, c.Opts.Height, filters[c.Opts.Filter])
+			cover = resize(cover, c.Opts.Width, c.Opts.Height, c.resizer(c.Opts.Filter))
 		}
 	}
 
-	ext := c.Opts.Format
-	if ext == "jpeg" {
-		ext = "jpg"
-	}
+	format := c.imageFormat(cover)
+	ext := formatExt(format)
 
 	var fName string
 	if c.Opts.Recursive {
 		fDir := strings.Split(filepath.Dir(fileName), string(os.PathSeparator))[1:]
 		err := os.MkdirAll(filepath.Join(c.Opts.OutDir, filepath.Join(fDir...)), 0755)
 		if err != nil {
-			return fmt.Errorf("%s: %w", fileName, err)
+			return fmt.Errorf("coverSave: %w", err)
 		}
 
 		fName = filepath.Join(c.Opts.OutDir, filepath.Join(fDir...), fmt.Sprintf("%s.%s", baseNoExt(fileName), ext))
@@ -289,20 +595,65 @@ func (c *Converter) Cover(fileName string, fileInfo os.FileInfo) error {
 
 	w, err := os.Create(fName)
 	if err != nil {
-		return fmt.Errorf("imageConvert: %w", err)
+		return fmt.Errorf("coverSave: %w", err)
 	}
 	defer w.Close()
 
-	if err := c.imageEncode(cover, w); err != nil {
-		return fmt.Errorf("%s: %w", fileName, err)
+	if err := c.imageEncodeFormat(cover, format, w); err != nil {
+		return fmt.Errorf("coverSave: %w", err)
 	}
 
 	return nil
 }
 
+// CoverImage returns cover as image.Image.
+func (c *Converter) CoverImage(fileName string, fileInfo os.FileInfo) (image.Image, error) {
+	c = c.begin(fileName, false)
+	defer c.end()
+
+	cover, err := c.coverImage(fileName, fileInfo)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", fileName, err)
+	}
+
+	return cover, nil
+}
+
+// CoverData returns raw cover image data. Images from archives and directories are returned unmodified,
+// pages from documents are encoded with the configured image format.
+func (c *Converter) CoverData(fileName string, fileInfo os.FileInfo) ([]byte, error) {
+	c = c.begin(fileName, false)
+	defer c.end()
+
+	var err error
+	var data []byte
+
+	switch {
+	case fileInfo.IsDir(), isImage(fileName):
+		data, err = c.coverDirectoryData(fileName)
+	case isDocument(fileName):
+		var cover image.Image
+		cover, err = c.coverDocument(fileName)
+		if err == nil {
+			var w bytes.Buffer
+			err = c.imageEncode(cover, &w)
+			data = w.Bytes()
+		}
+	case isArchive(fileName):
+		data, err = c.coverArchiveData(fileName)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", fileName, err)
+	}
+
+	return data, nil
+}
+
 // Thumbnail extracts thumbnail.
 func (c *Converter) Thumbnail(fileName string, fileInfo os.FileInfo) error {
-	c.CurrFile++
+	c = c.begin(fileName, true)
+	defer c.end()
 
 	cover, err := c.coverImage(fileName, fileInfo)
 	if err != nil {
@@ -311,29 +662,42 @@ func (c *Converter) Thumbnail(fileName string, fileInfo os.FileInfo) error {
 
 	if c.Opts.Width > 0 || c.Opts.Height > 0 {
 		if c.Opts.Fit {
-			cover = fit(cover, c.Opts.Width, c.Opts.Height, filters[c.Opts.Filter])
+			cover = fit(cover, c.Opts.Width, c.Opts.Height, c.resizer(c.Opts.Filter))
 		} else {
-			cover = resize(cover, c.Opts.Width, c.Opts.Height, filters[c.Opts.Filter])
+			cover = resize(cover, c.Opts.Width, c.Opts.Height, c.resizer(c.Opts.Filter))
 		}
 	} else {
-		cover = resize(cover, 256, 0, filters[c.Opts.Filter])
+		cover = resize(cover, 256, 0, c.resizer(c.Opts.Filter))
+	}
+
+	if err := c.thumbnailSave(fileName, fileInfo, cover); err != nil {
+		return fmt.Errorf("%s: %w", fileName, err)
+	}
+
+	return nil
+}
+
+// thumbnailSave encodes thumbnail to PNG with freedesktop attributes, file name is MD5 of the URI or Options.OutFile.
+func (c *Converter) thumbnailSave(fileName string, fileInfo os.FileInfo, cover image.Image) error {
+	if c.Opts.ThumbnailEnhance {
+		cover = enhance(cover)
 	}
 
 	var buf bytes.Buffer
-	err = png.Encode(&buf, cover)
+	err := png.Encode(&buf, cover)
 	if err != nil {
-		return fmt.Errorf("%s: %w", fileName, err)
+		return fmt.Errorf("thumbnailSave: %w", err)
 	}
 
 	pmp := pngstructure.NewPngMediaParser()
 	csTmp, err := pmp.ParseBytes(buf.Bytes())
 	if err != nil {
-		return fmt.Errorf("%s: %w", fileName, err)
+		return fmt.Errorf("thumbnailSave: %w", err)
 	}
 
 	cs, ok := csTmp.(*pngstructure.ChunkSlice)
 	if !ok {
-		return fmt.Errorf("%s: type is not ChunkSlice", fileName)
+		return fmt.Errorf("thumbnailSave: type is not ChunkSlice")
 	}
 
 	var fName string
@@ -346,7 +710,7 @@ func (c *Converter) Thumbnail(fileName string, fileInfo os.FileInfo) error {
 			fDir := strings.Split(filepath.Dir(fileName), string(os.PathSeparator))[1:]
 			err := os.MkdirAll(filepath.Join(c.Opts.OutDir, filepath.Join(fDir...)), 0755)
 			if err != nil {
-				return fmt.Errorf("%s: %w", fileName, err)
+				return fmt.Errorf("thumbnailSave: %w", err)
 			}
 
 			fName = filepath.Join(c.Opts.OutDir, filepath.Join(fDir...), fmt.Sprintf("%x.png", md5.Sum([]byte(fURI))))
@@ -381,22 +745,24 @@ func (c *Converter) Thumbnail(fileName string, fileInfo os.FileInfo) error {
 		)...,
 	)
 
+	// chunks reference the encoded data, the thumbnail is written to a new buffer
+	var out bytes.Buffer
 	cs = pngstructure.NewChunkSlice(chunks)
-	err = cs.WriteTo(&buf)
+	err = cs.WriteTo(&out)
 	if err != nil {
-		return fmt.Errorf("%s: %w", fileName, err)
+		return fmt.Errorf("thumbnailSave: %w", err)
 	}
 
 	f, err := os.Create(fName)
 	if err != nil {
-		return fmt.Errorf("%s: %w", fileName, err)
+		return fmt.Errorf("thumbnailSave: %w", err)
 	}
 
 	defer f.Close()
 
-	_, err = buf.WriteTo(f)
+	_, err = out.WriteTo(f)
 	if err != nil {
-		return fmt.Errorf("%s: %w", fileName, err)
+		return fmt.Errorf("thumbnailSave: %w", err)
 	}
 
 	return nil
@@ -404,7 +770,8 @@ func (c *Converter) Thumbnail(fileName string, fileInfo os.FileInfo) error {
 
 // Meta manipulates with CBZ metadata.
 func (c *Converter) Meta(fileName string) (any, error) {
-	c.CurrFile++
+	c = c.begin(fileName, true)
+	defer c.end()
 
 	switch {
 	case c.Opts.Cover:
@@ -444,13 +811,197 @@ func (c *Converter) Meta(fileName string) (any, error) {
 		if err != nil {
 			return nil, fmt.Errorf("%s: %w", fileName, err)
 		}
+	case c.Opts.FileGet != "":
+		data, err := c.archiveFileGet(fileName, c.Opts.FileGet)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", fileName, err)
+		}
+
+		return data, nil
+	case c.Opts.FileGetAll != "":
+		files, err := c.archiveFileGetAll(fileName, c.Opts.FileGetAll, c.Opts.OutDir)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", fileName, err)
+		}
+
+		return files, nil
+	case c.Opts.Validate:
+		lines, err := c.archiveValidate(fileName)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", fileName, err)
+		}
+
+		return lines, nil
+	case c.Opts.NormalizeNames || c.Opts.Renumber || c.Opts.EntryTime != "":
+		changes, err := c.archiveNormalize(fileName)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", fileName, err)
+		}
+
+		return changes, nil
+	case c.Opts.CoverDir != "":
+		lines, err := c.archiveCoverSet(fileName)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", fileName, err)
+		}
+
+		return lines, nil
+	case c.Opts.CoverSet != "":
+		lines, err := c.archiveCoverSetFile(fileName)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", fileName, err)
+		}
+
+		return lines, nil
+	case c.Opts.Fetch:
+		lines, err := c.archiveComicInfoFetch(fileName)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", fileName, err)
+		}
+
+		return lines, nil
+	case c.Opts.SetTitle != "" || c.Opts.SetSeries != "" || c.Opts.SetNumber != "" || c.Opts.SetWriter != "":
+		err := c.archiveComicInfoSet(fileName)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", fileName, err)
+		}
 	}
 
 	return "", nil
 }
 
+// PageCount returns number of pages in archive, document or directory.
+func (c *Converter) PageCount(fileName string, fileInfo os.FileInfo) (int, error) {
+	switch {
+	case fileInfo.IsDir(), isImage(fileName):
+		contents, err := imagesFromPath(fileName)
+		if err != nil {
+			return 0, fmt.Errorf("%s: %w", fileName, err)
+		}
+
+		return len(imagesFromSlice(contents)), nil
+	case isDocument(fileName):
+		doc, err := openDocument(fileName)
+		if err != nil {
+			return 0, fmt.Errorf("%s: %w", fileName, err)
+		}
+		defer doc.Close()
+
+		return doc.NumPage(), nil
+	case isArchive(fileName):
+		contents, err := c.archiveList(fileName)
+		if err != nil {
+			return 0, fmt.Errorf("%s: %w", fileName, err)
+		}
+
+		return len(imagesFromSlice(contents)), nil
+	}
+
+	return 0, nil
+}
+
+// ListPages returns pages in archive, document or directory, sorted in natural order.
+// If dimensions is true, image headers are decoded to get the width and height.
+func (c *Converter) ListPages(fileName string, fileInfo os.FileInfo, dimensions bool) ([]PageInfo, error) {
+	var err error
+	var pages []PageInfo
+
+	switch {
+	case fileInfo.IsDir(), isImage(fileName):
+		pages, err = c.pagesDirectory(fileName, dimensions)
+	case isDocument(fileName):
+		pages, err = c.pagesDocument(fileName, dimensions)
+	case isArchive(fileName):
+		pages, err = c.pagesArchive(fileName, dimensions)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", fileName, err)
+	}
+
+	return pages, nil
+}
+
+// Estimate encodes samples pages, evenly spaced, with current options and projects the output size.
+// All pages are encoded if samples is zero.
+func (c *Converter) Estimate(fileName string, fileInfo os.FileInfo, samples int) (Estimate, error) {
+	c = c.begin(fileName, false)
+	defer c.end()
+
+	var est Estimate
+
+	pages, err := c.ListPages(fileName, fileInfo, false)
+	if err != nil {
+		return est, err
+	}
+
+	est.Pages = len(pages)
+	est.InputSize = fileInfo.Size()
+	if fileInfo.IsDir() {
+		est.InputSize = 0
+		for _, page := range pages {
+			est.InputSize += page.Size
+		}
+	}
+
+	if len(pages) == 0 {
+		return est, nil
+	}
+
+	sampled := samplePages(pages, samples)
+	sizes := make([]int64, 0, len(sampled))
+
+	if c.Opts.NoConvert {
+		for _, page := range sampled {
+			sizes = append(sizes, page.Size)
+		}
+	} else {
+		if c.Opts.TargetSize > 0 {
+			quality, err := c.targetQuality(fileName, fileInfo)
+			if err != nil {
+				return est, fmt.Errorf("%s: %w", fileName, err)
+			}
+
+			c.Opts.Quality = quality
+		}
+
+		images, err := c.sampleImages(fileName, fileInfo, sampled)
+		if err != nil {
+			return est, fmt.Errorf("%s: %w", fileName, err)
+		}
+
+		for _, img := range images {
+			size, err := c.sampleSize(c.imageTransform(img))
+			if err != nil {
+				return est, fmt.Errorf("%s: %w", fileName, err)
+			}
+
+			sizes = append(sizes, size)
+		}
+	}
+
+	var total int64
+	for _, size := range sizes {
+		total += size
+	}
+
+	est.Samples = len(sizes)
+	est.OutputSize = total * int64(est.Pages) / int64(est.Samples)
+
+	return est, nil
+}
+
 // Preview returns image preview.
 func (c *Converter) Preview(fileName string, fileInfo os.FileInfo, width, height int) (Image, error) {
+	return c.PreviewZoom(fileName, fileInfo, width, height, 0)
+}
+
+// PreviewZoom returns image preview scaled by zoom factor (i.e. 1 is 100%).
+// If zoom is 0, the image is scaled to fit the width and height.
+func (c *Converter) PreviewZoom(fileName string, fileInfo os.FileInfo, width, height int, zoom float64) (Image, error) {
+	c = c.begin(fileName, false)
+	defer c.end()
+
 	var img Image
 
 	i, err := c.coverImage(fileName, fileInfo)
@@ -477,8 +1028,17 @@ func (c *Converter) Preview(fileName string, fileInfo os.FileInfo, width, height
 		return img, fmt.Errorf("%s: %w", fileName, err)
 	}
 
-	if width != 0 && height != 0 {
-		dec = fit(dec, width, height, filters[c.Opts.Filter])
+	switch {
+	case zoom > 0 && zoom != 1:
+		filter := c.resizer(c.Opts.Filter)
+		if zoom > 1 {
+			// keep pixels sharp when zooming in, for pixel-accurate inspection
+			filter = c.resizer(nearestNeighbor)
+		}
+
+		dec = resize(dec, int(float64(img.Width)*zoom), int(float64(img.Height)*zoom), filter)
+	case zoom == 0 && width != 0 && height != 0:
+		dec = fit(dec, width, height, c.resizer(c.Opts.Filter))
 	}
 
 	img.Image = dec
@@ -486,35 +1046,208 @@ func (c *Converter) Preview(fileName string, fileInfo os.FileInfo, width, height
 	return img, nil
 }
 
-// Convert converts comic book.
-func (c *Converter) Convert(fileName string, fileInfo os.FileInfo) error {
-	c.CurrFile++
+// WriteArchive writes pages to w as CBZ archive, images are transformed and encoded with given options.
+func WriteArchive(w io.Writer, pages []PageSource, opts Options) error {
+	c := New(opts)
+
+	rules, err := compressionRules(c.Opts.Compression)
+	if err != nil {
+		return fmt.Errorf("WriteArchive: %w", err)
+	}
+
+	z, err := c.zipWriter(w)
+	if err != nil {
+		return fmt.Errorf("WriteArchive: %w", err)
+	}
+
+	for idx, page := range pages {
+		name := filepath.Base(filepath.ToSlash(page.Name))
+		if page.Name == "" {
+			name = fmt.Sprintf("%03d", idx)
+		}
+
+		var data []byte
+		var img image.Image
+
+		switch {
+		case page.Image != nil:
+			img = page.Image
+		case page.Reader != nil:
+			b, err := io.ReadAll(page.Reader)
+			if err != nil {
+				return fmt.Errorf("WriteArchive: %s: %w", name, err)
+			}
+
+			if (page.Name != "" && !isImage(name)) || c.Opts.NoConvert {
+				data = b
+
+				break
+			}
+
+			img, err = c.imageDecode(bytes.NewReader(b))
+			if err != nil {
+				return fmt.Errorf("WriteArchive: %s: %w", name, err)
+			}
+		default:
+			return fmt.Errorf("WriteArchive: %s: empty page", name)
+		}
+
+		if img != nil {
+			img = c.imageTransform(img)
+			format := c.imageFormat(img)
+
+			var buf bytes.Buffer
+			if err := c.imageEncodeFormat(img, format, &buf); err != nil {
+				return fmt.Errorf("WriteArchive: %s: %w", name, err)
+			}
+
+			name = fmt.Sprintf("%s.%s", baseNoExt(name), formatExt(format))
+			data = buf.Bytes()
+		}
+
+		fw, err := z.CreateHeader(&zip.FileHeader{Name: name, Method: zipMethod(rules, name), Modified: c.now()})
+		if err != nil {
+			return fmt.Errorf("WriteArchive: %w", err)
+		}
+
+		if _, err = fw.Write(data); err != nil {
+			return fmt.Errorf("WriteArchive: %w", err)
+		}
+	}
+
+	if err := z.Close(); err != nil {
+		return fmt.Errorf("WriteArchive: %w", err)
+	}
+
+	return nil
+}
+
+// Convert converts comic book. Working directory is removed when Convert returns, also on error.
+func (c *Converter) Convert(fileName string, fileInfo os.FileInfo) (Result, error) {
+	c = c.begin(fileName, true)
+	defer c.end()
+
+	var res Result
 
 	ctx, cancel := context.WithCancel(context.Background())
+	if c.Opts.FileTimeout > 0 {
+		ctx, cancel = context.WithTimeout(context.Background(), c.Opts.FileTimeout)
+	}
 	defer cancel()
 
-	c.OnCancel = cancel
+	c.setCancel(cancel)
 
-	switch {
-	case fileInfo.IsDir():
-		if err := c.convertDirectory(ctx, fileName); err != nil {
-			return fmt.Errorf("%s: %w", fileName, err)
+	start := time.Now()
+	defer since(&c.shared.stats.elapsed, start)
+
+	if c.Opts.Overwrite == "skip" && c.Opts.AppendTo == "" {
+		if _, err := os.Stat(c.OutputName(fileName)); err == nil {
+			c.log(LogNormal, "output exists, skipped", "file", fileName, "output", c.OutputName(fileName))
+
+			return res, nil
 		}
-	case isDocument(fileName):
-		if err := c.convertDocument(ctx, fileName); err != nil {
-			return fmt.Errorf("%s: %w", fileName, err)
+	}
+
+	c.log(LogVerbose, "converting", "file", fileName, "index", c.job.CurrFile, "total", c.Nfiles)
+
+	if !fileInfo.IsDir() {
+		c.shared.stats.bytesIn.Add(fileInfo.Size())
+	}
+
+	if c.Opts.TargetSize > 0 && !c.Opts.NoConvert {
+		quality, err := c.targetQuality(fileName, fileInfo)
+		if err != nil {
+			return res, fmt.Errorf("%s: %w", fileName, err)
+		}
+
+		c.log(LogVerbose, "target quality", "file", fileName, "quality", quality)
+
+		c.Opts.Quality = quality
+	}
+
+	if c.Opts.AutoWidth && !c.Opts.NoConvert {
+		pages, err := c.ListPages(fileName, fileInfo, true)
+		if err != nil {
+			return res, err
 		}
+
+		c.job.autoWidth = MedianWidth(pages)
+		if c.Opts.Width > 0 && (c.job.autoWidth == 0 || c.job.autoWidth > c.Opts.Width) {
+			c.job.autoWidth = c.Opts.Width
+		}
+
+		c.log(LogVerbose, "auto width", "file", fileName, "width", c.job.autoWidth)
+	}
+
+	var err error
+
+	switch {
+	case fileInfo.IsDir(), isImage(fileName):
+		err = c.convertDirectory(ctx, fileName)
+	case isDocument(fileName):
+		err = c.convertDocument(ctx, fileName)
 	case isArchive(fileName):
-		if err := c.convertArchive(ctx, fileName); err != nil {
-			return fmt.Errorf("%s: %w", fileName, err)
+		err = c.convertArchive(ctx, fileName)
+	}
+
+	res.Workdir = c.job.workdir
+
+	if err != nil {
+		if c.job.checkpoint != nil && errors.Is(err, context.Canceled) {
+			if err := c.checkpointSave(fileName); err != nil {
+				c.log(LogErrors, "checkpoint failed", "file", fileName, "error", err)
+			} else {
+				// pages in the workdir are reused when the conversion is resumed
+				c.job.keep = true
+				c.log(LogNormal, "checkpoint saved", "file", fileName, "workdir", c.job.workdir)
+			}
 		}
+
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) && !errors.Is(err, ErrTimeout) {
+			return res, fmt.Errorf("%s: %w after %s", fileName, ErrTimeout, c.Opts.FileTimeout)
+		}
+
+		return res, fmt.Errorf("%s: %w", fileName, err)
 	}
 
-	if err := c.archiveSave(fileName); err != nil {
-		return fmt.Errorf("%s: %w", fileName, err)
+	if c.Opts.Sample > 0 {
+		if err := c.reviewSave(fileName, fileInfo); err != nil {
+			return res, fmt.Errorf("%s: %w", fileName, err)
+		}
 	}
 
-	c.OnCancel = nil
+	c.log(LogDebug, "saving", "workdir", c.job.workdir, "archive", c.Opts.Archive)
 
-	return nil
+	saveStart := time.Now()
+
+	output, err := c.archiveSave(fileName)
+	if err != nil {
+		return res, fmt.Errorf("%s: %w", fileName, err)
+	}
+
+	res.Output = output
+	since(&c.shared.stats.save, saveStart)
+	c.checkpointRemove()
+	c.shared.stats.files.Add(1)
+	if stat, err := os.Stat(output); err == nil {
+		c.shared.stats.bytesOut.Add(stat.Size())
+	}
+
+	c.log(LogVerbose, "converted", "file", fileName, "output", output, "duration", time.Since(start).Round(time.Millisecond))
+
+	if c.Opts.PostCmd != "" {
+		c.log(LogDebug, "post-cmd", "output", output)
+
+		if err := c.postCmd(fileName, output); err != nil {
+			return res, fmt.Errorf("%s: %w", fileName, err)
+		}
+	}
+
+	if c.Opts.DeleteOriginal {
+		if err := c.removeOriginal(fileName, output); err != nil {
+			return res, fmt.Errorf("%s: %w", fileName, err)
+		}
+	}
+
+	return res, nil
 }
@@ -0,0 +1,53 @@
+package cbconvert
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// altTexts returns page descriptions for images, from the sidecar JSON file and OnAltText function.
+// Sidecar keys are page names (with or without extension) or page numbers, starting from 1.
+func (c *Converter) altTexts(fileName string, images []string) ([]string, error) {
+	texts := make([]string, len(images))
+
+	if c.Opts.AltText != "" {
+		sidecar := strings.NewReplacer("{name}", baseNoExt(fileName), "{dir}", filepath.Dir(fileName)).Replace(c.Opts.AltText)
+
+		data, err := os.ReadFile(sidecar)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return texts, fmt.Errorf("altTexts: %w", err)
+		}
+
+		if err == nil {
+			desc := make(map[string]string)
+			if err := json.Unmarshal(data, &desc); err != nil {
+				return texts, fmt.Errorf("altTexts: %s: %w", sidecar, err)
+			}
+
+			for idx, img := range images {
+				for _, key := range []string{img, baseNoExt(img), strconv.Itoa(idx + 1)} {
+					if text, ok := desc[key]; ok {
+						texts[idx] = text
+
+						break
+					}
+				}
+			}
+		}
+	}
+
+	if c.OnAltText != nil {
+		for idx, img := range images {
+			if text := c.OnAltText(idx, img); text != "" {
+				texts[idx] = text
+			}
+		}
+	}
+
+	return texts, nil
+}
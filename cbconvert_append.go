@@ -0,0 +1,191 @@
+package cbconvert
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/fvbommel/sortorder"
+)
+
+// numberedName returns name of the page number idx, numbers are padded to width.
+func numberedName(name string, idx, width int) string {
+	return fmt.Sprintf("%0*d%s", width, idx, strings.ToLower(filepath.Ext(name)))
+}
+
+// archiveAppend appends converted images from workdir to the existing CBZ archive, i.e. to the archive of the ongoing
+// webcomic. New pages are numbered after the existing pages, existing pages are renumbered in the archive root
+// if they are not numbered or the number width grows. Existing data is copied without re-encoding.
+func (c *Converter) archiveAppend(fileName, appendName string) error {
+	if abs, err := filepath.Abs(fileName); err == nil {
+		if dst, err := filepath.Abs(appendName); err == nil && abs == dst {
+			return fmt.Errorf("archiveAppend: %s is the archive to append to", fileName)
+		}
+	}
+
+	if c.OnCompress != nil {
+		c.OnCompress(c.job)
+	}
+
+	err := c.outputAtomic(appendName, func(tmpName string) error {
+		return c.archiveAppendZip(appendName, tmpName)
+	})
+	if err != nil {
+		return fmt.Errorf("archiveAppend: %w", err)
+	}
+
+	err = os.RemoveAll(c.job.workdir)
+	if err != nil {
+		return fmt.Errorf("archiveAppend: %w", err)
+	}
+
+	return nil
+}
+
+// archiveAppendZip writes entries of the zipName archive and images from workdir to the tmpName archive.
+func (c *Converter) archiveAppendZip(zipName, tmpName string) error {
+	zr, err := zip.OpenReader(zipName)
+	if err != nil {
+		return fmt.Errorf("archiveAppendZip: %w", err)
+	}
+	defer zr.Close()
+
+	var names []string
+	for _, item := range zr.File {
+		names = append(names, item.Name)
+	}
+
+	images := imagesFromSlice(names)
+	sort.SliceStable(images, func(i, j int) bool {
+		return sortorder.NaturalLess(images[i], images[j])
+	})
+
+	files, err := c.workdirFiles()
+	if err != nil {
+		return fmt.Errorf("archiveAppendZip: %w", err)
+	}
+
+	var pages []os.DirEntry
+	for _, file := range files {
+		if isImage(file.Name()) {
+			pages = append(pages, file)
+		}
+	}
+
+	// numbering starts from 0, or from 1 if the existing pages start from 1
+	first := 0
+	if len(images) > 0 {
+		if n, err := strconv.Atoi(strings.TrimSuffix(images[0], filepath.Ext(images[0]))); err == nil && n == 1 {
+			first = 1
+		}
+	}
+
+	width := max(3, len(fmt.Sprint(first+len(images)+len(pages)-1)))
+
+	renamed := make(map[string]string)
+	renumber := false
+	for idx, name := range images {
+		renamed[name] = numberedName(name, first+idx, width)
+		if renamed[name] != name {
+			renumber = true
+		}
+	}
+
+	rules, err := compressionRules(c.Opts.Compression)
+	if err != nil {
+		return fmt.Errorf("archiveAppendZip: %w", err)
+	}
+
+	zf, err := os.Create(tmpName)
+	if err != nil {
+		return fmt.Errorf("archiveAppendZip: %w", err)
+	}
+	defer zf.Close()
+
+	zw, err := c.zipWriter(zf)
+	if err != nil {
+		return fmt.Errorf("archiveAppendZip: %w", err)
+	}
+
+	if err = zw.SetComment(zr.Comment); err != nil {
+		return fmt.Errorf("archiveAppendZip: %w", err)
+	}
+
+	for _, item := range zr.File {
+		ir, err := item.OpenRaw()
+		if err != nil {
+			return fmt.Errorf("archiveAppendZip: %w", err)
+		}
+
+		header := item.FileHeader
+		if newName, ok := renamed[item.Name]; ok && renumber {
+			header.Name = newName
+			header.Extra = zipExtraWithout(header.Extra, zipUnicodePathID)
+		}
+
+		it, err := zw.CreateRaw(&header)
+		if err != nil {
+			return fmt.Errorf("archiveAppendZip: %w", err)
+		}
+
+		_, err = io.Copy(it, ir)
+		if err != nil {
+			return fmt.Errorf("archiveAppendZip: %w", err)
+		}
+	}
+
+	if renumber {
+		c.log(LogVerbose, "renumbered pages", "file", zipName, "pages", len(images))
+	}
+
+	for idx, file := range pages {
+		data, err := os.ReadFile(filepath.Join(c.job.workdir, file.Name()))
+		if err != nil {
+			return fmt.Errorf("archiveAppendZip: %w", err)
+		}
+
+		info, err := file.Info()
+		if err != nil {
+			return fmt.Errorf("archiveAppendZip: %w", err)
+		}
+
+		zipInfo, err := zip.FileInfoHeader(info)
+		if err != nil {
+			return fmt.Errorf("archiveAppendZip: %w", err)
+		}
+
+		zipInfo.Name = numberedName(file.Name(), first+len(images)+idx, width)
+		zipInfo.Method = zipMethod(rules, file.Name())
+		if c.Opts.Reproducible {
+			zipInfo.Modified = reproducibleTime
+			zipInfo.SetMode(0644)
+		}
+
+		w, err := zw.CreateHeader(zipInfo)
+		if err != nil {
+			return fmt.Errorf("archiveAppendZip: %w", err)
+		}
+
+		_, err = w.Write(data)
+		if err != nil {
+			return fmt.Errorf("archiveAppendZip: %w", err)
+		}
+	}
+
+	c.log(LogVerbose, "appended pages", "file", zipName, "pages", len(pages))
+
+	if err = zw.Close(); err != nil {
+		return fmt.Errorf("archiveAppendZip: %w", err)
+	}
+
+	if err = zf.Close(); err != nil {
+		return fmt.Errorf("archiveAppendZip: %w", err)
+	}
+
+	return nil
+}
@@ -3,43 +3,199 @@ package cbconvert
 import (
 	"archive/tar"
 	"archive/zip"
+	"bytes"
+	"compress/bzip2"
+	"compress/flate"
+	"compress/gzip"
 	"fmt"
 	"io"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"sort"
 	"strings"
+	"time"
 
+	"github.com/fvbommel/sortorder"
 	"github.com/gen2brain/go-unarr"
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
 )
 
-// archiveSave saves workdir to CBZ archive.
-func (c *Converter) archiveSave(fileName string) error {
-	if c.Opts.Archive == "zip" {
-		return c.archiveSaveZip(fileName)
-	} else if c.Opts.Archive == "tar" {
-		return c.archiveSaveTar(fileName)
+// defaultCompression is used when Options.Compression is empty.
+const defaultCompression = "jpg=store,jpeg=store,png=store,gif=store,webp=store,avif=store,jxl=store,*=deflate"
+
+// archiveSave saves workdir to archive, it returns the output file name.
+func (c *Converter) archiveSave(fileName string) (string, error) {
+	if c.Opts.AppendTo != "" {
+		if err := c.archiveAppend(fileName, c.Opts.AppendTo); err != nil {
+			return "", fmt.Errorf("archiveSave: %w", err)
+		}
+
+		return c.Opts.AppendTo, nil
 	}
 
-	return nil
-}
+	if c.Opts.ComicInfoName && c.Opts.Archive != "mobi" && c.Opts.Archive != "epub" {
+		if err := c.comicInfoNameWrite(fileName); err != nil {
+			return "", fmt.Errorf("archiveSave: %w", err)
+		}
+	}
 
-// archiveSaveZip saves workdir to CBZ archive.
-func (c *Converter) archiveSaveZip(fileName string) error {
-	if c.OnCompress != nil {
-		c.OnCompress()
+	// conversion does not fail if the issue is not found or ComicVine is not available
+	if c.Opts.Fetch && c.Opts.Archive != "mobi" && c.Opts.Archive != "epub" {
+		if err := c.comicInfoFetchWrite(fileName); err != nil {
+			c.log(LogErrors, "metadata not fetched", "file", fileName, "error", err)
+		}
+	}
+
+	// existing ComicInfo.xml is kept, its page table is updated for the converted pages
+	if (c.Opts.ComicInfo || c.comicInfoName() != "") && c.Opts.Archive != "mobi" && c.Opts.Archive != "epub" {
+		if err := c.comicInfoWrite(); err != nil {
+			return "", fmt.Errorf("archiveSave: %w", err)
+		}
+	}
+
+	if c.Opts.EmbedOptions && c.Opts.Archive != "mobi" && c.Opts.Archive != "epub" {
+		if err := c.optionsWrite(); err != nil {
+			return "", fmt.Errorf("archiveSave: %w", err)
+		}
 	}
 
-	var zipName string
+	switch c.Opts.Archive {
+	case "zip":
+		zipName, err := c.outputName(fileName, archiveExts["zip"])
+		if err != nil {
+			return "", fmt.Errorf("archiveSave: %w", err)
+		}
+
+		if err := c.outputAtomic(zipName, func(tmpName string) error {
+			return c.archiveSaveZip(tmpName, c.commentTemplate(fileName))
+		}); err != nil {
+			return "", fmt.Errorf("archiveSave: %w", err)
+		}
+
+		return zipName, nil
+	case "tar", "tar.gz", "tar.zst", "tar.xz":
+		tarName, err := c.outputName(fileName, archiveExts[c.Opts.Archive])
+		if err != nil {
+			return "", fmt.Errorf("archiveSave: %w", err)
+		}
+
+		if err := c.outputAtomic(tarName, func(tmpName string) error {
+			return c.archiveSaveTar(tmpName)
+		}); err != nil {
+			return "", fmt.Errorf("archiveSave: %w", err)
+		}
+
+		return tarName, nil
+	case "7z":
+		sevenZipName, err := c.outputName(fileName, archiveExts["7z"])
+		if err != nil {
+			return "", fmt.Errorf("archiveSave: %w", err)
+		}
+
+		if err := c.outputAtomic(sevenZipName, func(tmpName string) error {
+			return c.archiveSave7z(tmpName)
+		}); err != nil {
+			return "", fmt.Errorf("archiveSave: %w", err)
+		}
+
+		return sevenZipName, nil
+	case "mobi":
+		mobiName, err := c.outputName(fileName, archiveExts["mobi"])
+		if err != nil {
+			return "", fmt.Errorf("archiveSave: %w", err)
+		}
+
+		if err := c.outputAtomic(mobiName, func(tmpName string) error {
+			return c.archiveSaveMobi(fileName, tmpName)
+		}); err != nil {
+			return "", fmt.Errorf("archiveSave: %w", err)
+		}
+
+		return mobiName, nil
+	case "epub":
+		epubName, err := c.outputName(fileName, archiveExts["epub"])
+		if err != nil {
+			return "", fmt.Errorf("archiveSave: %w", err)
+		}
+
+		if err := c.outputAtomic(epubName, func(tmpName string) error {
+			return c.archiveSaveEpub(fileName, tmpName)
+		}); err != nil {
+			return "", fmt.Errorf("archiveSave: %w", err)
+		}
+
+		return epubName, nil
+	case "none":
+		dirName, err := c.outputName(fileName, archiveExts["none"])
+		if err != nil {
+			return "", fmt.Errorf("archiveSave: %w", err)
+		}
+
+		if err := c.archiveSaveDir(fileName, dirName); err != nil {
+			return "", fmt.Errorf("archiveSave: %w", err)
+		}
+
+		return dirName, nil
+	}
+
+	return "", nil
+}
+
+// archiveExts are extensions of the output archive formats.
+var archiveExts = map[string]string{
+	"zip":     ".cbz",
+	"tar":     ".cbt",
+	"tar.gz":  ".cbt.gz",
+	"tar.zst": ".cbt.zst",
+	"tar.xz":  ".cbt.xz",
+	"7z":      ".cb7",
+	"mobi":    ".mobi",
+	"epub":    ".epub",
+	"none":    "",
+}
+
+// OutputName returns file name of the converted archive for the input, the Overwrite policy is not applied.
+func (c *Converter) OutputName(fileName string) string {
+	return c.outputPath(fileName, archiveExts[c.Opts.Archive])
+}
+
+// outputPath returns output file name with suffix and extension, the input directory structure is kept in recursive mode.
+func (c *Converter) outputPath(fileName, ext string) string {
+	baseName := fmt.Sprintf("%s%s%s", baseNoExt(fileName), c.Opts.Suffix, ext)
+
 	if c.Opts.Recursive {
 		fDir := strings.Split(filepath.Dir(fileName), string(os.PathSeparator))[1:]
-		err := os.MkdirAll(filepath.Join(c.Opts.OutDir, filepath.Join(fDir...)), 0755)
-		if err != nil {
-			return fmt.Errorf("archiveSaveZip: %w", err)
+
+		return filepath.Join(c.Opts.OutDir, filepath.Join(fDir...), baseName)
+	}
+
+	return filepath.Join(c.Opts.OutDir, baseName)
+}
+
+// outputName returns output file name, subdirectories are created in recursive mode.
+// Existing file is kept with the rename policy, a number is appended to the name.
+func (c *Converter) outputName(fileName, ext string) (string, error) {
+	name := c.outputPath(fileName, ext)
+
+	if c.Opts.Recursive {
+		if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			return "", fmt.Errorf("outputName: %w", err)
 		}
+	}
 
-		zipName = filepath.Join(c.Opts.OutDir, filepath.Join(fDir...), fmt.Sprintf("%s%s.cbz", baseNoExt(fileName), c.Opts.Suffix))
-	} else {
-		zipName = filepath.Join(c.Opts.OutDir, fmt.Sprintf("%s%s.cbz", baseNoExt(fileName), c.Opts.Suffix))
+	if c.Opts.Overwrite == "rename" {
+		name = uniqueName(name)
+	}
+
+	return name, nil
+}
+
+// archiveSaveZip saves workdir to CBZ archive, comment is set if not empty.
+func (c *Converter) archiveSaveZip(zipName, comment string) error {
+	if c.OnCompress != nil {
+		c.OnCompress(c.job)
 	}
 
 	zipFile, err := os.Create(zipName)
@@ -47,15 +203,29 @@ func (c *Converter) archiveSaveZip(fileName string) error {
 		return fmt.Errorf("archiveSaveZip: %w", err)
 	}
 
-	z := zip.NewWriter(zipFile)
+	rules, err := compressionRules(c.Opts.Compression)
+	if err != nil {
+		return fmt.Errorf("archiveSaveZip: %w", err)
+	}
+
+	z, err := c.zipWriter(zipFile)
+	if err != nil {
+		return fmt.Errorf("archiveSaveZip: %w", err)
+	}
+
+	if comment != "" {
+		if err = z.SetComment(comment); err != nil {
+			return fmt.Errorf("archiveSaveZip: %w", err)
+		}
+	}
 
-	files, err := os.ReadDir(c.Workdir)
+	files, err := c.workdirFiles()
 	if err != nil {
 		return fmt.Errorf("archiveSaveZip: %w", err)
 	}
 
 	for _, file := range files {
-		r, err := os.ReadFile(filepath.Join(c.Workdir, file.Name()))
+		r, err := os.ReadFile(filepath.Join(c.job.workdir, file.Name()))
 		if err != nil {
 			return fmt.Errorf("archiveSaveZip: %w", err)
 		}
@@ -70,7 +240,12 @@ func (c *Converter) archiveSaveZip(fileName string) error {
 			return fmt.Errorf("archiveSaveZip: %w", err)
 		}
 
-		zipInfo.Method = zip.Deflate
+		zipInfo.Method = zipMethod(rules, file.Name())
+		if c.Opts.Reproducible {
+			zipInfo.Modified = reproducibleTime
+			zipInfo.SetMode(0644)
+		}
+
 		w, err := z.CreateHeader(zipInfo)
 		if err != nil {
 			return fmt.Errorf("archiveSaveZip: %w", err)
@@ -90,7 +265,7 @@ func (c *Converter) archiveSaveZip(fileName string) error {
 		return fmt.Errorf("archiveSaveZip: %w", err)
 	}
 
-	err = os.RemoveAll(c.Workdir)
+	err = os.RemoveAll(c.job.workdir)
 	if err != nil {
 		return fmt.Errorf("archiveSaveZip: %w", err)
 	}
@@ -98,23 +273,184 @@ func (c *Converter) archiveSaveZip(fileName string) error {
 	return nil
 }
 
-// archiveSaveTar saves workdir to CBT archive.
-func (c *Converter) archiveSaveTar(fileName string) error {
+// compressionRules parses comma separated extension=method rules, i.e. "jpg=store,*=deflate", or a single method
+// for all entries (store or deflate). Already compressed images are stored and everything else is deflated if rules are empty.
+func compressionRules(rules string) (map[string]uint16, error) {
+	switch strings.ToLower(strings.TrimSpace(rules)) {
+	case "":
+		rules = defaultCompression
+	case "store", "deflate":
+		rules = "*=" + rules
+	}
+
+	m := make(map[string]uint16)
+
+	for _, rule := range strings.Split(rules, ",") {
+		ext, method, ok := strings.Cut(strings.TrimSpace(rule), "=")
+		if !ok {
+			return nil, fmt.Errorf("compressionRules: invalid rule %q", rule)
+		}
+
+		ext = strings.TrimPrefix(strings.ToLower(strings.TrimSpace(ext)), ".")
+
+		switch strings.ToLower(strings.TrimSpace(method)) {
+		case "store":
+			m[ext] = zip.Store
+		case "deflate":
+			m[ext] = zip.Deflate
+		default:
+			return nil, fmt.Errorf("compressionRules: invalid method %q", method)
+		}
+	}
+
+	return m, nil
+}
+
+// zipMethod returns compression method for file name, the "*" rule is the fallback.
+func zipMethod(rules map[string]uint16, name string) uint16 {
+	if method, ok := rules[strings.TrimPrefix(strings.ToLower(filepath.Ext(name)), ".")]; ok {
+		return method
+	}
+
+	if method, ok := rules["*"]; ok {
+		return method
+	}
+
+	return zip.Deflate
+}
+
+// zipWriter returns ZIP writer, deflated entries are compressed with Options.ZipLevel.
+func (c *Converter) zipWriter(w io.Writer) (*zip.Writer, error) {
+	z := zip.NewWriter(w)
+
+	if c.Opts.ZipLevel != 0 {
+		if c.Opts.ZipLevel < flate.BestSpeed || c.Opts.ZipLevel > flate.BestCompression {
+			return nil, fmt.Errorf("zipWriter: invalid level %d", c.Opts.ZipLevel)
+		}
+
+		level := c.Opts.ZipLevel
+		z.RegisterCompressor(zip.Deflate, func(out io.Writer) (io.WriteCloser, error) {
+			return flate.NewWriter(out, level)
+		})
+	}
+
+	return z, nil
+}
+
+// workdirFiles returns workdir entries sorted with Less function, or in natural order.
+func (c *Converter) workdirFiles() ([]os.DirEntry, error) {
+	files, err := os.ReadDir(c.job.workdir)
+	if err != nil {
+		return nil, fmt.Errorf("workdirFiles: %w", err)
+	}
+
+	less := c.Less
+	if less == nil {
+		less = sortorder.NaturalLess
+	}
+
+	sort.SliceStable(files, func(i, j int) bool {
+		return less(files[i].Name(), files[j].Name())
+	})
+
+	return files, nil
+}
+
+// archiveSaveDir moves workdir files to the output directory without archiving, an existing directory is replaced.
+func (c *Converter) archiveSaveDir(fileName, dirName string) error {
 	if c.OnCompress != nil {
-		c.OnCompress()
+		c.OnCompress(c.job)
 	}
 
-	var tarName string
-	if c.Opts.Recursive {
-		fDir := strings.Split(filepath.Dir(fileName), string(os.PathSeparator))[1:]
-		err := os.MkdirAll(filepath.Join(c.Opts.OutDir, filepath.Join(fDir...)), 0755)
+	if in, err := os.Stat(fileName); err == nil {
+		if out, err := os.Stat(dirName); err == nil && os.SameFile(in, out) {
+			return fmt.Errorf("archiveSaveDir: output directory %s is the input, set suffix or output directory", dirName)
+		}
+	}
+
+	if err := os.RemoveAll(dirName); err != nil {
+		return fmt.Errorf("archiveSaveDir: %w", err)
+	}
+
+	if err := os.MkdirAll(dirName, 0755); err != nil {
+		return fmt.Errorf("archiveSaveDir: %w", err)
+	}
+
+	files, err := c.workdirFiles()
+	if err != nil {
+		return fmt.Errorf("archiveSaveDir: %w", err)
+	}
+
+	for _, file := range files {
+		src := filepath.Join(c.job.workdir, file.Name())
+		dst := filepath.Join(dirName, file.Name())
+
+		if c.Opts.Reproducible {
+			if err = os.Chtimes(src, reproducibleTime, reproducibleTime); err != nil {
+				return fmt.Errorf("archiveSaveDir: %w", err)
+			}
+		}
+
+		// workdir can be on another filesystem, files are copied if they can not be moved
+		if err = os.Rename(src, dst); err == nil {
+			continue
+		}
+
+		f, err := os.Open(src)
 		if err != nil {
-			return fmt.Errorf("archiveSaveTar: %w", err)
+			return fmt.Errorf("archiveSaveDir: %w", err)
 		}
 
-		tarName = filepath.Join(c.Opts.OutDir, filepath.Join(fDir...), fmt.Sprintf("%s%s.cbt", baseNoExt(fileName), c.Opts.Suffix))
-	} else {
-		tarName = filepath.Join(c.Opts.OutDir, fmt.Sprintf("%s%s.cbt", baseNoExt(fileName), c.Opts.Suffix))
+		err = copyFile(c.throttle(f), dst)
+		_ = f.Close()
+		if err != nil {
+			return fmt.Errorf("archiveSaveDir: %w", err)
+		}
+
+		if c.Opts.Reproducible {
+			if err = os.Chtimes(dst, reproducibleTime, reproducibleTime); err != nil {
+				return fmt.Errorf("archiveSaveDir: %w", err)
+			}
+		}
+	}
+
+	err = os.RemoveAll(c.job.workdir)
+	if err != nil {
+		return fmt.Errorf("archiveSaveDir: %w", err)
+	}
+
+	return nil
+}
+
+// nopWriteCloser type, writer with no-op Close.
+type nopWriteCloser struct {
+	io.Writer
+}
+
+// Close does nothing.
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+// tarCompressor returns writer that compresses the tar stream for the archive format (tar.gz, tar.zst or tar.xz),
+// plain tar is not compressed. The returned writer must be closed before the file.
+func tarCompressor(w io.Writer, archive string) (io.WriteCloser, error) {
+	switch archive {
+	case "tar.gz":
+		return gzip.NewWriterLevel(w, gzip.BestCompression)
+	case "tar.zst":
+		return zstd.NewWriter(w, zstd.WithEncoderLevel(zstd.SpeedBestCompression))
+	case "tar.xz":
+		return xz.NewWriter(w)
+	}
+
+	return nopWriteCloser{w}, nil
+}
+
+// archiveSaveTar saves workdir to CBT archive, compressed with gzip, zstd or xz for the tar.gz, tar.zst and tar.xz formats.
+func (c *Converter) archiveSaveTar(tarName string) error {
+	if c.OnCompress != nil {
+		c.OnCompress(c.job)
 	}
 
 	tarFile, err := os.Create(tarName)
@@ -122,15 +458,20 @@ func (c *Converter) archiveSaveTar(fileName string) error {
 		return fmt.Errorf("archiveSaveTar: %w", err)
 	}
 
-	tw := tar.NewWriter(tarFile)
+	cw, err := tarCompressor(tarFile, c.Opts.Archive)
+	if err != nil {
+		return fmt.Errorf("archiveSaveTar: %w", err)
+	}
+
+	tw := tar.NewWriter(cw)
 
-	files, err := os.ReadDir(c.Workdir)
+	files, err := c.workdirFiles()
 	if err != nil {
 		return fmt.Errorf("archiveSaveTar: %w", err)
 	}
 
 	for _, file := range files {
-		r, err := os.ReadFile(filepath.Join(c.Workdir, file.Name()))
+		r, err := os.ReadFile(filepath.Join(c.job.workdir, file.Name()))
 		if err != nil {
 			return fmt.Errorf("archiveSaveTar: %w", err)
 		}
@@ -145,6 +486,18 @@ func (c *Converter) archiveSaveTar(fileName string) error {
 			return fmt.Errorf("archiveSaveTar: %w", err)
 		}
 
+		if c.Opts.TarNormalize || c.Opts.Reproducible {
+			header.Uid, header.Gid = 0, 0
+			header.Uname, header.Gname = "", ""
+			header.Mode = 0644
+			header.ModTime = reproducibleTime
+			header.AccessTime, header.ChangeTime = time.Time{}, time.Time{}
+		}
+
+		if c.Opts.TarPAX {
+			header.Format = tar.FormatPAX
+		}
+
 		err = tw.WriteHeader(header)
 		if err != nil {
 			return fmt.Errorf("archiveSaveTar: %w", err)
@@ -160,11 +513,15 @@ func (c *Converter) archiveSaveTar(fileName string) error {
 		return fmt.Errorf("archiveSaveTar: %w", err)
 	}
 
+	if err = cw.Close(); err != nil {
+		return fmt.Errorf("archiveSaveTar: %w", err)
+	}
+
 	if err = tarFile.Close(); err != nil {
 		return fmt.Errorf("archiveSaveTar: %w", err)
 	}
 
-	err = os.RemoveAll(c.Workdir)
+	err = os.RemoveAll(c.job.workdir)
 	if err != nil {
 		return fmt.Errorf("archiveSaveTar: %w", err)
 	}
@@ -172,11 +529,315 @@ func (c *Converter) archiveSaveTar(fileName string) error {
 	return nil
 }
 
+// archiveSave7z saves workdir to 7z archive with external 7zz, 7z, 7za or 7zr command (LZMA2, ultra compression).
+func (c *Converter) archiveSave7z(sevenZipName string) error {
+	if c.OnCompress != nil {
+		c.OnCompress(c.job)
+	}
+
+	var path string
+	for _, name := range []string{"7zz", "7z", "7za", "7zr"} {
+		if p, err := exec.LookPath(name); err == nil {
+			path = p
+
+			break
+		}
+	}
+
+	if path == "" {
+		return fmt.Errorf("archiveSave7z: 7z archives require 7zz, 7z, 7za or 7zr command")
+	}
+
+	sevenZipName, err := filepath.Abs(sevenZipName)
+	if err != nil {
+		return fmt.Errorf("archiveSave7z: %w", err)
+	}
+
+	// 7z adds files to the existing archive
+	if err = os.Remove(sevenZipName); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("archiveSave7z: %w", err)
+	}
+
+	files, err := c.workdirFiles()
+	if err != nil {
+		return fmt.Errorf("archiveSave7z: %w", err)
+	}
+
+	args := []string{"a", "-t7z", "-mx=9", "-bd", "-y", sevenZipName, "--"}
+	for _, file := range files {
+		if c.Opts.Reproducible {
+			if err = os.Chtimes(filepath.Join(c.job.workdir, file.Name()), reproducibleTime, reproducibleTime); err != nil {
+				return fmt.Errorf("archiveSave7z: %w", err)
+			}
+		}
+
+		args = append(args, file.Name())
+	}
+
+	cmd := exec.Command(path, args...)
+	cmd.Dir = c.job.workdir
+
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("archiveSave7z: %w: %s", err, strings.TrimSpace(string(out)))
+	}
+
+	err = os.RemoveAll(c.job.workdir)
+	if err != nil {
+		return fmt.Errorf("archiveSave7z: %w", err)
+	}
+
+	return nil
+}
+
+// archiveReader type, unarr archive with optional memory-mapped data that is unmapped on Close.
+type archiveReader struct {
+	*unarr.Archive
+	unmap func() error
+}
+
+// Close closes the archive and unmaps the data.
+func (a *archiveReader) Close() error {
+	err := a.Archive.Close()
+
+	if a.unmap != nil {
+		if e := a.unmap(); e != nil && err == nil {
+			err = e
+		}
+	}
+
+	return err
+}
+
+// archiveOpen opens archive, archives wrapped in gzip, bzip2, zstd or xz are decompressed,
+// ACE archives are extracted with external unace or unar command, password protected archives are decrypted
+// with Options.Password or ErrEncrypted is returned. Formats handled by plugins are extracted with the handler executable.
+func (c *Converter) archiveOpen(fileName string) (*archiveReader, error) {
+	var r io.Reader
+
+	if path, ok := pluginFind(fileName); ok {
+		data, err := archivePlugin(path, fileName, c.Opts.Password)
+		if err != nil {
+			return nil, fmt.Errorf("archiveOpen: %w", err)
+		}
+
+		archive, err := unarr.NewArchiveFromMemory(data)
+		if err != nil {
+			return nil, fmt.Errorf("archiveOpen: %w", err)
+		}
+
+		return &archiveReader{Archive: archive}, nil
+	}
+
+	switch strings.ToLower(filepath.Ext(fileName)) {
+	case ".cba":
+		data, err := archiveACE(fileName)
+		if err != nil {
+			return nil, fmt.Errorf("archiveOpen: %w", err)
+		}
+
+		archive, err := unarr.NewArchiveFromMemory(data)
+		if err != nil {
+			return nil, fmt.Errorf("archiveOpen: %w", err)
+		}
+
+		return &archiveReader{Archive: archive}, nil
+	case ".gz", ".tgz":
+		file, err := os.Open(fileName)
+		if err != nil {
+			return nil, fmt.Errorf("archiveOpen: %w", err)
+		}
+		defer file.Close()
+
+		gz, err := gzip.NewReader(file)
+		if err != nil {
+			return nil, fmt.Errorf("archiveOpen: %w", err)
+		}
+		defer gz.Close()
+
+		r = gz
+	case ".bz2", ".tbz2":
+		file, err := os.Open(fileName)
+		if err != nil {
+			return nil, fmt.Errorf("archiveOpen: %w", err)
+		}
+		defer file.Close()
+
+		r = bzip2.NewReader(file)
+	case ".zst", ".tzst":
+		file, err := os.Open(fileName)
+		if err != nil {
+			return nil, fmt.Errorf("archiveOpen: %w", err)
+		}
+		defer file.Close()
+
+		zr, err := zstd.NewReader(file)
+		if err != nil {
+			return nil, fmt.Errorf("archiveOpen: %w", err)
+		}
+		defer zr.Close()
+
+		r = zr
+	case ".xz", ".txz":
+		file, err := os.Open(fileName)
+		if err != nil {
+			return nil, fmt.Errorf("archiveOpen: %w", err)
+		}
+		defer file.Close()
+
+		xr, err := xz.NewReader(file)
+		if err != nil {
+			return nil, fmt.Errorf("archiveOpen: %w", err)
+		}
+
+		r = xr
+	default:
+		if encrypted, err := archiveEncrypted(fileName); err == nil && encrypted {
+			if c.Opts.Password == "" {
+				return nil, fmt.Errorf("archiveOpen: %w", ErrEncrypted)
+			}
+
+			data, err := archiveDecrypt(fileName, c.Opts.Password)
+			if err != nil {
+				return nil, fmt.Errorf("archiveOpen: %w", err)
+			}
+
+			archive, err := unarr.NewArchiveFromMemory(data)
+			if err != nil {
+				return nil, fmt.Errorf("archiveOpen: %w", err)
+			}
+
+			return &archiveReader{Archive: archive}, nil
+		}
+
+		if c.Opts.Mmap {
+			data, unmap, err := mmapFile(fileName)
+			if err == nil {
+				archive, err := unarr.NewArchiveFromMemory(data)
+				if err != nil {
+					_ = unmap()
+
+					return nil, fmt.Errorf("archiveOpen: %w", err)
+				}
+
+				return &archiveReader{Archive: archive, unmap: unmap}, nil
+			}
+
+			c.log(LogDebug, "mmap failed, reading file", "file", fileName, "error", err)
+		}
+
+		archive, err := unarr.NewArchive(fileName)
+		if err != nil {
+			return nil, fmt.Errorf("archiveOpen: %w", err)
+		}
+
+		return &archiveReader{Archive: archive}, nil
+	}
+
+	// the stream is read into memory, a crafted archive can decompress to any size
+	limit := c.streamLimit()
+	if limit > 0 {
+		r = io.LimitReader(r, limit+1)
+	}
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("archiveOpen: %w", err)
+	}
+
+	if limit > 0 && int64(len(data)) > limit {
+		return nil, fmt.Errorf("archiveOpen: %w: decompressed size is over %d bytes", ErrLimit, limit)
+	}
+
+	archive, err := unarr.NewArchiveFromMemory(data)
+	if err != nil {
+		return nil, fmt.Errorf("archiveOpen: %w", err)
+	}
+
+	return &archiveReader{Archive: archive}, nil
+}
+
+// archiveACE extracts ACE archive with external command and returns contents as ZIP archive.
+func archiveACE(fileName string) ([]byte, error) {
+	tmpDir, err := os.MkdirTemp(os.TempDir(), "cbc")
+	if err != nil {
+		return nil, fmt.Errorf("archiveACE: %w", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	var cmd *exec.Cmd
+	if path, err := exec.LookPath("unace"); err == nil {
+		cmd = exec.Command(path, "x", "-y", fileName, tmpDir+string(os.PathSeparator))
+	} else if path, err := exec.LookPath("unar"); err == nil {
+		cmd = exec.Command(path, "-q", "-f", "-D", "-o", tmpDir, fileName)
+	} else {
+		return nil, fmt.Errorf("archiveACE: ACE archives require unace or unar command")
+	}
+
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("archiveACE: %w: %s", err, strings.TrimSpace(string(out)))
+	}
+
+	data, err := dirZip(tmpDir)
+	if err != nil {
+		return nil, fmt.Errorf("archiveACE: %w", err)
+	}
+
+	return data, nil
+}
+
+// dirZip returns files in directory as ZIP archive, files are stored.
+func dirZip(dir string) ([]byte, error) {
+	var buf bytes.Buffer
+	z := zip.NewWriter(&buf)
+
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+
+		w, err := z.CreateHeader(&zip.FileHeader{Name: filepath.ToSlash(rel), Method: zip.Store, Modified: info.ModTime()})
+		if err != nil {
+			return err
+		}
+
+		_, err = w.Write(data)
+
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("dirZip: %w", err)
+	}
+
+	if err = z.Close(); err != nil {
+		return nil, fmt.Errorf("dirZip: %w", err)
+	}
+
+	return buf.Bytes(), nil
+}
+
 // archiveList lists contents of archive.
 func (c *Converter) archiveList(fileName string) ([]string, error) {
-	var contents []string
+	// cached contents are checked again, limits can differ between operations
+	contents := c.shared.cache.contents(fileName)
+	if contents != nil {
+		if err := c.archiveLimits(contents); err != nil {
+			return nil, fmt.Errorf("archiveList: %w", err)
+		}
 
-	archive, err := unarr.NewArchive(fileName)
+		return contents, nil
+	}
+
+	archive, err := c.archiveOpen(fileName)
 	if err != nil {
 		return contents, fmt.Errorf("archiveList: %w", err)
 	}
@@ -187,11 +848,35 @@ func (c *Converter) archiveList(fileName string) ([]string, error) {
 		return contents, fmt.Errorf("archiveList: %w", err)
 	}
 
+	if err = c.archiveLimits(contents); err != nil {
+		return nil, fmt.Errorf("archiveList: %w", err)
+	}
+
+	c.shared.cache.setContents(fileName, contents)
+
 	return contents, nil
 }
 
-// archiveComment returns ZIP comment.
+// commentTemplate returns ZIP comment from the template for the input file.
+func (c *Converter) commentTemplate(fileName string) string {
+	if c.Opts.CommentTemplate == "" {
+		return ""
+	}
+
+	return strings.NewReplacer(
+		"{version}", version(),
+		"{input}", filepath.Base(fileName),
+		"{date}", c.now().UTC().Format(time.RFC3339),
+		"{hash}", c.Opts.Hash(),
+	).Replace(c.Opts.CommentTemplate)
+}
+
+// archiveComment returns ZIP comment, other archives have no comment.
 func (c *Converter) archiveComment(fileName string) (string, error) {
+	if archiveKind(fileName) != "zip" {
+		return "", nil
+	}
+
 	zr, err := zip.OpenReader(fileName)
 	if err != nil {
 		return "", fmt.Errorf("archiveComment: %w", err)
@@ -201,8 +886,13 @@ func (c *Converter) archiveComment(fileName string) (string, error) {
 	return zr.Comment, nil
 }
 
-// archiveSetComment sets ZIP comment.
+// archiveSetComment sets ZIP comment, other archives are converted to CBZ.
 func (c *Converter) archiveSetComment(fileName, commentBody string) error {
+	fileName, err := c.archiveZip(fileName)
+	if err != nil {
+		return fmt.Errorf("archiveSetComment: %w", err)
+	}
+
 	zr, err := zip.OpenReader(fileName)
 	if err != nil {
 		return fmt.Errorf("archiveSetComment: %w", err)
@@ -265,8 +955,30 @@ func (c *Converter) archiveSetComment(fileName, commentBody string) error {
 	return nil
 }
 
-// archiveFileAdd adds file to archive.
+// archiveFileAdd adds file to archive, TAR archives are rewritten and other archives are converted to CBZ.
 func (c *Converter) archiveFileAdd(fileName, newFileName string) error {
+	if archiveKind(fileName) == "tar" {
+		data, err := os.ReadFile(newFileName)
+		if err != nil {
+			return fmt.Errorf("archiveFileAdd: %w", err)
+		}
+
+		name := filepath.Base(newFileName)
+		err = c.archiveTarRewrite(fileName, map[string][]byte{name: data}, func(entry string) (bool, error) {
+			return entry == name, nil
+		})
+		if err != nil {
+			return fmt.Errorf("archiveFileAdd: %w", err)
+		}
+
+		return nil
+	}
+
+	fileName, err := c.archiveZip(fileName)
+	if err != nil {
+		return fmt.Errorf("archiveFileAdd: %w", err)
+	}
+
 	zr, err := zip.OpenReader(fileName)
 	if err != nil {
 		return fmt.Errorf("archiveFileAdd: %w", err)
@@ -281,7 +993,10 @@ func (c *Converter) archiveFileAdd(fileName, newFileName string) error {
 	tmpName := zf.Name()
 	defer os.Remove(tmpName)
 
-	zw := zip.NewWriter(zf)
+	zw, err := c.zipWriter(zf)
+	if err != nil {
+		return fmt.Errorf("archiveFileAdd: %w", err)
+	}
 
 	for _, item := range zr.File {
 		if item.Name == newFileName {
@@ -321,7 +1036,12 @@ func (c *Converter) archiveFileAdd(fileName, newFileName string) error {
 		return fmt.Errorf("archiveFileAdd: %w", err)
 	}
 
-	zipInfo.Method = zip.Deflate
+	rules, err := compressionRules(c.Opts.Compression)
+	if err != nil {
+		return fmt.Errorf("archiveFileAdd: %w", err)
+	}
+
+	zipInfo.Method = zipMethod(rules, newFileName)
 	w, err := zw.CreateHeader(zipInfo)
 	if err != nil {
 		return fmt.Errorf("archiveFileAdd: %w", err)
@@ -355,8 +1075,24 @@ func (c *Converter) archiveFileAdd(fileName, newFileName string) error {
 	return nil
 }
 
-// archiveFileRemove removes files from archive.
+// archiveFileRemove removes files from archive, TAR archives are rewritten and other archives are converted to CBZ.
 func (c *Converter) archiveFileRemove(fileName, pattern string) error {
+	if archiveKind(fileName) == "tar" {
+		err := c.archiveTarRewrite(fileName, nil, func(entry string) (bool, error) {
+			return filepath.Match(pattern, entry)
+		})
+		if err != nil {
+			return fmt.Errorf("archiveFileRemove: %w", err)
+		}
+
+		return nil
+	}
+
+	fileName, err := c.archiveZip(fileName)
+	if err != nil {
+		return fmt.Errorf("archiveFileRemove: %w", err)
+	}
+
 	zr, err := zip.OpenReader(fileName)
 	if err != nil {
 		return fmt.Errorf("archiveFileRemove: %w", err)
@@ -423,3 +1159,102 @@ func (c *Converter) archiveFileRemove(fileName, pattern string) error {
 
 	return nil
 }
+
+// archiveFileGet reads file from archive, the name is matched case-insensitively if there is no exact match.
+func (c *Converter) archiveFileGet(fileName, name string) ([]byte, error) {
+	contents, err := c.archiveList(fileName)
+	if err != nil {
+		return nil, fmt.Errorf("archiveFileGet: %w", err)
+	}
+
+	entry := entryMatch(contents, name)
+	if entry == "" {
+		return nil, fmt.Errorf("archiveFileGet: %s: %w", name, os.ErrNotExist)
+	}
+
+	archive, err := c.archiveOpen(fileName)
+	if err != nil {
+		return nil, fmt.Errorf("archiveFileGet: %w", err)
+	}
+	defer archive.Close()
+
+	if err = archive.EntryFor(entry); err != nil {
+		return nil, fmt.Errorf("archiveFileGet: %w", err)
+	}
+
+	data, err := c.archiveRead(archive)
+	if err != nil {
+		return nil, fmt.Errorf("archiveFileGet: %w", err)
+	}
+
+	return data, nil
+}
+
+// entryMatch returns the entry with name, the name is matched case-insensitively if there is no exact match.
+// Empty string is returned if there is no such entry.
+func entryMatch(contents []string, name string) string {
+	entry := ""
+	for _, ct := range contents {
+		if ct == name {
+			return ct
+		}
+
+		if entry == "" && strings.EqualFold(ct, name) {
+			entry = ct
+		}
+	}
+
+	return entry
+}
+
+// archiveFileGetAll extracts files matching the glob pattern to directory and returns the written files.
+// Entry names are sanitized, a number is appended to the name of files that already exist.
+func (c *Converter) archiveFileGetAll(fileName, pattern, dir string) ([]string, error) {
+	contents, err := c.archiveList(fileName)
+	if err != nil {
+		return nil, fmt.Errorf("archiveFileGetAll: %w", err)
+	}
+
+	var entries []string
+	for _, ct := range contents {
+		matched, err := filepath.Match(pattern, ct)
+		if err != nil {
+			return nil, fmt.Errorf("archiveFileGetAll: %w", err)
+		}
+
+		if matched {
+			entries = append(entries, ct)
+		}
+	}
+
+	if len(entries) == 0 {
+		return nil, nil
+	}
+
+	archive, err := c.archiveOpen(fileName)
+	if err != nil {
+		return nil, fmt.Errorf("archiveFileGetAll: %w", err)
+	}
+	defer archive.Close()
+
+	files := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if err = archive.EntryFor(entry); err != nil {
+			return files, fmt.Errorf("archiveFileGetAll: %w", err)
+		}
+
+		data, err := c.archiveRead(archive)
+		if err != nil {
+			return files, fmt.Errorf("archiveFileGetAll: %w", err)
+		}
+
+		name := uniqueName(filepath.Join(dir, entryName(entry)))
+		if err = copyFile(bytes.NewReader(data), name); err != nil {
+			return files, fmt.Errorf("archiveFileGetAll: %w", err)
+		}
+
+		files = append(files, name)
+	}
+
+	return files, nil
+}
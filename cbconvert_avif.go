@@ -0,0 +1,16 @@
+//go:build !noavif
+
+package cbconvert
+
+import (
+	"image"
+	"io"
+
+	"github.com/gen2brain/avif"
+)
+
+func init() {
+	codecs["avif"] = &codec{[]string{".avif"}, "github.com/gen2brain/avif", 65536, func(w io.Writer, img image.Image, quality int) error {
+		return avif.Encode(w, img, avif.Options{Quality: quality, Speed: avif.DefaultSpeed})
+	}}
+}
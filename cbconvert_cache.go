@@ -0,0 +1,125 @@
+package cbconvert
+
+import (
+	"image"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+)
+
+// cacheSize is number of recently used files kept in the cache.
+const cacheSize = 4
+
+// fileKey type, identity of the input file.
+type fileKey struct {
+	name    string
+	size    int64
+	modTime int64
+}
+
+// newFileKey returns identity of the file, directories are not cached since their modification time
+// does not change when the images are modified.
+func newFileKey(fileName string) (fileKey, bool) {
+	stat, err := os.Stat(fileName)
+	if err != nil || stat.IsDir() {
+		return fileKey{}, false
+	}
+
+	abs, err := filepath.Abs(fileName)
+	if err != nil {
+		return fileKey{}, false
+	}
+
+	return fileKey{abs, stat.Size(), stat.ModTime().UnixNano()}, true
+}
+
+// cacheEntry type, work reused between operations on the same file.
+type cacheEntry struct {
+	key fileKey
+	// Archive contents
+	contents []string
+	// Decoded cover and options it was decoded with
+	cover       image.Image
+	coverPolicy string
+}
+
+// cache type, entries of the recently used files (i.e. Preview, Cover and Convert in the GUI), most recent is first.
+type cache struct {
+	mu      sync.Mutex
+	entries []*cacheEntry
+}
+
+// entry returns entry for the file, with create the missing entry is added and the oldest is evicted.
+func (c *cache) entry(fileName string, create bool) *cacheEntry {
+	key, ok := newFileKey(fileName)
+	if !ok {
+		return nil
+	}
+
+	idx := slices.IndexFunc(c.entries, func(e *cacheEntry) bool {
+		return e.key == key
+	})
+
+	var e *cacheEntry
+	switch {
+	case idx >= 0:
+		e = c.entries[idx]
+		c.entries = slices.Delete(c.entries, idx, idx+1)
+	case create:
+		e = &cacheEntry{key: key}
+	default:
+		return nil
+	}
+
+	c.entries = slices.Insert(c.entries, 0, e)
+	if len(c.entries) > cacheSize {
+		c.entries = c.entries[:cacheSize]
+	}
+
+	return e
+}
+
+// contents returns cached archive contents.
+func (c *cache) contents(fileName string) []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e := c.entry(fileName, false); e != nil {
+		return e.contents
+	}
+
+	return nil
+}
+
+// setContents caches archive contents.
+func (c *cache) setContents(fileName string, contents []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e := c.entry(fileName, true); e != nil {
+		e.contents = contents
+	}
+}
+
+// cover returns cached cover decoded with the policy.
+func (c *cache) cover(fileName, policy string) image.Image {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e := c.entry(fileName, false); e != nil && e.coverPolicy == policy {
+		return e.cover
+	}
+
+	return nil
+}
+
+// setCover caches decoded cover.
+func (c *cache) setCover(fileName, policy string, cover image.Image) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e := c.entry(fileName, true); e != nil {
+		e.cover, e.coverPolicy = cover, policy
+	}
+}
@@ -0,0 +1,146 @@
+package cbconvert
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"sync/atomic"
+)
+
+// checkpoint type, pages converted before the conversion was canceled.
+type checkpoint struct {
+	mu   sync.Mutex
+	path string
+	done map[string]bool
+}
+
+// checkpointFile type, saved next to the kept workdir.
+type checkpointFile struct {
+	Input string   `json:"input"`
+	Pages []string `json:"pages"`
+}
+
+// workdir creates working directory. With Options.Checkpoint the directory is derived from the input
+// and the options, pages recorded by the canceled conversion of the same input are loaded.
+func (c *Converter) workdir(fileName string) (string, error) {
+	c.job.checkpoint = nil
+
+	if !c.Opts.Checkpoint {
+		dir, err := os.MkdirTemp(os.TempDir(), "cbc")
+		if err != nil {
+			return "", fmt.Errorf("workdir: %w", err)
+		}
+
+		return dir, nil
+	}
+
+	path, err := filepath.Abs(fileName)
+	if err != nil {
+		return "", fmt.Errorf("workdir: %w", err)
+	}
+
+	stat, err := os.Stat(path)
+	if err != nil {
+		return "", fmt.Errorf("workdir: %w", err)
+	}
+
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s %d %d %s", path, stat.Size(), stat.ModTime().UnixNano(), c.Opts.Hash())))
+	dir := filepath.Join(os.TempDir(), "cbc-checkpoint-"+hex.EncodeToString(sum[:8]))
+
+	c.job.checkpoint = &checkpoint{path: dir + ".json", done: make(map[string]bool)}
+
+	if data, err := os.ReadFile(c.job.checkpoint.path); err == nil {
+		var cf checkpointFile
+		if err := json.Unmarshal(data, &cf); err == nil {
+			for _, page := range cf.Pages {
+				c.job.checkpoint.done[page] = true
+			}
+
+			c.log(LogNormal, "resuming from checkpoint", "file", fileName, "pages", len(cf.Pages))
+		}
+	} else {
+		// pages of the interrupted run without checkpoint file are not trusted
+		if err := os.RemoveAll(dir); err != nil {
+			return "", fmt.Errorf("workdir: %w", err)
+		}
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("workdir: %w", err)
+	}
+
+	return dir, nil
+}
+
+// checkpointSkip reports if the page was converted before the checkpoint, progress is updated for the skipped page.
+func (c *Converter) checkpointSkip(page string) bool {
+	if c.job.checkpoint == nil {
+		return false
+	}
+
+	c.job.checkpoint.mu.Lock()
+	done := c.job.checkpoint.done[page]
+	c.job.checkpoint.mu.Unlock()
+
+	if done {
+		atomic.AddInt32(&c.job.CurrContent, 1)
+		if c.OnProgress != nil {
+			c.OnProgress(c.job)
+		}
+	}
+
+	return done
+}
+
+// checkpointAdd records converted page.
+func (c *Converter) checkpointAdd(page string) {
+	if c.job.checkpoint == nil {
+		return
+	}
+
+	c.job.checkpoint.mu.Lock()
+	defer c.job.checkpoint.mu.Unlock()
+
+	c.job.checkpoint.done[page] = true
+}
+
+// checkpointSave writes converted pages, the workdir is kept for the next run.
+func (c *Converter) checkpointSave(fileName string) error {
+	c.job.checkpoint.mu.Lock()
+	defer c.job.checkpoint.mu.Unlock()
+
+	cf := checkpointFile{Input: fileName, Pages: make([]string, 0, len(c.job.checkpoint.done))}
+	for page := range c.job.checkpoint.done {
+		cf.Pages = append(cf.Pages, page)
+	}
+	slices.Sort(cf.Pages)
+
+	data, err := json.MarshalIndent(cf, "", "  ")
+	if err != nil {
+		return fmt.Errorf("checkpointSave: %w", err)
+	}
+
+	if err := os.WriteFile(c.job.checkpoint.path, data, 0644); err != nil {
+		return fmt.Errorf("checkpointSave: %w", err)
+	}
+
+	return nil
+}
+
+// checkpointRemove removes the checkpoint file after successful conversion.
+func (c *Converter) checkpointRemove() {
+	if c.job.checkpoint == nil {
+		return
+	}
+
+	if err := os.Remove(c.job.checkpoint.path); err != nil && !os.IsNotExist(err) {
+		c.log(LogVerbose, "checkpoint not removed", "path", c.job.checkpoint.path, "error", err)
+	}
+
+	c.job.checkpoint = nil
+}
@@ -0,0 +1,179 @@
+package cbconvert
+
+import (
+	"image"
+	_ "image/gif"
+	"image/png"
+	"io"
+	"os/exec"
+	"runtime"
+	"runtime/debug"
+	"slices"
+
+	"github.com/gen2brain/jpegli"
+	"github.com/jsummers/gobmp"
+	"golang.org/x/image/tiff"
+)
+
+// Codec type, image format or input type available in this build.
+type Codec struct {
+	// Name, i.e. webp, archive, document
+	Name string
+	// File extensions, i.e. .jpg, .jpeg
+	Extensions []string
+	// Input is supported
+	Decode bool
+	// Can be used as output image format
+	Encode bool
+	// Go module that implements the codec, i.e. github.com/gen2brain/webp
+	Module string
+	// Module version from build info
+	Version string
+}
+
+// encoder encodes image with given quality.
+type encoder func(w io.Writer, img image.Image, quality int) error
+
+// codec type, registered image format.
+type codec struct {
+	extensions []string
+	module     string
+	// maximum width and height the encoder supports, 0 if there is no practical limit
+	maxSize int
+	// nil for formats that can only be decoded
+	encode encoder
+}
+
+// imageFormats are image formats in order of preference.
+var imageFormats = []string{"jpeg", "png", "tiff", "bmp", "gif", "webp", "avif", "jxl"}
+
+// codecs by image format, optional formats are registered in files that are excluded with
+// nowebp, noavif and nojxl build tags.
+var codecs = map[string]*codec{
+	"jpeg": {[]string{".jpg", ".jpeg"}, "github.com/gen2brain/jpegli", 65535, func(w io.Writer, img image.Image, quality int) error {
+		opts := &jpegli.EncodingOptions{}
+		opts.Quality = quality
+		opts.ChromaSubsampling = image.YCbCrSubsampleRatio420
+		opts.ProgressiveLevel = 2
+		opts.AdaptiveQuantization = true
+		opts.DCTMethod = jpegli.DefaultDCTMethod
+
+		return jpegli.Encode(w, img, opts)
+	}},
+	"png": {[]string{".png"}, "std", 0, func(w io.Writer, img image.Image, _ int) error {
+		return png.Encode(w, img)
+	}},
+	"tiff": {[]string{".tiff", ".tif"}, "golang.org/x/image", 0, func(w io.Writer, img image.Image, _ int) error {
+		return tiff.Encode(w, img, &tiff.Options{Compression: tiff.Uncompressed})
+	}},
+	"bmp": {[]string{".bmp"}, "github.com/jsummers/gobmp", 0, func(w io.Writer, img image.Image, _ int) error {
+		opts := &gobmp.EncoderOptions{}
+		opts.SupportTransparency(false)
+
+		return gobmp.EncodeWithOptions(w, imageToPaletted(img), opts)
+	}},
+	"gif": {[]string{".gif"}, "std", 65535, nil},
+}
+
+// webpFrames decodes frames of animated WEBP, it is nil if built with nowebp tag.
+var webpFrames func(data []byte) ([]image.Image, error)
+
+// encoderFor returns encoder for the image format, nil if the format is not available.
+func encoderFor(format string) encoder {
+	if cd, ok := codecs[format]; ok {
+		return cd.encode
+	}
+
+	return nil
+}
+
+// SupportedFormats returns output image formats available in this build, auto format is last.
+func SupportedFormats() []Codec {
+	var formats []Codec
+	for _, c := range SupportedInputs() {
+		if c.Encode {
+			formats = append(formats, c)
+		}
+	}
+
+	return append(formats, Codec{Name: "auto", Encode: true})
+}
+
+// SupportedInputs returns image formats, archives and documents that can be read in this build.
+func SupportedInputs() []Codec {
+	var inputs []Codec
+	for _, format := range imageFormats {
+		cd, ok := codecs[format]
+		if !ok {
+			continue
+		}
+
+		inputs = append(inputs, Codec{
+			Name:       format,
+			Extensions: slices.Clone(cd.extensions),
+			Decode:     true,
+			Encode:     cd.encode != nil,
+			Module:     cd.module,
+			Version:    moduleVersion(cd.module),
+		})
+	}
+
+	inputs = append(inputs, Codec{
+		Name:       "archive",
+		Extensions: slices.Clone(archiveTypes),
+		Decode:     true,
+		Module:     "github.com/gen2brain/go-unarr",
+		Version:    moduleVersion("github.com/gen2brain/go-unarr"),
+	})
+
+	if documents {
+		inputs = append(inputs, Codec{
+			Name:       "document",
+			Extensions: slices.Clone(documentTypes),
+			Decode:     true,
+			Module:     documentModule,
+			Version:    moduleVersion(documentModule),
+		})
+	}
+
+	if _, err := exec.LookPath("ddjvu"); err == nil {
+		inputs = append(inputs, Codec{
+			Name:       "djvu",
+			Extensions: slices.Clone(djvuTypes),
+			Decode:     true,
+			Module:     "ddjvu (DjVuLibre)",
+		})
+	}
+
+	inputs = append(inputs, pluginCodecs()...)
+
+	return inputs
+}
+
+// moduleVersion returns version of the module from build info, Go version for the standard library.
+func moduleVersion(path string) string {
+	if path == "std" {
+		return runtime.Version()
+	}
+
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "(devel)"
+	}
+
+	if info.Main.Path == path {
+		return info.Main.Version
+	}
+
+	for _, dep := range info.Deps {
+		if dep.Path == path {
+			if dep.Replace != nil {
+				return dep.Replace.Version
+			}
+
+			return dep.Version
+		}
+	}
+
+	return "(devel)"
+}
@@ -0,0 +1,408 @@
+package cbconvert
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/fvbommel/sortorder"
+	"github.com/gen2brain/cbconvert/meta"
+)
+
+// reTileSuffix matches name of tile or frame, i.e. 001_002.
+var reTileSuffix = regexp.MustCompile(`^(.+)_(\d{3})$`)
+
+// ComicInfo type, ComicRack metadata (ComicInfo.xml), parsed and written with package meta.
+type ComicInfo = meta.ComicInfo
+
+// ComicInfoPage type, page in the ComicInfo page table.
+type ComicInfoPage = meta.Page
+
+// comicInfoName returns ComicInfo.xml file name in workdir, or empty string if there is none.
+func (c *Converter) comicInfoName() string {
+	files, err := os.ReadDir(c.job.workdir)
+	if err != nil {
+		return ""
+	}
+
+	for _, file := range files {
+		if strings.EqualFold(file.Name(), "comicinfo.xml") {
+			return file.Name()
+		}
+	}
+
+	return ""
+}
+
+// comicInfoWrite writes ComicInfo.xml with the page table to workdir, existing file is updated. Pages of the existing
+// page table are matched to the converted images by name, page types and bookmarks are kept and sizes are updated.
+// Existing file without page table is kept as is unless Options.ComicInfo is set.
+func (c *Converter) comicInfoWrite() error {
+	var info ComicInfo
+	var existing []byte
+
+	name := c.comicInfoName()
+	if name != "" {
+		var err error
+		existing, err = os.ReadFile(filepath.Join(c.job.workdir, name))
+		if err != nil {
+			return fmt.Errorf("comicInfoWrite: %w", err)
+		}
+
+		info, err = meta.Parse(existing)
+		if err != nil {
+			if !c.Opts.ComicInfo {
+				// invalid file is kept as is
+				c.log(LogVerbose, "ComicInfo.xml not updated", "error", err)
+
+				return nil
+			}
+
+			return fmt.Errorf("comicInfoWrite: %w", err)
+		}
+
+		if len(info.Pages) == 0 && !c.Opts.ComicInfo {
+			return nil
+		}
+	} else {
+		name = "ComicInfo.xml"
+	}
+
+	files, err := c.workdirFiles()
+	if err != nil {
+		return fmt.Errorf("comicInfoWrite: %w", err)
+	}
+
+	images := make([]string, 0)
+	for _, file := range files {
+		if isImage(file.Name()) {
+			images = append(images, file.Name())
+		}
+	}
+
+	cover := c.coverName(images)
+
+	bookmarks := make(map[string]string)
+	for chapter, page := range c.job.chapters {
+		bookmarks[page] = chapter
+	}
+
+	// pages of the existing page table by source image name without extension
+	source := make(map[string]ComicInfoPage)
+	for _, page := range info.Pages {
+		if page.Image >= 0 && page.Image < len(c.job.pages) {
+			source[baseNoExt(entryName(c.job.pages[page.Image]))] = page
+		}
+	}
+
+	info.Pages = make([]ComicInfoPage, 0, len(images))
+	for idx, img := range images {
+		page := ComicInfoPage{Image: idx}
+
+		if src, first, ok := comicInfoSource(source, baseNoExt(img)); ok {
+			page.Type, page.DoublePage, page.Bookmark = src.Type, src.DoublePage, src.Bookmark
+			if !first {
+				// tiles and frames after the first one
+				page.Bookmark = ""
+				if page.Type == "FrontCover" {
+					page.Type = ""
+				}
+			}
+		} else if img == cover {
+			page.Type = "FrontCover"
+		}
+
+		if chapter, ok := bookmarks[baseNoExt(img)]; ok && page.Bookmark == "" {
+			page.Bookmark = chapter
+		}
+
+		data, err := os.ReadFile(filepath.Join(c.job.workdir, img))
+		if err != nil {
+			return fmt.Errorf("comicInfoWrite: %w", err)
+		}
+
+		page.ImageSize = int64(len(data))
+		page.ImageWidth, page.ImageHeight, _ = imageDimensions(bytes.NewReader(data))
+
+		info.Pages = append(info.Pages, page)
+	}
+
+	info.PageCount = len(images)
+
+	data, err := meta.Marshal(existing, info)
+	if err != nil {
+		return fmt.Errorf("comicInfoWrite: %w", err)
+	}
+
+	err = os.WriteFile(filepath.Join(c.job.workdir, name), data, 0644)
+	if err != nil {
+		return fmt.Errorf("comicInfoWrite: %w", err)
+	}
+
+	return nil
+}
+
+// comicInfoNameWrite writes ComicInfo.xml with the fields parsed from the input file name to workdir,
+// fields of the existing file are set only if they are empty.
+func (c *Converter) comicInfoNameWrite(fileName string) error {
+	parsed, ok := nameComicInfo(fileName)
+	if !ok {
+		c.log(LogVerbose, "no series in file name", "file", fileName)
+
+		return nil
+	}
+
+	var info ComicInfo
+	var existing []byte
+
+	name := c.comicInfoName()
+	if name != "" {
+		var err error
+		existing, err = os.ReadFile(filepath.Join(c.job.workdir, name))
+		if err != nil {
+			return fmt.Errorf("comicInfoNameWrite: %w", err)
+		}
+
+		info, err = meta.Parse(existing)
+		if err != nil {
+			return fmt.Errorf("comicInfoNameWrite: %w", err)
+		}
+	} else {
+		name = "ComicInfo.xml"
+	}
+
+	if info.Series == "" {
+		info.Series = parsed.Series
+	}
+
+	if info.Number == "" {
+		info.Number = parsed.Number
+	}
+
+	if info.Volume == 0 {
+		info.Volume = parsed.Volume
+	}
+
+	if info.Year == 0 {
+		info.Year = parsed.Year
+	}
+
+	data, err := meta.Marshal(existing, info)
+	if err != nil {
+		return fmt.Errorf("comicInfoNameWrite: %w", err)
+	}
+
+	err = os.WriteFile(filepath.Join(c.job.workdir, name), data, 0644)
+	if err != nil {
+		return fmt.Errorf("comicInfoNameWrite: %w", err)
+	}
+
+	return nil
+}
+
+// archiveComicInfoSet sets ComicInfo.xml fields from Options.SetTitle, Options.SetSeries, Options.SetNumber and
+// Options.SetWriter in ZIP archive, the file is created if there is none. Images are not touched.
+func (c *Converter) archiveComicInfoSet(fileName string) error {
+	return c.archiveComicInfoUpdate(fileName, func(info *ComicInfo) error {
+		for _, field := range []struct {
+			dst *string
+			src string
+		}{
+			{&info.Title, c.Opts.SetTitle},
+			{&info.Series, c.Opts.SetSeries},
+			{&info.Number, c.Opts.SetNumber},
+			{&info.Writer, c.Opts.SetWriter},
+		} {
+			if field.src != "" {
+				*field.dst = field.src
+			}
+		}
+
+		return nil
+	})
+}
+
+// archiveComicInfoFetch fetches ComicInfo.xml fields from ComicVine and writes them to ZIP archive, the file is created
+// if there is none. Series and number are parsed from the file name if the existing file has no series.
+func (c *Converter) archiveComicInfoFetch(fileName string) ([]string, error) {
+	var fetched ComicInfo
+
+	err := c.archiveComicInfoUpdate(fileName, func(info *ComicInfo) error {
+		var err error
+		fetched, err = c.comicInfoFetch(fileName, *info)
+		if err != nil {
+			return err
+		}
+
+		*info = fetched
+
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("archiveComicInfoFetch: %w", err)
+	}
+
+	return []string{fmt.Sprintf("%s: %s #%s (%d) %s", fileName, fetched.Series, fetched.Number, fetched.Year, fetched.Web)}, nil
+}
+
+// archiveComicInfoUpdate reads ComicInfo.xml from archive, calls fn and writes the result back, other entries are not touched.
+func (c *Converter) archiveComicInfoUpdate(fileName string, fn func(info *ComicInfo) error) error {
+	contents, err := c.archiveList(fileName)
+	if err != nil {
+		return fmt.Errorf("archiveComicInfoUpdate: %w", err)
+	}
+
+	var info ComicInfo
+	var existing []byte
+
+	entry := entryMatch(contents, "ComicInfo.xml")
+	if entry != "" {
+		existing, err = c.archiveFileGet(fileName, entry)
+		if err != nil {
+			return fmt.Errorf("archiveComicInfoUpdate: %w", err)
+		}
+
+		info, err = meta.Parse(existing)
+		if err != nil {
+			return fmt.Errorf("archiveComicInfoUpdate: %s: %w", entry, err)
+		}
+	} else {
+		entry = "ComicInfo.xml"
+	}
+
+	if err := fn(&info); err != nil {
+		return fmt.Errorf("archiveComicInfoUpdate: %w", err)
+	}
+
+	data, err := meta.Marshal(existing, info)
+	if err != nil {
+		return fmt.Errorf("archiveComicInfoUpdate: %w", err)
+	}
+
+	if err := c.archiveFileReplace(fileName, entry, data); err != nil {
+		return fmt.Errorf("archiveComicInfoUpdate: %w", err)
+	}
+
+	return nil
+}
+
+// comicInfoFetch returns info with the fields fetched from ComicVine, series, number and year are parsed from
+// the file name if info has no series.
+func (c *Converter) comicInfoFetch(fileName string, info ComicInfo) (ComicInfo, error) {
+	query := info
+	if query.Series == "" {
+		if parsed, ok := nameComicInfo(fileName); ok {
+			query.Series, query.Number, query.Year = parsed.Series, parsed.Number, parsed.Year
+		}
+	}
+
+	fetched, err := c.comicVineFetch(context.Background(), query)
+	if err != nil {
+		return info, fmt.Errorf("comicInfoFetch: %w", err)
+	}
+
+	return fetched, nil
+}
+
+// comicInfoFetchWrite writes ComicInfo.xml with the fields fetched from ComicVine to workdir, the page table and
+// other fields of the existing file are kept.
+func (c *Converter) comicInfoFetchWrite(fileName string) error {
+	var info ComicInfo
+	var existing []byte
+
+	name := c.comicInfoName()
+	if name != "" {
+		var err error
+		existing, err = os.ReadFile(filepath.Join(c.job.workdir, name))
+		if err != nil {
+			return fmt.Errorf("comicInfoFetchWrite: %w", err)
+		}
+
+		info, err = meta.Parse(existing)
+		if err != nil {
+			return fmt.Errorf("comicInfoFetchWrite: %w", err)
+		}
+	} else {
+		name = "ComicInfo.xml"
+	}
+
+	info, err := c.comicInfoFetch(fileName, info)
+	if err != nil {
+		return fmt.Errorf("comicInfoFetchWrite: %w", err)
+	}
+
+	data, err := meta.Marshal(existing, info)
+	if err != nil {
+		return fmt.Errorf("comicInfoFetchWrite: %w", err)
+	}
+
+	err = os.WriteFile(filepath.Join(c.job.workdir, name), data, 0644)
+	if err != nil {
+		return fmt.Errorf("comicInfoFetchWrite: %w", err)
+	}
+
+	return nil
+}
+
+// comicInfoSource returns the source page of the converted image, tiles and frames (i.e. 001_002) are matched to
+// the page they were split from (001). It reports if the image is the first part of the page.
+func comicInfoSource(source map[string]ComicInfoPage, stem string) (ComicInfoPage, bool, bool) {
+	if page, ok := source[stem]; ok {
+		return page, true, true
+	}
+
+	if m := reTileSuffix.FindStringSubmatch(stem); m != nil {
+		if page, ok := source[m[1]]; ok {
+			return page, m[2] == "001", true
+		}
+	}
+
+	return ComicInfoPage{}, false, false
+}
+
+// sortedPages returns images in natural order, as the pages are indexed in the ComicInfo page table.
+func sortedPages(images []string) []string {
+	pages := slices.Clone(images)
+	sort.SliceStable(pages, func(i, j int) bool {
+		return sortorder.NaturalLess(pages[i], pages[j])
+	})
+
+	return pages
+}
+
+// keepNonImage checks if non-image file is kept in the converted archive.
+func (c *Converter) keepNonImage(name string) bool {
+	if strings.EqualFold(entryName(name), "comicinfo.xml") {
+		return !c.Opts.NoComicInfo || c.Opts.ComicInfo
+	}
+
+	return !c.Opts.NoNonImage
+}
+
+// chapterAdd records the chapter of the image, the chapter title is the image directory relative to root.
+func (c *Converter) chapterAdd(pathName, root string) {
+	rel, err := filepath.Rel(root, pathName)
+	if err != nil {
+		return
+	}
+
+	dir := filepath.ToSlash(filepath.Dir(rel))
+	if dir == "." || dir == "/" {
+		return
+	}
+
+	if c.job.chapters == nil {
+		c.job.chapters = make(map[string]string)
+	}
+
+	page := baseNoExt(entryName(pathName))
+	if first, ok := c.job.chapters[dir]; !ok || sortorder.NaturalLess(page, first) {
+		c.job.chapters[dir] = page
+	}
+}
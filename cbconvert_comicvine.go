@@ -0,0 +1,304 @@
+package cbconvert
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// comicVineURL is the ComicVine API endpoint.
+const comicVineURL = "https://comicvine.gamespot.com/api"
+
+// comicVineInterval is minimum time between requests, ComicVine blocks clients that send requests too fast.
+const comicVineInterval = time.Second
+
+// comicVineVolumes is maximum number of matching volumes (series) searched for the issue.
+const comicVineVolumes = 3
+
+// ErrNotFound is returned when the issue is not found by the metadata provider.
+var ErrNotFound = errors.New("not found")
+
+// reHTMLTag matches HTML tag, ComicVine descriptions are HTML.
+var reHTMLTag = regexp.MustCompile(`<[^>]*>`)
+
+// comicVine type, ComicVine API client.
+type comicVine struct {
+	url      string
+	interval time.Duration
+	client   *http.Client
+
+	mu sync.Mutex
+	// Start time of the last scheduled request
+	last time.Time
+}
+
+// comicVineVolume type, ComicVine volume (series).
+type comicVineVolume struct {
+	ID        int    `json:"id"`
+	Name      string `json:"name"`
+	StartYear string `json:"start_year"`
+	Publisher *struct {
+		Name string `json:"name"`
+	} `json:"publisher"`
+}
+
+// comicVineIssue type, ComicVine issue.
+type comicVineIssue struct {
+	ID            int    `json:"id"`
+	Name          string `json:"name"`
+	IssueNumber   string `json:"issue_number"`
+	CoverDate     string `json:"cover_date"`
+	Description   string `json:"description"`
+	SiteDetailURL string `json:"site_detail_url"`
+	PersonCredits []struct {
+		Name string `json:"name"`
+		Role string `json:"role"`
+	} `json:"person_credits"`
+}
+
+// comicVineClient returns ComicVine API client.
+func (c *Converter) comicVineClient() *comicVine {
+	c.shared.mu.Lock()
+	defer c.shared.mu.Unlock()
+
+	if c.shared.comicVine == nil {
+		c.shared.comicVine = &comicVine{url: comicVineURL, interval: comicVineInterval, client: &http.Client{Timeout: 30 * time.Second}}
+	}
+
+	return c.shared.comicVine
+}
+
+// comicVineGet requests resource with params and decodes results into v, requests are started at least the interval apart.
+func (c *Converter) comicVineGet(ctx context.Context, resource string, params url.Values, v any) error {
+	if c.Opts.ComicVineKey == "" {
+		return fmt.Errorf("comicVineGet: ComicVine API key is not set")
+	}
+
+	cv := c.comicVineClient()
+
+	// the request is scheduled after the last one, other requests are not blocked while it waits
+	cv.mu.Lock()
+	start := cv.last.Add(cv.interval)
+	if now := time.Now(); start.Before(now) {
+		start = now
+	}
+	cv.last = start
+	cv.mu.Unlock()
+
+	if wait := time.Until(start); wait > 0 {
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return fmt.Errorf("comicVineGet: %w", ctx.Err())
+		}
+	}
+
+	params.Set("api_key", c.Opts.ComicVineKey)
+	params.Set("format", "json")
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, cv.url+"/"+resource+"/?"+params.Encode(), nil)
+	if err != nil {
+		return fmt.Errorf("comicVineGet: %w", err)
+	}
+
+	// requests with the default user agent are rejected
+	req.Header.Set("User-Agent", "cbconvert")
+
+	c.log(LogDebug, "comicvine request", "resource", resource)
+
+	resp, err := cv.client.Do(req)
+	if err != nil {
+		// key is part of the URL
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+
+		return fmt.Errorf("comicVineGet: %s: %w", resource, err)
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		Error      string          `json:"error"`
+		StatusCode int             `json:"status_code"`
+		Results    json.RawMessage `json:"results"`
+	}
+
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 16<<20)).Decode(&body); err != nil {
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("comicVineGet: %s: %s", resource, resp.Status)
+		}
+
+		return fmt.Errorf("comicVineGet: %s: %w", resource, err)
+	}
+
+	// status code 1 is OK, i.e. 100 is invalid API key, 107 is rate limit exceeded
+	if body.StatusCode != 1 {
+		return fmt.Errorf("comicVineGet: %s: %s", resource, body.Error)
+	}
+
+	if err := json.Unmarshal(body.Results, v); err != nil {
+		return fmt.Errorf("comicVineGet: %s: %w", resource, err)
+	}
+
+	return nil
+}
+
+// comicVineFetch looks up the issue by Series, Number and Year of info on ComicVine and returns info with the fetched fields,
+// ErrNotFound is returned if there is no such issue.
+func (c *Converter) comicVineFetch(ctx context.Context, info ComicInfo) (ComicInfo, error) {
+	if info.Series == "" || info.Number == "" {
+		return info, fmt.Errorf("comicVineFetch: series and number are required: %w", ErrNotFound)
+	}
+
+	// the number is a value of the filter, comma and colon are its separators
+	if strings.ContainsAny(info.Number, ",:") {
+		return info, fmt.Errorf("comicVineFetch: invalid issue number %q: %w", info.Number, ErrNotFound)
+	}
+
+	var volumes []comicVineVolume
+	err := c.comicVineGet(ctx, "search", url.Values{
+		"resources":  {"volume"},
+		"query":      {info.Series},
+		"field_list": {"id,name,start_year,publisher"},
+		"limit":      {"20"},
+	}, &volumes)
+	if err != nil {
+		return info, fmt.Errorf("comicVineFetch: %w", err)
+	}
+
+	for _, volume := range comicVineRank(volumes, info.Series, info.Year) {
+		var issues []comicVineIssue
+		err := c.comicVineGet(ctx, "issues", url.Values{
+			"filter":     {fmt.Sprintf("volume:%d,issue_number:%s", volume.ID, info.Number)},
+			"field_list": {"id,name,issue_number,cover_date,description,site_detail_url"},
+			"limit":      {"1"},
+		}, &issues)
+		if err != nil {
+			return info, fmt.Errorf("comicVineFetch: %w", err)
+		}
+
+		if len(issues) == 0 {
+			continue
+		}
+
+		issue := issues[0]
+
+		// credits are returned only for the single issue
+		var details comicVineIssue
+		err = c.comicVineGet(ctx, fmt.Sprintf("issue/4000-%d", issue.ID), url.Values{"field_list": {"person_credits"}}, &details)
+		if err != nil {
+			return info, fmt.Errorf("comicVineFetch: %w", err)
+		}
+
+		issue.PersonCredits = details.PersonCredits
+
+		return comicVineInfo(info, volume, issue), nil
+	}
+
+	return info, fmt.Errorf("comicVineFetch: %s #%s: %w", info.Series, info.Number, ErrNotFound)
+}
+
+// comicVineRank returns volumes named as series, the volume that started closest before the year is the first.
+func comicVineRank(volumes []comicVineVolume, series string, year int) []comicVineVolume {
+	normalize := func(s string) string {
+		return strings.Join(strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+			return !('a' <= r && r <= 'z' || '0' <= r && r <= '9' || r > 0x7f)
+		}), " ")
+	}
+
+	var ranked []comicVineVolume
+	for _, volume := range volumes {
+		if normalize(volume.Name) == normalize(series) {
+			ranked = append(ranked, volume)
+		}
+	}
+
+	if year > 0 {
+		distance := func(v comicVineVolume) int {
+			start, err := strconv.Atoi(v.StartYear)
+			if err != nil || start > year {
+				return 1 << 16
+			}
+
+			return year - start
+		}
+
+		// search order is kept for volumes with the same distance
+		slices.SortStableFunc(ranked, func(a, b comicVineVolume) int {
+			return distance(a) - distance(b)
+		})
+	}
+
+	if len(ranked) > comicVineVolumes {
+		ranked = ranked[:comicVineVolumes]
+	}
+
+	return ranked
+}
+
+// comicVineInfo returns info with the fields of the ComicVine volume and issue, the page table and other fields are kept.
+func comicVineInfo(info ComicInfo, volume comicVineVolume, issue comicVineIssue) ComicInfo {
+	info.Series = volume.Name
+	info.Number = issue.IssueNumber
+
+	if volume.Publisher != nil && volume.Publisher.Name != "" {
+		info.Publisher = volume.Publisher.Name
+	}
+
+	if issue.Name != "" {
+		info.Title = issue.Name
+	}
+
+	if summary := strings.TrimSpace(html.UnescapeString(reHTMLTag.ReplaceAllString(issue.Description, " "))); summary != "" {
+		info.Summary = strings.Join(strings.Fields(summary), " ")
+	}
+
+	if date, err := time.Parse(time.DateOnly, issue.CoverDate); err == nil {
+		info.Year, info.Month, info.Day = date.Year(), int(date.Month()), date.Day()
+	}
+
+	credits := make(map[string][]string)
+	for _, credit := range issue.PersonCredits {
+		for _, role := range strings.Split(credit.Role, ",") {
+			role = strings.TrimSpace(role)
+			if role == "artist" {
+				role = "penciler"
+			}
+
+			if !slices.Contains(credits[role], credit.Name) {
+				credits[role] = append(credits[role], credit.Name)
+			}
+		}
+	}
+
+	for role, field := range map[string]*string{
+		"writer":   &info.Writer,
+		"penciler": &info.Penciller,
+		"inker":    &info.Inker,
+		"colorist": &info.Colorist,
+		"letterer": &info.Letterer,
+		"cover":    &info.CoverArtist,
+		"editor":   &info.Editor,
+	} {
+		if names := credits[role]; len(names) > 0 {
+			*field = strings.Join(names, ", ")
+		}
+	}
+
+	info.Web = issue.SiteDetailURL
+	info.Notes = fmt.Sprintf("Tagged with cbconvert using info from ComicVine [Issue ID %d]", issue.ID)
+
+	return info
+}
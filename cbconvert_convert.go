@@ -6,64 +6,109 @@ import (
 	"errors"
 	"fmt"
 	"image"
-	"image/png"
+	"image/gif"
 	"io"
+	"math"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"runtime"
+	"slices"
 	"strings"
 	"sync/atomic"
+	"time"
 
-	"github.com/gen2brain/avif"
-	"github.com/gen2brain/go-fitz"
-	"github.com/gen2brain/go-unarr"
-	"github.com/gen2brain/jpegli"
-	"github.com/gen2brain/jpegxl"
-	"github.com/gen2brain/webp"
-	"github.com/jsummers/gobmp"
-	"golang.org/x/image/tiff"
 	"golang.org/x/sync/errgroup"
 )
 
 // convertDocument converts PDF/EPUB document to CBZ.
 func (c *Converter) convertDocument(ctx context.Context, fileName string) error {
-	var err error
-
-	c.Workdir, err = os.MkdirTemp(os.TempDir(), "cbc")
+	workdir, err := c.workdir(fileName)
 	if err != nil {
 		return fmt.Errorf("convertDocument: %w", err)
 	}
 
-	doc, err := fitz.New(fileName)
+	c.setWorkdir(workdir)
+
+	doc, err := openDocument(fileName)
 	if err != nil {
 		return fmt.Errorf("convertDocument: %w", err)
 	}
 
-	defer doc.Close()
+	// closed when the last render returns
+	rendered := make(chan struct{})
+	close(rendered)
+
+	defer func() {
+		closeDocument(doc, rendered)
+	}()
 
-	c.Ncontents = doc.NumPage()
-	c.CurrContent = 0
+	c.job.Ncontents = doc.NumPage()
+	c.job.CurrContent = 0
+
+	c.job.chapters = nil
+	c.job.pages = nil
+	if toc, err := doc.ToC(); err == nil {
+		for _, outline := range toc {
+			if outline.Level == 1 && outline.Page >= 0 {
+				if c.job.chapters == nil {
+					c.job.chapters = make(map[string]string)
+				}
+
+				c.job.chapters[outline.Title] = fmt.Sprintf("%03d", outline.Page)
+			}
+		}
+	}
 
 	if c.OnStart != nil {
-		c.OnStart()
+		c.OnStart(c.job)
 	}
 
 	eg, ctx := errgroup.WithContext(ctx)
-	eg.SetLimit(runtime.NumCPU() + 1)
+	eg.SetLimit(c.workers())
 
-	for n := 0; n < c.Ncontents; n++ {
+	for n := 0; n < c.job.Ncontents; n++ {
 		if ctx.Err() != nil {
+			// pages in progress are finished, they are recorded in the checkpoint
+			_ = eg.Wait()
+
 			return fmt.Errorf("convertDocument: %w", ctx.Err())
 		}
 
-		img, err := doc.Image(n)
-		if err != nil {
-			return fmt.Errorf("convertDocument: %w", err)
+		page := fmt.Sprintf("%03d", n)
+		if c.checkpointSkip(page) {
+			continue
+		}
+
+		var img image.Image
+		if n == 0 && (c.Opts.CoverPage == "" || c.Opts.CoverPage == "1") {
+			// cover rendered by Cover, Thumbnail or Preview
+			img = c.shared.cache.cover(fileName, c.coverPolicy())
+		}
+
+		if img == nil {
+			done := make(chan struct{})
+			rendered = done
+
+			img, err = pageTimeout(ctx, c.Opts.PageTimeout, fmt.Sprintf("%03d", n), func() (image.Image, error) {
+				defer close(done)
+
+				return c.documentImage(doc, n)
+			})
+			if err != nil {
+				return fmt.Errorf("convertDocument: %w", err)
+			}
 		}
 
 		if img != nil {
 			eg.Go(func() error {
-				return c.imageConvert(ctx, img, n, "")
+				if err := c.imageConvert(ctx, img, n, ""); err != nil {
+					return err
+				}
+
+				c.checkpointAdd(page)
+
+				return nil
 			})
 		}
 	}
@@ -76,42 +121,62 @@ func (c *Converter) convertDocument(ctx context.Context, fileName string) error
 	return nil
 }
 
+// closeDocument closes the document when the last render is done. Render abandoned after the page timeout keeps
+// using the document in the background, the document is closed when it returns.
+func closeDocument(doc document, rendered <-chan struct{}) {
+	select {
+	case <-rendered:
+		_ = doc.Close()
+	default:
+		go func() {
+			<-rendered
+			_ = doc.Close()
+		}()
+	}
+}
+
 // convertArchive converts archive to CBZ.
 func (c *Converter) convertArchive(ctx context.Context, fileName string) error {
-	var err error
-
-	c.Workdir, err = os.MkdirTemp(os.TempDir(), "cbc")
+	workdir, err := c.workdir(fileName)
 	if err != nil {
 		return fmt.Errorf("convertArchive: %w", err)
 	}
 
+	c.setWorkdir(workdir)
+
 	contents, err := c.archiveList(fileName)
 	if err != nil {
 		return fmt.Errorf("convertArchive: %w", err)
 	}
 
+	c.job.chapters = nil
+
 	images := imagesFromSlice(contents)
+	c.job.pages = sortedPages(images)
 
-	c.Ncontents = len(images)
-	c.CurrContent = 0
+	c.job.Ncontents = len(images)
+	c.job.CurrContent = 0
 
 	if c.OnStart != nil {
-		c.OnStart()
+		c.OnStart(c.job)
 	}
 
 	cover := c.coverName(images)
 
-	archive, err := unarr.NewArchive(fileName)
+	archive, err := c.archiveOpen(fileName)
 	if err != nil {
 		return fmt.Errorf("convertArchive: %w", err)
 	}
 	defer archive.Close()
 
 	eg, ctx := errgroup.WithContext(ctx)
-	eg.SetLimit(runtime.NumCPU() + 1)
+	eg.SetLimit(c.workers())
 
 	for {
 		if ctx.Err() != nil {
+			// pages in progress are finished, they are recorded in the checkpoint
+			_ = eg.Wait()
+
 			return fmt.Errorf("convertArchive: %w", ctx.Err())
 		}
 
@@ -124,7 +189,7 @@ func (c *Converter) convertArchive(ctx context.Context, fileName string) error {
 			return fmt.Errorf("convertArchive: %w", err)
 		}
 
-		data, err := archive.ReadAll()
+		data, err := c.archiveRead(archive)
 		if err != nil {
 			return fmt.Errorf("convertArchive: %w", err)
 		}
@@ -132,8 +197,9 @@ func (c *Converter) convertArchive(ctx context.Context, fileName string) error {
 		pathName := archive.Name()
 
 		if isImage(pathName) {
+			c.chapterAdd(pathName, "")
 			if c.Opts.NoConvert {
-				if err = copyFile(bytes.NewReader(data), filepath.Join(c.Workdir, filepath.Base(pathName))); err != nil {
+				if err = copyFile(bytes.NewReader(data), c.workdirPath(pathName)); err != nil {
 					return fmt.Errorf("convertArchive: %w", err)
 				}
 
@@ -141,21 +207,61 @@ func (c *Converter) convertArchive(ctx context.Context, fileName string) error {
 			}
 
 			if cover == pathName && c.Opts.NoCover {
-				if err = copyFile(bytes.NewReader(data), filepath.Join(c.Workdir, filepath.Base(pathName))); err != nil {
+				if err = copyFile(bytes.NewReader(data), c.workdirPath(pathName)); err != nil {
 					return fmt.Errorf("convertArchive: %w", err)
 				}
 
 				continue
 			}
 
-			var img image.Image
-			img, err = c.imageDecode(bytes.NewReader(data))
+			if c.checkpointSkip(pathName) {
+				continue
+			}
+
+			frames, err := c.imageFrames(data)
 			if err != nil {
 				return fmt.Errorf("convertArchive: %w", err)
 			}
 
+			if frames != nil {
+				if c.Opts.Animation == "keep" {
+					if err = copyFile(bytes.NewReader(data), c.workdirPath(pathName)); err != nil {
+						return fmt.Errorf("convertArchive: %w", err)
+					}
+
+					continue
+				}
+
+				eg.Go(func() error {
+					if err := c.imageConvertFrames(ctx, frames, 0, pathName); err != nil {
+						return err
+					}
+
+					c.checkpointAdd(pathName)
+
+					return nil
+				})
+
+				continue
+			}
+
+			var img image.Image
+			if cover == pathName {
+				// cover decoded by Cover, Thumbnail or Preview
+				img = c.shared.cache.cover(fileName, c.coverPolicy())
+			}
+
+			if img == nil {
+				img, err = pageTimeout(ctx, c.Opts.PageTimeout, pathName, func() (image.Image, error) {
+					return c.imageDecode(bytes.NewReader(data))
+				})
+				if err != nil {
+					return fmt.Errorf("convertArchive: %w", err)
+				}
+			}
+
 			if c.Opts.NoRGB && !isGrayScale(img) {
-				if err = copyFile(bytes.NewReader(data), filepath.Join(c.Workdir, filepath.Base(pathName))); err != nil {
+				if err = copyFile(bytes.NewReader(data), c.workdirPath(pathName)); err != nil {
 					return fmt.Errorf("convertArchive: %w", err)
 				}
 
@@ -164,7 +270,13 @@ func (c *Converter) convertArchive(ctx context.Context, fileName string) error {
 
 			if img != nil {
 				eg.Go(func() error {
-					return c.imageConvert(ctx, img, 0, pathName)
+					if err := c.imageConvert(ctx, img, 0, pathName); err != nil {
+						return err
+					}
+
+					c.checkpointAdd(pathName)
+
+					return nil
 				})
 			}
 		} else {
@@ -172,8 +284,8 @@ func (c *Converter) convertArchive(ctx context.Context, fileName string) error {
 				continue
 			}
 
-			if !c.Opts.NoNonImage {
-				if err = copyFile(bytes.NewReader(data), filepath.Join(c.Workdir, filepath.Base(pathName))); err != nil {
+			if c.keepNonImage(pathName) {
+				if err = copyFile(bytes.NewReader(data), c.workdirPath(pathName)); err != nil {
 					return fmt.Errorf("convertArchive: %w", err)
 				}
 			}
@@ -190,28 +302,36 @@ func (c *Converter) convertArchive(ctx context.Context, fileName string) error {
 
 // convertDirectory converts directory to CBZ.
 func (c *Converter) convertDirectory(ctx context.Context, dirPath string) error {
-	var err error
-
-	c.Workdir, err = os.MkdirTemp(os.TempDir(), "cbc")
+	workdir, err := os.MkdirTemp(os.TempDir(), "cbc")
 	if err != nil {
 		return fmt.Errorf("convertDirectory: %w", err)
 	}
 
+	c.setWorkdir(workdir)
+
 	contents, err := imagesFromPath(dirPath)
 	if err != nil {
 		return fmt.Errorf("convertDirectory: %w", err)
 	}
 
+	root, err := filepath.Abs(dirPath)
+	if err != nil {
+		return fmt.Errorf("convertDirectory: %w", err)
+	}
+
+	c.job.chapters = nil
+
 	images := imagesFromSlice(contents)
-	c.Ncontents = len(images)
-	c.CurrContent = 0
+	c.job.pages = sortedPages(images)
+	c.job.Ncontents = len(images)
+	c.job.CurrContent = 0
 
 	if c.OnStart != nil {
-		c.OnStart()
+		c.OnStart(c.job)
 	}
 
 	eg, ctx := errgroup.WithContext(ctx)
-	eg.SetLimit(runtime.NumCPU() + 1)
+	eg.SetLimit(c.workers())
 
 	for index, img := range contents {
 		if ctx.Err() != nil {
@@ -223,8 +343,12 @@ func (c *Converter) convertDirectory(ctx context.Context, dirPath string) error
 			return fmt.Errorf("convertDirectory: %w", err)
 		}
 
-		if isNonImage(img) && !c.Opts.NoNonImage {
-			if err = copyFile(file, filepath.Join(c.Workdir, filepath.Base(img))); err != nil {
+		if stat, err := file.Stat(); err == nil {
+			c.shared.stats.bytesIn.Add(stat.Size())
+		}
+
+		if isNonImage(img) && c.keepNonImage(img) {
+			if err = copyFile(file, c.workdirPath(img)); err != nil {
 				return fmt.Errorf("convertDirectory: %w", err)
 			}
 
@@ -234,8 +358,10 @@ func (c *Converter) convertDirectory(ctx context.Context, dirPath string) error
 
 			continue
 		} else if isImage(img) {
+			c.chapterAdd(img, root)
+
 			if c.Opts.NoConvert {
-				if err = copyFile(file, filepath.Join(c.Workdir, filepath.Base(img))); err != nil {
+				if err = copyFile(file, c.workdirPath(img)); err != nil {
 					return fmt.Errorf("convertDirectory: %w", err)
 				}
 
@@ -246,14 +372,52 @@ func (c *Converter) convertDirectory(ctx context.Context, dirPath string) error
 				continue
 			}
 
+			if c.Opts.Animation == "keep" || c.Opts.Animation == "expand" {
+				data, err := io.ReadAll(file)
+				if err != nil {
+					return fmt.Errorf("convertDirectory: %w", err)
+				}
+
+				frames, err := c.imageFrames(data)
+				if err != nil {
+					return fmt.Errorf("convertDirectory: %w", err)
+				}
+
+				if frames != nil {
+					if c.Opts.Animation == "keep" {
+						err = copyFile(bytes.NewReader(data), c.workdirPath(img))
+					} else {
+						eg.Go(func() error {
+							return c.imageConvertFrames(ctx, frames, index, img)
+						})
+					}
+
+					if err != nil {
+						return fmt.Errorf("convertDirectory: %w", err)
+					}
+
+					if err = file.Close(); err != nil {
+						return fmt.Errorf("convertDirectory: %w", err)
+					}
+
+					continue
+				}
+
+				if _, err = file.Seek(0, io.SeekStart); err != nil {
+					return fmt.Errorf("convertDirectory: %w", err)
+				}
+			}
+
 			var i image.Image
-			i, err = c.imageDecode(file)
+			i, err = pageTimeout(ctx, c.Opts.PageTimeout, img, func() (image.Image, error) {
+				return c.imageDecode(file)
+			})
 			if err != nil {
 				return fmt.Errorf("convertDirectory: %w", err)
 			}
 
 			if c.Opts.NoRGB && !isGrayScale(i) {
-				if err = copyFile(file, filepath.Join(c.Workdir, filepath.Base(img))); err != nil {
+				if err = copyFile(file, c.workdirPath(img)); err != nil {
 					return fmt.Errorf("convertDirectory: %w", err)
 				}
 
@@ -284,6 +448,15 @@ func (c *Converter) convertDirectory(ctx context.Context, dirPath string) error
 	return nil
 }
 
+// workers returns number of concurrent image conversions.
+func (c *Converter) workers() int {
+	if c.Opts.Workers > 0 {
+		return c.Opts.Workers
+	}
+
+	return runtime.NumCPU() + 1
+}
+
 // imageConvert converts image.Image.
 func (c *Converter) imageConvert(ctx context.Context, img image.Image, index int, pathName string) error {
 	err := ctx.Err()
@@ -291,47 +464,214 @@ func (c *Converter) imageConvert(ctx context.Context, img image.Image, index int
 		return fmt.Errorf("imageConvert: %w", err)
 	}
 
-	atomic.AddInt32(&c.CurrContent, 1)
+	atomic.AddInt32(&c.job.CurrContent, 1)
+	c.shared.rate.tick()
+	if c.OnProgress != nil {
+		c.OnProgress(c.job)
+	}
+
+	_, err = pageTimeout(ctx, c.Opts.PageTimeout, pathName, func() (struct{}, error) {
+		return struct{}{}, c.imageSave(img, index, pathName)
+	})
+	if err != nil {
+		return fmt.Errorf("imageConvert: %w", err)
+	}
+
+	return nil
+}
+
+// imageConvertFrames converts frames of animated image, each frame is saved as a page.
+func (c *Converter) imageConvertFrames(ctx context.Context, frames []image.Image, index int, pathName string) error {
+	err := ctx.Err()
+	if err != nil {
+		return fmt.Errorf("imageConvertFrames: %w", err)
+	}
+
+	atomic.AddInt32(&c.job.CurrContent, 1)
+	c.shared.rate.tick()
 	if c.OnProgress != nil {
-		c.OnProgress()
+		c.OnProgress(c.job)
+	}
+
+	ext := filepath.Ext(pathName)
+	for n, frame := range frames {
+		name := fmt.Sprintf("%s_%03d%s", strings.TrimSuffix(pathName, ext), n+1, ext)
+		if pathName == "" {
+			name = fmt.Sprintf("%03d_%03d", index, n+1)
+		}
+
+		_, err := pageTimeout(ctx, c.Opts.PageTimeout, name, func() (struct{}, error) {
+			return struct{}{}, c.imageSave(frame, index, name)
+		})
+		if err != nil {
+			return fmt.Errorf("imageConvertFrames: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// pageTimeout runs fn and returns ErrTimeout if it does not finish within timeout or before the context deadline
+// (file timeout). Decoders and encoders can not be interrupted, fn keeps running in the background after the timeout.
+func pageTimeout[T any](ctx context.Context, timeout time.Duration, name string, fn func() (T, error)) (T, error) {
+	var zero T
+
+	deadline, ok := ctx.Deadline()
+	if timeout <= 0 && !ok {
+		return fn()
+	}
+
+	type result struct {
+		value T
+		err   error
+	}
+
+	done := make(chan result, 1)
+	go func() {
+		value, err := fn()
+		done <- result{value, err}
+	}()
+
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+
+		expired = timer.C
+	}
+
+	select {
+	case r := <-done:
+		return r.value, r.err
+	case <-expired:
+		return zero, fmt.Errorf("page %s: %w after %s", name, ErrTimeout, timeout)
+	case <-ctx.Done():
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return zero, fmt.Errorf("page %s: %w, file deadline %s", name, ErrTimeout, deadline.Format(time.TimeOnly))
+		}
+
+		return zero, ctx.Err()
+	}
+}
+
+// imageSave transforms and encodes image to the work directory, pages taller than the tile height are split into tiles.
+func (c *Converter) imageSave(img image.Image, index int, pathName string) error {
+	start := time.Now()
+	img = c.imageTransform(img)
+	since(&c.shared.stats.transform, start)
+
+	format := c.imageFormat(img)
+
+	tiles := imageTiles(img, c.tileHeight(format))
+	if tiles == nil {
+		return c.imageWrite(img, format, index, pathName)
+	}
+
+	c.log(LogVerbose, "page split into tiles", "index", index, "name", pathName, "height", img.Bounds().Dy(), "tiles", len(tiles))
+
+	ext := filepath.Ext(pathName)
+	for n, tile := range tiles {
+		name := fmt.Sprintf("%s_%03d%s", strings.TrimSuffix(pathName, ext), n+1, ext)
+		if pathName == "" {
+			name = fmt.Sprintf("%03d_%03d", index, n+1)
+		}
+
+		if err := c.imageWrite(tile, format, index, name); err != nil {
+			return err
+		}
 	}
 
-	ext := c.Opts.Format
-	if ext == "jpeg" {
-		ext = "jpg"
+	return nil
+}
+
+// imageWrite encodes image to the work directory, fallback formats are tried if encoding fails.
+func (c *Converter) imageWrite(img image.Image, format string, index int, pathName string) error {
+	c.log(LogDebug, "page", "index", index, "name", pathName, "format", format)
+
+	start := time.Now()
+
+	var buf bytes.Buffer
+	for _, fallback := range c.fallbackFormats(format) {
+		err := c.imageEncodeFormat(img, format, &buf)
+		if err == nil {
+			break
+		}
+
+		if fallback == "" {
+			return fmt.Errorf("imageWrite: %w", err)
+		}
+
+		c.log(LogNormal, "encoding failed, using fallback format", "index", index, "name", pathName,
+			"format", format, "fallback", fallback, "error", err)
+		c.shared.stats.fallbacks.Add(1)
+
+		buf.Reset()
+		format = fallback
 	}
 
+	ext := formatExt(format)
+
 	var fileName string
 	if pathName != "" {
-		fileName = filepath.Join(c.Workdir, fmt.Sprintf("%s.%s", baseNoExt(pathName), ext))
+		fileName = filepath.Join(c.job.workdir, fmt.Sprintf("%s.%s", baseNoExt(entryName(pathName)), ext))
 	} else {
-		fileName = filepath.Join(c.Workdir, fmt.Sprintf("%03d.%s", index, ext))
+		fileName = filepath.Join(c.job.workdir, fmt.Sprintf("%03d.%s", index, ext))
 	}
 
-	img = c.imageTransform(img)
+	if err := os.WriteFile(fileName, buf.Bytes(), 0644); err != nil {
+		return fmt.Errorf("imageWrite: %w", err)
+	}
 
-	w, err := os.Create(fileName)
-	if err != nil {
-		return fmt.Errorf("imageConvert: %w", err)
+	since(&c.shared.stats.encode, start)
+	c.shared.stats.pages.Add(1)
+
+	return nil
+}
+
+// tileHeight returns maximum page height, Options.TileHeight or the smallest limit of the format and fallback formats.
+func (c *Converter) tileHeight(format string) int {
+	height := c.Opts.TileHeight
+	for _, f := range append([]string{format}, c.fallbackFormats(format)...) {
+		if cd, ok := codecs[f]; ok && cd.maxSize > 0 && (height <= 0 || cd.maxSize < height) {
+			height = cd.maxSize
+		}
 	}
-	defer w.Close()
 
-	if err := c.imageEncode(img, w); err != nil {
-		return fmt.Errorf("imageConvert: %w", err)
+	return height
+}
+
+// fallbackFormats returns for each format tried in order the format used if encoding fails, empty string for the last.
+func (c *Converter) fallbackFormats(format string) []string {
+	formats := []string{format}
+	for _, f := range strings.Split(c.Opts.Fallback, ",") {
+		f = strings.ToLower(strings.TrimSpace(f))
+		if f != "" && f != "auto" && !slices.Contains(formats, f) {
+			formats = append(formats, f)
+		}
 	}
 
-	return nil
+	return append(formats[1:], "")
 }
 
-// imageTransform transforms image (resize, rotate, brightness, contrast).
+// imageTransform transforms image (resize, rotate, brightness, contrast, gamma, grayscale).
 func (c *Converter) imageTransform(img image.Image) image.Image {
-	var i = img
+	i, meta := splitMeta(img)
+
+	switch {
+	case c.job.autoWidth > 0:
+		width := c.job.autoWidth
+		if b := i.Bounds(); b.Dx() > b.Dy() {
+			width *= 2
+		}
 
-	if c.Opts.Width > 0 || c.Opts.Height > 0 {
+		if i.Bounds().Dx() > width {
+			i = resize(i, width, 0, c.resizer(c.Opts.Filter))
+		}
+	case c.Opts.Width > 0 || c.Opts.Height > 0:
 		if c.Opts.Fit {
-			i = fit(i, c.Opts.Width, c.Opts.Height, filters[c.Opts.Filter])
+			i = fit(i, c.Opts.Width, c.Opts.Height, c.resizer(c.Opts.Filter))
 		} else {
-			i = resize(i, c.Opts.Width, c.Opts.Height, filters[c.Opts.Filter])
+			i = resize(i, c.Opts.Width, c.Opts.Height, c.resizer(c.Opts.Filter))
 		}
 	}
 
@@ -354,55 +694,243 @@ func (c *Converter) imageTransform(img image.Image) image.Image {
 		i = contrast(i, float64(c.Opts.Contrast))
 	}
 
-	if c.Opts.Grayscale {
-		i = imageToGray(i)
+	if c.Opts.Gamma > 0 && c.Opts.Gamma != 1 {
+		i = gamma(i, c.Opts.Gamma)
 	}
 
-	return i
+	if c.Opts.Grayscale || c.Opts.GrayDepth == 4 || (c.Opts.GrayscaleAuto > 0 && saturation(i) < float64(c.Opts.GrayscaleAuto)) {
+		// grayscale has no alpha channel, invalid background is reported by imageEncode
+		if bg, err := parseColor(c.Opts.Background); err == nil && !isOpaque(i) {
+			i = flatten(i, bg)
+		}
+
+		if c.Opts.GrayDepth == 4 {
+			i = imageToGray16(imageToGray(i))
+		} else {
+			i = imageToGray(i)
+		}
+	}
+
+	return withMeta(i, meta)
+}
+
+// imageFrames returns frames of animated GIF or WEBP image if the animation policy is keep or expand,
+// nil is returned for still images.
+func (c *Converter) imageFrames(data []byte) ([]image.Image, error) {
+	if c.Opts.Animation != "keep" && c.Opts.Animation != "expand" {
+		return nil, nil
+	}
+
+	defer since(&c.shared.stats.decode, time.Now())
+
+	var frames []image.Image
+
+	switch {
+	case bytes.HasPrefix(data, []byte("GIF8")):
+		g, err := gif.DecodeAll(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("imageFrames: %w", err)
+		}
+
+		frames = gifFrames(g)
+	case webpFrames != nil && len(data) > 16 && bytes.Equal(data[8:16], []byte("WEBPVP8X")) && bytes.Contains(data, []byte("ANIM")):
+		var err error
+		frames, err = webpFrames(data)
+		if err != nil {
+			return nil, fmt.Errorf("imageFrames: %w", err)
+		}
+	}
+
+	if len(frames) < 2 {
+		return nil, nil
+	}
+
+	return frames, nil
 }
 
-// imageDecode decodes image from reader.
+// imageDecode decodes image from reader, EXIF orientation is applied and metadata is attached if the policy requires it.
 func (c *Converter) imageDecode(reader io.Reader) (image.Image, error) {
-	img, _, err := image.Decode(reader)
+	defer since(&c.shared.stats.decode, time.Now())
+
+	if !c.Opts.Salvage && c.Opts.Metadata != "orientation" && c.Opts.Metadata != "keep" {
+		img, _, err := image.Decode(reader)
+		if err != nil {
+			return img, fmt.Errorf("imageDecode: %w", err)
+		}
+
+		return img, nil
+	}
+
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, fmt.Errorf("imageDecode: %w", err)
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil && c.Opts.Salvage {
+		if salvaged, e := salvageJPEG(data); e == nil {
+			c.log(LogNormal, "damaged image salvaged", "error", err)
+			img, err = salvaged, nil
+		}
+	}
+
 	if err != nil {
 		return img, fmt.Errorf("imageDecode: %w", err)
 	}
 
+	if c.Opts.Metadata != "orientation" && c.Opts.Metadata != "keep" {
+		return img, nil
+	}
+
+	meta := readMeta(data)
+	if orientation, _ := exifOrientation(meta.exif); orientation > 1 {
+		img = orient(img, orientation)
+		meta.exif = exifOrientationReset(meta.exif)
+	}
+
+	if c.Opts.Metadata == "keep" {
+		return withMeta(img, meta), nil
+	}
+
 	return img, nil
 }
 
+// imageFormat returns image format, in auto mode png is used for flat colors and webp for everything else.
+func (c *Converter) imageFormat(img image.Image) string {
+	if c.Opts.Format != "auto" {
+		return c.Opts.Format
+	}
+
+	if isFlat(img) {
+		return "png"
+	}
+
+	if encoderFor("webp") == nil {
+		// built with nowebp
+		return "jpeg"
+	}
+
+	return "webp"
+}
+
 // imageEncode encodes image to file.
 func (c *Converter) imageEncode(img image.Image, w io.Writer) error {
-	var err error
-
-	switch c.Opts.Format {
-	case "png":
-		err = png.Encode(w, img)
-	case "tiff":
-		err = tiff.Encode(w, img, &tiff.Options{Compression: tiff.Uncompressed})
-	case "jpeg":
-		opts := &jpegli.EncodingOptions{}
-		opts.Quality = c.Opts.Quality
-		opts.ChromaSubsampling = image.YCbCrSubsampleRatio420
-		opts.ProgressiveLevel = 2
-		opts.AdaptiveQuantization = true
-		opts.DCTMethod = jpegli.DefaultDCTMethod
-		err = jpegli.Encode(w, img, opts)
-	case "webp":
-		err = webp.Encode(w, img, webp.Options{Quality: c.Opts.Quality, Method: webp.DefaultMethod})
-	case "avif":
-		err = avif.Encode(w, img, avif.Options{Quality: c.Opts.Quality, Speed: avif.DefaultSpeed})
-	case "jxl":
-		err = jpegxl.Encode(w, img, jpegxl.Options{Quality: c.Opts.Quality, Effort: jpegxl.DefaultEffort})
-	case "bmp":
-		opts := &gobmp.EncoderOptions{}
-		opts.SupportTransparency(false)
-		err = gobmp.EncodeWithOptions(w, imageToPaletted(img), opts)
+	return c.imageEncodeFormat(img, c.imageFormat(img), w)
+}
+
+// imageAlpha returns image flattened to the background color, if the alpha policy or format requires it.
+func (c *Converter) imageAlpha(img image.Image, format string) (image.Image, error) {
+	bg, err := parseColor(c.Opts.Background)
+	if err != nil {
+		return img, fmt.Errorf("imageAlpha: %w", err)
+	}
+
+	if isOpaque(img) || (c.Opts.Alpha != "flatten" && alphaFormat(format)) {
+		return img, nil
+	}
+
+	return flatten(img, bg), nil
+}
+
+// imageQuality returns encoding quality for the image, with adaptive quality it is chosen between minimum and
+// maximum quality by the image complexity.
+func (c *Converter) imageQuality(img image.Image) int {
+	if !c.Opts.AdaptiveQuality {
+		return c.Opts.Quality
+	}
+
+	lo, hi := c.Opts.QualityMin, c.Opts.QualityMax
+	if hi < lo {
+		lo, hi = hi, lo
+	}
+
+	return lo + int(math.Round(complexity(img)*float64(hi-lo)))
+}
+
+// imageEncodeFormat encodes image to file with given format, attached metadata is written to JPEG, PNG and WEBP.
+func (c *Converter) imageEncodeFormat(img image.Image, format string, w io.Writer) error {
+	img, meta := splitMeta(img)
+	if !meta.empty() {
+		var buf bytes.Buffer
+		if err := c.imageEncodeFormat(img, format, &buf); err != nil {
+			return err
+		}
+
+		if _, err := w.Write(writeMeta(buf.Bytes(), format, meta, img)); err != nil {
+			return fmt.Errorf("imageEncode: %w", err)
+		}
+
+		return nil
+	}
+
+	img, err := c.imageAlpha(img, format)
+	if err != nil {
+		return fmt.Errorf("imageEncode: %w", err)
+	}
+
+	quality := c.imageQuality(img)
+
+	enc := encoderFor(format)
+	if enc == nil {
+		return fmt.Errorf("imageEncode: unsupported format %q", format)
 	}
 
+	err = enc(w, img, quality)
 	if err != nil {
 		return fmt.Errorf("imageEncode: %w", err)
 	}
 
 	return nil
 }
+
+// postCmd executes post-conversion command for the output file.
+func (c *Converter) postCmd(fileName, output string) error {
+	args := commandArgs(c.Opts.PostCmd, map[string]string{
+		"{input}":  fileName,
+		"{output}": output,
+		"{name}":   baseNoExt(output),
+		"{dir}":    filepath.Dir(output),
+	})
+
+	if len(args) == 0 {
+		return nil
+	}
+
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("postCmd: %w", err)
+	}
+
+	return nil
+}
+
+// removeOriginal moves the input to the trash or deletes it, the input is kept if it was replaced by the output.
+func (c *Converter) removeOriginal(fileName, output string) error {
+	inStat, err := os.Stat(fileName)
+	if err != nil {
+		return fmt.Errorf("removeOriginal: %w", err)
+	}
+
+	if outStat, err := os.Stat(output); err == nil && os.SameFile(inStat, outStat) {
+		c.log(LogVerbose, "original replaced by output, not removed", "file", fileName)
+
+		return nil
+	}
+
+	if c.Opts.Permanent {
+		if err := os.RemoveAll(fileName); err != nil {
+			return fmt.Errorf("removeOriginal: %w", err)
+		}
+	} else {
+		if err := trash(fileName); err != nil {
+			return fmt.Errorf("removeOriginal: %w", err)
+		}
+	}
+
+	c.log(LogVerbose, "original removed", "file", fileName, "permanent", c.Opts.Permanent)
+
+	return nil
+}
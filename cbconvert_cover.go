@@ -7,20 +7,35 @@ import (
 	"os"
 	"path/filepath"
 	"sort"
+	"strconv"
 	"strings"
 
 	"github.com/fvbommel/sortorder"
-	"github.com/gen2brain/go-fitz"
-	"github.com/gen2brain/go-unarr"
 )
 
 // coverArchive extracts cover from archive.
 func (c *Converter) coverArchive(fileName string) (image.Image, error) {
+	data, err := c.coverArchiveData(fileName)
+	if err != nil {
+		return nil, fmt.Errorf("coverArchive: %w", err)
+	}
+
+	var img image.Image
+	img, err = c.imageDecode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("coverArchive: %w", err)
+	}
+
+	return img, nil
+}
+
+// coverArchiveData extracts cover data from archive.
+func (c *Converter) coverArchiveData(fileName string) ([]byte, error) {
 	var images []string
 
 	contents, err := c.archiveList(fileName)
 	if err != nil {
-		return nil, fmt.Errorf("coverArchive: %w", err)
+		return nil, fmt.Errorf("coverArchiveData: %w", err)
 	}
 
 	for _, ct := range contents {
@@ -31,39 +46,33 @@ func (c *Converter) coverArchive(fileName string) (image.Image, error) {
 
 	cover := c.coverName(images)
 
-	archive, err := unarr.NewArchive(fileName)
+	archive, err := c.archiveOpen(fileName)
 	if err != nil {
-		return nil, fmt.Errorf("coverArchive: %w", err)
+		return nil, fmt.Errorf("coverArchiveData: %w", err)
 	}
 	defer archive.Close()
 
 	if err = archive.EntryFor(cover); err != nil {
-		return nil, fmt.Errorf("coverArchive: %w", err)
+		return nil, fmt.Errorf("coverArchiveData: %w", err)
 	}
 
-	data, err := archive.ReadAll()
+	data, err := c.archiveRead(archive)
 	if err != nil {
-		return nil, fmt.Errorf("coverArchive: %w", err)
+		return nil, fmt.Errorf("coverArchiveData: %w", err)
 	}
 
-	var img image.Image
-	img, err = c.imageDecode(bytes.NewReader(data))
-	if err != nil {
-		return nil, fmt.Errorf("coverArchive: %w", err)
-	}
-
-	return img, nil
+	return data, nil
 }
 
 // coverDocument extracts cover from document.
 func (c *Converter) coverDocument(fileName string) (image.Image, error) {
-	doc, err := fitz.New(fileName)
+	doc, err := openDocument(fileName)
 	if err != nil {
 		return nil, fmt.Errorf("coverDocument: %w", err)
 	}
 	defer doc.Close()
 
-	img, err := doc.Image(0)
+	img, err := c.coverDocumentPage(doc)
 	if err != nil {
 		return nil, fmt.Errorf("coverDocument: %w", err)
 	}
@@ -71,24 +80,59 @@ func (c *Converter) coverDocument(fileName string) (image.Image, error) {
 	return img, nil
 }
 
-// coverDirectory extracts cover from directory.
-func (c *Converter) coverDirectory(dir string) (image.Image, error) {
-	contents, err := imagesFromPath(dir)
-	if err != nil {
-		return nil, fmt.Errorf("coverDirectory: %w", err)
+// coverDocumentPage returns document page selected with the cover page option, in auto mode leading blank pages are skipped.
+func (c *Converter) coverDocumentPage(doc document) (image.Image, error) {
+	// number of leading pages checked in auto mode
+	const maxBlank = 5
+
+	if c.Opts.CoverPage != "" && c.Opts.CoverPage != "auto" {
+		n, err := strconv.Atoi(c.Opts.CoverPage)
+		if err != nil || n < 1 || n > doc.NumPage() {
+			return nil, fmt.Errorf("coverDocumentPage: invalid cover page %q", c.Opts.CoverPage)
+		}
+
+		img, err := c.documentImage(doc, n-1)
+		if err != nil {
+			return nil, fmt.Errorf("coverDocumentPage: %w", err)
+		}
+
+		return img, nil
 	}
 
-	images := imagesFromSlice(contents)
-	cover := c.coverName(images)
+	var first image.Image
+	for n := 0; n < min(doc.NumPage(), maxBlank); n++ {
+		img, err := c.documentImage(doc, n)
+		if err != nil {
+			return nil, fmt.Errorf("coverDocumentPage: %w", err)
+		}
 
-	file, err := os.Open(cover)
+		if c.Opts.CoverPage != "auto" || !isBlank(img) {
+			return img, nil
+		}
+
+		if first == nil {
+			first = img
+		}
+
+		c.log(LogDebug, "blank page skipped", "page", n+1)
+	}
+
+	if first == nil {
+		return nil, fmt.Errorf("coverDocumentPage: document has no pages")
+	}
+
+	return first, nil
+}
+
+// coverDirectory extracts cover from directory.
+func (c *Converter) coverDirectory(dir string) (image.Image, error) {
+	data, err := c.coverDirectoryData(dir)
 	if err != nil {
 		return nil, fmt.Errorf("coverDirectory: %w", err)
 	}
-	defer file.Close()
 
 	var img image.Image
-	img, err = c.imageDecode(file)
+	img, err = c.imageDecode(bytes.NewReader(data))
 	if err != nil {
 		return nil, fmt.Errorf("coverDirectory: %w", err)
 	}
@@ -96,6 +140,24 @@ func (c *Converter) coverDirectory(dir string) (image.Image, error) {
 	return img, nil
 }
 
+// coverDirectoryData extracts cover data from directory.
+func (c *Converter) coverDirectoryData(dir string) ([]byte, error) {
+	contents, err := imagesFromPath(dir)
+	if err != nil {
+		return nil, fmt.Errorf("coverDirectoryData: %w", err)
+	}
+
+	images := imagesFromSlice(contents)
+	cover := c.coverName(images)
+
+	data, err := os.ReadFile(cover)
+	if err != nil {
+		return nil, fmt.Errorf("coverDirectoryData: %w", err)
+	}
+
+	return data, nil
+}
+
 // coverName returns the filename that is the most likely to be the cover.
 func (c *Converter) coverName(images []string) string {
 	if len(images) == 0 {
@@ -127,27 +189,37 @@ func (c *Converter) coverName(images []string) string {
 	return ""
 }
 
+// coverPolicy returns options that the decoded cover depends on, used as the cache key.
+func (c *Converter) coverPolicy() string {
+	return fmt.Sprintf("%s/%s/%t/%q/%d/%d/%d/%d", c.Opts.Metadata, c.Opts.CoverPage, c.Opts.Salvage, c.Opts.Password,
+		c.Opts.MaxPagePixels, c.Opts.MaxEntries, c.Opts.MaxEntrySize, c.Opts.MaxDepth)
+}
+
 // coverImage returns cover as image.Image.
 func (c *Converter) coverImage(fileName string, fileInfo os.FileInfo) (image.Image, error) {
 	var err error
-	var cover image.Image
 
-	switch {
-	case fileInfo.IsDir():
-		cover, err = c.coverDirectory(fileName)
-	case isDocument(fileName):
-		cover, err = c.coverDocument(fileName)
-	case isArchive(fileName):
-		cover, err = c.coverArchive(fileName)
+	cover := c.shared.cache.cover(fileName, c.coverPolicy())
+	if cover == nil {
+		switch {
+		case fileInfo.IsDir(), isImage(fileName):
+			cover, err = c.coverDirectory(fileName)
+		case isDocument(fileName):
+			cover, err = c.coverDocument(fileName)
+		case isArchive(fileName):
+			cover, err = c.coverArchive(fileName)
+		}
 	}
 
 	if c.OnProgress != nil {
-		c.OnProgress()
+		c.OnProgress(c.job)
 	}
 
 	if err != nil {
 		return nil, fmt.Errorf("coverImage: %w", err)
 	}
 
+	c.shared.cache.setCover(fileName, c.coverPolicy(), cover)
+
 	return cover, nil
 }
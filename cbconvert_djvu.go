@@ -0,0 +1,378 @@
+package cbconvert
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// djvuTypes are extensions of DjVu documents, they are rendered with external DjVuLibre commands.
+var djvuTypes = []string{".djvu", ".djv"}
+
+// pnmMaxPixels is maximum number of pixels of rendered page, larger sizes are from damaged documents.
+const pnmMaxPixels = 1 << 28
+
+// djvuDocument type, DjVu document rendered with external ddjvu command, pages and outline are read with djvused.
+type djvuDocument struct {
+	fileName string
+	ddjvu    string
+	djvused  string
+	sizes    []image.Rectangle
+}
+
+// openDjvu opens DjVu document.
+func openDjvu(fileName string) (document, error) {
+	ddjvu, err := exec.LookPath("ddjvu")
+	if err != nil {
+		return nil, fmt.Errorf("openDjvu: DjVu documents require ddjvu and djvused commands (DjVuLibre)")
+	}
+
+	djvused, err := exec.LookPath("djvused")
+	if err != nil {
+		return nil, fmt.Errorf("openDjvu: DjVu documents require ddjvu and djvused commands (DjVuLibre)")
+	}
+
+	d := &djvuDocument{fileName: fileName, ddjvu: ddjvu, djvused: djvused}
+
+	out, err := d.exec("size")
+	if err != nil {
+		return nil, fmt.Errorf("openDjvu: %w", err)
+	}
+
+	// one line per page, i.e. width=2550 height=3300 rotation=0
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		var width, height int
+		for _, field := range strings.Fields(scanner.Text()) {
+			key, value, _ := strings.Cut(field, "=")
+			switch key {
+			case "width":
+				width, _ = strconv.Atoi(value)
+			case "height":
+				height, _ = strconv.Atoi(value)
+			}
+		}
+
+		d.sizes = append(d.sizes, image.Rect(0, 0, width, height))
+	}
+
+	if len(d.sizes) == 0 {
+		return nil, fmt.Errorf("openDjvu: document has no pages")
+	}
+
+	return d, nil
+}
+
+// exec runs djvused script and returns the output.
+func (d *djvuDocument) exec(script string) ([]byte, error) {
+	var stderr bytes.Buffer
+
+	cmd := exec.Command(d.djvused, "-e", script, d.fileName)
+	cmd.Stderr = &stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	return out, nil
+}
+
+// NumPage returns number of pages.
+func (d *djvuDocument) NumPage() int {
+	return len(d.sizes)
+}
+
+// Image returns rendered page.
+func (d *djvuDocument) Image(n int) (image.Image, error) {
+	if n < 0 || n >= len(d.sizes) {
+		return nil, fmt.Errorf("djvu: page %d out of range", n)
+	}
+
+	var stderr bytes.Buffer
+
+	// page is written to stdout as PBM, PGM or PPM image
+	cmd := exec.Command(d.ddjvu, "-format=pnm", fmt.Sprintf("-page=%d", n+1), d.fileName)
+	cmd.Stderr = &stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("djvu: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	img, err := pnmDecode(bytes.NewReader(out))
+	if err != nil {
+		return nil, fmt.Errorf("djvu: %w", err)
+	}
+
+	return img, nil
+}
+
+// Size returns size of the rendered page, pages are rendered at their resolution.
+func (d *djvuDocument) Size(n int) (image.Point, error) {
+	if n < 0 || n >= len(d.sizes) {
+		return image.Point{}, fmt.Errorf("djvu: page %d out of range", n)
+	}
+
+	return d.sizes[n].Size(), nil
+}
+
+// ToC returns table of contents, bookmarks that do not link to page number are skipped.
+func (d *djvuDocument) ToC() ([]outline, error) {
+	out, err := d.exec("print-outline")
+	if err != nil {
+		return nil, fmt.Errorf("djvu: %w", err)
+	}
+
+	expr, err := sexpParse(out)
+	if err != nil {
+		return nil, fmt.Errorf("djvu: %w", err)
+	}
+
+	outlines := make([]outline, 0)
+
+	var walk func(items []sexp, level int)
+	walk = func(items []sexp, level int) {
+		for _, item := range items {
+			if len(item.list) < 2 {
+				continue
+			}
+
+			page, err := strconv.Atoi(strings.TrimPrefix(item.list[1].atom, "#"))
+			if err == nil && page > 0 {
+				outlines = append(outlines, outline{Level: level, Title: item.list[0].atom, Page: page - 1})
+			}
+
+			walk(item.list[2:], level+1)
+		}
+	}
+
+	// (bookmarks ("Title" "#1" children...) ...)
+	for _, e := range expr {
+		if len(e.list) > 0 && e.list[0].atom == "bookmarks" {
+			walk(e.list[1:], 1)
+		}
+	}
+
+	return outlines, nil
+}
+
+// Close closes the document.
+func (d *djvuDocument) Close() error {
+	return nil
+}
+
+// sexp type, S-expression printed by djvused, atom or list.
+type sexp struct {
+	atom string
+	list []sexp
+}
+
+// sexpParse parses S-expressions.
+func sexpParse(data []byte) ([]sexp, error) {
+	r := bufio.NewReader(bytes.NewReader(data))
+
+	var parse func(depth int) ([]sexp, error)
+	parse = func(depth int) ([]sexp, error) {
+		var exprs []sexp
+
+		for {
+			b, err := r.ReadByte()
+			if err != nil {
+				if errors.Is(err, io.EOF) && depth == 0 {
+					return exprs, nil
+				}
+
+				return nil, fmt.Errorf("sexpParse: unexpected end")
+			}
+
+			switch {
+			case b == '(':
+				list, err := parse(depth + 1)
+				if err != nil {
+					return nil, err
+				}
+
+				exprs = append(exprs, sexp{list: list})
+			case b == ')':
+				if depth == 0 {
+					return nil, fmt.Errorf("sexpParse: unexpected )")
+				}
+
+				return exprs, nil
+			case b == '"':
+				var s strings.Builder
+				for {
+					c, err := r.ReadByte()
+					if err != nil {
+						return nil, fmt.Errorf("sexpParse: unterminated string")
+					}
+
+					if c == '"' {
+						break
+					}
+
+					if c == '\\' {
+						if c, err = r.ReadByte(); err != nil {
+							return nil, fmt.Errorf("sexpParse: unterminated string")
+						}
+
+						switch c {
+						case 'n':
+							c = '\n'
+						case 't':
+							c = '\t'
+						}
+					}
+
+					s.WriteByte(c)
+				}
+
+				exprs = append(exprs, sexp{atom: s.String()})
+			case b == ' ' || b == '\t' || b == '\n' || b == '\r':
+				// whitespace between expressions
+			default:
+				var s strings.Builder
+				s.WriteByte(b)
+				for {
+					c, err := r.ReadByte()
+					if err != nil {
+						break
+					}
+
+					if c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '(' || c == ')' || c == '"' {
+						_ = r.UnreadByte()
+
+						break
+					}
+
+					s.WriteByte(c)
+				}
+
+				exprs = append(exprs, sexp{atom: s.String()})
+			}
+		}
+	}
+
+	return parse(0)
+}
+
+// pnmDecode decodes binary PBM (P4), PGM (P5) and PPM (P6) image with 8-bit samples.
+func pnmDecode(r io.Reader) (image.Image, error) {
+	br := bufio.NewReader(r)
+
+	// header fields are separated by whitespace, comments start with #
+	field := func() (string, error) {
+		var s strings.Builder
+		for {
+			b, err := br.ReadByte()
+			if err != nil {
+				return "", err
+			}
+
+			switch {
+			case b == '#':
+				if _, err := br.ReadString('\n'); err != nil {
+					return "", err
+				}
+			case b == ' ' || b == '\t' || b == '\n' || b == '\r':
+				if s.Len() > 0 {
+					return s.String(), nil
+				}
+			default:
+				s.WriteByte(b)
+			}
+		}
+	}
+
+	number := func() (int, error) {
+		s, err := field()
+		if err != nil {
+			return 0, err
+		}
+
+		return strconv.Atoi(s)
+	}
+
+	magic, err := field()
+	if err != nil {
+		return nil, fmt.Errorf("pnmDecode: %w", err)
+	}
+
+	width, err := number()
+	if err != nil {
+		return nil, fmt.Errorf("pnmDecode: %w", err)
+	}
+
+	height, err := number()
+	if err != nil {
+		return nil, fmt.Errorf("pnmDecode: %w", err)
+	}
+
+	if width <= 0 || height <= 0 || width*height > pnmMaxPixels {
+		return nil, fmt.Errorf("pnmDecode: invalid size %dx%d", width, height)
+	}
+
+	maxValue := 1
+	if magic != "P4" {
+		if maxValue, err = number(); err != nil {
+			return nil, fmt.Errorf("pnmDecode: %w", err)
+		}
+
+		if maxValue != 255 {
+			return nil, fmt.Errorf("pnmDecode: unsupported maximum value %d", maxValue)
+		}
+	}
+
+	rect := image.Rect(0, 0, width, height)
+
+	switch magic {
+	case "P4":
+		img := image.NewGray(rect)
+		row := make([]byte, (width+7)/8)
+		for y := 0; y < height; y++ {
+			if _, err := io.ReadFull(br, row); err != nil {
+				return nil, fmt.Errorf("pnmDecode: %w", err)
+			}
+
+			for x := 0; x < width; x++ {
+				// 1 is black
+				if row[x/8]&(0x80>>(x%8)) == 0 {
+					img.Pix[y*img.Stride+x] = 0xff
+				}
+			}
+		}
+
+		return img, nil
+	case "P5":
+		img := image.NewGray(rect)
+		if _, err := io.ReadFull(br, img.Pix); err != nil {
+			return nil, fmt.Errorf("pnmDecode: %w", err)
+		}
+
+		return img, nil
+	case "P6":
+		img := image.NewNRGBA(rect)
+		row := make([]byte, width*3)
+		for y := 0; y < height; y++ {
+			if _, err := io.ReadFull(br, row); err != nil {
+				return nil, fmt.Errorf("pnmDecode: %w", err)
+			}
+
+			for x := 0; x < width; x++ {
+				img.SetNRGBA(x, y, color.NRGBA{R: row[x*3], G: row[x*3+1], B: row[x*3+2], A: 0xff})
+			}
+		}
+
+		return img, nil
+	}
+
+	return nil, fmt.Errorf("pnmDecode: unsupported format %q", magic)
+}
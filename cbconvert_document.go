@@ -0,0 +1,44 @@
+package cbconvert
+
+import (
+	"image"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// documentTypes are extensions of the supported documents.
+var documentTypes = []string{".pdf", ".xps", ".epub", ".mobi", ".docx", ".pptx", ".xlsx"}
+
+// openDocument opens document, DjVu documents are rendered with external DjVuLibre commands.
+func openDocument(fileName string) (document, error) {
+	if slices.Contains(djvuTypes, strings.ToLower(filepath.Ext(fileName))) {
+		return openDjvu(fileName)
+	}
+
+	return openFitz(fileName)
+}
+
+// document type, PDF, EPUB and other documents with rendered pages.
+type document interface {
+	// NumPage returns number of pages
+	NumPage() int
+	// Image returns rendered page
+	Image(n int) (image.Image, error)
+	// Size returns size of the rendered page in pixels
+	Size(n int) (image.Point, error)
+	// ToC returns table of contents
+	ToC() ([]outline, error)
+	// Close closes the document
+	Close() error
+}
+
+// outline type, table of contents entry.
+type outline struct {
+	// Hierarchy level, starting from 1
+	Level int
+	// Title
+	Title string
+	// Page index
+	Page int
+}
@@ -0,0 +1,232 @@
+package cbconvert
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// archiveKind returns format of the archive from its signature, zip, tar or empty for archives that are not edited in place
+// (RAR, 7Z, compressed TAR and formats handled by plugins).
+func archiveKind(fileName string) string {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return ""
+	}
+	defer file.Close()
+
+	header := make([]byte, 512)
+	n, _ := io.ReadFull(file, header)
+	header = header[:n]
+
+	switch {
+	case bytes.HasPrefix(header, []byte("PK\x03\x04")), bytes.HasPrefix(header, []byte("PK\x05\x06")):
+		return "zip"
+	case len(header) >= 262 && bytes.Equal(header[257:262], []byte("ustar")):
+		return "tar"
+	}
+
+	return ""
+}
+
+// archiveZip converts archive that is not ZIP to CBZ next to it and removes the original, ZIP archives are returned as is.
+// It returns name of the CBZ archive, i.e. book.cbz for book.cbr or book.cbt.gz.
+func (c *Converter) archiveZip(fileName string) (string, error) {
+	if archiveKind(fileName) == "zip" {
+		return fileName, nil
+	}
+
+	name := fileName
+	if isWrapped(name) {
+		name = strings.TrimSuffix(name, filepath.Ext(name))
+	}
+
+	zipName := strings.TrimSuffix(name, filepath.Ext(name)) + ".cbz"
+
+	// i.e. RAR archive with .cbz extension is replaced
+	if zipName != fileName {
+		if _, err := os.Stat(zipName); err == nil {
+			return "", fmt.Errorf("archiveZip: %s: %w", zipName, os.ErrExist)
+		}
+	}
+
+	rules, err := compressionRules(c.Opts.Compression)
+	if err != nil {
+		return "", fmt.Errorf("archiveZip: %w", err)
+	}
+
+	err = c.outputAtomic(zipName, func(tmpName string) error {
+		archive, err := c.archiveOpen(fileName)
+		if err != nil {
+			return err
+		}
+		defer archive.Close()
+
+		f, err := os.Create(tmpName)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		zw, err := c.zipWriter(f)
+		if err != nil {
+			return err
+		}
+
+		for {
+			err := archive.Entry()
+			if err != nil {
+				if errors.Is(err, io.EOF) {
+					break
+				}
+
+				return err
+			}
+
+			data, err := c.archiveRead(archive)
+			if err != nil {
+				return err
+			}
+
+			entry := strings.ReplaceAll(archive.Name(), "\\", "/")
+
+			header := &zip.FileHeader{Name: entry, Method: zipMethod(rules, entry), Modified: archive.ModTime()}
+			header.SetMode(0644)
+
+			w, err := zw.CreateHeader(header)
+			if err != nil {
+				return err
+			}
+
+			if _, err = w.Write(data); err != nil {
+				return err
+			}
+		}
+
+		if err := zw.Close(); err != nil {
+			return err
+		}
+
+		return f.Close()
+	})
+	if err != nil {
+		return "", fmt.Errorf("archiveZip: %w", err)
+	}
+
+	if zipName != fileName {
+		if err := os.Remove(fileName); err != nil {
+			return "", fmt.Errorf("archiveZip: %w", err)
+		}
+	}
+
+	c.log(LogNormal, "converted to CBZ", "file", fileName, "output", zipName)
+
+	return zipName, nil
+}
+
+// archiveTarRewrite rewrites TAR archive in place. Entries for which remove returns true are dropped, entries named
+// as the files are replaced with their data and keep their position, files that are not in the archive are added to the end.
+func (c *Converter) archiveTarRewrite(fileName string, files map[string][]byte, remove func(name string) (bool, error)) error {
+	err := c.outputAtomic(fileName, func(tmpName string) error {
+		in, err := os.Open(fileName)
+		if err != nil {
+			return err
+		}
+		defer in.Close()
+
+		out, err := os.Create(tmpName)
+		if err != nil {
+			return err
+		}
+		defer out.Close()
+
+		tr := tar.NewReader(in)
+		tw := tar.NewWriter(out)
+
+		written := make(map[string]bool)
+
+		for {
+			header, err := tr.Next()
+			if err != nil {
+				if errors.Is(err, io.EOF) {
+					break
+				}
+
+				return err
+			}
+
+			if remove != nil {
+				drop, err := remove(header.Name)
+				if err != nil {
+					return err
+				}
+
+				if drop {
+					continue
+				}
+			}
+
+			if data, ok := files[header.Name]; ok && header.Typeflag == tar.TypeReg {
+				header.Size = int64(len(data))
+				// size is taken from the header
+				delete(header.PAXRecords, "size")
+
+				if err = tw.WriteHeader(header); err != nil {
+					return err
+				}
+
+				if _, err = tw.Write(data); err != nil {
+					return err
+				}
+
+				written[header.Name] = true
+
+				continue
+			}
+
+			if err = tw.WriteHeader(header); err != nil {
+				return err
+			}
+
+			if _, err = io.Copy(tw, tr); err != nil {
+				return err
+			}
+		}
+
+		for _, name := range slices.Sorted(maps.Keys(files)) {
+			if written[name] {
+				continue
+			}
+
+			header := &tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(files[name])), ModTime: time.Now()}
+
+			if err = tw.WriteHeader(header); err != nil {
+				return err
+			}
+
+			if _, err = tw.Write(files[name]); err != nil {
+				return err
+			}
+		}
+
+		if err = tw.Close(); err != nil {
+			return err
+		}
+
+		return out.Close()
+	})
+	if err != nil {
+		return fmt.Errorf("archiveTarRewrite: %w", err)
+	}
+
+	return nil
+}
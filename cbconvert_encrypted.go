@@ -0,0 +1,368 @@
+package cbconvert
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// ErrEncrypted is returned for password protected archives if Options.Password is not set.
+var ErrEncrypted = errors.New("archive is password protected")
+
+// ErrPassword is returned when password protected archive can not be decrypted with Options.Password.
+var ErrPassword = errors.New("wrong password")
+
+var (
+	rar4Signature = []byte("Rar!\x1a\x07\x00")
+	rar5Signature = []byte("Rar!\x1a\x07\x01\x00")
+)
+
+// rar5MaxHeader is maximum size of RAR 5.x header, larger sizes are from damaged or crafted archives.
+const rar5MaxHeader = 2 * 1024 * 1024
+
+// archiveEncrypted checks if archive (zip or rar) has encrypted headers or encrypted first file.
+func archiveEncrypted(fileName string) (bool, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return false, fmt.Errorf("archiveEncrypted: %w", err)
+	}
+	defer file.Close()
+
+	r := bufio.NewReader(file)
+
+	sig, err := r.Peek(len(rar5Signature))
+	if err != nil {
+		return false, nil
+	}
+
+	switch {
+	case bytes.HasPrefix(sig, []byte("PK")):
+		stat, err := file.Stat()
+		if err != nil {
+			return false, fmt.Errorf("archiveEncrypted: %w", err)
+		}
+
+		z, err := zip.NewReader(file, stat.Size())
+		if err != nil {
+			return false, nil
+		}
+
+		for _, f := range z.File {
+			if f.Flags&0x1 != 0 {
+				return true, nil
+			}
+		}
+	case bytes.HasPrefix(sig, rar5Signature):
+		_, _ = r.Discard(len(rar5Signature))
+
+		return rar5Encrypted(r), nil
+	case bytes.HasPrefix(sig, rar4Signature):
+		_, _ = r.Discard(len(rar4Signature))
+
+		return rar4Encrypted(r), nil
+	}
+
+	return false, nil
+}
+
+// rar4Encrypted walks RAR 4.x blocks until the first file header.
+func rar4Encrypted(r *bufio.Reader) bool {
+	for {
+		var h struct {
+			CRC   uint16
+			Type  uint8
+			Flags uint16
+			Size  uint16
+		}
+
+		if err := binary.Read(r, binary.LittleEndian, &h); err != nil || h.Size < 7 {
+			return false
+		}
+
+		switch h.Type {
+		case 0x73: // main header
+			if h.Flags&0x0080 != 0 {
+				return true
+			}
+		case 0x74: // file header
+			return h.Flags&0x0004 != 0
+		}
+
+		skip := int64(h.Size) - 7
+		if h.Flags&0x8000 != 0 {
+			var add uint32
+			if err := binary.Read(r, binary.LittleEndian, &add); err != nil {
+				return false
+			}
+			skip += int64(add) - 4
+		}
+
+		if _, err := io.CopyN(io.Discard, r, skip); err != nil {
+			return false
+		}
+	}
+}
+
+// rar5Encrypted walks RAR 5.x headers until the first file header.
+func rar5Encrypted(r *bufio.Reader) bool {
+	for {
+		if _, err := r.Discard(4); err != nil { // header CRC32
+			return false
+		}
+
+		size, err := binary.ReadUvarint(r)
+		if err != nil || size == 0 || size > rar5MaxHeader {
+			return false
+		}
+
+		header := make([]byte, size)
+		if _, err := io.ReadFull(r, header); err != nil {
+			return false
+		}
+
+		hr := bytes.NewReader(header)
+		typ, _ := binary.ReadUvarint(hr)
+		flags, _ := binary.ReadUvarint(hr)
+
+		var extraSize, dataSize uint64
+		if flags&0x0001 != 0 {
+			extraSize, _ = binary.ReadUvarint(hr)
+		}
+		if flags&0x0002 != 0 {
+			dataSize, _ = binary.ReadUvarint(hr)
+		}
+
+		switch typ {
+		case 4: // archive encryption header
+			return true
+		case 2: // file header
+			if extraSize == 0 || extraSize > size {
+				return false
+			}
+
+			return rar5ExtraEncrypted(header[size-extraSize:])
+		}
+
+		if _, err := io.CopyN(io.Discard, r, int64(dataSize)); err != nil {
+			return false
+		}
+	}
+}
+
+// rar5ExtraEncrypted checks extra area records for the file encryption record.
+func rar5ExtraEncrypted(extra []byte) bool {
+	er := bytes.NewReader(extra)
+	for er.Len() > 0 {
+		size, err := binary.ReadUvarint(er)
+		if err != nil || size == 0 || size > uint64(er.Len()) {
+			return false
+		}
+
+		record := make([]byte, size)
+		_, _ = io.ReadFull(er, record)
+
+		if typ, _ := binary.ReadUvarint(bytes.NewReader(record)); typ == 1 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// zipMethodAES is compression method of WinZip AES encrypted entries.
+const zipMethodAES = 99
+
+// archiveDecrypt decrypts password protected archive and returns contents as ZIP archive. ZIP entries encrypted
+// with traditional PKWARE encryption are decrypted directly, AES encrypted ZIP and RAR archives are extracted
+// with external 7z, unrar or unar command.
+func archiveDecrypt(fileName, password string) ([]byte, error) {
+	z, err := zip.OpenReader(fileName)
+	if err == nil {
+		defer z.Close()
+
+		aes := false
+		for _, f := range z.File {
+			if f.Flags&0x1 != 0 && f.Method == zipMethodAES {
+				aes = true
+			}
+		}
+
+		if !aes {
+			data, err := zipDecrypt(&z.Reader, password)
+			if err != nil {
+				return nil, fmt.Errorf("archiveDecrypt: %w", err)
+			}
+
+			return data, nil
+		}
+	}
+
+	tmpDir, err := os.MkdirTemp(os.TempDir(), "cbc")
+	if err != nil {
+		return nil, fmt.Errorf("archiveDecrypt: %w", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	var cmd *exec.Cmd
+	for _, name := range []string{"7zz", "7z", "unrar", "unar"} {
+		path, err := exec.LookPath(name)
+		if err != nil {
+			continue
+		}
+
+		switch name {
+		case "unrar":
+			cmd = exec.Command(path, "x", "-p"+password, "-y", fileName, tmpDir+string(os.PathSeparator))
+		case "unar":
+			cmd = exec.Command(path, "-q", "-f", "-D", "-p", password, "-o", tmpDir, fileName)
+		default:
+			cmd = exec.Command(path, "x", "-p"+password, "-y", "-o"+tmpDir, fileName)
+		}
+
+		break
+	}
+
+	if cmd == nil {
+		return nil, fmt.Errorf("archiveDecrypt: password protected archives require 7z, unrar or unar command")
+	}
+
+	// stdin is empty, so the commands do not wait for the password prompt if the password is wrong
+	cmd.Stdin = strings.NewReader("")
+
+	if out, err := cmd.CombinedOutput(); err != nil {
+		if bytes.Contains(bytes.ToLower(out), []byte("password")) {
+			return nil, fmt.Errorf("archiveDecrypt: %w", ErrPassword)
+		}
+
+		return nil, fmt.Errorf("archiveDecrypt: %w: %s", err, strings.TrimSpace(string(out)))
+	}
+
+	data, err := dirZip(tmpDir)
+	if err != nil {
+		return nil, fmt.Errorf("archiveDecrypt: %w", err)
+	}
+
+	return data, nil
+}
+
+// zipDecrypt returns ZIP archive with entries encrypted with traditional PKWARE encryption decrypted and stored.
+func zipDecrypt(z *zip.Reader, password string) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+
+	for _, f := range z.File {
+		r, err := f.OpenRaw()
+		if err != nil {
+			return nil, fmt.Errorf("zipDecrypt: %w", err)
+		}
+
+		if f.Flags&0x1 == 0 {
+			w, err := zw.CreateRaw(&f.FileHeader)
+			if err != nil {
+				return nil, fmt.Errorf("zipDecrypt: %w", err)
+			}
+
+			if _, err = io.Copy(w, r); err != nil {
+				return nil, fmt.Errorf("zipDecrypt: %w", err)
+			}
+
+			continue
+		}
+
+		raw, err := io.ReadAll(r)
+		if err != nil {
+			return nil, fmt.Errorf("zipDecrypt: %w", err)
+		}
+
+		// the last byte of the encryption header is checked against the high byte of the CRC, or of the time
+		// when the CRC is in the data descriptor
+		check := byte(f.CRC32 >> 24)
+		if f.Flags&0x8 != 0 {
+			check = byte(f.ModifiedTime >> 8)
+		}
+
+		if len(raw) < 12 {
+			return nil, fmt.Errorf("zipDecrypt: %s: %w", f.Name, zip.ErrFormat)
+		}
+
+		plain := newZipCrypto(password).decrypt(raw)
+		if plain[11] != check {
+			return nil, fmt.Errorf("zipDecrypt: %w", ErrPassword)
+		}
+
+		var data []byte
+		switch f.Method {
+		case zip.Store:
+			data = plain[12:]
+		case zip.Deflate:
+			fr := flate.NewReader(bytes.NewReader(plain[12:]))
+			data, err = io.ReadAll(fr)
+			_ = fr.Close()
+			if err != nil {
+				return nil, fmt.Errorf("zipDecrypt: %s: %w", f.Name, ErrPassword)
+			}
+		default:
+			return nil, fmt.Errorf("zipDecrypt: %s: %w", f.Name, zip.ErrAlgorithm)
+		}
+
+		if crc32.ChecksumIEEE(data) != f.CRC32 {
+			return nil, fmt.Errorf("zipDecrypt: %s: %w", f.Name, ErrPassword)
+		}
+
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: f.Name, Method: zip.Store, Modified: f.Modified})
+		if err != nil {
+			return nil, fmt.Errorf("zipDecrypt: %w", err)
+		}
+
+		if _, err = w.Write(data); err != nil {
+			return nil, fmt.Errorf("zipDecrypt: %w", err)
+		}
+	}
+
+	if err := zw.Close(); err != nil {
+		return nil, fmt.Errorf("zipDecrypt: %w", err)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// zipCrypto type, keys of traditional PKWARE encryption.
+type zipCrypto [3]uint32
+
+// newZipCrypto returns keys initialized with password.
+func newZipCrypto(password string) *zipCrypto {
+	k := &zipCrypto{0x12345678, 0x23456789, 0x34567890}
+	for i := 0; i < len(password); i++ {
+		k.update(password[i])
+	}
+
+	return k
+}
+
+// update updates keys with plain byte.
+func (k *zipCrypto) update(b byte) {
+	k[0] = crc32.IEEETable[byte(k[0])^b] ^ (k[0] >> 8)
+	k[1] = (k[1]+k[0]&0xff)*134775813 + 1
+	k[2] = crc32.IEEETable[byte(k[2])^byte(k[1]>>24)] ^ (k[2] >> 8)
+}
+
+// decrypt returns decrypted data.
+func (k *zipCrypto) decrypt(data []byte) []byte {
+	out := make([]byte, len(data))
+	for i, c := range data {
+		t := k[2] | 2
+		out[i] = c ^ byte((t*(t^1))>>8)
+		k.update(out[i])
+	}
+
+	return out
+}
@@ -0,0 +1,325 @@
+package cbconvert
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/xml"
+	"fmt"
+	"hash/crc32"
+	"html"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/gen2brain/cbconvert/meta"
+)
+
+// epubMediaTypes are media types of the images, EPUB 3.3 core media types.
+var epubMediaTypes = map[string]string{
+	".jpg":  "image/jpeg",
+	".jpeg": "image/jpeg",
+	".png":  "image/png",
+	".gif":  "image/gif",
+	".webp": "image/webp",
+}
+
+// epubPage type, page in the EPUB spine.
+type epubPage struct {
+	Image  string
+	Alt    string
+	Width  int
+	Height int
+}
+
+// archiveSaveEpub saves workdir to fixed-layout EPUB3 book, every image is a pre-paginated page.
+func (c *Converter) archiveSaveEpub(fileName, epubName string) error {
+	if c.OnCompress != nil {
+		c.OnCompress(c.job)
+	}
+
+	if _, ok := epubMediaTypes["."+c.Opts.Format]; !ok && !c.Opts.NoConvert {
+		return fmt.Errorf("archiveSaveEpub: unsupported image format %s, use jpeg, png, gif or webp", c.Opts.Format)
+	}
+
+	files, err := c.workdirFiles()
+	if err != nil {
+		return fmt.Errorf("archiveSaveEpub: %w", err)
+	}
+
+	var images []string
+	for _, file := range files {
+		if _, ok := epubMediaTypes[strings.ToLower(filepath.Ext(file.Name()))]; ok {
+			images = append(images, file.Name())
+		}
+	}
+
+	if len(images) == 0 {
+		return fmt.Errorf("archiveSaveEpub: no images")
+	}
+
+	var info ComicInfo
+	if name := c.comicInfoName(); name != "" {
+		if data, err := os.ReadFile(filepath.Join(c.job.workdir, name)); err == nil {
+			info, _ = meta.Parse(data)
+		}
+	}
+
+	title := info.Title
+	if title == "" {
+		title = baseNoExt(fileName)
+	}
+
+	lang := info.LanguageISO
+	if lang == "" {
+		lang = "en"
+	}
+
+	rtl := c.Opts.RightToLeft || info.Manga == "YesAndRightToLeft"
+
+	alts, err := c.altTexts(fileName, images)
+	if err != nil {
+		return fmt.Errorf("archiveSaveEpub: %w", err)
+	}
+
+	pages := make([]epubPage, 0, len(images))
+	for idx, img := range images {
+		file, err := os.Open(filepath.Join(c.job.workdir, img))
+		if err != nil {
+			return fmt.Errorf("archiveSaveEpub: %w", err)
+		}
+
+		width, height, err := imageDimensions(file)
+		_ = file.Close()
+		if err != nil {
+			return fmt.Errorf("archiveSaveEpub: %s: %w", img, err)
+		}
+
+		pages = append(pages, epubPage{Image: img, Alt: alts[idx], Width: width, Height: height})
+	}
+
+	epubFile, err := os.Create(epubName)
+	if err != nil {
+		return fmt.Errorf("archiveSaveEpub: %w", err)
+	}
+
+	z := zip.NewWriter(epubFile)
+
+	modified := c.now()
+
+	write := func(name string, method uint16, data []byte) error {
+		w, err := z.CreateHeader(&zip.FileHeader{Name: name, Method: method, Modified: modified})
+		if err != nil {
+			return err
+		}
+
+		_, err = w.Write(data)
+
+		return err
+	}
+
+	// mimetype must be the first entry, stored without compression, extra field and data descriptor
+	mimetype := []byte("application/epub+zip")
+	w, err := z.CreateRaw(&zip.FileHeader{Name: "mimetype", Method: zip.Store, CRC32: crc32.ChecksumIEEE(mimetype),
+		CompressedSize64: uint64(len(mimetype)), UncompressedSize64: uint64(len(mimetype))})
+	if err != nil {
+		return fmt.Errorf("archiveSaveEpub: %w", err)
+	}
+
+	if _, err = w.Write(mimetype); err != nil {
+		return fmt.Errorf("archiveSaveEpub: %w", err)
+	}
+
+	if err = write("META-INF/container.xml", zip.Deflate, []byte(epubContainer)); err != nil {
+		return fmt.Errorf("archiveSaveEpub: %w", err)
+	}
+
+	cover := c.coverName(images)
+
+	for idx, page := range pages {
+		data, err := os.ReadFile(filepath.Join(c.job.workdir, page.Image))
+		if err != nil {
+			return fmt.Errorf("archiveSaveEpub: %w", err)
+		}
+
+		if err = write("OEBPS/images/"+page.Image, zip.Store, data); err != nil {
+			return fmt.Errorf("archiveSaveEpub: %w", err)
+		}
+
+		if err = write(fmt.Sprintf("OEBPS/pages/%04d.xhtml", idx+1), zip.Deflate, epubPageXHTML(title, page)); err != nil {
+			return fmt.Errorf("archiveSaveEpub: %w", err)
+		}
+	}
+
+	if err = write("OEBPS/nav.xhtml", zip.Deflate, c.epubNav(title, lang, images)); err != nil {
+		return fmt.Errorf("archiveSaveEpub: %w", err)
+	}
+
+	sum := sha256.Sum256([]byte(baseNoExt(fileName) + " " + c.Opts.Hash()))
+	uuid := fmt.Sprintf("%x-%x-%x-%x-%x", sum[0:4], sum[4:6], sum[6:8], sum[8:10], sum[10:16])
+
+	var opf bytes.Buffer
+	opf.WriteString(xml.Header)
+	opf.WriteString(`<package xmlns="http://www.idpf.org/2007/opf" version="3.0" unique-identifier="uid" prefix="rendition: http://www.idpf.org/vocab/rendition/#">` + "\n")
+	opf.WriteString(`  <metadata xmlns:dc="http://purl.org/dc/elements/1.1/">` + "\n")
+	fmt.Fprintf(&opf, "    <dc:identifier id=\"uid\">urn:uuid:%s</dc:identifier>\n", uuid)
+	fmt.Fprintf(&opf, "    <dc:title>%s</dc:title>\n", html.EscapeString(title))
+	fmt.Fprintf(&opf, "    <dc:language>%s</dc:language>\n", html.EscapeString(lang))
+	if info.Writer != "" {
+		fmt.Fprintf(&opf, "    <dc:creator>%s</dc:creator>\n", html.EscapeString(info.Writer))
+	}
+	if info.Publisher != "" {
+		fmt.Fprintf(&opf, "    <dc:publisher>%s</dc:publisher>\n", html.EscapeString(info.Publisher))
+	}
+	fmt.Fprintf(&opf, "    <meta property=\"dcterms:modified\">%s</meta>\n", modified.UTC().Format("2006-01-02T15:04:05Z"))
+	opf.WriteString("    <meta property=\"rendition:layout\">pre-paginated</meta>\n")
+	opf.WriteString("    <meta property=\"rendition:orientation\">auto</meta>\n")
+	opf.WriteString("    <meta property=\"rendition:spread\">landscape</meta>\n")
+	opf.WriteString("    <meta name=\"cover\" content=\"cover\"/>\n")
+	opf.WriteString("    <meta name=\"fixed-layout\" content=\"true\"/>\n")
+	opf.WriteString("    <meta name=\"book-type\" content=\"comic\"/>\n")
+	fmt.Fprintf(&opf, "    <meta name=\"original-resolution\" content=\"%dx%d\"/>\n", pages[0].Width, pages[0].Height)
+	if rtl {
+		opf.WriteString("    <meta name=\"primary-writing-mode\" content=\"horizontal-rl\"/>\n")
+	}
+	opf.WriteString("  </metadata>\n")
+
+	opf.WriteString("  <manifest>\n")
+	opf.WriteString("    <item id=\"nav\" href=\"nav.xhtml\" media-type=\"application/xhtml+xml\" properties=\"nav\"/>\n")
+	for idx, page := range pages {
+		id, props := fmt.Sprintf("img%04d", idx+1), ""
+		if page.Image == cover {
+			id, props = "cover", ` properties="cover-image"`
+		}
+
+		fmt.Fprintf(&opf, "    <item id=\"%s\" href=\"images/%s\" media-type=\"%s\"%s/>\n",
+			id, epubHref(page.Image), epubMediaTypes[strings.ToLower(filepath.Ext(page.Image))], props)
+		fmt.Fprintf(&opf, "    <item id=\"page%04d\" href=\"pages/%04d.xhtml\" media-type=\"application/xhtml+xml\"/>\n", idx+1, idx+1)
+	}
+	opf.WriteString("  </manifest>\n")
+
+	direction := "ltr"
+	if rtl {
+		direction = "rtl"
+	}
+
+	fmt.Fprintf(&opf, "  <spine page-progression-direction=\"%s\">\n", direction)
+	for idx, page := range pages {
+		// readers rotate the view for pages turned sideways with Options.Rotate
+		props := ""
+		if c.Opts.Rotate == 90 || c.Opts.Rotate == 270 {
+			props = ` properties="rendition:orientation-portrait"`
+			if page.Width > page.Height {
+				props = ` properties="rendition:orientation-landscape"`
+			}
+		}
+
+		fmt.Fprintf(&opf, "    <itemref idref=\"page%04d\"%s/>\n", idx+1, props)
+	}
+	opf.WriteString("  </spine>\n")
+	opf.WriteString("</package>\n")
+
+	if err = write("OEBPS/content.opf", zip.Deflate, opf.Bytes()); err != nil {
+		return fmt.Errorf("archiveSaveEpub: %w", err)
+	}
+
+	if err = z.Close(); err != nil {
+		return fmt.Errorf("archiveSaveEpub: %w", err)
+	}
+
+	if err = epubFile.Close(); err != nil {
+		return fmt.Errorf("archiveSaveEpub: %w", err)
+	}
+
+	err = os.RemoveAll(c.job.workdir)
+	if err != nil {
+		return fmt.Errorf("archiveSaveEpub: %w", err)
+	}
+
+	return nil
+}
+
+// epubHref returns URL of the image in XML attribute.
+func epubHref(name string) string {
+	return html.EscapeString((&url.URL{Path: name}).EscapedPath())
+}
+
+// epubContainer is META-INF/container.xml pointing to the package document.
+const epubContainer = `<?xml version="1.0" encoding="UTF-8"?>
+<container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container">
+  <rootfiles>
+    <rootfile full-path="OEBPS/content.opf" media-type="application/oebps-package+xml"/>
+  </rootfiles>
+</container>
+`
+
+// epubPageXHTML returns page document, the viewport is set to the image dimensions.
+func epubPageXHTML(title string, page epubPage) []byte {
+	var b bytes.Buffer
+	b.WriteString(xml.Header)
+	b.WriteString("<!DOCTYPE html>\n")
+	b.WriteString(`<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops">` + "\n")
+	b.WriteString("<head>\n")
+	fmt.Fprintf(&b, "  <title>%s</title>\n", html.EscapeString(title))
+	fmt.Fprintf(&b, "  <meta name=\"viewport\" content=\"width=%d, height=%d\"/>\n", page.Width, page.Height)
+	b.WriteString("  <style>html, body { margin: 0; padding: 0; } img { display: block; width: 100%; height: 100%; }</style>\n")
+	b.WriteString("</head>\n")
+	b.WriteString("<body>\n")
+	fmt.Fprintf(&b, "  <img src=\"../images/%s\" alt=\"%s\" width=\"%d\" height=\"%d\"/>\n",
+		epubHref(page.Image), html.EscapeString(page.Alt), page.Width, page.Height)
+	b.WriteString("</body>\n")
+	b.WriteString("</html>\n")
+
+	return b.Bytes()
+}
+
+// epubNav returns navigation document, chapters are listed in the table of contents, or the first page if there are none.
+func (c *Converter) epubNav(title, lang string, images []string) []byte {
+	type entry struct {
+		Title string
+		Page  int
+	}
+
+	pageIndex := make(map[string]int, len(images))
+	for idx, img := range images {
+		pageIndex[baseNoExt(img)] = idx + 1
+	}
+
+	var toc []entry
+	for chapter, page := range c.job.chapters {
+		if idx, ok := pageIndex[page]; ok {
+			toc = append(toc, entry{Title: chapter, Page: idx})
+		}
+	}
+
+	if len(toc) == 0 {
+		toc = append(toc, entry{Title: title, Page: 1})
+	}
+
+	sort.Slice(toc, func(i, j int) bool {
+		return toc[i].Page < toc[j].Page
+	})
+
+	var b bytes.Buffer
+	b.WriteString(xml.Header)
+	b.WriteString("<!DOCTYPE html>\n")
+	fmt.Fprintf(&b, "<html xmlns=\"http://www.w3.org/1999/xhtml\" xmlns:epub=\"http://www.idpf.org/2007/ops\" lang=\"%s\" xml:lang=\"%s\">\n", html.EscapeString(lang), html.EscapeString(lang))
+	fmt.Fprintf(&b, "<head>\n  <title>%s</title>\n</head>\n", html.EscapeString(title))
+	b.WriteString("<body>\n")
+	b.WriteString("  <nav epub:type=\"toc\" id=\"toc\">\n    <ol>\n")
+	for _, e := range toc {
+		fmt.Fprintf(&b, "      <li><a href=\"pages/%04d.xhtml\">%s</a></li>\n", e.Page, html.EscapeString(e.Title))
+	}
+	b.WriteString("    </ol>\n  </nav>\n")
+	b.WriteString("  <nav epub:type=\"page-list\" hidden=\"\">\n    <ol>\n")
+	for idx := range images {
+		fmt.Fprintf(&b, "      <li><a href=\"pages/%04d.xhtml\">%d</a></li>\n", idx+1, idx+1)
+	}
+	b.WriteString("    </ol>\n  </nav>\n")
+	b.WriteString("</body>\n")
+	b.WriteString("</html>\n")
+
+	return b.Bytes()
+}
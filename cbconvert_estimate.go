@@ -0,0 +1,145 @@
+package cbconvert
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"os"
+	"strconv"
+)
+
+// sampleImages returns decoded sampled pages, pages are from ListPages.
+func (c *Converter) sampleImages(fileName string, fileInfo os.FileInfo, pages []PageInfo) ([]image.Image, error) {
+	var err error
+	var images []image.Image
+
+	switch {
+	case fileInfo.IsDir(), isImage(fileName):
+		images, err = c.sampleDirectory(pages)
+	case isDocument(fileName):
+		images, err = c.sampleDocument(fileName, pages)
+	case isArchive(fileName):
+		images, err = c.sampleArchive(fileName, pages)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("sampleImages: %w", err)
+	}
+
+	return images, nil
+}
+
+// sampleArchive returns decoded sampled archive pages.
+func (c *Converter) sampleArchive(fileName string, pages []PageInfo) ([]image.Image, error) {
+	images := make([]image.Image, 0, len(pages))
+
+	archive, err := c.archiveOpen(fileName)
+	if err != nil {
+		return nil, fmt.Errorf("sampleArchive: %w", err)
+	}
+	defer archive.Close()
+
+	for _, page := range pages {
+		if err = archive.EntryFor(page.Name); err != nil {
+			return nil, fmt.Errorf("sampleArchive: %s: %w", page.Name, err)
+		}
+
+		data, err := c.archiveRead(archive)
+		if err != nil {
+			return nil, fmt.Errorf("sampleArchive: %s: %w", page.Name, err)
+		}
+
+		img, err := c.imageDecode(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("sampleArchive: %s: %w", page.Name, err)
+		}
+
+		images = append(images, img)
+	}
+
+	return images, nil
+}
+
+// sampleDocument returns sampled document pages.
+func (c *Converter) sampleDocument(fileName string, pages []PageInfo) ([]image.Image, error) {
+	images := make([]image.Image, 0, len(pages))
+
+	doc, err := openDocument(fileName)
+	if err != nil {
+		return nil, fmt.Errorf("sampleDocument: %w", err)
+	}
+	defer doc.Close()
+
+	for _, page := range pages {
+		// document pages are named by index
+		n, err := strconv.Atoi(page.Name)
+		if err != nil {
+			return nil, fmt.Errorf("sampleDocument: %w", err)
+		}
+
+		img, err := c.documentImage(doc, n)
+		if err != nil {
+			return nil, fmt.Errorf("sampleDocument: %w", err)
+		}
+
+		images = append(images, img)
+	}
+
+	return images, nil
+}
+
+// sampleDirectory returns decoded sampled directory pages.
+func (c *Converter) sampleDirectory(pages []PageInfo) ([]image.Image, error) {
+	images := make([]image.Image, 0, len(pages))
+
+	for _, page := range pages {
+		file, err := os.Open(page.Name)
+		if err != nil {
+			return nil, fmt.Errorf("sampleDirectory: %w", err)
+		}
+
+		img, err := c.imageDecode(file)
+		_ = file.Close()
+
+		if err != nil {
+			return nil, fmt.Errorf("sampleDirectory: %s: %w", page.Name, err)
+		}
+
+		images = append(images, img)
+	}
+
+	return images, nil
+}
+
+// sampleSize returns size of the encoded image.
+func (c *Converter) sampleSize(img image.Image) (int64, error) {
+	var w countWriter
+	if err := c.imageEncode(img, &w); err != nil {
+		return 0, fmt.Errorf("sampleSize: %w", err)
+	}
+
+	return int64(w), nil
+}
+
+// samplePages returns n pages evenly spaced in pages, all pages if n is zero or greater than number of pages.
+func samplePages(pages []PageInfo, n int) []PageInfo {
+	if n <= 0 || n >= len(pages) {
+		return pages
+	}
+
+	sampled := make([]PageInfo, 0, n)
+	for i := 0; i < n; i++ {
+		sampled = append(sampled, pages[i*len(pages)/n])
+	}
+
+	return sampled
+}
+
+// countWriter type, counts written bytes.
+type countWriter int64
+
+func (w *countWriter) Write(p []byte) (int, error) {
+	*w += countWriter(len(p))
+
+	return len(p), nil
+}
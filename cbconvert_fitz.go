@@ -0,0 +1,60 @@
+//go:build !nofitz
+
+package cbconvert
+
+import (
+	"image"
+
+	"github.com/gen2brain/go-fitz"
+)
+
+// documents reports if documents are supported.
+const documents = true
+
+// documentModule is module that renders documents.
+const documentModule = "github.com/gen2brain/go-fitz"
+
+// fitzDocument type, document rendered with MuPDF.
+type fitzDocument struct {
+	*fitz.Document
+}
+
+// openFitz opens document with MuPDF.
+func openFitz(fileName string) (document, error) {
+	doc, err := fitz.New(fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	return fitzDocument{doc}, nil
+}
+
+// Image returns rendered page.
+func (d fitzDocument) Image(n int) (image.Image, error) {
+	return d.Document.Image(n)
+}
+
+// Size returns size of the rendered page, pages are rendered at 300 DPI and bounds are in points.
+func (d fitzDocument) Size(n int) (image.Point, error) {
+	bound, err := d.Document.Bound(n)
+	if err != nil {
+		return image.Point{}, err
+	}
+
+	return image.Pt(bound.Dx()*300/72, bound.Dy()*300/72), nil
+}
+
+// ToC returns table of contents.
+func (d fitzDocument) ToC() ([]outline, error) {
+	toc, err := d.Document.ToC()
+	if err != nil {
+		return nil, err
+	}
+
+	outlines := make([]outline, 0, len(toc))
+	for _, o := range toc {
+		outlines = append(outlines, outline{Level: o.Level, Title: o.Title, Page: o.Page})
+	}
+
+	return outlines, nil
+}
@@ -5,9 +5,14 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
+	"time"
 )
 
+// reproducibleTime is used for all timestamps in reproducible mode (earliest time ZIP can store).
+var reproducibleTime = time.Date(1980, 1, 1, 0, 0, 0, 0, time.UTC)
+
 // imagesFromPath returns list of found image files for given directory.
 func imagesFromPath(path string) ([]string, error) {
 	var images []string
@@ -59,22 +64,49 @@ func imagesFromSlice(files []string) []string {
 	return images
 }
 
+// archiveTypes are extensions of the supported archives.
+var archiveTypes = []string{".rar", ".zip", ".7z", ".tar", ".cbr", ".cbz", ".cb7", ".cbt", ".cba", ".tgz", ".tbz2", ".tzst", ".txz"}
+
 // isArchive checks if file is archive.
 func isArchive(f string) bool {
-	var types = []string{".rar", ".zip", ".7z", ".tar", ".cbr", ".cbz", ".cb7", ".cbt"}
-	for _, t := range types {
+	if isWrapped(f) {
+		return true
+	}
+
+	for _, t := range archiveTypes {
 		if strings.ToLower(filepath.Ext(f)) == t {
 			return true
 		}
 	}
 
+	// formats handled by plugins are read as archives
+	_, ok := pluginFind(f)
+
+	return ok
+}
+
+// isWrapped checks if file is archive compressed with gzip, bzip2, zstd or xz, i.e. .tar.gz or .cbt.zst.
+func isWrapped(f string) bool {
+	var types = []string{".zip", ".tar", ".cbz", ".cbt"}
+
+	ext := strings.ToLower(filepath.Ext(f))
+	if ext != ".gz" && ext != ".bz2" && ext != ".zst" && ext != ".xz" {
+		return false
+	}
+
+	inner := strings.ToLower(filepath.Ext(strings.TrimSuffix(f, filepath.Ext(f))))
+	for _, t := range types {
+		if inner == t {
+			return true
+		}
+	}
+
 	return false
 }
 
 // isDocument checks if file is document.
 func isDocument(f string) bool {
-	var types = []string{".pdf", ".xps", ".epub", ".mobi", ".docx", ".pptx", ".xlsx"}
-	for _, t := range types {
+	for _, t := range slices.Concat(documentTypes, djvuTypes) {
 		if strings.ToLower(filepath.Ext(f)) == t {
 			return true
 		}
@@ -118,11 +150,76 @@ func isSize(a, b int64) bool {
 	return true
 }
 
+// formatExt returns file extension for image format.
+func formatExt(format string) string {
+	if format == "jpeg" {
+		return "jpg"
+	}
+
+	return format
+}
+
 // baseNoExt returns base name without extension.
 func baseNoExt(filename string) string {
+	if isWrapped(filename) {
+		filename = strings.TrimSuffix(filename, filepath.Ext(filename))
+	}
+
 	return strings.TrimSuffix(filepath.Base(filename), filepath.Ext(filename))
 }
 
+// entryName returns name of the archive entry that is safe to use in workdir, i.e. ../../etc/passwd is passwd.
+// Directories are removed with both slash and backslash separators, entries from Windows archives are
+// also stripped on Unix, so they cannot escape when the output is extracted elsewhere.
+func entryName(name string) string {
+	parts := strings.FieldsFunc(name, func(r rune) bool {
+		return r == '/' || r == '\\'
+	})
+
+	base := ""
+	if len(parts) > 0 {
+		base = parts[len(parts)-1]
+	}
+
+	// volume name, i.e. C:file
+	if len(base) >= 2 && base[1] == ':' && ((base[0] >= 'a' && base[0] <= 'z') || (base[0] >= 'A' && base[0] <= 'Z')) {
+		base = base[2:]
+	}
+
+	base = strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f {
+			return -1
+		}
+
+		return r
+	}, base)
+
+	if base == "" || base == "." || base == ".." {
+		return "_"
+	}
+
+	return base
+}
+
+// workdirPath returns path of the archive entry or file in workdir.
+func (c *Converter) workdirPath(name string) string {
+	return filepath.Join(c.job.workdir, entryName(name))
+}
+
+// uniqueName returns name that does not exist, i.e. book.2.cbz if book.cbz exists.
+func uniqueName(name string) string {
+	ext := filepath.Ext(name)
+	stem := strings.TrimSuffix(name, ext)
+
+	for i := 2; ; i++ {
+		if _, err := os.Lstat(name); os.IsNotExist(err) {
+			return name
+		}
+
+		name = fmt.Sprintf("%s.%d%s", stem, i, ext)
+	}
+}
+
 // copyFile copies reader to file.
 func copyFile(reader io.Reader, filename string) error {
 	err := os.MkdirAll(filepath.Dir(filename), 0755)
@@ -143,3 +240,62 @@ func copyFile(reader io.Reader, filename string) error {
 
 	return nil
 }
+
+// commandArgs splits command line into arguments and replaces placeholders in each argument.
+// Arguments can be quoted with single or double quotes.
+func commandArgs(command string, vars map[string]string) []string {
+	var args []string
+	var arg strings.Builder
+	var quote rune
+	var inArg bool
+
+	for _, r := range command {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				arg.WriteRune(r)
+			}
+		case r == '"' || r == '\'':
+			quote = r
+			inArg = true
+		case r == ' ' || r == '\t':
+			if inArg {
+				args = append(args, arg.String())
+				arg.Reset()
+				inArg = false
+			}
+		default:
+			arg.WriteRune(r)
+			inArg = true
+		}
+	}
+
+	if inArg {
+		args = append(args, arg.String())
+	}
+
+	for idx, a := range args {
+		for k, v := range vars {
+			a = strings.ReplaceAll(a, k, v)
+		}
+		args[idx] = a
+	}
+
+	return args
+}
+
+// version returns the library module version from build info.
+func version() string {
+	return moduleVersion("github.com/gen2brain/cbconvert")
+}
+
+// now returns current time, or fixed time in reproducible mode.
+func (c *Converter) now() time.Time {
+	if c.Opts.Reproducible {
+		return reproducibleTime
+	}
+
+	return time.Now()
+}
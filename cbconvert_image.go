@@ -1,13 +1,19 @@
 package cbconvert
 
 import (
+	"encoding/hex"
+	"fmt"
 	"image"
 	"image/color"
 	"image/draw"
+	"image/gif"
 	"math"
+	"strings"
 
 	"github.com/anthonynsimon/bild/adjust"
+	"github.com/anthonynsimon/bild/effect"
 	"github.com/anthonynsimon/bild/transform"
+	xdraw "golang.org/x/image/draw"
 )
 
 // Resample filters.
@@ -38,7 +44,35 @@ var filters = map[int]transform.ResampleFilter{
 	lanczos:           transform.Lanczos,
 }
 
-func resize(img image.Image, width, height int, filter transform.ResampleFilter) *image.RGBA {
+// resampler resizes image to the exact dimensions.
+type resampler func(img image.Image, width, height int) *image.RGBA
+
+// resizer returns resampler with the filter, implementation is selected with Options.Resizer.
+// bild resamples in a single pass, golang.org/x/image/draw uses separable kernels with fast paths for RGBA, NRGBA,
+// YCbCr and Gray images, the same filter functions are used in both.
+func (c *Converter) resizer(filter int) resampler {
+	f := filters[filter]
+
+	if c.Opts.Resizer == "draw" {
+		var scaler xdraw.Scaler = xdraw.NearestNeighbor
+		if f.Fn != nil {
+			scaler = &xdraw.Kernel{Support: f.Support, At: f.Fn}
+		}
+
+		return func(img image.Image, width, height int) *image.RGBA {
+			dst := image.NewRGBA(image.Rect(0, 0, width, height))
+			scaler.Scale(dst, dst.Bounds(), img, img.Bounds(), xdraw.Src, nil)
+
+			return dst
+		}
+	}
+
+	return func(img image.Image, width, height int) *image.RGBA {
+		return transform.Resize(img, width, height, f)
+	}
+}
+
+func resize(img image.Image, width, height int, resample resampler) *image.RGBA {
 	dstW, dstH := width, height
 
 	srcW := img.Bounds().Dx()
@@ -57,10 +91,10 @@ func resize(img image.Image, width, height int, filter transform.ResampleFilter)
 		return imageToRGBA(img)
 	}
 
-	return transform.Resize(img, dstW, dstH, filter)
+	return resample(img, dstW, dstH)
 }
 
-func fit(img image.Image, width, height int, filter transform.ResampleFilter) *image.RGBA {
+func fit(img image.Image, width, height int, resample resampler) *image.RGBA {
 	maxW, maxH := width, height
 
 	b := img.Bounds()
@@ -83,13 +117,62 @@ func fit(img image.Image, width, height int, filter transform.ResampleFilter) *i
 		dstW = int(float64(dstH) * srcAspectRatio)
 	}
 
-	return resize(img, dstW, dstH, filter)
+	return resize(img, dstW, dstH, resample)
+}
+
+// imageTiles splits image taller than height into tiles of equal height, nil is returned if the image is not split.
+func imageTiles(img image.Image, height int) []image.Image {
+	i, meta := splitMeta(img)
+
+	b := i.Bounds()
+	if height <= 0 || b.Dy() <= height {
+		return nil
+	}
+
+	sub, ok := i.(interface {
+		SubImage(r image.Rectangle) image.Image
+	})
+	if !ok {
+		sub = imageToRGBA(i)
+	}
+
+	n := (b.Dy() + height - 1) / height
+	tileH := (b.Dy() + n - 1) / n
+
+	tiles := make([]image.Image, 0, n)
+	for y := b.Min.Y; y < b.Max.Y; y += tileH {
+		tiles = append(tiles, withMeta(sub.SubImage(image.Rect(b.Min.X, y, b.Max.X, min(y+tileH, b.Max.Y))), meta))
+	}
+
+	return tiles
 }
 
 func rotate(img image.Image, angle float64) *image.RGBA {
 	return transform.Rotate(img, angle, &transform.RotationOptions{ResizeBounds: true, Pivot: &image.Point{}})
 }
 
+// orient transforms image according to EXIF orientation.
+func orient(img image.Image, orientation int) image.Image {
+	switch orientation {
+	case 2:
+		return transform.FlipH(img)
+	case 3:
+		return rotate(img, 180)
+	case 4:
+		return transform.FlipV(img)
+	case 5:
+		return rotate(transform.FlipH(img), 270)
+	case 6:
+		return rotate(img, 90)
+	case 7:
+		return rotate(transform.FlipV(img), 270)
+	case 8:
+		return rotate(img, 270)
+	}
+
+	return img
+}
+
 func brightness(img image.Image, change float64) *image.RGBA {
 	return adjust.Brightness(img, change/100)
 }
@@ -98,6 +181,23 @@ func contrast(img image.Image, change float64) *image.RGBA {
 	return adjust.Contrast(img, change/100)
 }
 
+// enhance sharpens image with unsharp mask and slightly raises contrast, for thumbnails of low resolution covers.
+func enhance(img image.Image) *image.RGBA {
+	return adjust.Contrast(effect.UnsharpMask(img, 1, 0.6), 0.08)
+}
+
+// gamma applies gamma curve to the image, values above 1 darken midtones (KCC uses 1.8 for Kindle e-ink).
+func gamma(img image.Image, g float64) *image.RGBA {
+	var lut [256]uint8
+	for i := range lut {
+		lut[i] = uint8(math.Round(255 * math.Pow(float64(i)/255, g)))
+	}
+
+	return adjust.Apply(img, func(c color.RGBA) color.RGBA {
+		return color.RGBA{R: lut[c.R], G: lut[c.G], B: lut[c.B], A: c.A}
+	})
+}
+
 // imageToRGBA converts an image.Image to *image.RGBA.
 func imageToRGBA(src image.Image) *image.RGBA {
 	if dst, ok := src.(*image.RGBA); ok {
@@ -134,6 +234,205 @@ func isGrayScale(img image.Image) bool {
 	return false
 }
 
+// sampleStep returns horizontal and vertical step for a grid of about 128x128 sampled pixels.
+func sampleStep(b image.Rectangle) (int, int) {
+	const samples = 128
+
+	return max(b.Dx()/samples, 1), max(b.Dy()/samples, 1)
+}
+
+// isFlat checks if image has flat colors (line art, text), it counts unique colors in a grid of sampled pixels.
+func isFlat(img image.Image) bool {
+	const maxColors = 256
+
+	b := img.Bounds()
+	stepX, stepY := sampleStep(b)
+
+	seen := make(map[color.RGBA]struct{}, maxColors)
+	for y := b.Min.Y; y < b.Max.Y; y += stepY {
+		for x := b.Min.X; x < b.Max.X; x += stepX {
+			seen[color.RGBAModel.Convert(img.At(x, y)).(color.RGBA)] = struct{}{}
+			if len(seen) > maxColors {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// saturation returns mean saturation (0-100) of the sampled pixels, measured as chroma so noise in dark pixels is ignored.
+func saturation(img image.Image) float64 {
+	if isGrayScale(img) {
+		return 0
+	}
+
+	b := img.Bounds()
+	stepX, stepY := sampleStep(b)
+
+	var sum float64
+	var n int
+	for y := b.Min.Y; y < b.Max.Y; y += stepY {
+		for x := b.Min.X; x < b.Max.X; x += stepX {
+			r, g, bl, _ := img.At(x, y).RGBA()
+			sum += float64(max(r, g, bl)-min(r, g, bl)) / 0xffff
+			n++
+		}
+	}
+
+	if n == 0 {
+		return 0
+	}
+
+	return sum / float64(n) * 100
+}
+
+// isBlank checks if image is blank or nearly blank (i.e. legal page with a few lines of text), it measures
+// the fraction of sampled pixels that differ from the most common luma.
+func isBlank(img image.Image) bool {
+	const (
+		// luma difference of ink from the background
+		threshold = 32
+		// maximum ink coverage in percent
+		maxCoverage = 3
+	)
+
+	b := img.Bounds()
+	stepX, stepY := sampleStep(b)
+
+	var histogram [256]int
+	var total int
+	for y := b.Min.Y; y < b.Max.Y; y += stepY {
+		for x := b.Min.X; x < b.Max.X; x += stepX {
+			histogram[color.GrayModel.Convert(img.At(x, y)).(color.Gray).Y]++
+			total++
+		}
+	}
+
+	var background int
+	for l, n := range histogram {
+		if n > histogram[background] {
+			background = l
+		}
+	}
+
+	ink := total
+	for l := max(background-threshold, 0); l <= min(background+threshold, 255); l++ {
+		ink -= histogram[l]
+	}
+
+	return ink*100 < total*maxCoverage
+}
+
+// complexity returns detail of the image in the range [0, 1], it is the mean luma difference of sampled pixels
+// and their right and bottom neighbours.
+func complexity(img image.Image) float64 {
+	// mean difference (of 255) considered as full detail
+	const scale = 32
+
+	b := img.Bounds()
+	stepX, stepY := sampleStep(b)
+
+	luma := func(x, y int) float64 {
+		return float64(color.GrayModel.Convert(img.At(x, y)).(color.Gray).Y)
+	}
+
+	var sum float64
+	var n int
+	for y := b.Min.Y; y < b.Max.Y-1; y += stepY {
+		for x := b.Min.X; x < b.Max.X-1; x += stepX {
+			l := luma(x, y)
+			sum += math.Abs(l-luma(x+1, y)) + math.Abs(l-luma(x, y+1))
+			n += 2
+		}
+	}
+
+	if n == 0 {
+		return 0
+	}
+
+	return min(sum/float64(n)/scale, 1)
+}
+
+// gifFrames returns composed frames of animated GIF, frame disposal methods are applied.
+func gifFrames(g *gif.GIF) []image.Image {
+	bounds := image.Rect(0, 0, g.Config.Width, g.Config.Height)
+	if bounds.Empty() && len(g.Image) > 0 {
+		bounds = g.Image[0].Bounds()
+	}
+
+	canvas := image.NewRGBA(bounds)
+	frames := make([]image.Image, 0, len(g.Image))
+
+	for idx, frame := range g.Image {
+		var previous *image.RGBA
+		if idx < len(g.Disposal) && g.Disposal[idx] == gif.DisposalPrevious {
+			previous = image.NewRGBA(bounds)
+			copy(previous.Pix, canvas.Pix)
+		}
+
+		draw.Draw(canvas, frame.Bounds(), frame, frame.Bounds().Min, draw.Over)
+
+		dst := image.NewRGBA(bounds)
+		copy(dst.Pix, canvas.Pix)
+		frames = append(frames, dst)
+
+		if idx < len(g.Disposal) {
+			switch g.Disposal[idx] {
+			case gif.DisposalBackground:
+				draw.Draw(canvas, frame.Bounds(), image.Transparent, image.Point{}, draw.Src)
+			case gif.DisposalPrevious:
+				canvas = previous
+			}
+		}
+	}
+
+	return frames
+}
+
+// isOpaque checks if image has no transparent pixels.
+func isOpaque(img image.Image) bool {
+	if o, ok := img.(interface{ Opaque() bool }); ok {
+		return o.Opaque()
+	}
+
+	return false
+}
+
+// flatten draws image over the background color.
+func flatten(img image.Image, bg color.Color) *image.RGBA {
+	b := img.Bounds()
+	dst := image.NewRGBA(b)
+	draw.Draw(dst, b, &image.Uniform{C: bg}, image.Point{}, draw.Src)
+	draw.Draw(dst, b, img, b.Min, draw.Over)
+
+	return dst
+}
+
+// parseColor parses hex color, i.e. ffffff or #ffffff, white is returned for empty string.
+func parseColor(s string) (color.RGBA, error) {
+	if s == "" {
+		return color.RGBA{R: 255, G: 255, B: 255, A: 255}, nil
+	}
+
+	b, err := hex.DecodeString(strings.TrimPrefix(s, "#"))
+	if err != nil || len(b) != 3 {
+		return color.RGBA{}, fmt.Errorf("parseColor: invalid color %q", s)
+	}
+
+	return color.RGBA{R: b[0], G: b[1], B: b[2], A: 255}, nil
+}
+
+// alphaFormat checks if image format supports transparency.
+func alphaFormat(format string) bool {
+	switch format {
+	case "png", "tiff", "webp", "avif", "jxl":
+		return true
+	}
+
+	return false
+}
+
 var colors16 = []color.Color{
 	color.RGBA{0, 0, 0, 255},
 	color.RGBA{17, 17, 17, 255},
@@ -153,6 +452,16 @@ var colors16 = []color.Color{
 	color.RGBA{255, 255, 255, 255},
 }
 
+// imageToGray16 converts an image.Image to *image.Paletted with 16 gray levels using Floyd-Steinberg dithering,
+// PNG is encoded with 4-bit depth.
+func imageToGray16(src image.Image) *image.Paletted {
+	b := src.Bounds()
+	dst := image.NewPaletted(b, colors16)
+	draw.FloydSteinberg.Draw(dst, dst.Bounds(), src, b.Min)
+
+	return dst
+}
+
 // imageToPaletted converts an image.Image to *image.Paletted using 16-color palette.
 func imageToPaletted(src image.Image) *image.Paletted {
 	b := src.Bounds()
@@ -0,0 +1,150 @@
+package cbconvert
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+)
+
+// Job type, state of a single operation. Each operation (Convert, Cover, Meta etc.) runs in its own job,
+// so one Converter can serve multiple operations at the same time, i.e. Preview while Convert is running.
+// Jobs are passed to the progress functions, see also Converter.Jobs.
+type Job struct {
+	// Input file
+	File string
+	// Number of files, Converter.Nfiles when the job started
+	Nfiles int
+	// Index of the file, operations that process files of the batch (Convert, Cover, Thumbnail, Meta) are counted
+	CurrFile int
+	// Number of contents in archive/document
+	Ncontents int
+	// Index of current content
+	CurrContent int32
+
+	// First page of each chapter (subdirectory), used for ComicInfo bookmarks
+	chapters map[string]string
+	// Source images in natural order, indexes of the existing ComicInfo page table refer to them
+	pages []string
+	// Converted pages, set with Options.Checkpoint
+	checkpoint *checkpoint
+	// Maximum page width, set with Options.AutoWidth
+	autoWidth int
+	// Recent page times of the converter, used by ETA
+	rate *rate
+	// Working directory, removed when the job ends
+	workdir string
+	// Set if the working directory is kept for checkpoint
+	keep bool
+}
+
+// shared type, state shared by the jobs of a converter.
+type shared struct {
+	// Counters, returned by Stats
+	stats stats
+	// Recent page times, used by ETA
+	rate rate
+	// Recently used files, decoded covers and archive contents are reused between operations
+	cache cache
+	// Index of the last counted file
+	currFile atomic.Int64
+
+	mu sync.Mutex
+	// Running jobs and their cancel functions
+	jobs map[*Job]context.CancelFunc
+	// Cover images by series and issue number, read from Options.CoverDir
+	covers map[string]string
+	// ComicVine API client, used with Options.Fetch
+	comicVine *comicVine
+}
+
+// begin returns copy of the converter that runs a new job for fileName, the file is counted in the batch if count is set.
+// Options and functions are copied, changes to the copy do not affect other operations. Call end when the job is done.
+func (c *Converter) begin(fileName string, count bool) *Converter {
+	job := &Job{File: fileName, Nfiles: c.Nfiles, CurrFile: int(c.shared.currFile.Load()), rate: &c.shared.rate}
+	if count {
+		job.CurrFile = int(c.shared.currFile.Add(1))
+	}
+
+	j := *c
+	j.job = job
+
+	c.shared.mu.Lock()
+	defer c.shared.mu.Unlock()
+
+	if c.shared.jobs == nil {
+		c.shared.jobs = make(map[*Job]context.CancelFunc)
+	}
+	c.shared.jobs[job] = nil
+
+	return &j
+}
+
+// end removes the job from the running jobs and removes its working directory, unless it is kept for checkpoint.
+// It is deferred by each operation, so the working directory is also removed on error or panic.
+func (c *Converter) end() {
+	c.shared.mu.Lock()
+	delete(c.shared.jobs, c.job)
+	c.shared.mu.Unlock()
+
+	if c.job.workdir != "" && !c.job.keep {
+		if err := os.RemoveAll(c.job.workdir); err != nil {
+			c.log(LogErrors, "workdir not removed", "workdir", c.job.workdir, "error", err)
+		}
+	}
+}
+
+// setWorkdir sets working directory of the job, it is removed by end or Cleanup.
+func (c *Converter) setWorkdir(dir string) {
+	c.shared.mu.Lock()
+	defer c.shared.mu.Unlock()
+
+	c.job.workdir = dir
+}
+
+// setCancel sets cancel function of the job, it is called by Cancel.
+func (c *Converter) setCancel(cancel context.CancelFunc) {
+	c.shared.mu.Lock()
+	defer c.shared.mu.Unlock()
+
+	if _, ok := c.shared.jobs[c.job]; ok {
+		c.shared.jobs[c.job] = cancel
+	}
+}
+
+// Cleanup removes working directories of the running jobs, i.e. before exiting on signal, when the operations
+// are not able to return. Working directories are otherwise removed when the operation returns.
+func (c *Converter) Cleanup() error {
+	c.shared.mu.Lock()
+	defer c.shared.mu.Unlock()
+
+	var errs []error
+	for job := range c.shared.jobs {
+		if job.workdir != "" {
+			if err := os.RemoveAll(job.workdir); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("Cleanup: %w", err)
+	}
+
+	return nil
+}
+
+// Jobs returns running jobs.
+func (c *Converter) Jobs() []*Job {
+	c.shared.mu.Lock()
+	defer c.shared.mu.Unlock()
+
+	jobs := make([]*Job, 0, len(c.shared.jobs))
+	for job := range c.shared.jobs {
+		jobs = append(jobs, job)
+	}
+
+	return jobs
+}
@@ -0,0 +1,16 @@
+//go:build !nojxl
+
+package cbconvert
+
+import (
+	"image"
+	"io"
+
+	"github.com/gen2brain/jpegxl"
+)
+
+func init() {
+	codecs["jxl"] = &codec{[]string{".jxl"}, "github.com/gen2brain/jpegxl", 0, func(w io.Writer, img image.Image, quality int) error {
+		return jpegxl.Encode(w, img, jpegxl.Options{Quality: quality, Effort: jpegxl.DefaultEffort})
+	}}
+}
@@ -0,0 +1,88 @@
+package cbconvert
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"strings"
+)
+
+// ErrLimit is returned for archives that exceed Options.MaxEntries, Options.MaxEntrySize or Options.MaxDepth,
+// and for documents with pages larger than Options.MaxPagePixels.
+var ErrLimit = errors.New("limit exceeded")
+
+// archiveLimits checks number of entries and directory depth of the entry names.
+func (c *Converter) archiveLimits(contents []string) error {
+	if c.Opts.MaxEntries > 0 && len(contents) > c.Opts.MaxEntries {
+		return fmt.Errorf("archiveLimits: %w: %d entries, maximum is %d", ErrLimit, len(contents), c.Opts.MaxEntries)
+	}
+
+	if c.Opts.MaxDepth > 0 {
+		for _, name := range contents {
+			if depth := entryDepth(name); depth > c.Opts.MaxDepth {
+				return fmt.Errorf("archiveLimits: %w: %s is %d directories deep, maximum is %d", ErrLimit, name, depth, c.Opts.MaxDepth)
+			}
+		}
+	}
+
+	return nil
+}
+
+// archiveRead reads the current entry, the size is checked before the data is allocated.
+func (c *Converter) archiveRead(archive *archiveReader) ([]byte, error) {
+	if limit := int64(c.Opts.MaxEntrySize) * 1024 * 1024; limit > 0 && int64(archive.Size()) > limit {
+		return nil, fmt.Errorf("archiveRead: %w: %s is %d bytes, maximum is %d MB", ErrLimit, archive.Name(), archive.Size(), c.Opts.MaxEntrySize)
+	}
+
+	data, err := archive.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("archiveRead: %w", err)
+	}
+
+	return data, nil
+}
+
+// tarOverhead is maximum size of the headers of tar entry, with the extended headers for long names.
+const tarOverhead = 8 * 1024
+
+// streamLimit returns maximum decompressed size of the compressed tar archive, derived from Options.MaxEntries
+// and Options.MaxEntrySize, 0 if either is disabled.
+func (c *Converter) streamLimit() int64 {
+	if c.Opts.MaxEntries <= 0 || c.Opts.MaxEntrySize <= 0 {
+		return 0
+	}
+
+	return int64(c.Opts.MaxEntries) * (int64(c.Opts.MaxEntrySize)*1024*1024 + tarOverhead)
+}
+
+// documentImage renders page n of the document, the size is checked before the page is rendered,
+// so broken or malicious documents can not allocate huge images.
+func (c *Converter) documentImage(doc document, n int) (image.Image, error) {
+	if c.Opts.MaxPagePixels > 0 {
+		size, err := doc.Size(n)
+		if err != nil {
+			return nil, fmt.Errorf("documentImage: %w", err)
+		}
+
+		// float, sizes of crafted documents can overflow
+		if float64(size.X)*float64(size.Y) > float64(c.Opts.MaxPagePixels)*1e6 {
+			return nil, fmt.Errorf("documentImage: %w: page %d is %dx%d pixels, maximum is %d megapixels", ErrLimit, n+1, size.X, size.Y, c.Opts.MaxPagePixels)
+		}
+	}
+
+	img, err := doc.Image(n)
+	if err != nil {
+		return nil, fmt.Errorf("documentImage: %w", err)
+	}
+
+	return img, nil
+}
+
+// entryDepth returns number of directories in the entry name, both slash and backslash are separators.
+func entryDepth(name string) int {
+	parts := strings.FieldsFunc(name, func(r rune) bool {
+		return r == '/' || r == '\\'
+	})
+
+	return max(len(parts)-1, 0)
+}
@@ -0,0 +1,82 @@
+package cbconvert
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ReadList reads list of paths, one path per line (plain text or M3U playlist). Empty lines and lines starting
+// with # are skipped, relative paths are resolved against dir.
+func ReadList(r io.Reader, dir string) ([]string, error) {
+	paths := make([]string, 0)
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(strings.TrimPrefix(scanner.Text(), "\ufeff"))
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		line = strings.TrimPrefix(line, "file://")
+		if !filepath.IsAbs(line) && dir != "" {
+			line = filepath.Join(dir, line)
+		}
+
+		paths = append(paths, line)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("ReadList: %w", err)
+	}
+
+	return paths, nil
+}
+
+// ReadListFile reads list of paths from file, relative paths are resolved against the directory of the file.
+func ReadListFile(fileName string) ([]string, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return nil, fmt.Errorf("ReadListFile: %w", err)
+	}
+	defer file.Close()
+
+	paths, err := ReadList(file, filepath.Dir(fileName))
+	if err != nil {
+		return nil, fmt.Errorf("ReadListFile: %w", err)
+	}
+
+	return paths, nil
+}
+
+// ExpandArgs replaces arguments in the form @list.txt with paths read from the list file, an argument
+// that is an existing file starting with @ is kept.
+func ExpandArgs(args []string) ([]string, error) {
+	expanded := make([]string, 0, len(args))
+
+	for _, arg := range args {
+		if !strings.HasPrefix(arg, "@") || len(arg) == 1 {
+			expanded = append(expanded, arg)
+
+			continue
+		}
+
+		if _, err := os.Stat(arg); err == nil {
+			expanded = append(expanded, arg)
+
+			continue
+		}
+
+		paths, err := ReadListFile(arg[1:])
+		if err != nil {
+			return nil, fmt.Errorf("ExpandArgs: %w", err)
+		}
+
+		expanded = append(expanded, paths...)
+	}
+
+	return expanded, nil
+}
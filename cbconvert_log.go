@@ -0,0 +1,68 @@
+package cbconvert
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+)
+
+// LogLevel type.
+type LogLevel int
+
+// Log levels.
+const (
+	// Nothing is printed
+	LogSilent LogLevel = iota
+	// Only errors are printed
+	LogErrors
+	// Progress and errors
+	LogNormal
+	// Processed files and output
+	LogVerbose
+	// Pages and internal details
+	LogDebug
+)
+
+var logLevels = []string{"silent", "errors", "normal", "verbose", "debug"}
+
+// String returns log level name.
+func (l LogLevel) String() string {
+	if l < LogSilent || int(l) >= len(logLevels) {
+		return fmt.Sprintf("LogLevel(%d)", int(l))
+	}
+
+	return logLevels[l]
+}
+
+// ParseLogLevel returns log level for name, valid values are silent, errors, normal, verbose, debug.
+func ParseLogLevel(name string) (LogLevel, error) {
+	for idx, l := range logLevels {
+		if strings.EqualFold(name, l) {
+			return LogLevel(idx), nil
+		}
+	}
+
+	return LogNormal, fmt.Errorf("ParseLogLevel: invalid log level %q", name)
+}
+
+// slogLevel returns slog level for log level.
+func (l LogLevel) slogLevel() slog.Level {
+	switch {
+	case l <= LogErrors:
+		return slog.LevelError
+	case l <= LogVerbose:
+		return slog.LevelInfo
+	default:
+		return slog.LevelDebug
+	}
+}
+
+// log forwards message to Logger if the level is enabled with Options.LogLevel.
+func (c *Converter) log(level LogLevel, msg string, args ...any) {
+	if c.Logger == nil || level > c.Opts.LogLevel || level == LogSilent {
+		return
+	}
+
+	c.Logger.Log(context.Background(), level.slogLevel(), msg, args...)
+}
@@ -0,0 +1,210 @@
+package cbconvert
+
+import (
+	"archive/zip"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+var (
+	// reGroups matches groups in brackets, i.e. (2019), [Digital], {Scanner}
+	reGroups = regexp.MustCompile(`\([^)]*\)|\[[^]]*]|\{[^}]*}`)
+	// reSeriesNumber matches series name and issue number, optionally followed by the title or total count
+	reSeriesNumber = regexp.MustCompile(`^(.+?)(?:\s+-\s+|\s*#|\s+|_)(\d+(?:\.\d+)?)(?:\s+-\s+.*|\s+of\s+\d+)?$`)
+	// reVolume matches volume, i.e. v02, Vol. 2, Volume 2
+	reVolume = regexp.MustCompile(`(?i)(?:^|\s)(?:v|vol\.?\s*|volume\s*)(\d+)(?:\s|$)`)
+	// reYear matches year in brackets, i.e. (2019) or (2019-03)
+	reYear = regexp.MustCompile(`\(((?:19|20)\d{2})(?:-\d{2})?\)`)
+)
+
+// seriesNumber parses series name and issue number from file name, i.e. "SeriesName - 012.jpg" or
+// "Series Name #12 (2019) (Digital).cbz". It returns key that is the same for the matching names.
+func seriesNumber(fileName string) (string, bool) {
+	name := baseNoExt(filepath.Base(fileName))
+	name = strings.TrimSpace(reGroups.ReplaceAllString(name, ""))
+	name = strings.Join(strings.Fields(name), " ")
+
+	m := reSeriesNumber.FindStringSubmatch(name)
+	if m == nil {
+		return "", false
+	}
+
+	series := strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToLower(r)
+		}
+
+		return -1
+	}, m[1])
+
+	if series == "" {
+		return "", false
+	}
+
+	return series + "#" + issueNumber(m[2]), true
+}
+
+// issueNumber returns issue number without leading zeros, i.e. 12 for 012.
+func issueNumber(s string) string {
+	number := strings.TrimLeft(s, "0")
+	if number == "" || strings.HasPrefix(number, ".") {
+		number = "0" + number
+	}
+
+	return number
+}
+
+// nameComicInfo parses release name, i.e. "Series Name v02 #012 (2019) (Digital).cbz", it returns ComicInfo with
+// Series, Number, Volume and Year. It reports false if there is no series name.
+func nameComicInfo(fileName string) (ComicInfo, bool) {
+	info := ComicInfo{}
+
+	name := baseNoExt(filepath.Base(fileName))
+	if m := reYear.FindStringSubmatch(name); m != nil {
+		info.Year, _ = strconv.Atoi(m[1])
+	}
+
+	name = reGroups.ReplaceAllString(name, "")
+	name = strings.Join(strings.Fields(strings.ReplaceAll(name, "_", " ")), " ")
+
+	if m := reVolume.FindStringSubmatchIndex(name); m != nil {
+		info.Volume, _ = strconv.Atoi(name[m[2]:m[3]])
+		name = strings.Join(strings.Fields(name[:m[0]]+" "+name[m[1]:]), " ")
+	}
+
+	if m := reSeriesNumber.FindStringSubmatch(name); m != nil {
+		name = m[1]
+		info.Number = issueNumber(m[2])
+	} else if series, _, ok := strings.Cut(name, " - "); ok {
+		// title after the series name
+		name = series
+	}
+
+	info.Series = strings.TrimRight(name, " -#")
+	if info.Series == "" {
+		return ComicInfo{}, false
+	}
+
+	return info, true
+}
+
+// coverIndex returns cover images in directory by series and issue number key.
+func coverIndex(dir string) (map[string]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("coverIndex: %w", err)
+	}
+
+	covers := make(map[string]string)
+	for _, entry := range entries {
+		if entry.IsDir() || !isImage(entry.Name()) {
+			continue
+		}
+
+		if key, ok := seriesNumber(entry.Name()); ok {
+			covers[key] = filepath.Join(dir, entry.Name())
+		}
+	}
+
+	return covers, nil
+}
+
+// archiveCoverSet replaces the cover of archive with the matching image from Options.CoverDir, or inserts it
+// as the first page with Options.CoverInsert. Archives that are not ZIP are converted to CBZ. It returns "archive <- image", or nothing if there is no matching image.
+func (c *Converter) archiveCoverSet(fileName string) ([]string, error) {
+	key, ok := seriesNumber(fileName)
+
+	// the index is read once and shared by the jobs
+	c.shared.mu.Lock()
+	if c.shared.covers == nil {
+		covers, err := coverIndex(c.Opts.CoverDir)
+		if err != nil {
+			c.shared.mu.Unlock()
+
+			return nil, fmt.Errorf("archiveCoverSet: %w", err)
+		}
+
+		c.shared.covers = covers
+	}
+	coverName := c.shared.covers[key]
+	c.shared.mu.Unlock()
+
+	if !ok || coverName == "" {
+		c.log(LogVerbose, "no matching cover", "file", fileName)
+
+		return nil, nil
+	}
+
+	data, err := os.ReadFile(coverName)
+	if err != nil {
+		return nil, fmt.Errorf("archiveCoverSet: %w", err)
+	}
+
+	fileName, err = c.archiveZip(fileName)
+	if err != nil {
+		return nil, fmt.Errorf("archiveCoverSet: %w", err)
+	}
+
+	ext := filepath.Ext(coverName)
+	if c.Opts.CoverInsert {
+		err = c.archivePageSet(fileName, 0, data, ext, true)
+	} else {
+		err = c.archiveCoverReplace(fileName, data, ext)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("archiveCoverSet: %w", err)
+	}
+
+	return []string{fmt.Sprintf("%s <- %s", fileName, coverName)}, nil
+}
+
+// archiveCoverSetFile adds Options.CoverSet image as the cover of archive, archives that are not ZIP are converted to CBZ.
+// It returns "archive <- image".
+func (c *Converter) archiveCoverSetFile(fileName string) ([]string, error) {
+	data, err := os.ReadFile(c.Opts.CoverSet)
+	if err != nil {
+		return nil, fmt.Errorf("archiveCoverSetFile: %w", err)
+	}
+
+	fileName, err = c.archiveZip(fileName)
+	if err != nil {
+		return nil, fmt.Errorf("archiveCoverSetFile: %w", err)
+	}
+
+	if err = c.archiveCoverAdd(fileName, data, filepath.Ext(c.Opts.CoverSet)); err != nil {
+		return nil, fmt.Errorf("archiveCoverSetFile: %w", err)
+	}
+
+	return []string{fmt.Sprintf("%s <- %s", fileName, c.Opts.CoverSet)}, nil
+}
+
+// archiveCoverReplace replaces the cover page of archive, the cover is found as with Cover.
+func (c *Converter) archiveCoverReplace(fileName string, data []byte, ext string) error {
+	zr, err := zip.OpenReader(fileName)
+	if err != nil {
+		return fmt.Errorf("archiveCoverReplace: %w", err)
+	}
+
+	images := archivePages(&zr.Reader)
+	_ = zr.Close()
+
+	cover := c.coverName(images)
+
+	index := 0
+	for idx, name := range images {
+		if filepath.ToSlash(name) == cover {
+			index = idx
+		}
+	}
+
+	if err = c.archivePageSet(fileName, index, data, ext, false); err != nil {
+		return fmt.Errorf("archiveCoverReplace: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,309 @@
+package cbconvert
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+)
+
+const (
+	jpegExifPrefix = "Exif\x00\x00"
+	jpegXMPPrefix  = "http://ns.adobe.com/xap/1.0/\x00"
+	pngXMPKeyword  = "XML:com.adobe.xmp\x00"
+)
+
+// imageMeta type, raw EXIF (TIFF structure) and XMP packet of the image.
+type imageMeta struct {
+	exif []byte
+	xmp  []byte
+}
+
+func (m imageMeta) empty() bool {
+	return len(m.exif) == 0 && len(m.xmp) == 0
+}
+
+// metaImage type, decoded image that carries metadata of the source to the encoder.
+type metaImage struct {
+	image.Image
+	meta imageMeta
+}
+
+// splitMeta returns image and its metadata, if any.
+func splitMeta(img image.Image) (image.Image, imageMeta) {
+	if m, ok := img.(*metaImage); ok {
+		return m.Image, m.meta
+	}
+
+	return img, imageMeta{}
+}
+
+// withMeta returns image with metadata attached, image is returned unchanged for empty metadata.
+func withMeta(img image.Image, meta imageMeta) image.Image {
+	if meta.empty() {
+		return img
+	}
+
+	return &metaImage{img, meta}
+}
+
+// readMeta reads EXIF and XMP from JPEG, PNG or WEBP data.
+func readMeta(data []byte) imageMeta {
+	var meta imageMeta
+
+	switch {
+	case bytes.HasPrefix(data, []byte{0xff, 0xd8}):
+		for off := 2; off+4 <= len(data) && data[off] == 0xff; {
+			marker := data[off+1]
+			if marker == 0xda || marker == 0xd9 {
+				break
+			}
+
+			size := int(binary.BigEndian.Uint16(data[off+2:]))
+			if size < 2 || off+2+size > len(data) {
+				break
+			}
+
+			payload := data[off+4 : off+2+size]
+			if marker == 0xe1 {
+				switch {
+				case bytes.HasPrefix(payload, []byte(jpegExifPrefix)):
+					meta.exif = payload[len(jpegExifPrefix):]
+				case bytes.HasPrefix(payload, []byte(jpegXMPPrefix)):
+					meta.xmp = payload[len(jpegXMPPrefix):]
+				}
+			}
+
+			off += 2 + size
+		}
+	case bytes.HasPrefix(data, []byte("\x89PNG\r\n\x1a\n")):
+		for off := 8; off+12 <= len(data); {
+			size := int(binary.BigEndian.Uint32(data[off:]))
+			if size < 0 || off+12+size > len(data) {
+				break
+			}
+
+			typ := string(data[off+4 : off+8])
+			chunk := data[off+8 : off+8+size]
+
+			switch typ {
+			case "eXIf":
+				meta.exif = chunk
+			case "iTXt":
+				// keyword, compression flag, compression method, language tag and translated keyword precede the text
+				if bytes.HasPrefix(chunk, []byte(pngXMPKeyword)) && len(chunk) > len(pngXMPKeyword)+2 && chunk[len(pngXMPKeyword)] == 0 {
+					rest := chunk[len(pngXMPKeyword)+2:]
+					for i := 0; i < 2; i++ {
+						if n := bytes.IndexByte(rest, 0); n >= 0 {
+							rest = rest[n+1:]
+						}
+					}
+					meta.xmp = rest
+				}
+			case "IDAT", "IEND":
+				return meta
+			}
+
+			off += 12 + size
+		}
+	case len(data) >= 12 && string(data[:4]) == "RIFF" && string(data[8:12]) == "WEBP":
+		for off := 12; off+8 <= len(data); {
+			size := int(binary.LittleEndian.Uint32(data[off+4:]))
+			if size < 0 || off+8+size > len(data) {
+				break
+			}
+
+			chunk := data[off+8 : off+8+size]
+
+			switch string(data[off : off+4]) {
+			case "EXIF":
+				meta.exif = bytes.TrimPrefix(chunk, []byte(jpegExifPrefix))
+			case "XMP ":
+				meta.xmp = chunk
+			}
+
+			off += 8 + size + size%2
+		}
+	}
+
+	return meta
+}
+
+// exifOrientation returns orientation from EXIF and offset of the value, zero is returned if not found.
+func exifOrientation(exif []byte) (int, int) {
+	if len(exif) < 8 {
+		return 0, 0
+	}
+
+	var order binary.ByteOrder
+	switch string(exif[:4]) {
+	case "II*\x00":
+		order = binary.LittleEndian
+	case "MM\x00*":
+		order = binary.BigEndian
+	default:
+		return 0, 0
+	}
+
+	ifd := int(order.Uint32(exif[4:]))
+	if ifd < 8 || ifd+2 > len(exif) {
+		return 0, 0
+	}
+
+	count := int(order.Uint16(exif[ifd:]))
+	for i := 0; i < count; i++ {
+		entry := ifd + 2 + i*12
+		if entry+12 > len(exif) {
+			break
+		}
+
+		// orientation tag, type SHORT
+		if order.Uint16(exif[entry:]) == 0x0112 && order.Uint16(exif[entry+2:]) == 3 {
+			return int(order.Uint16(exif[entry+8:])), entry + 8
+		}
+	}
+
+	return 0, 0
+}
+
+// exifOrientationReset returns copy of EXIF with orientation set to normal (1).
+func exifOrientationReset(exif []byte) []byte {
+	_, off := exifOrientation(exif)
+	if off == 0 {
+		return exif
+	}
+
+	b := bytes.Clone(exif)
+	if string(b[:2]) == "II" {
+		binary.LittleEndian.PutUint16(b[off:], 1)
+	} else {
+		binary.BigEndian.PutUint16(b[off:], 1)
+	}
+
+	return b
+}
+
+// writeMeta inserts metadata into encoded JPEG, PNG or WEBP image, data is returned unchanged for other formats.
+func writeMeta(data []byte, format string, meta imageMeta, img image.Image) []byte {
+	var out bytes.Buffer
+
+	switch format {
+	case "jpeg":
+		if !bytes.HasPrefix(data, []byte{0xff, 0xd8}) {
+			return data
+		}
+
+		out.Write(data[:2])
+
+		segment := func(prefix string, payload []byte) {
+			size := 2 + len(prefix) + len(payload)
+			if len(payload) == 0 || size > 0xffff {
+				return
+			}
+
+			out.Write([]byte{0xff, 0xe1})
+			_ = binary.Write(&out, binary.BigEndian, uint16(size))
+			out.WriteString(prefix)
+			out.Write(payload)
+		}
+
+		segment(jpegExifPrefix, meta.exif)
+		segment(jpegXMPPrefix, meta.xmp)
+		out.Write(data[2:])
+	case "png":
+		// signature and IHDR chunk
+		const ihdrEnd = 8 + 12 + 13
+		if !bytes.HasPrefix(data, []byte("\x89PNG\r\n\x1a\n")) || len(data) < ihdrEnd {
+			return data
+		}
+
+		out.Write(data[:ihdrEnd])
+
+		chunk := func(typ string, payload []byte) {
+			if len(payload) == 0 {
+				return
+			}
+
+			_ = binary.Write(&out, binary.BigEndian, uint32(len(payload)))
+			crc := crc32.NewIEEE()
+			crc.Write([]byte(typ))
+			crc.Write(payload)
+			out.WriteString(typ)
+			out.Write(payload)
+			_ = binary.Write(&out, binary.BigEndian, crc.Sum32())
+		}
+
+		chunk("eXIf", meta.exif)
+		if len(meta.xmp) > 0 {
+			chunk("iTXt", append([]byte(pngXMPKeyword+"\x00\x00\x00\x00"), meta.xmp...))
+		}
+
+		out.Write(data[ihdrEnd:])
+	case "webp":
+		if len(data) < 20 || string(data[:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+			return data
+		}
+
+		chunks := data[12:]
+
+		var flags byte
+		if len(meta.exif) > 0 {
+			flags |= 0x08
+		}
+		if len(meta.xmp) > 0 {
+			flags |= 0x04
+		}
+
+		if string(chunks[:4]) == "VP8X" {
+			chunks = bytes.Clone(chunks)
+			chunks[8] |= flags
+		} else {
+			if !isOpaque(img) {
+				flags |= 0x10
+			}
+
+			b := img.Bounds()
+			vp8x := make([]byte, 18)
+			copy(vp8x, "VP8X")
+			binary.LittleEndian.PutUint32(vp8x[4:], 10)
+			vp8x[8] = flags
+			putUint24(vp8x[12:], uint32(b.Dx()-1))
+			putUint24(vp8x[15:], uint32(b.Dy()-1))
+			chunks = append(vp8x, chunks...)
+		}
+
+		out.WriteString("RIFF")
+		_ = binary.Write(&out, binary.LittleEndian, uint32(0))
+		out.WriteString("WEBP")
+		out.Write(chunks)
+
+		chunk := func(typ string, payload []byte) {
+			if len(payload) == 0 {
+				return
+			}
+
+			out.WriteString(typ)
+			_ = binary.Write(&out, binary.LittleEndian, uint32(len(payload)))
+			out.Write(payload)
+			if len(payload)%2 == 1 {
+				out.WriteByte(0)
+			}
+		}
+
+		chunk("EXIF", meta.exif)
+		chunk("XMP ", meta.xmp)
+
+		b := out.Bytes()
+		binary.LittleEndian.PutUint32(b[4:], uint32(len(b)-8))
+
+		return b
+	default:
+		return data
+	}
+
+	return out.Bytes()
+}
+
+func putUint24(b []byte, v uint32) {
+	b[0], b[1], b[2] = byte(v), byte(v>>8), byte(v>>16)
+}
@@ -0,0 +1,202 @@
+package cbconvert
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"math/rand/v2"
+	"os"
+	"slices"
+	"strings"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+)
+
+// TestPatterns are valid patterns of MakeTest.
+var TestPatterns = []string{"gradient", "noise", "text"}
+
+// testWords are words of the text pattern.
+var testWords = strings.Fields("lorem ipsum dolor sit amet consectetur adipiscing elit sed do eiusmod tempor incididunt ut labore " +
+	"et dolore magna aliqua enim ad minim veniam quis nostrud exercitation ullamco laboris nisi aliquip ex ea commodo consequat")
+
+// MakeTest writes CBZ archive with synthesized pages of width and height, so bug reports can include reproduction inputs.
+// Pattern is gradient (smooth tones), noise (photographic worst case) or text (line art with panels and lettering).
+// Pages are encoded with Options.Format, output is the same for the same arguments and seed.
+func (c *Converter) MakeTest(fileName string, pages, width, height int, pattern string, seed uint64) error {
+	switch {
+	case pages <= 0:
+		return fmt.Errorf("MakeTest: invalid number of pages %d", pages)
+	case width <= 0 || height <= 0:
+		return fmt.Errorf("MakeTest: invalid size %dx%d", width, height)
+	case !slices.Contains(TestPatterns, pattern):
+		return fmt.Errorf("MakeTest: invalid pattern %q, valid values are %s", pattern, strings.Join(TestPatterns, ", "))
+	}
+
+	return c.outputAtomic(fileName, func(tmpName string) error {
+		f, err := os.Create(tmpName)
+		if err != nil {
+			return fmt.Errorf("MakeTest: %w", err)
+		}
+		defer f.Close()
+
+		z, err := c.zipWriter(f)
+		if err != nil {
+			return fmt.Errorf("MakeTest: %w", err)
+		}
+
+		for page := range pages {
+			rng := rand.New(rand.NewPCG(seed, uint64(page)))
+
+			var img image.Image
+			switch pattern {
+			case "gradient":
+				img = testGradient(width, height, rng)
+			case "noise":
+				img = testNoise(width, height, rng)
+			case "text":
+				img = testText(width, height, page+1, rng)
+			}
+
+			format := c.imageFormat(img)
+
+			var buf bytes.Buffer
+			if err := c.imageEncodeFormat(img, format, &buf); err != nil {
+				return fmt.Errorf("MakeTest: %w", err)
+			}
+
+			header := &zip.FileHeader{
+				Name:     fmt.Sprintf("%03d.%s", page+1, formatExt(format)),
+				Method:   zip.Store,
+				Modified: reproducibleTime,
+			}
+			header.SetMode(0644)
+
+			w, err := z.CreateHeader(header)
+			if err != nil {
+				return fmt.Errorf("MakeTest: %w", err)
+			}
+
+			if _, err := w.Write(buf.Bytes()); err != nil {
+				return fmt.Errorf("MakeTest: %w", err)
+			}
+		}
+
+		if err := z.Close(); err != nil {
+			return fmt.Errorf("MakeTest: %w", err)
+		}
+
+		return f.Close()
+	})
+}
+
+// testGradient returns diagonal gradient between two random colors.
+func testGradient(width, height int, rng *rand.Rand) *image.RGBA {
+	from := [3]float64{float64(rng.IntN(256)), float64(rng.IntN(256)), float64(rng.IntN(256))}
+	to := [3]float64{float64(rng.IntN(256)), float64(rng.IntN(256)), float64(rng.IntN(256))}
+
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	span := float64(max(1, width+height-2))
+
+	for y := range height {
+		for x := range width {
+			t := float64(x+y) / span
+			i := img.PixOffset(x, y)
+			for ch := range 3 {
+				img.Pix[i+ch] = uint8(from[ch] + (to[ch]-from[ch])*t + 0.5)
+			}
+			img.Pix[i+3] = 0xff
+		}
+	}
+
+	return img
+}
+
+// testNoise returns random color noise.
+func testNoise(width, height int, rng *rand.Rand) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+
+	for i := 0; i < len(img.Pix); i += 4 {
+		v := rng.Uint32()
+		img.Pix[i] = uint8(v)
+		img.Pix[i+1] = uint8(v >> 8)
+		img.Pix[i+2] = uint8(v >> 16)
+		img.Pix[i+3] = 0xff
+	}
+
+	return img
+}
+
+// testText returns white page with bordered panels filled with random words and the page number.
+// Page is drawn at lower resolution and enlarged without filtering, so lettering stays sharp at any size.
+func testText(width, height, number int, rng *rand.Rand) *image.Gray {
+	scale := max(1, width/600)
+	w, h := max(1, width/scale), max(1, height/scale)
+
+	small := image.NewGray(image.Rect(0, 0, w, h))
+	draw.Draw(small, small.Bounds(), image.White, image.Point{}, draw.Src)
+
+	face := basicfont.Face7x13
+	d := &font.Drawer{Dst: small, Src: image.Black, Face: face}
+
+	const columns, rows, margin, border = 2, 3, 12, 2
+	panelW := (w - margin*(columns+1)) / columns
+	panelH := (h - margin*(rows+1) - face.Height) / rows
+
+	for row := range rows {
+		for col := range columns {
+			x := margin + col*(panelW+margin)
+			y := margin + row*(panelH+margin)
+
+			for i := range border {
+				testRect(small, image.Rect(x+i, y+i, x+panelW-i, y+panelH-i))
+			}
+
+			for line := y + border + margin + face.Height; line < y+panelH-border-margin; line += face.Height + 2 {
+				var text string
+				for {
+					word := testWords[rng.IntN(len(testWords))]
+					if d.MeasureString(text+" "+word).Ceil() > panelW-2*(border+margin) {
+						break
+					}
+					text = strings.TrimSpace(text + " " + word)
+				}
+
+				d.Dot = fixed.P(x+border+margin, line)
+				d.DrawString(text)
+			}
+		}
+	}
+
+	label := fmt.Sprintf("%d", number)
+	d.Dot = fixed.P((w-d.MeasureString(label).Ceil())/2, h-margin)
+	d.DrawString(label)
+
+	img := image.NewGray(image.Rect(0, 0, width, height))
+	for y := range height {
+		for x := range width {
+			img.Pix[y*img.Stride+x] = small.Pix[min(y/scale, h-1)*small.Stride+min(x/scale, w-1)]
+		}
+	}
+
+	return img
+}
+
+// testRect draws one pixel wide rectangle outline.
+func testRect(img *image.Gray, r image.Rectangle) {
+	black := color.Gray{}
+
+	for x := r.Min.X; x < r.Max.X; x++ {
+		img.SetGray(x, r.Min.Y, black)
+		img.SetGray(x, r.Max.Y-1, black)
+	}
+
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		img.SetGray(r.Min.X, y, black)
+		img.SetGray(r.Max.X-1, y, black)
+	}
+}
@@ -0,0 +1,41 @@
+//go:build unix && (amd64 || arm64 || loong64 || mips64 || mips64le || ppc64 || ppc64le || riscv64 || s390x)
+
+package cbconvert
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// mmapAdvise gives the kernel a hint about the access pattern, set on Linux.
+var mmapAdvise = func(data []byte) {}
+
+// mmapFile maps file read-only into memory, returned function unmaps it.
+func mmapFile(fileName string) ([]byte, func() error, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return nil, nil, fmt.Errorf("mmapFile: %w", err)
+	}
+	defer file.Close()
+
+	stat, err := file.Stat()
+	if err != nil {
+		return nil, nil, fmt.Errorf("mmapFile: %w", err)
+	}
+
+	if stat.Size() == 0 {
+		return nil, nil, fmt.Errorf("mmapFile: %s: empty file", fileName)
+	}
+
+	data, err := syscall.Mmap(int(file.Fd()), 0, int(stat.Size()), syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, nil, fmt.Errorf("mmapFile: %w", err)
+	}
+
+	mmapAdvise(data)
+
+	return data, func() error {
+		return syscall.Munmap(data)
+	}, nil
+}
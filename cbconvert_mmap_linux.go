@@ -0,0 +1,14 @@
+//go:build linux && (amd64 || arm64 || loong64 || mips64 || mips64le || ppc64 || ppc64le || riscv64 || s390x)
+
+package cbconvert
+
+import (
+	"syscall"
+)
+
+func init() {
+	mmapAdvise = func(data []byte) {
+		// pages are read mostly in order
+		_ = syscall.Madvise(data, syscall.MADV_SEQUENTIAL)
+	}
+}
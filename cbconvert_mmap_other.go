@@ -0,0 +1,12 @@
+//go:build !(unix && (amd64 || arm64 || loong64 || mips64 || mips64le || ppc64 || ppc64le || riscv64 || s390x))
+
+package cbconvert
+
+import (
+	"errors"
+)
+
+// mmapFile is not supported on 32-bit and non-Unix systems, archives are read from the file.
+func mmapFile(string) ([]byte, func() error, error) {
+	return nil, nil, errors.New("mmapFile: not supported")
+}
@@ -0,0 +1,313 @@
+package cbconvert
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"html"
+	"image"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	mobiRecordSize = 4096
+	mobiHeaderSize = 0xE8
+)
+
+// archiveSaveMobi saves workdir to MOBI (fixed-layout) book.
+func (c *Converter) archiveSaveMobi(fileName, mobiName string) error {
+	if c.OnCompress != nil {
+		c.OnCompress(c.job)
+	}
+
+	if c.Opts.Format != "jpeg" && c.Opts.Format != "png" && !c.Opts.NoConvert {
+		return fmt.Errorf("archiveSaveMobi: unsupported image format %s, use jpeg or png", c.Opts.Format)
+	}
+
+	files, err := c.workdirFiles()
+	if err != nil {
+		return fmt.Errorf("archiveSaveMobi: %w", err)
+	}
+
+	var images []string
+	for _, file := range files {
+		if isImage(file.Name()) {
+			images = append(images, file.Name())
+		}
+	}
+
+	if len(images) == 0 {
+		return fmt.Errorf("archiveSaveMobi: no images")
+	}
+
+	title := baseNoExt(fileName)
+
+	alts, err := c.altTexts(fileName, images)
+	if err != nil {
+		return fmt.Errorf("archiveSaveMobi: %w", err)
+	}
+
+	var body bytes.Buffer
+	body.WriteString(`<html><head><guide><reference type="text" title="Start" filepos=0000000000 /></guide></head><body>`)
+	for idx := range images {
+		fmt.Fprintf(&body, `<p><img recindex="%05d" alt="%s" /></p><mbp:pagebreak/>`, idx+1, html.EscapeString(alts[idx]))
+	}
+	body.WriteString(`</body></html>`)
+
+	text := body.Bytes()
+
+	records := make([][]byte, 0)
+	records = append(records, nil) // header, set below
+
+	for off := 0; off < len(text); off += mobiRecordSize {
+		records = append(records, text[off:min(off+mobiRecordSize, len(text))])
+	}
+
+	textRecords := len(records) - 1
+	firstImage := len(records)
+
+	var width, height int
+	for idx, img := range images {
+		data, err := os.ReadFile(filepath.Join(c.job.workdir, img))
+		if err != nil {
+			return fmt.Errorf("archiveSaveMobi: %w", err)
+		}
+
+		data, err = c.mobiImage(img, data)
+		if err != nil {
+			return fmt.Errorf("archiveSaveMobi: %w", err)
+		}
+
+		if idx == 0 {
+			width, height, _ = imageDimensions(bytes.NewReader(data))
+		}
+
+		records = append(records, data)
+	}
+
+	lastContent := len(records) - 1
+	flis := len(records)
+	records = append(records, mobiFLIS())
+	fcis := len(records)
+	records = append(records, mobiFCIS(len(text)))
+	records = append(records, []byte{0xE9, 0x8E, 0x0D, 0x0A})
+
+	exth := mobiEXTH([]mobiExth{
+		{503, []byte(title)},
+		{201, binary.BigEndian.AppendUint32(nil, 0)},
+		{203, binary.BigEndian.AppendUint32(nil, 0)},
+		{122, []byte("true")},
+		{307, []byte(fmt.Sprintf("%dx%d", width, height))},
+		{524, []byte("en")},
+	})
+
+	var h bytes.Buffer
+	be := func(v any) {
+		_ = binary.Write(&h, binary.BigEndian, v)
+	}
+
+	// PalmDOC header
+	be(uint16(1)) // no compression
+	be(uint16(0))
+	be(uint32(len(text)))
+	be(uint16(textRecords))
+	be(uint16(mobiRecordSize))
+	be(uint16(0)) // no encryption
+	be(uint16(0))
+
+	// MOBI header
+	fullNameOffset := 16 + mobiHeaderSize + len(exth)
+
+	h.WriteString("MOBI")
+	be(uint32(mobiHeaderSize))
+	be(uint32(2))     // book
+	be(uint32(65001)) // UTF-8
+	be(uint32(c.now().Unix()))
+	be(uint32(6))
+	for i := 0; i < 10; i++ {
+		be(uint32(0xFFFFFFFF)) // indexes
+	}
+	be(uint32(firstImage)) // first non-book record
+	be(uint32(fullNameOffset))
+	be(uint32(len(title)))
+	be(uint32(9)) // english
+	be(uint32(0))
+	be(uint32(0))
+	be(uint32(6)) // min version
+	be(uint32(firstImage))
+	be([4]uint32{})  // huffman
+	be(uint32(0x50)) // EXTH flags
+	be([32]byte{})
+	be(uint32(0xFFFFFFFF))
+	be(uint32(0xFFFFFFFF)) // DRM offset
+	be(uint32(0))
+	be(uint32(0))
+	be(uint32(0))
+	be([8]byte{})
+	be(uint16(1))
+	be(uint16(lastContent))
+	be(uint32(1))
+	be(uint32(fcis))
+	be(uint32(1))
+	be(uint32(flis))
+	be(uint32(1))
+	be([8]byte{})
+	be(uint32(0xFFFFFFFF))
+	be(uint32(0))
+	be(uint32(0xFFFFFFFF))
+	be(uint32(0xFFFFFFFF))
+	be(uint32(0))          // extra record data flags
+	be(uint32(0xFFFFFFFF)) // INDX
+
+	h.Write(exth)
+	h.WriteString(title)
+	h.Write(make([]byte, 2+(4-(len(title)+2)%4)%4))
+
+	records[0] = h.Bytes()
+
+	mobiFile, err := os.Create(mobiName)
+	if err != nil {
+		return fmt.Errorf("archiveSaveMobi: %w", err)
+	}
+
+	if _, err = mobiFile.Write(mobiPDB(title, c.now(), records)); err != nil {
+		return fmt.Errorf("archiveSaveMobi: %w", err)
+	}
+
+	if err = mobiFile.Close(); err != nil {
+		return fmt.Errorf("archiveSaveMobi: %w", err)
+	}
+
+	err = os.RemoveAll(c.job.workdir)
+	if err != nil {
+		return fmt.Errorf("archiveSaveMobi: %w", err)
+	}
+
+	return nil
+}
+
+// mobiImage returns image data that MOBI readers can display, JPEG, PNG and GIF are kept and other formats
+// (i.e. pages copied with NoConvert) are converted to JPEG.
+func (c *Converter) mobiImage(name string, data []byte) ([]byte, error) {
+	_, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err == nil && (format == "jpeg" || format == "png" || format == "gif") {
+		return data, nil
+	}
+
+	img, err := c.imageDecode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("mobiImage: %s: %w", name, err)
+	}
+
+	var buf bytes.Buffer
+	if err = c.imageEncodeFormat(img, "jpeg", &buf); err != nil {
+		return nil, fmt.Errorf("mobiImage: %s: %w", name, err)
+	}
+
+	c.log(LogVerbose, "page converted to JPEG for MOBI", "name", name, "format", format)
+
+	return buf.Bytes(), nil
+}
+
+// mobiExth type, EXTH record.
+type mobiExth struct {
+	Type uint32
+	Data []byte
+}
+
+// mobiEXTH returns EXTH header, padded to 4 bytes.
+func mobiEXTH(records []mobiExth) []byte {
+	var b bytes.Buffer
+
+	size := 12
+	for _, r := range records {
+		size += 8 + len(r.Data)
+	}
+
+	b.WriteString("EXTH")
+	_ = binary.Write(&b, binary.BigEndian, uint32(size))
+	_ = binary.Write(&b, binary.BigEndian, uint32(len(records)))
+
+	for _, r := range records {
+		_ = binary.Write(&b, binary.BigEndian, r.Type)
+		_ = binary.Write(&b, binary.BigEndian, uint32(8+len(r.Data)))
+		b.Write(r.Data)
+	}
+
+	b.Write(make([]byte, (4-size%4)%4))
+
+	return b.Bytes()
+}
+
+// mobiFLIS returns FLIS record.
+func mobiFLIS() []byte {
+	var b bytes.Buffer
+	b.WriteString("FLIS")
+	for _, v := range []any{
+		uint32(8), uint16(0x41), uint16(0), uint32(0), uint32(0xFFFFFFFF),
+		uint16(1), uint16(3), uint32(3), uint32(1), uint32(0xFFFFFFFF),
+	} {
+		_ = binary.Write(&b, binary.BigEndian, v)
+	}
+
+	return b.Bytes()
+}
+
+// mobiFCIS returns FCIS record.
+func mobiFCIS(textLength int) []byte {
+	var b bytes.Buffer
+	b.WriteString("FCIS")
+	for _, v := range []any{
+		uint32(0x14), uint32(0x10), uint32(1), uint32(0), uint32(textLength),
+		uint32(0), uint32(0x20), uint32(8), uint16(1), uint16(1), uint32(0),
+	} {
+		_ = binary.Write(&b, binary.BigEndian, v)
+	}
+
+	return b.Bytes()
+}
+
+// mobiPDB returns Palm database with given records.
+func mobiPDB(title string, created time.Time, records [][]byte) []byte {
+	var b bytes.Buffer
+	be := func(v any) {
+		_ = binary.Write(&b, binary.BigEndian, v)
+	}
+
+	name := make([]byte, 32)
+	copy(name[:31], strings.ReplaceAll(title, " ", "_"))
+
+	now := uint32(created.Unix() + 2082844800) // seconds since 1904
+
+	b.Write(name)
+	be(uint16(0))
+	be(uint16(0))
+	be(now)
+	be(now)
+	be(uint32(0))
+	be(uint32(0))
+	be(uint32(0))
+	be(uint32(0))
+	b.WriteString("BOOKMOBI")
+	be(uint32(2*len(records) - 1))
+	be(uint32(0))
+	be(uint16(len(records)))
+
+	offset := 78 + 8*len(records) + 2
+	for idx, r := range records {
+		be(uint32(offset))
+		be(uint32(2 * idx)) // attributes and unique id
+		offset += len(r)
+	}
+
+	be(uint16(0))
+
+	for _, r := range records {
+		b.Write(r)
+	}
+
+	return b.Bytes()
+}
@@ -0,0 +1,165 @@
+package cbconvert
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+)
+
+const (
+	// montageCellWidth is the default cell width of montage
+	montageCellWidth = 200
+	// montageGap is space between montage cells
+	montageGap = 8
+)
+
+// Montage composes covers of files into a poster wall, covers are fitted into cells of Options.MontageCellWidth and
+// Options.MontageCellHeight arranged in Options.MontageColumns columns. Files with covers that can not be extracted are skipped.
+func (c *Converter) Montage(files []File) (image.Image, error) {
+	cellW := c.Opts.MontageCellWidth
+	if cellW <= 0 {
+		cellW = montageCellWidth
+	}
+
+	cellH := c.Opts.MontageCellHeight
+	if cellH <= 0 {
+		cellH = cellW * 3 / 2
+	}
+
+	bg, err := parseColor(c.Opts.Background)
+	if err != nil {
+		return nil, fmt.Errorf("Montage: %w", err)
+	}
+
+	covers := make([]image.Image, 0, len(files))
+	labels := make([]string, 0, len(files))
+
+	for _, file := range files {
+		job := c.begin(file.Path, true)
+		cover, err := job.coverImage(file.Path, file.Stat)
+		job.end()
+		if err != nil {
+			c.log(LogErrors, "cover skipped", "file", file.Path, "error", err)
+
+			continue
+		}
+
+		covers = append(covers, montageFit(cover, cellW, cellH, c.resizer(c.Opts.Filter)))
+		labels = append(labels, baseNoExt(file.Path))
+	}
+
+	if len(covers) == 0 {
+		return nil, fmt.Errorf("Montage: no covers")
+	}
+
+	columns := c.Opts.MontageColumns
+	if columns <= 0 {
+		columns = int(math.Ceil(math.Sqrt(float64(len(covers)))))
+	}
+	columns = min(columns, len(covers))
+	rows := (len(covers) + columns - 1) / columns
+
+	face := basicfont.Face7x13
+	labelH := 0
+	if c.Opts.MontageLabels {
+		labelH = face.Height + montageGap/2
+	}
+
+	width := columns*(cellW+montageGap) + montageGap
+	height := rows*(cellH+labelH+montageGap) + montageGap
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(dst, dst.Bounds(), &image.Uniform{C: bg}, image.Point{}, draw.Src)
+
+	for idx, cover := range covers {
+		x := montageGap + (idx%columns)*(cellW+montageGap)
+		y := montageGap + (idx/columns)*(cellH+labelH+montageGap)
+
+		// covers are centered in the cell
+		b := cover.Bounds()
+		pt := image.Pt(x+(cellW-b.Dx())/2, y+(cellH-b.Dy())/2)
+		draw.Draw(dst, image.Rectangle{Min: pt, Max: pt.Add(b.Size())}, cover, b.Min, draw.Over)
+
+		if c.Opts.MontageLabels {
+			montageLabel(dst, labels[idx], x, y+cellH+montageGap/2, cellW, face, bg)
+		}
+	}
+
+	return dst, nil
+}
+
+// MontageSave composes montage of files and encodes it to Options.OutFile, or montage.<ext> in the output directory.
+func (c *Converter) MontageSave(files []File) (string, error) {
+	img, err := c.Montage(files)
+	if err != nil {
+		return "", err
+	}
+
+	format := c.imageFormat(img)
+
+	fName := c.Opts.OutFile
+	if fName == "" {
+		fName = filepath.Join(c.Opts.OutDir, fmt.Sprintf("montage.%s", formatExt(format)))
+	}
+
+	w, err := os.Create(fName)
+	if err != nil {
+		return "", fmt.Errorf("MontageSave: %w", err)
+	}
+	defer w.Close()
+
+	if err := c.imageEncodeFormat(img, format, w); err != nil {
+		return "", fmt.Errorf("MontageSave: %w", err)
+	}
+
+	if err := w.Close(); err != nil {
+		return "", fmt.Errorf("MontageSave: %w", err)
+	}
+
+	return fName, nil
+}
+
+// montageFit scales image to fit into width and height, smaller images are enlarged.
+func montageFit(img image.Image, width, height int, resample resampler) *image.RGBA {
+	b := img.Bounds()
+	scale := min(float64(width)/float64(b.Dx()), float64(height)/float64(b.Dy()))
+
+	w := max(1, int(float64(b.Dx())*scale))
+	h := max(1, int(float64(b.Dy())*scale))
+
+	return resize(img, min(w, width), min(h, height), resample)
+}
+
+// montageLabel draws text centered under the cell, text that does not fit is truncated.
+func montageLabel(dst draw.Image, text string, x, y, width int, face font.Face, bg color.RGBA) {
+	// dark text on light background and vice versa
+	fg := color.Black
+	if int(bg.R)+int(bg.G)+int(bg.B) < 384 {
+		fg = color.White
+	}
+
+	d := &font.Drawer{Dst: dst, Src: image.NewUniform(fg), Face: face}
+
+	if d.MeasureString(text).Ceil() > width {
+		runes := []rune(text)
+		for len(runes) > 0 {
+			runes = runes[:len(runes)-1]
+			text = string(runes) + "..."
+			if d.MeasureString(text).Ceil() <= width {
+				break
+			}
+		}
+	}
+
+	w := d.MeasureString(text).Ceil()
+	d.Dot = fixed.P(x+(width-w)/2, y+face.Metrics().Ascent.Ceil())
+	d.DrawString(text)
+}
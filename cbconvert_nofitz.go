@@ -0,0 +1,18 @@
+//go:build nofitz
+
+package cbconvert
+
+import (
+	"errors"
+)
+
+// documents reports if documents are supported.
+const documents = false
+
+// documentModule is module that renders documents.
+const documentModule = ""
+
+// openFitz returns error, documents are not supported if built with nofitz tag.
+func openFitz(string) (document, error) {
+	return nil, errors.New("documents are not supported in this build (nofitz)")
+}
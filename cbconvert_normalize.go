@@ -0,0 +1,237 @@
+package cbconvert
+
+import (
+	"archive/zip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fvbommel/sortorder"
+)
+
+// entryTime parses Options.EntryTime, valid values are RFC3339 time, now and reproducible (1980-01-01).
+func entryTime(value string) (time.Time, error) {
+	switch value {
+	case "now":
+		return time.Now(), nil
+	case "reproducible":
+		return reproducibleTime, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return t, fmt.Errorf("entryTime: %w", err)
+	}
+
+	return t, nil
+}
+
+// normalizeNames returns new names of the archive entries. With Options.NormalizeNames folder prefixes are stripped
+// and extensions are lowercase, with Options.Renumber images are renamed to numbers in natural order of the old names.
+func (c *Converter) normalizeNames(names []string) (map[string]string, error) {
+	renamed := make(map[string]string)
+
+	images := imagesFromSlice(names)
+	sort.SliceStable(images, func(i, j int) bool {
+		return sortorder.NaturalLess(images[i], images[j])
+	})
+
+	number := make(map[string]int)
+	for idx, name := range images {
+		number[name] = idx
+	}
+
+	width := max(3, len(fmt.Sprint(len(images)-1)))
+
+	for _, name := range names {
+		newName := name
+		if c.Opts.NormalizeNames {
+			newName = entryName(name)
+		}
+
+		if c.Opts.NormalizeNames || c.Opts.Renumber {
+			ext := filepath.Ext(newName)
+			if idx, ok := number[name]; ok && c.Opts.Renumber {
+				dir := filepath.ToSlash(filepath.Dir(newName))
+				newName = fmt.Sprintf("%0*d%s", width, idx, strings.ToLower(ext))
+				if dir != "." {
+					newName = dir + "/" + newName
+				}
+			} else {
+				newName = strings.TrimSuffix(newName, ext) + strings.ToLower(ext)
+			}
+		}
+
+		renamed[name] = newName
+	}
+
+	seen := make(map[string]string)
+	for _, name := range names {
+		if prev, ok := seen[renamed[name]]; ok {
+			return nil, fmt.Errorf("normalizeNames: %s and %s are both renamed to %s, use renumber", prev, name, renamed[name])
+		}
+
+		seen[renamed[name]] = name
+	}
+
+	return renamed, nil
+}
+
+// zipExtraWithout returns ZIP extra fields without the fields with given IDs.
+func zipExtraWithout(extra []byte, ids ...uint16) []byte {
+	var out []byte
+
+	for len(extra) >= 4 {
+		id := binary.LittleEndian.Uint16(extra)
+		size := int(binary.LittleEndian.Uint16(extra[2:]))
+		if 4+size > len(extra) {
+			break
+		}
+
+		keep := true
+		for _, i := range ids {
+			if id == i {
+				keep = false
+			}
+		}
+
+		if keep {
+			out = append(out, extra[:4+size]...)
+		}
+
+		extra = extra[4+size:]
+	}
+
+	return out
+}
+
+// msDosTime returns MS-DOS date and time, the time is stored as UTC.
+func msDosTime(t time.Time) (uint16, uint16) {
+	t = t.UTC()
+	if t.Year() < 1980 {
+		t = reproducibleTime
+	}
+
+	return uint16(t.Day() + int(t.Month())<<5 + (t.Year()-1980)<<9), uint16(t.Second()/2 + t.Minute()<<5 + t.Hour()<<11)
+}
+
+const (
+	// zipExtTimeID is ID of the extended timestamp extra field
+	zipExtTimeID = 0x5455
+	// zipUnicodePathID is ID of the Info-ZIP Unicode path extra field
+	zipUnicodePathID = 0x7075
+)
+
+// archiveNormalize renames entries and sets their modification time in ZIP archive, the data is copied without
+// decompression. Other archives are converted to CBZ. Directory entries and macOS metadata are removed when folder prefixes are stripped.
+// It returns renamed entries as "old -> new".
+func (c *Converter) archiveNormalize(fileName string) ([]string, error) {
+	var modified time.Time
+	if c.Opts.EntryTime != "" {
+		var err error
+		modified, err = entryTime(c.Opts.EntryTime)
+		if err != nil {
+			return nil, fmt.Errorf("archiveNormalize: %w", err)
+		}
+	}
+
+	fileName, err := c.archiveZip(fileName)
+	if err != nil {
+		return nil, fmt.Errorf("archiveNormalize: %w", err)
+	}
+
+	zr, err := zip.OpenReader(fileName)
+	if err != nil {
+		return nil, fmt.Errorf("archiveNormalize: %w", err)
+	}
+	defer zr.Close()
+
+	var files []*zip.File
+	var names []string
+	for _, item := range zr.File {
+		if c.Opts.NormalizeNames && (item.FileInfo().IsDir() || strings.Contains(item.Name, "__MACOSX") || filepath.Base(item.Name) == ".DS_Store") {
+			continue
+		}
+
+		files = append(files, item)
+		names = append(names, item.Name)
+	}
+
+	renamed, err := c.normalizeNames(names)
+	if err != nil {
+		return nil, fmt.Errorf("archiveNormalize: %w", err)
+	}
+
+	zf, err := os.CreateTemp(os.TempDir(), "cbc")
+	if err != nil {
+		return nil, fmt.Errorf("archiveNormalize: %w", err)
+	}
+
+	tmpName := zf.Name()
+	defer os.Remove(tmpName)
+
+	zw := zip.NewWriter(zf)
+
+	if err = zw.SetComment(zr.Comment); err != nil {
+		return nil, fmt.Errorf("archiveNormalize: %w", err)
+	}
+
+	var changes []string
+	for _, item := range files {
+		ir, err := item.OpenRaw()
+		if err != nil {
+			return nil, fmt.Errorf("archiveNormalize: %w", err)
+		}
+
+		header := item.FileHeader
+		if newName := renamed[item.Name]; newName != item.Name {
+			changes = append(changes, fmt.Sprintf("%s -> %s", item.Name, newName))
+			header.Name = newName
+			header.Extra = zipExtraWithout(header.Extra, zipUnicodePathID)
+		}
+
+		// raw entries are written with the MS-DOS time and extra fields of the header
+		if !modified.IsZero() {
+			header.Extra = zipExtraWithout(header.Extra, zipExtTimeID)
+			header.Modified = modified
+			header.ModifiedDate, header.ModifiedTime = msDosTime(modified)
+		}
+
+		it, err := zw.CreateRaw(&header)
+		if err != nil {
+			return nil, fmt.Errorf("archiveNormalize: %w", err)
+		}
+
+		_, err = io.Copy(it, ir)
+		if err != nil {
+			return nil, fmt.Errorf("archiveNormalize: %w", err)
+		}
+	}
+
+	err = zw.Close()
+	if err != nil {
+		return nil, fmt.Errorf("archiveNormalize: %w", err)
+	}
+
+	err = zf.Close()
+	if err != nil {
+		return nil, fmt.Errorf("archiveNormalize: %w", err)
+	}
+
+	data, err := os.ReadFile(tmpName)
+	if err != nil {
+		return nil, fmt.Errorf("archiveNormalize: %w", err)
+	}
+
+	err = os.WriteFile(fileName, data, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("archiveNormalize: %w", err)
+	}
+
+	return changes, nil
+}
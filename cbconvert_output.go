@@ -0,0 +1,168 @@
+package cbconvert
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// throttleReader type, reader limited to rate bytes per second.
+type throttleReader struct {
+	r     io.Reader
+	rate  float64
+	read  int64
+	start time.Time
+}
+
+// Read reads into b and sleeps until the average rate is within the limit.
+func (t *throttleReader) Read(b []byte) (int, error) {
+	// small reads keep the rate even for slow limits
+	if limit := int(t.rate / 10); limit > 0 && len(b) > limit {
+		b = b[:limit]
+	}
+
+	n, err := t.r.Read(b)
+	t.read += int64(n)
+
+	wait := time.Duration(float64(t.read)/t.rate*float64(time.Second)) - time.Since(t.start)
+	if wait > 0 {
+		time.Sleep(wait)
+	}
+
+	return n, err
+}
+
+// throttle returns reader limited to Options.WriteLimit, r is returned if the limit is not set.
+func (c *Converter) throttle(r io.Reader) io.Reader {
+	if c.Opts.WriteLimit <= 0 {
+		return r
+	}
+
+	return &throttleReader{r: r, rate: c.Opts.WriteLimit * 1024 * 1024, start: time.Now()}
+}
+
+// progressWriter type, writer that reports number of written bytes.
+type progressWriter struct {
+	w       io.Writer
+	written int64
+	total   int64
+	fn      func(written, total int64)
+}
+
+// Write writes p and reports progress.
+func (p *progressWriter) Write(b []byte) (int, error) {
+	n, err := p.w.Write(b)
+	p.written += int64(n)
+
+	if p.fn != nil {
+		p.fn(p.written, p.total)
+	}
+
+	return n, err
+}
+
+// outputAtomic calls save with temporary file name and moves the file to name when it is complete,
+// so readers and library scanners never see partial output.
+func (c *Converter) outputAtomic(name string, save func(tmpName string) error) error {
+	f, err := os.CreateTemp(os.TempDir(), "cbc*"+filepath.Ext(name))
+	if err != nil {
+		return fmt.Errorf("outputAtomic: %w", err)
+	}
+
+	tmpName := f.Name()
+	defer os.Remove(tmpName)
+
+	if err = f.Close(); err != nil {
+		return fmt.Errorf("outputAtomic: %w", err)
+	}
+
+	if err = save(tmpName); err != nil {
+		return fmt.Errorf("outputAtomic: %w", err)
+	}
+
+	if err = c.outputCommit(tmpName, name); err != nil {
+		return fmt.Errorf("outputAtomic: %w", err)
+	}
+
+	return nil
+}
+
+// outputCommit moves temporary file to name. If rename fails, i.e. output directory is on another filesystem
+// (NAS, network share), the file is copied to a temporary file in the output directory, synced and renamed there.
+// Mode of the existing file is kept.
+func (c *Converter) outputCommit(tmpName, name string) error {
+	mode := os.FileMode(0644)
+	if stat, err := os.Stat(name); err == nil {
+		mode = stat.Mode().Perm()
+	}
+
+	if err := os.Chmod(tmpName, mode); err != nil {
+		return fmt.Errorf("outputCommit: %w", err)
+	}
+
+	err := os.Rename(tmpName, name)
+	if err == nil {
+		return nil
+	}
+
+	c.log(LogDebug, "rename failed, copying", "file", name, "error", err)
+
+	src, err := os.Open(tmpName)
+	if err != nil {
+		return fmt.Errorf("outputCommit: %w", err)
+	}
+	defer src.Close()
+
+	stat, err := src.Stat()
+	if err != nil {
+		return fmt.Errorf("outputCommit: %w", err)
+	}
+
+	dst, err := os.CreateTemp(filepath.Dir(name), "."+filepath.Base(name)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("outputCommit: %w", err)
+	}
+
+	dstName := dst.Name()
+	defer os.Remove(dstName)
+
+	w := &progressWriter{w: dst, total: stat.Size()}
+	if c.OnCopy != nil {
+		w.fn = func(written, total int64) {
+			c.OnCopy(c.job, written, total)
+		}
+	}
+	if _, err = io.Copy(w, c.throttle(src)); err != nil {
+		_ = dst.Close()
+
+		return fmt.Errorf("outputCommit: %w", err)
+	}
+
+	if err = dst.Sync(); err != nil {
+		_ = dst.Close()
+
+		return fmt.Errorf("outputCommit: %w", err)
+	}
+
+	if err = dst.Close(); err != nil {
+		return fmt.Errorf("outputCommit: %w", err)
+	}
+
+	if err = os.Chmod(dstName, mode); err != nil {
+		return fmt.Errorf("outputCommit: %w", err)
+	}
+
+	if err = os.Rename(dstName, name); err != nil {
+		return fmt.Errorf("outputCommit: %w", err)
+	}
+
+	// directory entry is synced where it is supported, it fails i.e. on Windows
+	if dir, err := os.Open(filepath.Dir(name)); err == nil {
+		_ = dir.Sync()
+		_ = dir.Close()
+	}
+
+	return nil
+}
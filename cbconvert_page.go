@@ -0,0 +1,341 @@
+package cbconvert
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fvbommel/sortorder"
+)
+
+// ReplacePage replaces the page at index (images in natural order, starting from 0) of CBZ archive with image data,
+// the new page keeps the name of the replaced page with the extension ext (i.e. .jpg). Other entries are copied without re-encoding.
+func (c *Converter) ReplacePage(fileName string, index int, data []byte, ext string) error {
+	if err := c.archivePageSet(fileName, index, data, ext, false); err != nil {
+		return fmt.Errorf("%s: %w", fileName, err)
+	}
+
+	return nil
+}
+
+// InsertPage inserts image data as the page at index (images in natural order, starting from 0) of CBZ archive,
+// images are renumbered (i.e. 000.jpg) to keep the order. Other entries are copied without re-encoding.
+func (c *Converter) InsertPage(fileName string, index int, data []byte, ext string) error {
+	if err := c.archivePageSet(fileName, index, data, ext, true); err != nil {
+		return fmt.Errorf("%s: %w", fileName, err)
+	}
+
+	return nil
+}
+
+// SetCover adds image data as the cover of CBZ archive, the image is written as the first entry and named so that
+// natural sort places it first (i.e. 0_cover.jpg), other pages keep their names. The ComicInfo.xml page table is updated if present.
+func (c *Converter) SetCover(fileName string, data []byte, ext string) error {
+	if err := c.archiveCoverAdd(fileName, data, ext); err != nil {
+		return fmt.Errorf("%s: %w", fileName, err)
+	}
+
+	return nil
+}
+
+// coverEntryName returns name for the cover that is sorted before images (in natural order), in the directory of the first image.
+func coverEntryName(images []string, ext string) string {
+	if len(images) == 0 {
+		return "cover" + ext
+	}
+
+	dir, _ := path.Split(images[0])
+	name := dir + "cover" + ext
+
+	// digits are sorted before other characters and a number with less leading zeros first
+	for n := 0; !sortorder.NaturalLess(name, images[0]) || slices.Contains(images, name); n++ {
+		name = dir + "0" + strings.Repeat("!", n) + "_cover" + ext
+	}
+
+	return name
+}
+
+// archiveCoverAdd writes image data as the first entry of ZIP archive and updates ComicInfo.xml page table.
+func (c *Converter) archiveCoverAdd(fileName string, data []byte, ext string) error {
+	ext = strings.ToLower(ext)
+	if !isImage(ext) {
+		return fmt.Errorf("archiveCoverAdd: unsupported image extension %q", ext)
+	}
+
+	var comicInfo string
+
+	err := c.outputAtomic(fileName, func(tmpName string) error {
+		zr, err := zip.OpenReader(fileName)
+		if err != nil {
+			return err
+		}
+		defer zr.Close()
+
+		names := make([]string, 0, len(zr.File))
+		for _, item := range zr.File {
+			names = append(names, item.Name)
+		}
+
+		comicInfo = entryMatch(names, "ComicInfo.xml")
+		coverName := coverEntryName(archivePages(&zr.Reader), ext)
+
+		zf, err := os.Create(tmpName)
+		if err != nil {
+			return err
+		}
+		defer zf.Close()
+
+		zw, err := c.zipWriter(zf)
+		if err != nil {
+			return err
+		}
+
+		if err = zw.SetComment(zr.Comment); err != nil {
+			return err
+		}
+
+		rules, err := compressionRules(c.Opts.Compression)
+		if err != nil {
+			return err
+		}
+
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: coverName, Method: zipMethod(rules, coverName), Modified: c.now()})
+		if err != nil {
+			return err
+		}
+
+		if _, err = w.Write(data); err != nil {
+			return err
+		}
+
+		for _, item := range zr.File {
+			ir, err := item.OpenRaw()
+			if err != nil {
+				return err
+			}
+
+			h := item.FileHeader
+
+			w, err := zw.CreateRaw(&h)
+			if err != nil {
+				return err
+			}
+
+			if _, err = io.Copy(w, ir); err != nil {
+				return err
+			}
+		}
+
+		if err = zw.Close(); err != nil {
+			return err
+		}
+
+		return zf.Close()
+	})
+	if err != nil {
+		return fmt.Errorf("archiveCoverAdd: %w", err)
+	}
+
+	if comicInfo == "" {
+		return nil
+	}
+
+	err = c.archiveComicInfoUpdate(fileName, func(info *ComicInfo) error {
+		if info.PageCount > 0 {
+			info.PageCount++
+		}
+
+		if len(info.Pages) == 0 {
+			return nil
+		}
+
+		page := ComicInfoPage{Image: 0, Type: "FrontCover", ImageSize: int64(len(data))}
+		page.ImageWidth, page.ImageHeight, _ = imageDimensions(bytes.NewReader(data))
+
+		pages := []ComicInfoPage{page}
+		for _, p := range info.Pages {
+			p.Image++
+			if p.Type == "FrontCover" {
+				p.Type = ""
+			}
+
+			pages = append(pages, p)
+		}
+
+		info.Pages = pages
+
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("archiveCoverAdd: %w", err)
+	}
+
+	return nil
+}
+
+// archivePages returns images of ZIP archive in natural order.
+func archivePages(z *zip.Reader) []string {
+	names := make([]string, 0, len(z.File))
+	for _, item := range z.File {
+		names = append(names, item.Name)
+	}
+
+	images := imagesFromSlice(names)
+	sort.SliceStable(images, func(i, j int) bool {
+		return sortorder.NaturalLess(images[i], images[j])
+	})
+
+	return images
+}
+
+// archivePageSet replaces or inserts the page at index of ZIP archive.
+func (c *Converter) archivePageSet(fileName string, index int, data []byte, ext string, insert bool) error {
+	ext = strings.ToLower(ext)
+	if !isImage(ext) {
+		return fmt.Errorf("archivePageSet: unsupported image extension %q", ext)
+	}
+
+	err := c.outputAtomic(fileName, func(tmpName string) error {
+		zr, err := zip.OpenReader(fileName)
+		if err != nil {
+			return err
+		}
+		defer zr.Close()
+
+		images := archivePages(&zr.Reader)
+
+		maxIndex := len(images) - 1
+		if insert {
+			maxIndex = len(images)
+		}
+
+		if index < 0 || index > maxIndex {
+			return fmt.Errorf("page %d out of range", index)
+		}
+
+		renamed := make(map[string]string)
+		var pageName string
+
+		if insert {
+			width := max(3, len(fmt.Sprint(len(images))))
+			for idx, name := range images {
+				if idx >= index {
+					idx++
+				}
+
+				renamed[name] = numberedName(name, idx, width)
+			}
+
+			pageName = numberedName(ext, index, width)
+		} else {
+			old := images[index]
+			pageName = strings.TrimSuffix(old, filepath.Ext(old)) + ext
+		}
+
+		zf, err := os.Create(tmpName)
+		if err != nil {
+			return err
+		}
+		defer zf.Close()
+
+		zw, err := c.zipWriter(zf)
+		if err != nil {
+			return err
+		}
+
+		if err = zw.SetComment(zr.Comment); err != nil {
+			return err
+		}
+
+		rules, err := compressionRules(c.Opts.Compression)
+		if err != nil {
+			return err
+		}
+
+		// the new page is written at the position of the replaced page, or before the page at index
+		writePage := func(modified time.Time) error {
+			w, err := zw.CreateHeader(&zip.FileHeader{Name: pageName, Method: zipMethod(rules, pageName), Modified: modified})
+			if err != nil {
+				return err
+			}
+
+			_, err = w.Write(data)
+
+			return err
+		}
+
+		written := false
+		for _, item := range zr.File {
+			if index < len(images) && item.Name == images[index] {
+				modified := item.Modified
+				if insert {
+					modified = c.now()
+				}
+
+				if err = writePage(modified); err != nil {
+					return err
+				}
+
+				written = true
+
+				if !insert {
+					continue
+				}
+			}
+
+			newName := item.Name
+			if name, ok := renamed[item.Name]; ok {
+				newName = name
+			}
+
+			if newName == pageName {
+				return fmt.Errorf("entry %s already exists", pageName)
+			}
+
+			ir, err := item.OpenRaw()
+			if err != nil {
+				return err
+			}
+
+			h := item.FileHeader
+			if newName != item.Name {
+				h.Name = newName
+				h.Extra = zipExtraWithout(h.Extra, zipUnicodePathID)
+			}
+
+			w, err := zw.CreateRaw(&h)
+			if err != nil {
+				return err
+			}
+
+			if _, err = io.Copy(w, ir); err != nil {
+				return err
+			}
+		}
+
+		if !written {
+			if err = writePage(c.now()); err != nil {
+				return err
+			}
+		}
+
+		if err = zw.Close(); err != nil {
+			return err
+		}
+
+		return zf.Close()
+	})
+	if err != nil {
+		return fmt.Errorf("archivePageSet: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,169 @@
+package cbconvert
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"os"
+	"slices"
+	"sort"
+
+	"github.com/fvbommel/sortorder"
+)
+
+// pagesArchive lists pages in archive.
+func (c *Converter) pagesArchive(fileName string, dimensions bool) ([]PageInfo, error) {
+	var pages []PageInfo
+
+	archive, err := c.archiveOpen(fileName)
+	if err != nil {
+		return nil, fmt.Errorf("pagesArchive: %w", err)
+	}
+	defer archive.Close()
+
+	for {
+		err := archive.Entry()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+
+			return nil, fmt.Errorf("pagesArchive: %w", err)
+		}
+
+		pathName := archive.Name()
+		if !isImage(pathName) {
+			continue
+		}
+
+		page := PageInfo{Name: pathName, Size: int64(archive.Size())}
+
+		if dimensions {
+			data, err := c.archiveRead(archive)
+			if err != nil {
+				return nil, fmt.Errorf("pagesArchive: %w", err)
+			}
+
+			page.Width, page.Height, err = imageDimensions(bytes.NewReader(data))
+			if err != nil {
+				return nil, fmt.Errorf("pagesArchive: %s: %w", pathName, err)
+			}
+		}
+
+		pages = append(pages, page)
+	}
+
+	sortPages(pages)
+
+	return pages, nil
+}
+
+// pagesDocument lists pages in document.
+func (c *Converter) pagesDocument(fileName string, dimensions bool) ([]PageInfo, error) {
+	doc, err := openDocument(fileName)
+	if err != nil {
+		return nil, fmt.Errorf("pagesDocument: %w", err)
+	}
+	defer doc.Close()
+
+	pages := make([]PageInfo, doc.NumPage())
+
+	for n := range pages {
+		pages[n].Name = fmt.Sprintf("%03d", n)
+
+		if dimensions {
+			size, err := doc.Size(n)
+			if err != nil {
+				return nil, fmt.Errorf("pagesDocument: %w", err)
+			}
+
+			pages[n].Width = size.X
+			pages[n].Height = size.Y
+		}
+	}
+
+	return pages, nil
+}
+
+// pagesDirectory lists pages in directory.
+func (c *Converter) pagesDirectory(dir string, dimensions bool) ([]PageInfo, error) {
+	var pages []PageInfo
+
+	contents, err := imagesFromPath(dir)
+	if err != nil {
+		return nil, fmt.Errorf("pagesDirectory: %w", err)
+	}
+
+	for _, img := range imagesFromSlice(contents) {
+		stat, err := os.Stat(img)
+		if err != nil {
+			return nil, fmt.Errorf("pagesDirectory: %w", err)
+		}
+
+		page := PageInfo{Name: img, Size: stat.Size()}
+
+		if dimensions {
+			file, err := os.Open(img)
+			if err != nil {
+				return nil, fmt.Errorf("pagesDirectory: %w", err)
+			}
+
+			page.Width, page.Height, err = imageDimensions(file)
+			_ = file.Close()
+
+			if err != nil {
+				return nil, fmt.Errorf("pagesDirectory: %s: %w", img, err)
+			}
+		}
+
+		pages = append(pages, page)
+	}
+
+	sortPages(pages)
+
+	return pages, nil
+}
+
+// MedianWidth returns median width of portrait pages, spreads (landscape pages) are counted only if there are no
+// portrait pages. Pages must be listed with dimensions.
+func MedianWidth(pages []PageInfo) int {
+	var widths, spreads []int
+	for _, page := range pages {
+		if page.Width > page.Height {
+			spreads = append(spreads, page.Width)
+		} else if page.Width > 0 {
+			widths = append(widths, page.Width)
+		}
+	}
+
+	if len(widths) == 0 {
+		widths = spreads
+	}
+
+	if len(widths) == 0 {
+		return 0
+	}
+
+	slices.Sort(widths)
+
+	return widths[len(widths)/2]
+}
+
+// imageDimensions returns image dimensions without decoding the whole image.
+func imageDimensions(reader io.Reader) (int, int, error) {
+	cfg, _, err := image.DecodeConfig(reader)
+	if err != nil {
+		return 0, 0, fmt.Errorf("imageDimensions: %w", err)
+	}
+
+	return cfg.Width, cfg.Height, nil
+}
+
+// sortPages sorts pages in natural order.
+func sortPages(pages []PageInfo) {
+	sort.SliceStable(pages, func(i, j int) bool {
+		return sortorder.NaturalLess(pages[i].Name, pages[j].Name)
+	})
+}
@@ -0,0 +1,221 @@
+package cbconvert
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// PluginPrefix is prefix of the handler executables found on PATH, i.e. cbconvert-handler-cbds handles .cbds files.
+const PluginPrefix = "cbconvert-handler-"
+
+// PluginVersion is version of the plugin protocol.
+const PluginVersion = 1
+
+// pluginInfoTimeout is timeout for the info request.
+const pluginInfoTimeout = 5 * time.Second
+
+// PluginRequest type, request is written to the handler standard input as a single line of JSON.
+//
+// With method extract the handler writes pages (and optionally ComicInfo.xml) of File to Dir, the files are read
+// in natural order of their names as if they were in an archive. With method info the handler returns its name and version.
+type PluginRequest struct {
+	// Protocol version, PluginVersion
+	Version int `json:"version"`
+	// Method, extract or info
+	Method string `json:"method"`
+	// Input file, absolute path
+	File string `json:"file,omitempty"`
+	// Output directory, absolute path
+	Dir string `json:"dir,omitempty"`
+	// Password of the protected input, Options.Password
+	Password string `json:"password,omitempty"`
+}
+
+// PluginResponse type, response is read from the handler standard output as a single line of JSON.
+// The handler should also exit with non-zero status on error, standard error is included in the error message.
+type PluginResponse struct {
+	// Error message, the request failed if not empty
+	Error string `json:"error,omitempty"`
+	// Format name, returned by info
+	Name string `json:"name,omitempty"`
+	// Handler version, returned by info
+	Version string `json:"version,omitempty"`
+}
+
+var (
+	pluginsOnce sync.Once
+	// plugins are handler executables by extension, i.e. .cbds
+	plugins map[string]string
+)
+
+// pluginFind returns handler executable for file, built-in archives, documents and images are not handled by plugins.
+func pluginFind(fileName string) (string, bool) {
+	ext := strings.ToLower(filepath.Ext(fileName))
+	if ext == "" || slices.Contains(archiveTypes, ext) || isDocument(fileName) || isImage(fileName) || isWrapped(fileName) {
+		return "", false
+	}
+
+	path, ok := pluginsLoad()[ext]
+
+	return path, ok
+}
+
+// pluginsLoad returns handler executables by extension, PATH is scanned once.
+func pluginsLoad() map[string]string {
+	pluginsOnce.Do(func() {
+		plugins = pluginScan(filepath.SplitList(os.Getenv("PATH")))
+	})
+
+	return plugins
+}
+
+// pluginScan returns handler executables found in directories, the first one found is used for each extension.
+func pluginScan(dirs []string) map[string]string {
+	found := make(map[string]string)
+
+	for _, dir := range dirs {
+		// relative directories (i.e. current directory) are skipped, as with exec.LookPath
+		if !filepath.IsAbs(dir) {
+			continue
+		}
+
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+
+		for _, entry := range entries {
+			name := entry.Name()
+			if !strings.HasPrefix(name, PluginPrefix) || entry.IsDir() {
+				continue
+			}
+
+			if runtime.GOOS == "windows" {
+				if !strings.EqualFold(filepath.Ext(name), ".exe") {
+					continue
+				}
+
+				name = strings.TrimSuffix(name, filepath.Ext(name))
+			}
+
+			ext := "." + strings.ToLower(strings.TrimPrefix(name, PluginPrefix))
+			if _, ok := found[ext]; ok || ext == "." {
+				continue
+			}
+
+			path := filepath.Join(dir, entry.Name())
+			if info, err := os.Stat(path); err != nil || (runtime.GOOS != "windows" && info.Mode()&0111 == 0) {
+				continue
+			}
+
+			found[ext] = path
+		}
+	}
+
+	return found
+}
+
+// pluginExec sends request to the handler and returns the response.
+func pluginExec(ctx context.Context, path string, req PluginRequest) (PluginResponse, error) {
+	var resp PluginResponse
+
+	req.Version = PluginVersion
+
+	data, err := json.Marshal(req)
+	if err != nil {
+		return resp, fmt.Errorf("pluginExec: %w", err)
+	}
+
+	var stdout, stderr bytes.Buffer
+
+	cmd := exec.CommandContext(ctx, path)
+	cmd.Stdin = bytes.NewReader(append(data, '\n'))
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	err = cmd.Run()
+
+	line, _, _ := bytes.Cut(bytes.TrimSpace(stdout.Bytes()), []byte("\n"))
+	if len(line) > 0 {
+		if e := json.Unmarshal(line, &resp); e != nil && err == nil {
+			return resp, fmt.Errorf("pluginExec: %s: invalid response: %w", filepath.Base(path), e)
+		}
+	}
+
+	switch {
+	case resp.Error != "":
+		return resp, fmt.Errorf("pluginExec: %s: %s", filepath.Base(path), resp.Error)
+	case err != nil:
+		return resp, fmt.Errorf("pluginExec: %s: %w: %s", filepath.Base(path), err, strings.TrimSpace(stderr.String()))
+	}
+
+	return resp, nil
+}
+
+// archivePlugin extracts file with the handler executable and returns contents as ZIP archive.
+func archivePlugin(path, fileName, password string) ([]byte, error) {
+	abs, err := filepath.Abs(fileName)
+	if err != nil {
+		return nil, fmt.Errorf("archivePlugin: %w", err)
+	}
+
+	tmpDir, err := os.MkdirTemp(os.TempDir(), "cbc")
+	if err != nil {
+		return nil, fmt.Errorf("archivePlugin: %w", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	_, err = pluginExec(context.Background(), path, PluginRequest{Method: "extract", File: abs, Dir: tmpDir, Password: password})
+	if err != nil {
+		return nil, fmt.Errorf("archivePlugin: %w", err)
+	}
+
+	data, err := dirZip(tmpDir)
+	if err != nil {
+		return nil, fmt.Errorf("archivePlugin: %w", err)
+	}
+
+	return data, nil
+}
+
+// pluginCodecs returns input formats of the handler executables found on PATH.
+func pluginCodecs() []Codec {
+	plugins := pluginsLoad()
+
+	exts := make([]string, 0, len(plugins))
+	for ext := range plugins {
+		exts = append(exts, ext)
+	}
+
+	sort.Strings(exts)
+
+	codecs := make([]Codec, 0, len(exts))
+	for _, ext := range exts {
+		cd := Codec{Name: strings.TrimPrefix(ext, "."), Extensions: []string{ext}, Decode: true, Module: plugins[ext]}
+
+		ctx, cancel := context.WithTimeout(context.Background(), pluginInfoTimeout)
+		if resp, err := pluginExec(ctx, plugins[ext], PluginRequest{Method: "info"}); err == nil {
+			if resp.Name != "" {
+				cd.Name = resp.Name
+			}
+
+			cd.Version = resp.Version
+		}
+		cancel()
+
+		codecs = append(codecs, cd)
+	}
+
+	return codecs
+}
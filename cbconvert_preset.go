@@ -0,0 +1,52 @@
+package cbconvert
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// optionsName is name of the file with recorded options in the converted archive.
+const optionsName = "cbconvert.json"
+
+// optionsWrite writes options that affect the converted output to workdir.
+func (c *Converter) optionsWrite() error {
+	data, err := json.MarshalIndent(c.Opts.output(), "", "  ")
+	if err != nil {
+		return fmt.Errorf("optionsWrite: %w", err)
+	}
+
+	if err := os.WriteFile(filepath.Join(c.job.workdir, optionsName), append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("optionsWrite: %w", err)
+	}
+
+	return nil
+}
+
+// ReadOptions returns options recorded in the archive converted with Options.EmbedOptions,
+// use Options.Preset to apply them.
+func (c *Converter) ReadOptions(fileName string) (Options, error) {
+	var opts Options
+
+	archive, err := c.archiveOpen(fileName)
+	if err != nil {
+		return opts, fmt.Errorf("ReadOptions: %w", err)
+	}
+	defer archive.Close()
+
+	if err := archive.EntryFor(optionsName); err != nil {
+		return opts, fmt.Errorf("ReadOptions: %s: %w", optionsName, err)
+	}
+
+	data, err := c.archiveRead(archive)
+	if err != nil {
+		return opts, fmt.Errorf("ReadOptions: %w", err)
+	}
+
+	if err := json.Unmarshal(data, &opts); err != nil {
+		return opts, fmt.Errorf("ReadOptions: %w", err)
+	}
+
+	return opts, nil
+}
@@ -0,0 +1,88 @@
+package cbconvert
+
+import (
+	"fmt"
+	"image"
+	"os"
+)
+
+const (
+	// Number of sampled pages used to choose the target quality
+	targetSamples = 10
+	// Lowest quality considered for the target size
+	targetMinQuality = 10
+)
+
+// targetQuality returns the highest quality for which the average encoded size of the sampled pages does not
+// exceed Options.TargetSize, the lowest quality is returned if the target can not be met.
+func (c *Converter) targetQuality(fileName string, fileInfo os.FileInfo) (int, error) {
+	switch c.Opts.Format {
+	case "png", "tiff", "bmp":
+		// lossless formats, quality is not used
+		return c.Opts.Quality, nil
+	}
+
+	if c.Opts.AdaptiveQuality {
+		// quality is chosen per page
+		return c.Opts.Quality, nil
+	}
+
+	pages, err := c.ListPages(fileName, fileInfo, false)
+	if err != nil {
+		return 0, fmt.Errorf("targetQuality: %w", err)
+	}
+
+	if len(pages) == 0 {
+		return c.Opts.Quality, nil
+	}
+
+	images, err := c.sampleImages(fileName, fileInfo, samplePages(pages, targetSamples))
+	if err != nil {
+		return 0, fmt.Errorf("targetQuality: %w", err)
+	}
+
+	for i, img := range images {
+		images[i] = c.imageTransform(img)
+	}
+
+	defer func(quality int) {
+		c.Opts.Quality = quality
+	}(c.Opts.Quality)
+
+	target := int64(c.Opts.TargetSize) * 1024
+	lo, hi := targetMinQuality, 100
+
+	for lo < hi {
+		quality := (lo + hi + 1) / 2
+
+		size, err := c.averageSize(images, quality)
+		if err != nil {
+			return 0, fmt.Errorf("targetQuality: %w", err)
+		}
+
+		if size <= target {
+			lo = quality
+		} else {
+			hi = quality - 1
+		}
+	}
+
+	return lo, nil
+}
+
+// averageSize returns average size of the images encoded with quality.
+func (c *Converter) averageSize(images []image.Image, quality int) (int64, error) {
+	c.Opts.Quality = quality
+
+	var total int64
+	for _, img := range images {
+		size, err := c.sampleSize(img)
+		if err != nil {
+			return 0, fmt.Errorf("averageSize: %w", err)
+		}
+
+		total += size
+	}
+
+	return total / int64(len(images)), nil
+}
@@ -0,0 +1,129 @@
+package cbconvert
+
+import (
+	"bytes"
+	"fmt"
+	"image/png"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+)
+
+// reviewSave writes randomly selected pages as before/after pairs to the review directory, it must be called before
+// the work directory is removed.
+func (c *Converter) reviewSave(fileName string, fileInfo os.FileInfo) error {
+	pages, err := c.ListPages(fileName, fileInfo, false)
+	if err != nil {
+		return fmt.Errorf("reviewSave: %w", err)
+	}
+
+	if len(pages) == 0 {
+		return nil
+	}
+
+	files, err := c.workdirFiles()
+	if err != nil {
+		return fmt.Errorf("reviewSave: %w", err)
+	}
+
+	// converted pages by base name
+	converted := make(map[string]string)
+	for _, file := range files {
+		converted[baseNoExt(file.Name())] = file.Name()
+	}
+
+	indices := rand.Perm(len(pages))[:min(c.Opts.Sample, len(pages))]
+	slices.Sort(indices)
+
+	dir := c.Opts.SampleDir
+	if dir == "" {
+		dir = c.Opts.OutDir
+	}
+
+	dir = filepath.Join(dir, baseNoExt(fileName))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("reviewSave: %w", err)
+	}
+
+	var archive *archiveReader
+	if !fileInfo.IsDir() && !isImage(fileName) && isArchive(fileName) {
+		archive, err = c.archiveOpen(fileName)
+		if err != nil {
+			return fmt.Errorf("reviewSave: %w", err)
+		}
+		defer archive.Close()
+	}
+
+	var doc document
+	if !fileInfo.IsDir() && isDocument(fileName) {
+		doc, err = openDocument(fileName)
+		if err != nil {
+			return fmt.Errorf("reviewSave: %w", err)
+		}
+		defer doc.Close()
+	}
+
+	for _, idx := range indices {
+		page := pages[idx]
+		base := baseNoExt(entryName(page.Name))
+
+		after, ok := converted[base]
+		if !ok {
+			continue
+		}
+
+		var before []byte
+		beforeExt := filepath.Ext(page.Name)
+
+		switch {
+		case doc != nil:
+			n, _ := strconv.Atoi(page.Name)
+
+			img, err := c.documentImage(doc, n)
+			if err != nil {
+				return fmt.Errorf("reviewSave: %w", err)
+			}
+
+			var buf bytes.Buffer
+			if err := png.Encode(&buf, img); err != nil {
+				return fmt.Errorf("reviewSave: %w", err)
+			}
+
+			before, beforeExt = buf.Bytes(), ".png"
+		case archive != nil:
+			if err := archive.EntryFor(page.Name); err != nil {
+				return fmt.Errorf("reviewSave: %s: %w", page.Name, err)
+			}
+
+			before, err = c.archiveRead(archive)
+			if err != nil {
+				return fmt.Errorf("reviewSave: %s: %w", page.Name, err)
+			}
+		default:
+			before, err = os.ReadFile(page.Name)
+			if err != nil {
+				return fmt.Errorf("reviewSave: %w", err)
+			}
+		}
+
+		if err := os.WriteFile(filepath.Join(dir, base+"_before"+beforeExt), before, 0644); err != nil {
+			return fmt.Errorf("reviewSave: %w", err)
+		}
+
+		file, err := os.Open(filepath.Join(c.job.workdir, after))
+		if err != nil {
+			return fmt.Errorf("reviewSave: %w", err)
+		}
+
+		err = copyFile(file, filepath.Join(dir, base+"_after"+filepath.Ext(after)))
+		_ = file.Close()
+
+		if err != nil {
+			return fmt.Errorf("reviewSave: %w", err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,124 @@
+package cbconvert
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"slices"
+)
+
+// salvageJPEG decodes truncated or corrupt JPEG. For progressive images incomplete scans are dropped,
+// for baseline images the rows that can be decoded are kept and the rest is filled with gray.
+func salvageJPEG(data []byte) (image.Image, error) {
+	if !bytes.HasPrefix(data, []byte{0xff, 0xd8}) {
+		return nil, errors.New("salvageJPEG: not a JPEG")
+	}
+
+	sof, progressive, scans := jpegSegments(data)
+	if sof == 0 {
+		return nil, errors.New("salvageJPEG: missing frame header")
+	}
+
+	eoi := []byte{0xff, 0xd9}
+
+	if progressive {
+		// drop scans from the end until the image decodes
+		for n := len(scans) - 1; n > 0; n-- {
+			img, err := jpeg.Decode(bytes.NewReader(append(slices.Clone(data[:scans[n]]), eoi...)))
+			if err == nil {
+				return img, nil
+			}
+		}
+
+		return nil, errors.New("salvageJPEG: no complete scan")
+	}
+
+	width := int(binary.BigEndian.Uint16(data[sof+7:]))
+	height := int(binary.BigEndian.Uint16(data[sof+5:]))
+
+	truncated := append(slices.Clone(data), eoi...)
+
+	decode := func(rows int) (image.Image, error) {
+		// decoding stops after the rows declared in the frame header, remaining data is skipped
+		binary.BigEndian.PutUint16(truncated[sof+5:], uint16(rows))
+
+		return jpeg.Decode(bytes.NewReader(truncated))
+	}
+
+	// binary search for the most rows that can be decoded
+	var part image.Image
+	lo, hi := 1, height
+	for lo <= hi {
+		rows := (lo + hi) / 2
+
+		img, err := decode(rows)
+		if err != nil {
+			hi = rows - 1
+
+			continue
+		}
+
+		part = img
+		lo = rows + 1
+	}
+
+	if part == nil {
+		return nil, errors.New("salvageJPEG: no complete rows")
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(img, img.Bounds(), image.NewUniform(color.Gray{Y: 0x80}), image.Point{}, draw.Src)
+	draw.Draw(img, part.Bounds(), part, part.Bounds().Min, draw.Src)
+
+	return img, nil
+}
+
+// jpegSegments returns offset of the frame header (SOF) marker, if it is progressive and offsets of the scan (SOS) markers.
+func jpegSegments(data []byte) (int, bool, []int) {
+	var sof int
+	var progressive bool
+	var scans []int
+
+	for off := 2; off+4 <= len(data); {
+		if data[off] != 0xff {
+			off++
+
+			continue
+		}
+
+		marker := data[off+1]
+		switch {
+		case marker == 0xff:
+			// fill byte
+			off++
+
+			continue
+		case marker == 0x00, marker >= 0xd0 && marker <= 0xd7:
+			// stuffed byte or restart marker in scan data
+			off += 2
+
+			continue
+		case marker == 0xd9:
+			return sof, progressive, scans
+		}
+
+		size := int(binary.BigEndian.Uint16(data[off+2:]))
+
+		switch marker {
+		case 0xc0, 0xc1, 0xc2:
+			if off+9 <= len(data) {
+				sof, progressive = off, marker == 0xc2
+			}
+		case 0xda:
+			scans = append(scans, off)
+		}
+
+		off += 2 + size
+	}
+
+	return sof, progressive, scans
+}
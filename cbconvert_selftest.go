@@ -0,0 +1,245 @@
+package cbconvert
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/gif"
+	"os"
+	"path/filepath"
+)
+
+// SelfTest type, check of the image codec, archive or document backend available in this build.
+type SelfTest struct {
+	// Name, i.e. encode webp, decode gif, archive, document
+	Name string
+	// Run runs the check, a broken native library may crash the process instead of returning error
+	Run func() error
+}
+
+// selftestSize is width and height of the fixture image.
+const selftestSize = 16
+
+// SelfTests returns checks for codecs, archives and documents available in this build.
+func SelfTests() []SelfTest {
+	var tests []SelfTest
+
+	for _, format := range imageFormats {
+		cd, ok := codecs[format]
+		if !ok {
+			continue
+		}
+
+		if cd.encode != nil {
+			tests = append(tests, SelfTest{Name: "encode " + format, Run: func() error {
+				return selftestCodec(format, cd.encode)
+			}})
+
+			continue
+		}
+
+		tests = append(tests, SelfTest{Name: "decode " + format, Run: func() error {
+			return selftestDecode(format)
+		}})
+	}
+
+	tests = append(tests, SelfTest{Name: "archive", Run: selftestArchive})
+
+	if documents {
+		tests = append(tests, SelfTest{Name: "document", Run: selftestDocument})
+	}
+
+	return tests
+}
+
+// selftestImage returns fixture image, a gradient with the opaque alpha.
+func selftestImage() *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, selftestSize, selftestSize))
+	for y := 0; y < selftestSize; y++ {
+		for x := 0; x < selftestSize; x++ {
+			img.SetNRGBA(x, y, color.NRGBA{R: uint8(x * 16), G: uint8(y * 16), B: 128, A: 255})
+		}
+	}
+
+	return img
+}
+
+// selftestCheck decodes the data and checks the format and dimensions.
+func selftestCheck(data []byte, format string) error {
+	img, name, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("decode: %w", err)
+	}
+
+	if name != format {
+		return fmt.Errorf("decode: format is %q, expected %q", name, format)
+	}
+
+	if b := img.Bounds(); b.Dx() != selftestSize || b.Dy() != selftestSize {
+		return fmt.Errorf("decode: size is %dx%d, expected %dx%d", b.Dx(), b.Dy(), selftestSize, selftestSize)
+	}
+
+	return nil
+}
+
+// selftestCodec encodes the fixture image and decodes it back.
+func selftestCodec(format string, enc encoder) error {
+	var buf bytes.Buffer
+	if err := enc(&buf, selftestImage(), 75); err != nil {
+		return fmt.Errorf("encode: %w", err)
+	}
+
+	return selftestCheck(buf.Bytes(), format)
+}
+
+// selftestDecode decodes the fixture image of the format that can only be decoded.
+func selftestDecode(format string) error {
+	var buf bytes.Buffer
+
+	switch format {
+	case "gif":
+		if err := gif.Encode(&buf, selftestImage(), nil); err != nil {
+			return fmt.Errorf("fixture: %w", err)
+		}
+	default:
+		return fmt.Errorf("no fixture for %q", format)
+	}
+
+	return selftestCheck(buf.Bytes(), format)
+}
+
+// selftestArchive lists and reads the fixture CBZ.
+func selftestArchive() error {
+	var page bytes.Buffer
+	if err := codecs["png"].encode(&page, selftestImage(), 0); err != nil {
+		return fmt.Errorf("fixture: %w", err)
+	}
+
+	var buf bytes.Buffer
+	z := zip.NewWriter(&buf)
+
+	w, err := z.Create("001.png")
+	if err != nil {
+		return fmt.Errorf("fixture: %w", err)
+	}
+
+	if _, err = w.Write(page.Bytes()); err != nil {
+		return fmt.Errorf("fixture: %w", err)
+	}
+
+	if err = z.Close(); err != nil {
+		return fmt.Errorf("fixture: %w", err)
+	}
+
+	fileName, remove, err := selftestFile("selftest.cbz", buf.Bytes())
+	if err != nil {
+		return err
+	}
+	defer remove()
+
+	c := New(NewOptions())
+
+	contents, err := c.archiveList(fileName)
+	if err != nil {
+		return err
+	}
+
+	if len(contents) != 1 || contents[0] != "001.png" {
+		return fmt.Errorf("archive: contents are %q, expected [001.png]", contents)
+	}
+
+	archive, err := c.archiveOpen(fileName)
+	if err != nil {
+		return err
+	}
+	defer archive.Close()
+
+	if err = archive.EntryFor(contents[0]); err != nil {
+		return fmt.Errorf("archive: %w", err)
+	}
+
+	data, err := c.archiveRead(archive)
+	if err != nil {
+		return fmt.Errorf("archive: %w", err)
+	}
+
+	return selftestCheck(data, "png")
+}
+
+// selftestDocument renders the fixture PDF.
+func selftestDocument() error {
+	fileName, remove, err := selftestFile("selftest.pdf", selftestPDF())
+	if err != nil {
+		return err
+	}
+	defer remove()
+
+	doc, err := openDocument(fileName)
+	if err != nil {
+		return err
+	}
+	defer doc.Close()
+
+	if n := doc.NumPage(); n != 1 {
+		return fmt.Errorf("document: %d pages, expected 1", n)
+	}
+
+	img, err := doc.Image(0)
+	if err != nil {
+		return fmt.Errorf("document: %w", err)
+	}
+
+	if img.Bounds().Empty() {
+		return fmt.Errorf("document: empty page")
+	}
+
+	return nil
+}
+
+// selftestFile writes the fixture to temporary directory, returned function removes it.
+func selftestFile(name string, data []byte) (string, func(), error) {
+	dir, err := os.MkdirTemp(os.TempDir(), "cbc")
+	if err != nil {
+		return "", nil, fmt.Errorf("fixture: %w", err)
+	}
+
+	fileName := filepath.Join(dir, name)
+	if err = os.WriteFile(fileName, data, 0644); err != nil {
+		_ = os.RemoveAll(dir)
+
+		return "", nil, fmt.Errorf("fixture: %w", err)
+	}
+
+	return fileName, func() { _ = os.RemoveAll(dir) }, nil
+}
+
+// selftestPDF returns one page PDF with a filled rectangle.
+func selftestPDF() []byte {
+	content := "0.5 g 4 4 8 8 re f"
+	objects := []string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %d %d] /Contents 4 0 R >>", selftestSize, selftestSize),
+		fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", len(content), content),
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("%PDF-1.4\n")
+
+	offsets := make([]int, len(objects))
+	for i, obj := range objects {
+		offsets[i] = buf.Len()
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", i+1, obj)
+	}
+
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+
+	return buf.Bytes()
+}
@@ -0,0 +1,185 @@
+package cbconvert
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Stats type, counters gathered during conversion.
+type Stats struct {
+	// Converted files
+	Files int64
+	// Converted pages (images that were decoded and encoded)
+	Pages int64
+	// Pages encoded with a fallback format
+	Fallbacks int64
+	// Bytes read from input files
+	BytesIn int64
+	// Bytes written to output files
+	BytesOut int64
+	// Time spent decoding images
+	Decode time.Duration
+	// Time spent transforming images
+	Transform time.Duration
+	// Time spent encoding images
+	Encode time.Duration
+	// Time spent saving archives
+	Save time.Duration
+	// Total conversion time
+	Elapsed time.Duration
+}
+
+// PagesPerSecond returns converted pages per second of the total conversion time.
+func (s Stats) PagesPerSecond() float64 {
+	if s.Elapsed <= 0 {
+		return 0
+	}
+
+	return float64(s.Pages) / s.Elapsed.Seconds()
+}
+
+// WritePrometheus writes stats in Prometheus text exposition format.
+func (s Stats) WritePrometheus(w io.Writer) error {
+	metrics := []struct {
+		name, help, typ string
+		labels          string
+		value           float64
+	}{
+		{"cbconvert_files_total", "Converted files.", "counter", "", float64(s.Files)},
+		{"cbconvert_pages_total", "Converted pages.", "counter", "", float64(s.Pages)},
+		{"cbconvert_fallback_pages_total", "Pages encoded with a fallback format.", "counter", "", float64(s.Fallbacks)},
+		{"cbconvert_read_bytes_total", "Bytes read from input files.", "counter", "", float64(s.BytesIn)},
+		{"cbconvert_written_bytes_total", "Bytes written to output files.", "counter", "", float64(s.BytesOut)},
+		{"cbconvert_stage_seconds_total", "Time spent in conversion stage.", "counter", `{stage="decode"}`, s.Decode.Seconds()},
+		{"cbconvert_stage_seconds_total", "", "", `{stage="transform"}`, s.Transform.Seconds()},
+		{"cbconvert_stage_seconds_total", "", "", `{stage="encode"}`, s.Encode.Seconds()},
+		{"cbconvert_stage_seconds_total", "", "", `{stage="save"}`, s.Save.Seconds()},
+		{"cbconvert_elapsed_seconds_total", "Total conversion time.", "counter", "", s.Elapsed.Seconds()},
+		{"cbconvert_pages_per_second", "Converted pages per second.", "gauge", "", s.PagesPerSecond()},
+	}
+
+	for _, m := range metrics {
+		if m.help != "" {
+			if _, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", m.name, m.help, m.name, m.typ); err != nil {
+				return fmt.Errorf("WritePrometheus: %w", err)
+			}
+		}
+
+		if _, err := fmt.Fprintf(w, "%s%s %g\n", m.name, m.labels, m.value); err != nil {
+			return fmt.Errorf("WritePrometheus: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// stats type, counters updated concurrently during conversion.
+type stats struct {
+	files     atomic.Int64
+	pages     atomic.Int64
+	fallbacks atomic.Int64
+	bytesIn   atomic.Int64
+	bytesOut  atomic.Int64
+	decode    atomic.Int64
+	transform atomic.Int64
+	encode    atomic.Int64
+	save      atomic.Int64
+	elapsed   atomic.Int64
+}
+
+// since adds time elapsed since start to counter.
+func since(counter *atomic.Int64, start time.Time) {
+	counter.Add(int64(time.Since(start)))
+}
+
+// Stats returns snapshot of the counters gathered during conversion.
+func (c *Converter) Stats() Stats {
+	return Stats{
+		Files:     c.shared.stats.files.Load(),
+		Pages:     c.shared.stats.pages.Load(),
+		Fallbacks: c.shared.stats.fallbacks.Load(),
+		BytesIn:   c.shared.stats.bytesIn.Load(),
+		BytesOut:  c.shared.stats.bytesOut.Load(),
+		Decode:    time.Duration(c.shared.stats.decode.Load()),
+		Transform: time.Duration(c.shared.stats.transform.Load()),
+		Encode:    time.Duration(c.shared.stats.encode.Load()),
+		Save:      time.Duration(c.shared.stats.save.Load()),
+		Elapsed:   time.Duration(c.shared.stats.elapsed.Load()),
+	}
+}
+
+// rateWindow is number of recent pages used for the rolling rate.
+const rateWindow = 32
+
+// rate type, times of recently converted pages.
+type rate struct {
+	mu    sync.Mutex
+	times [rateWindow]time.Time
+	count int
+}
+
+// tick records converted page.
+func (r *rate) tick() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.times[r.count%rateWindow] = time.Now()
+	r.count++
+}
+
+// perSecond returns pages per second over the recent pages and number of all recorded pages.
+func (r *rate) perSecond() (float64, int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	n := min(r.count, rateWindow)
+	if n < 2 {
+		return 0, r.count
+	}
+
+	d := r.times[(r.count-1)%rateWindow].Sub(r.times[(r.count-n)%rateWindow])
+	if d <= 0 {
+		return 0, r.count
+	}
+
+	return float64(n-1) / d.Seconds(), r.count
+}
+
+// ETA type, estimated remaining conversion time.
+type ETA struct {
+	// Pages per second over the recent pages
+	Rate float64
+	// Remaining time for the current file
+	File time.Duration
+	// Remaining time for all files, remaining files are expected to have the average number of pages
+	Batch time.Duration
+}
+
+// ETA returns estimated remaining time of the job and the batch, it is zero until the rate is known
+// (at least two pages are converted).
+func (j *Job) ETA() ETA {
+	if j.rate == nil {
+		return ETA{}
+	}
+
+	perSecond, count := j.rate.perSecond()
+	if perSecond == 0 {
+		return ETA{}
+	}
+
+	current := int(atomic.LoadInt32(&j.CurrContent))
+	remaining := max(j.Ncontents-current, 0)
+
+	eta := ETA{Rate: perSecond}
+	eta.File = time.Duration(float64(remaining) / perSecond * float64(time.Second))
+
+	// pages of the previous files and all pages of the current file
+	average := float64(count-current+j.Ncontents) / float64(max(j.CurrFile, 1))
+	files := max(j.Nfiles-j.CurrFile, 0)
+	eta.Batch = eta.File + time.Duration(float64(files)*average/perSecond*float64(time.Second))
+
+	return eta
+}
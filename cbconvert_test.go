@@ -1,10 +1,40 @@
 package cbconvert
 
 import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/md5"
+	"encoding/binary"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
 	"fmt"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"os"
 	"path/filepath"
+	"reflect"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/gen2brain/cbconvert/meta"
 )
 
 func TestConvert(t *testing.T) {
@@ -29,7 +59,7 @@ func TestConvert(t *testing.T) {
 		for _, file := range files {
 			conv.Opts.Suffix = fmt.Sprintf("_%s%s", format, filepath.Ext(file.Path))
 
-			err = conv.Convert(file.Path, file.Stat)
+			_, err = conv.Convert(file.Path, file.Stat)
 			if err != nil {
 				t.Errorf("format %s: file %s: %v", format, file.Name, err)
 			}
@@ -99,3 +129,3778 @@ func TestThumbnail(t *testing.T) {
 		t.Error(err)
 	}
 }
+
+func TestWriteArchive(t *testing.T) {
+	tmpDir, err := os.MkdirTemp(os.TempDir(), "cbc")
+	if err != nil {
+		t.Error(err)
+	}
+
+	pages := make([]PageSource, 0)
+	for _, name := range []string{"00.jpg", "01.jpg"} {
+		file, err := os.Open(filepath.Join("testdata", "test", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer file.Close()
+
+		pages = append(pages, PageSource{Name: name, Reader: file})
+	}
+
+	pages = append(pages, PageSource{Image: image.NewGray(image.Rect(0, 0, 100, 150))})
+
+	opts := NewOptions()
+	opts.Format = "png"
+
+	w, err := os.Create(filepath.Join(tmpDir, "test.cbz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	err = WriteArchive(w, pages, opts)
+	if err != nil {
+		t.Error(err)
+	}
+
+	zr, err := zip.OpenReader(w.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer zr.Close()
+
+	var names []string
+	for _, f := range zr.File {
+		names = append(names, f.Name)
+	}
+
+	if strings.Join(names, ",") != "00.png,01.png,002.png" {
+		t.Errorf("unexpected entries %v", names)
+	}
+
+	err = os.RemoveAll(tmpDir)
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestEntryName(t *testing.T) {
+	tests := map[string]string{
+		"001.jpg":                   "001.jpg",
+		"chapter/001.jpg":           "001.jpg",
+		"../../../etc/passwd":       "passwd",
+		"..\\..\\windows\\evil.jpg": "evil.jpg",
+		"/abs/evil.jpg":             "evil.jpg",
+		"C:evil.jpg":                "evil.jpg",
+		"C:\\evil.jpg":              "evil.jpg",
+		"dir/..":                    "_",
+		"..":                        "_",
+		"./":                        "_",
+		"":                          "_",
+		"evil\x00.jpg":              "evil.jpg",
+	}
+
+	for name, expected := range tests {
+		if got := entryName(name); got != expected {
+			t.Errorf("entryName(%q) = %q, expected %q", name, got, expected)
+		}
+	}
+}
+
+func TestConvertZipSlip(t *testing.T) {
+	tmpDir := t.TempDir()
+	t.Setenv("TMPDIR", filepath.Join(tmpDir, "a", "b", "c"))
+
+	if err := os.MkdirAll(os.TempDir(), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	page, err := os.ReadFile(filepath.Join("testdata", "test", "00.jpg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	names := []string{"../../../evil1.jpg", "..\\..\\..\\evil2.jpg", "/evil3.jpg", "C:\\evil4.jpg", "../../../evil.txt"}
+
+	fileName := filepath.Join(tmpDir, "slip.cbz")
+	w, err := os.Create(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	z := zip.NewWriter(w)
+	for _, name := range names {
+		f, err := z.CreateRaw(&zip.FileHeader{Name: name, Method: zip.Store, CompressedSize64: uint64(len(page)),
+			UncompressedSize64: uint64(len(page)), CRC32: crc32.ChecksumIEEE(page)})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err = f.Write(page); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err = z.Close(); err != nil {
+		t.Fatal(err)
+	}
+	_ = w.Close()
+
+	opts := NewOptions()
+	opts.NoConvert = true
+	opts.OutDir = filepath.Join(tmpDir, "out")
+	opts.Suffix = "_out"
+
+	if err := os.MkdirAll(opts.OutDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	stat, err := os.Stat(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = New(opts).Convert(fileName, stat); err != nil {
+		t.Fatal(err)
+	}
+
+	err = filepath.Walk(tmpDir, func(path string, info os.FileInfo, err error) error {
+		if err == nil && strings.HasPrefix(info.Name(), "evil") {
+			t.Errorf("entry extracted outside of workdir: %s", path)
+		}
+
+		return err
+	})
+	if err != nil {
+		t.Error(err)
+	}
+
+	zr, err := zip.OpenReader(filepath.Join(opts.OutDir, "slip_out.cbz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer zr.Close()
+
+	for _, f := range zr.File {
+		if f.Name != entryName(f.Name) {
+			t.Errorf("unsafe entry name in output: %s", f.Name)
+		}
+	}
+
+	if len(zr.File) != len(names) {
+		t.Errorf("%d entries in output, expected %d", len(zr.File), len(names))
+	}
+}
+
+func TestArchiveSaveEpub(t *testing.T) {
+	tests := []struct {
+		name    string
+		rotate  int
+		itemref string
+	}{
+		{"none", 0, `<itemref idref="page0002"/>`},
+		{"rotate", 90, `<itemref idref="page0002" properties="rendition:orientation-portrait"/>`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := NewOptions()
+			opts.Format = "png"
+			opts.RightToLeft = true
+			opts.Rotate = tt.rotate
+
+			c := New(opts)
+			c.job.workdir = t.TempDir()
+
+			for _, name := range []string{"001.png", "002.png"} {
+				file, err := os.Create(filepath.Join(c.job.workdir, name))
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if err = codecs["png"].encode(file, image.NewGray(image.Rect(0, 0, 100, 150)), 0); err != nil {
+					t.Fatal(err)
+				}
+				_ = file.Close()
+			}
+
+			epubName := filepath.Join(t.TempDir(), "test.epub")
+			if err := c.archiveSaveEpub("test.cbz", epubName); err != nil {
+				t.Fatal(err)
+			}
+
+			zr, err := zip.OpenReader(epubName)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer zr.Close()
+
+			if zr.File[0].Name != "mimetype" || zr.File[0].Method != zip.Store || len(zr.File[0].Extra) != 0 {
+				t.Errorf("first entry is %s, expected stored mimetype", zr.File[0].Name)
+			}
+
+			rc, err := zr.Open("OEBPS/content.opf")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer rc.Close()
+
+			opf, err := io.ReadAll(rc)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, s := range []string{`<spine page-progression-direction="rtl">`, `<meta property="rendition:layout">pre-paginated</meta>`, `properties="cover-image"`, tt.itemref} {
+				if !strings.Contains(string(opf), s) {
+					t.Errorf("content.opf does not contain %s", s)
+				}
+			}
+		})
+	}
+}
+
+func TestComicInfoValidate(t *testing.T) {
+	data := []byte(`<?xml version="1.0"?>
+<ComicInfo>
+  <Series>Test &amp; Series</Series>
+  <Year>2021-03-15</Year>
+  <Manga>true</Manga>
+  <Day>40</Day>
+  <Rating>5</Rating>
+  <Pages>
+    <Page Image="0" Type="Cover" />
+  </Pages>
+</ComicInfo>`)
+
+	issues, version, _, err := meta.Validate(data, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if version != "2.0" {
+		t.Errorf("schema version %s, expected 2.0", version)
+	}
+
+	expected := []string{`Year: invalid integer "2021-03-15"`, `Manga: invalid value "true"`, "Day: 40 is out of range",
+		"unknown element Rating", `Page 0: Type: invalid value "Cover"`}
+	if strings.Join(issues, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("issues %q, expected %q", issues, expected)
+	}
+
+	_, _, fixed, err := meta.Validate(data, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, s := range []string{"<Year>2021</Year>\n  <Month>3</Month>\n  <Manga>Yes</Manga>", "<Series>Test &amp; Series</Series>", "<Day>40</Day>", "<Rating>5</Rating>"} {
+		if !strings.Contains(string(fixed), s) {
+			t.Errorf("fixed data does not contain %s", s)
+		}
+	}
+}
+
+func TestWriteLimit(t *testing.T) {
+	data := bytes.Repeat([]byte("cbconvert"), 32*1024)
+
+	opts := NewOptions()
+	conv := New(opts)
+
+	r := bytes.NewReader(data)
+	if conv.throttle(r) != io.Reader(r) {
+		t.Errorf("reader is throttled without the limit")
+	}
+
+	// 1 MB/s, reads are split to 1/10 of the rate
+	opts.WriteLimit = 1
+	conv = New(opts)
+
+	tr := conv.throttle(bytes.NewReader(data))
+
+	var reads []int
+	pw := &progressWriter{w: io.Discard, total: int64(len(data)), fn: func(written, total int64) {
+		reads = append(reads, int(written))
+	}}
+
+	start := time.Now()
+	if _, err := io.Copy(pw, tr); err != nil {
+		t.Fatal(err)
+	}
+	elapsed := time.Since(start)
+
+	want := time.Duration(float64(len(data)) / (1024 * 1024) * float64(time.Second))
+	if elapsed < want*9/10 {
+		t.Errorf("copied %d bytes in %v, want at least %v", len(data), elapsed, want)
+	}
+
+	if pw.written != int64(len(data)) || reads[len(reads)-1] != len(data) {
+		t.Errorf("written %d bytes, want %d", pw.written, len(data))
+	}
+
+	for i := 1; i < len(reads); i++ {
+		if n := reads[i] - reads[i-1]; n > 1024*1024/10 {
+			t.Errorf("read %d bytes at once, want at most %d", n, 1024*1024/10)
+		}
+	}
+}
+
+func TestArchiveDecrypt(t *testing.T) {
+	fileName := filepath.Join("testdata", "encrypted", "test.cbz")
+
+	c := New(NewOptions())
+	if _, err := c.archiveList(fileName); !errors.Is(err, ErrEncrypted) {
+		t.Errorf("expected ErrEncrypted, got %v", err)
+	}
+
+	if _, err := archiveDecrypt(fileName, "wrong"); !errors.Is(err, ErrPassword) {
+		t.Errorf("expected ErrPassword, got %v", err)
+	}
+
+	c.Opts.Password = "secret"
+	contents, err := c.archiveList(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Join(contents, ",") != "00.png,01.png" {
+		t.Errorf("unexpected entries %v", contents)
+	}
+}
+
+func TestConvertEncrypted(t *testing.T) {
+	fileName := filepath.Join("testdata", "encrypted", "test.cbz")
+
+	stat, err := os.Stat(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, password := range []string{"", "wrong", "secret"} {
+		opts := NewOptions()
+		opts.NoConvert = true
+		opts.OutDir = t.TempDir()
+		opts.Password = password
+		conv := New(opts)
+
+		_, err := conv.ListPages(fileName, stat, false)
+		res, cerr := conv.Convert(fileName, stat)
+
+		switch password {
+		case "":
+			if !errors.Is(err, ErrEncrypted) || !errors.Is(cerr, ErrEncrypted) {
+				t.Errorf("without password: %v, %v", err, cerr)
+			}
+		case "wrong":
+			if !errors.Is(err, ErrPassword) || !errors.Is(cerr, ErrPassword) {
+				t.Errorf("wrong password: %v, %v", err, cerr)
+			}
+		default:
+			if err != nil || cerr != nil {
+				t.Fatalf("password: %v, %v", err, cerr)
+			}
+
+			zr, err := zip.OpenReader(res.Output)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(zr.File) != 2 || zr.File[0].Flags&0x1 != 0 {
+				t.Errorf("output is not decrypted")
+			}
+
+			_ = zr.Close()
+		}
+	}
+}
+
+func TestReadList(t *testing.T) {
+	dir := t.TempDir()
+	abs := filepath.Join(os.TempDir(), "002.cbz")
+
+	list := "\ufeff#EXTM3U\n# weekly\n\n  001.cbz  \n" + abs + "\r\n"
+
+	paths, err := ReadList(strings.NewReader(list), dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{filepath.Join(dir, "001.cbz"), abs}
+	if strings.Join(paths, ",") != strings.Join(expected, ",") {
+		t.Errorf("got %v, expected %v", paths, expected)
+	}
+}
+
+func TestDjvuParse(t *testing.T) {
+	// 10x2 bitmap, the first 4 pixels of each row are black
+	pbm := append([]byte("P4\n# ddjvu\n10 2\n"), 0xf0, 0x00, 0xf0, 0x00)
+
+	img, err := pnmDecode(bytes.NewReader(pbm))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gray := img.(*image.Gray)
+	if gray.Bounds().Dx() != 10 || gray.GrayAt(3, 1).Y != 0 || gray.GrayAt(4, 1).Y != 0xff {
+		t.Errorf("unexpected bitmap %v", gray.Pix)
+	}
+
+	expr, err := sexpParse([]byte(`(bookmarks ("Chapter \"1\"" "#3" ("Part" "#4")) ("Back" "#back"))`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(expr) != 1 || len(expr[0].list) != 3 || expr[0].list[1].list[0].atom != `Chapter "1"` || expr[0].list[1].list[2].list[1].atom != "#4" {
+		t.Errorf("unexpected expression %+v", expr)
+	}
+}
+
+func TestSeriesNumber(t *testing.T) {
+	tests := map[string]string{
+		"SeriesName - 012.jpg":                      "seriesname#12",
+		"Series Name 012 (2019) (Digital).cbz":      "seriesname#12",
+		"Series Name #12 - The Title [Scanner].cbr": "seriesname#12",
+		"2000 AD 0100.cbz":                          "2000ad#100",
+		"Series_000.5.cbz":                          "series#0.5",
+		"Series 03 of 12.cbz":                       "series#3",
+	}
+
+	for name, expected := range tests {
+		if key, _ := seriesNumber(name); key != expected {
+			t.Errorf("%s: got %q, expected %q", name, key, expected)
+		}
+	}
+
+	if _, ok := seriesNumber("Oneshot (2019).cbz"); ok {
+		t.Errorf("Oneshot (2019).cbz: unexpected match")
+	}
+}
+
+func TestNameComicInfo(t *testing.T) {
+	tests := map[string]string{
+		"Star Trek v02 #012 - The Title (2019) (Digital).cbz": "Star Trek|12|2|2019",
+		"Series_Name_003_(2020-05).cbr":                       "Series Name|3|0|2020",
+		"Saga Vol. 1 (2012).cbz":                              "Saga||1|2012",
+		"2000 AD 0100.cbz":                                    "2000 AD|100|0|0",
+		"Oneshot - Title [Scanner].cbz":                       "Oneshot||0|0",
+	}
+
+	for name, expected := range tests {
+		info, ok := nameComicInfo(name)
+		if !ok {
+			t.Errorf("%s: no series", name)
+
+			continue
+		}
+
+		if got := fmt.Sprintf("%s|%s|%d|%d", info.Series, info.Number, info.Volume, info.Year); got != expected {
+			t.Errorf("%s: got %q, expected %q", name, got, expected)
+		}
+	}
+}
+
+func TestMontage(t *testing.T) {
+	opts := NewOptions()
+	opts.MontageColumns = 2
+	opts.MontageCellWidth = 100
+	opts.MontageLabels = true
+
+	conv := New(opts)
+
+	files, err := conv.Files([]string{"testdata/test.cbz", "testdata/test.cbz"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	img, err := conv.Montage(files)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// two cells of 100x150 with gaps, and label under the covers
+	if w, h := img.Bounds().Dx(), img.Bounds().Dy(); w != 224 || h != 183 {
+		t.Errorf("montage size %dx%d, expected 224x183", w, h)
+	}
+}
+
+func TestComicInfoPages(t *testing.T) {
+	conv := New(NewOptions())
+	conv.job.workdir = t.TempDir()
+	conv.job.pages = []string{"book/b.jpg", "book/a.jpg"}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, 10, 20))); err != nil {
+		t.Fatal(err)
+	}
+
+	// a.jpg is split into tiles, b.jpg is converted
+	for _, name := range []string{"a_001.png", "a_002.png", "b.png"} {
+		if err := os.WriteFile(filepath.Join(conv.job.workdir, name), buf.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	data := `<ComicInfo><Notes>keep</Notes><Pages><Page Image="0" Type="FrontCover" ImageSize="1" />` +
+		`<Page Image="1" Type="Story" Bookmark="Chapter 1" ImageSize="2" /></Pages></ComicInfo>`
+	if err := os.WriteFile(filepath.Join(conv.job.workdir, "ComicInfo.xml"), []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := conv.comicInfoWrite(); err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := os.ReadFile(filepath.Join(conv.job.workdir, "ComicInfo.xml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var info ComicInfo
+	if err := xml.Unmarshal(out, &info); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []ComicInfoPage{
+		{Image: 0, Type: "Story", Bookmark: "Chapter 1", ImageSize: int64(buf.Len()), ImageWidth: 10, ImageHeight: 20},
+		{Image: 1, Type: "Story", ImageSize: int64(buf.Len()), ImageWidth: 10, ImageHeight: 20},
+		{Image: 2, Type: "FrontCover", ImageSize: int64(buf.Len()), ImageWidth: 10, ImageHeight: 20},
+	}
+
+	if fmt.Sprint(info.Pages) != fmt.Sprint(expected) {
+		t.Errorf("pages %v, expected %v", info.Pages, expected)
+	}
+
+	if info.PageCount != 3 || !strings.Contains(string(out), "<Notes>keep</Notes>") {
+		t.Errorf("ComicInfo.xml not preserved: %s", out)
+	}
+}
+
+func TestComicInfoMarshal(t *testing.T) {
+	data := `<?xml version="1.0" encoding="utf-8"?>
+<ComicInfo xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" xmlns:xsd="http://www.w3.org/2001/XMLSchema">
+  <Series>Saga</Series>
+  <!-- tagged by hand -->
+  <Volume>v2</Volume>
+  <Notes>remove</Notes>
+  <Tags>space opera</Tags>
+  <Year>2021-03-15</Year>
+  <Pages>
+    <Page Image="0" Type="FrontCover" />
+  </Pages>
+</ComicInfo>
+`
+
+	info, err := meta.Parse([]byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if info.Series != "Saga" || info.Volume != 2 || info.Year != 2021 || len(info.Pages) != 1 || info.Pages[0].Type != "FrontCover" {
+		t.Errorf("parsed %+v", info)
+	}
+
+	if len(info.Extra) != 1 || info.Extra[0].XMLName.Local != "Tags" || info.Extra[0].Inner != "space opera" {
+		t.Errorf("unknown elements %+v", info.Extra)
+	}
+
+	// unchanged year keeps the date, new fields are added before the page table
+	info.Series = "Saga & Co"
+	info.Title = "Chapter One"
+	info.Notes = ""
+
+	out, err := meta.Marshal([]byte(data), info)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `<?xml version="1.0" encoding="utf-8"?>
+<ComicInfo xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" xmlns:xsd="http://www.w3.org/2001/XMLSchema">
+  <Series>Saga &amp; Co</Series>
+  <!-- tagged by hand -->
+  <Volume>v2</Volume>
+  <Tags>space opera</Tags>
+  <Year>2021-03-15</Year>
+  <Title>Chapter One</Title>
+  <Pages>
+    <Page Image="0" Type="FrontCover" />
+  </Pages>
+</ComicInfo>
+`
+	if string(out) != expected {
+		t.Errorf("got\n%s\nexpected\n%s", out, expected)
+	}
+
+	// changed page table is replaced, other elements are not touched
+	info.Pages = []ComicInfoPage{{Image: 0, Type: "FrontCover", ImageWidth: 10}, {Image: 1, Bookmark: "Chapter 1"}}
+	info.PageCount = 2
+
+	out, err = meta.Marshal(out, info)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected = strings.Replace(expected, `  <Pages>
+    <Page Image="0" Type="FrontCover" />
+  </Pages>`, `  <PageCount>2</PageCount>
+  <Pages>
+    <Page Image="0" Type="FrontCover" ImageWidth="10"></Page>
+    <Page Image="1" Bookmark="Chapter 1"></Page>
+  </Pages>`, 1)
+	if string(out) != expected {
+		t.Errorf("got\n%s\nexpected\n%s", out, expected)
+	}
+
+	if parsed, err := meta.Parse(out); err != nil || !slices.Equal(parsed.Pages, info.Pages) {
+		t.Errorf("pages %v, %v", parsed.Pages, err)
+	}
+
+	tests := []struct {
+		data     string
+		expected string
+	}{
+		{"", xml.Header + "<ComicInfo>\n  <Title>T</Title>\n</ComicInfo>"},
+		{`<ComicInfo xmlns:xsd="http://www.w3.org/2001/XMLSchema"/>`,
+			`<ComicInfo xmlns:xsd="http://www.w3.org/2001/XMLSchema">` + "\n  <Title>T</Title>\n</ComicInfo>"},
+		{"<ComicInfo></ComicInfo>", "<ComicInfo>\n  <Title>T</Title>\n</ComicInfo>"},
+		{"<ComicInfo><Series>S</Series><Title>Old</Title><Title>Old</Title></ComicInfo>",
+			"<ComicInfo><Series>S</Series><Title>T</Title></ComicInfo>"},
+	}
+
+	for _, tt := range tests {
+		info, err := meta.Parse([]byte(tt.data))
+		if tt.data != "" && err != nil {
+			t.Fatal(err)
+		}
+
+		info.Title = "T"
+
+		out, err := meta.Marshal([]byte(tt.data), info)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(out) != tt.expected {
+			t.Errorf("%q: got %q, expected %q", tt.data, out, tt.expected)
+		}
+	}
+}
+
+func TestComicInfoKeep(t *testing.T) {
+	src, err := zip.OpenReader(filepath.Join("testdata", "test.cbz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+
+	comicInfo := `<?xml version="1.0"?>
+<ComicInfo xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance">
+  <Tags>space opera</Tags>
+  <Volume>Vol. 2</Volume>
+  <Year>2021-03-15</Year>
+  <Pages>
+    <Page Image="1" Type="Story" />
+    <Page Image="0" Type="FrontCover" />
+  </Pages>
+</ComicInfo>`
+
+	fileName := filepath.Join(t.TempDir(), "Saga 012.cbz")
+
+	f, err := os.Create(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	zw := zip.NewWriter(f)
+	for _, item := range src.File {
+		if err := zw.Copy(item); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	w, err := zw.Create("ComicInfo.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := w.Write([]byte(comicInfo)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// meta, name parsing and page table update are applied to the same file
+	opts := NewOptions()
+	opts.SetTitle = "Chapter Twelve"
+
+	if _, err := New(opts).Meta(fileName); err != nil {
+		t.Fatal(err)
+	}
+
+	stat, err := os.Stat(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts = NewOptions()
+	opts.NoConvert = true
+	opts.ComicInfoName = true
+	opts.OutDir = t.TempDir()
+
+	res, err := New(opts).Convert(fileName, stat)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := New(opts).archiveFileGet(res.Output, "ComicInfo.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, s := range []string{
+		`<ComicInfo xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance">` + "\n  <Tags>space opera</Tags>\n  <Volume>Vol. 2</Volume>\n  <Year>2021-03-15</Year>\n",
+		"<Title>Chapter Twelve</Title>",
+		"<Series>Saga</Series>",
+		"<Number>12</Number>",
+		`<Page Image="1" Type="Story" ImageSize="`,
+		`<Page Image="0" Type="FrontCover" ImageSize="`,
+	} {
+		if !strings.Contains(string(out), s) {
+			t.Errorf("ComicInfo.xml does not contain %q:\n%s", s, out)
+		}
+	}
+}
+
+func TestPlugin(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("shell script handler")
+	}
+
+	dir := t.TempDir()
+
+	// handler copies the input as the only page, the output directory is the last string in the request
+	script := `#!/bin/sh
+read -r req
+case "$req" in *'"info"'*) echo '{"name":"test","version":"1.0"}'; exit 0;; esac
+file=$(echo "$req" | sed 's/.*"file":"\([^"]*\)".*/\1/')
+dir=$(echo "$req" | sed 's/.*"dir":"\([^"]*\)".*/\1/')
+cp "$file" "$dir/001.jpg" && echo '{}'
+`
+	if err := os.WriteFile(filepath.Join(dir, PluginPrefix+"tst"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	plugins := pluginScan([]string{dir, "relative"})
+	if plugins[".tst"] != filepath.Join(dir, PluginPrefix+"tst") || len(plugins) != 1 {
+		t.Fatalf("plugins %v", plugins)
+	}
+
+	resp, err := pluginExec(context.Background(), plugins[".tst"], PluginRequest{Method: "info"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.Name != "test" || resp.Version != "1.0" {
+		t.Errorf("info %+v", resp)
+	}
+
+	data, err := archivePlugin(plugins[".tst"], "testdata/test/00.jpg", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	z, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(z.File) != 1 || z.File[0].Name != "001.jpg" {
+		t.Errorf("extracted %d files, expected 001.jpg", len(z.File))
+	}
+}
+
+func TestComicVineFetch(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("api_key") != "key" {
+			fmt.Fprint(w, `{"error":"Invalid API Key","status_code":100}`)
+			return
+		}
+
+		switch {
+		case r.URL.Path == "/search/":
+			fmt.Fprint(w, `{"error":"OK","status_code":1,"results":[{"id":1,"name":"Saga","start_year":"1990"},`+
+				`{"id":2,"name":"Saga","start_year":"2012","publisher":{"name":"Image"}},{"id":3,"name":"Saga Deluxe","start_year":"2013"}]}`)
+		case r.URL.Path == "/issues/" && r.URL.Query().Get("filter") == "volume:2,issue_number:12":
+			fmt.Fprint(w, `{"error":"OK","status_code":1,"results":[{"id":5,"name":"Chapter Twelve","issue_number":"12",`+
+				`"cover_date":"2013-05-01","description":"<p>Marko &amp; Alana</p>","site_detail_url":"https://comicvine.example/5"}]}`)
+		case r.URL.Path == "/issues/":
+			fmt.Fprint(w, `{"error":"OK","status_code":1,"results":[]}`)
+		case r.URL.Path == "/issue/4000-5/":
+			fmt.Fprint(w, `{"error":"OK","status_code":1,"results":{"person_credits":[{"name":"Writer","role":"writer"},`+
+				`{"name":"Artist","role":"artist, cover"}]}}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	data, err := os.ReadFile("testdata/test.cbz")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fileName := filepath.Join(t.TempDir(), "Saga 012 (2013).cbz")
+	if err := os.WriteFile(fileName, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := NewOptions()
+	opts.Fetch = true
+	opts.ComicVineKey = "key"
+
+	conv := New(opts)
+	conv.shared.comicVine = &comicVine{url: srv.URL, client: srv.Client()}
+
+	if _, err := conv.Meta(fileName); err != nil {
+		t.Fatal(err)
+	}
+
+	z, err := zip.OpenReader(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer z.Close()
+
+	r, err := z.Open("ComicInfo.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	var info ComicInfo
+	if err := xml.NewDecoder(r).Decode(&info); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := ComicInfo{XMLName: info.XMLName, Title: "Chapter Twelve", Series: "Saga", Number: "12", Summary: "Marko & Alana",
+		Notes: "Tagged with cbconvert using info from ComicVine [Issue ID 5]", Year: 2013, Month: 5, Day: 1, Writer: "Writer",
+		Penciller: "Artist", CoverArtist: "Artist", Publisher: "Image", Web: "https://comicvine.example/5"}
+	if fmt.Sprintf("%+v", info) != fmt.Sprintf("%+v", expected) {
+		t.Errorf("got %+v, expected %+v", info, expected)
+	}
+
+	conv.Opts.ComicVineKey = "invalid"
+	if _, err := conv.Meta(fileName); err == nil || !strings.Contains(err.Error(), "Invalid API Key") {
+		t.Errorf("expected invalid key error, got %v", err)
+	}
+
+	// separators of the filter are not sent
+	if _, err := conv.comicVineFetch(context.Background(), ComicInfo{Series: "Saga", Number: "12,volume:3"}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected not found error, got %v", err)
+	}
+
+	// the wait for the next request is canceled with the context
+	conv.shared.comicVine.interval = time.Hour
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := conv.comicVineGet(ctx, "search", url.Values{}, nil); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected canceled error, got %v", err)
+	}
+}
+
+func TestImageTiles(t *testing.T) {
+	img := image.NewGray(image.Rect(0, 0, 100, 40001))
+
+	if tiles := imageTiles(img, 0); tiles != nil {
+		t.Errorf("image split without tile height")
+	}
+
+	tiles := imageTiles(img, 16383)
+	if len(tiles) != 3 {
+		t.Fatalf("%d tiles, expected 3", len(tiles))
+	}
+
+	height := 0
+	for _, tile := range tiles {
+		if tile.Bounds().Dy() > 16383 || tile.Bounds().Dx() != 100 {
+			t.Errorf("tile %v exceeds the limit", tile.Bounds())
+		}
+
+		height += tile.Bounds().Dy()
+	}
+
+	if height != 40001 {
+		t.Errorf("tiles are %d pixels tall, expected 40001", height)
+	}
+}
+
+func TestComicInfoSet(t *testing.T) {
+	data, err := os.ReadFile("testdata/test.cbz")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fileName := filepath.Join(t.TempDir(), "test.cbz")
+	if err := os.WriteFile(fileName, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := NewOptions()
+	opts.SetSeries = "Test & Series"
+	opts.SetNumber = "12"
+
+	// ComicInfo.xml is created, then updated
+	for _, writer := range []string{"", "Writer"} {
+		opts.SetWriter = writer
+
+		if _, err := New(opts).Meta(fileName); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	z, err := zip.OpenReader(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer z.Close()
+
+	orig, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(z.File) != len(orig.File)+1 {
+		t.Fatalf("got %d entries, expected %d", len(z.File), len(orig.File)+1)
+	}
+
+	for i, item := range orig.File {
+		if z.File[i].Name != item.Name || z.File[i].CRC32 != item.CRC32 {
+			t.Errorf("entry %s changed", item.Name)
+		}
+	}
+
+	r, err := z.Open("ComicInfo.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	var info ComicInfo
+	if err := xml.NewDecoder(r).Decode(&info); err != nil {
+		t.Fatal(err)
+	}
+
+	if info.Series != "Test & Series" || info.Number != "12" || info.Writer != "Writer" {
+		t.Errorf("got %+v", info)
+	}
+}
+
+func FuzzArchiveList(f *testing.F) {
+	for _, name := range []string{"test.cbz", "test.cbr", "test.cb7", "test.cbt"} {
+		data, err := os.ReadFile(filepath.Join("testdata", name))
+		if err != nil {
+			f.Fatal(err)
+		}
+
+		f.Add(data)
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		fileName := filepath.Join(t.TempDir(), "fuzz.cbz")
+		if err := os.WriteFile(fileName, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		opts := NewOptions()
+		opts.MaxEntries = 100
+		opts.MaxEntrySize = 1
+		opts.MaxDepth = 4
+
+		conv := New(opts)
+
+		contents, err := conv.archiveList(fileName)
+		if err != nil {
+			return
+		}
+
+		if len(contents) > opts.MaxEntries {
+			t.Errorf("%d entries listed, maximum is %d", len(contents), opts.MaxEntries)
+		}
+
+		archive, err := conv.archiveOpen(fileName)
+		if err != nil {
+			return
+		}
+		defer archive.Close()
+
+		for archive.Entry() == nil {
+			data, err := conv.archiveRead(archive)
+			if err == nil && len(data) > opts.MaxEntrySize*1024*1024 {
+				t.Errorf("%s: %d bytes read, maximum is %d MB", archive.Name(), len(data), opts.MaxEntrySize)
+			}
+		}
+	})
+}
+
+func FuzzArchiveEncrypted(f *testing.F) {
+	for _, name := range []string{"test.cbz", "test.cbr"} {
+		data, err := os.ReadFile(filepath.Join("testdata", name))
+		if err != nil {
+			f.Fatal(err)
+		}
+
+		f.Add(data)
+	}
+
+	f.Add([]byte("Rar!\x1a\x07\x01\x00\x00\x00\x00\x00\xff\xff\xff\xff\x0f"))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		fileName := filepath.Join(t.TempDir(), "fuzz.cbz")
+		if err := os.WriteFile(fileName, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		_, _ = archiveEncrypted(fileName)
+	})
+}
+
+func TestArchiveOpenWrapped(t *testing.T) {
+	t.Run("gzip", func(t *testing.T) {
+		data, err := os.ReadFile(filepath.Join("testdata", "test.cbt"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		fileName := filepath.Join(t.TempDir(), "test.cbt.gz")
+
+		file, err := os.Create(fileName)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		gz := gzip.NewWriter(file)
+		if _, err = gz.Write(data); err != nil {
+			t.Fatal(err)
+		}
+
+		if err = gz.Close(); err != nil {
+			t.Fatal(err)
+		}
+		_ = file.Close()
+
+		expected, err := New(NewOptions()).archiveList(filepath.Join("testdata", "test.cbt"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		contents, err := New(NewOptions()).archiveList(fileName)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if fmt.Sprint(contents) != fmt.Sprint(expected) {
+			t.Errorf("contents %v, expected %v", contents, expected)
+		}
+	})
+
+	t.Run("ace", func(t *testing.T) {
+		if runtime.GOOS == "windows" {
+			t.Skip("shell script handler")
+		}
+
+		page, err := filepath.Abs(filepath.Join("testdata", "test", "00.jpg"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		dir := t.TempDir()
+
+		// unace x -y archive dir/ extracts the only page
+		script := "#!/bin/sh\ncp \"" + page + "\" \"$4\"\n"
+		if err := os.WriteFile(filepath.Join(dir, "unace"), []byte(script), 0755); err != nil {
+			t.Fatal(err)
+		}
+
+		t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+		fileName := filepath.Join(dir, "test.cba")
+		if err := os.WriteFile(fileName, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		contents, err := New(NewOptions()).archiveList(fileName)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(contents) != 1 || contents[0] != "00.jpg" {
+			t.Errorf("contents %v, expected [00.jpg]", contents)
+		}
+	})
+}
+
+func TestArchiveSaveMobi(t *testing.T) {
+	tests := []struct {
+		name   string
+		format string
+		magic  string
+	}{
+		{"png", "png", "\x89PNG"},
+		// pages copied with NoConvert in formats that MOBI readers do not display are converted to JPEG
+		{"bmp", "bmp", "\xff\xd8"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := NewOptions()
+			opts.Format = "png"
+			opts.NoConvert = true
+
+			c := New(opts)
+			c.job.workdir = t.TempDir()
+
+			for _, name := range []string{"001", "002"} {
+				file, err := os.Create(filepath.Join(c.job.workdir, name+"."+tt.format))
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if err = codecs[tt.format].encode(file, image.NewGray(image.Rect(0, 0, 100, 150)), 0); err != nil {
+					t.Fatal(err)
+				}
+				_ = file.Close()
+			}
+
+			mobiName := filepath.Join(t.TempDir(), "test.mobi")
+			if err := c.archiveSaveMobi("test.cbz", mobiName); err != nil {
+				t.Fatal(err)
+			}
+
+			data, err := os.ReadFile(mobiName)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if string(data[60:68]) != "BOOKMOBI" {
+				t.Fatalf("type and creator %q, expected BOOKMOBI", data[60:68])
+			}
+
+			// header, text, two images, FLIS, FCIS and EOF records
+			count := int(binary.BigEndian.Uint16(data[76:78]))
+			if count != 7 {
+				t.Fatalf("got %d records, expected 7", count)
+			}
+
+			record := func(idx int) []byte {
+				start := binary.BigEndian.Uint32(data[78+8*idx:])
+				end := uint32(len(data))
+				if idx+1 < count {
+					end = binary.BigEndian.Uint32(data[78+8*(idx+1):])
+				}
+
+				return data[start:end]
+			}
+
+			header := record(0)
+			if string(header[16:20]) != "MOBI" || string(header[16+mobiHeaderSize:16+mobiHeaderSize+4]) != "EXTH" {
+				t.Errorf("record 0 has no MOBI and EXTH headers")
+			}
+
+			if first := binary.BigEndian.Uint32(header[0x6C:]); first != 2 {
+				t.Errorf("first image record %d, expected 2", first)
+			}
+
+			for idx := 2; idx < 4; idx++ {
+				if !bytes.HasPrefix(record(idx), []byte(tt.magic)) {
+					t.Errorf("record %d does not start with %q", idx, tt.magic)
+				}
+			}
+
+			if !bytes.Equal(record(6), []byte{0xE9, 0x8E, 0x0D, 0x0A}) {
+				t.Errorf("last record is not EOF")
+			}
+		})
+	}
+}
+
+func TestAltTexts(t *testing.T) {
+	dir := t.TempDir()
+
+	sidecar := `{"001.png": "by name", "002": "by stem", "3": "by number"}`
+	if err := os.WriteFile(filepath.Join(dir, "book.json"), []byte(sidecar), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := NewOptions()
+	opts.AltText = "{dir}/{name}.json"
+
+	c := New(opts)
+	c.OnAltText = func(index int, name string) string {
+		if name == "004.png" {
+			return "by callback"
+		}
+
+		return ""
+	}
+
+	texts, err := c.altTexts(filepath.Join(dir, "book.cbz"), []string{"001.png", "002.png", "003.png", "004.png", "005.png"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"by name", "by stem", "by number", "by callback", ""}
+	if fmt.Sprint(texts) != fmt.Sprint(expected) {
+		t.Errorf("got %q, expected %q", texts, expected)
+	}
+
+	// missing sidecar is not an error
+	texts, err = c.altTexts(filepath.Join(dir, "other.cbz"), []string{"001.png"})
+	if err != nil || texts[0] != "" {
+		t.Errorf("got %q, %v", texts, err)
+	}
+}
+
+func TestCommentTemplate(t *testing.T) {
+	fileName := filepath.Join("testdata", "test.cbz")
+
+	stat, err := os.Stat(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts := NewOptions()
+	opts.NoConvert = true
+	opts.OutDir = t.TempDir()
+	opts.CommentTemplate = "{input} {hash}"
+
+	res, err := New(opts).Convert(fileName, stat)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	zr, err := zip.OpenReader(res.Output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer zr.Close()
+
+	if expected := "test.cbz " + opts.Hash(); zr.Comment != expected {
+		t.Errorf("comment %q, expected %q", zr.Comment, expected)
+	}
+}
+
+func TestCompressionRules(t *testing.T) {
+	tests := []struct {
+		rules    string
+		name     string
+		expected uint16
+	}{
+		{"", "001.jpg", zip.Store},
+		{"", "ComicInfo.xml", zip.Deflate},
+		{"jpg=deflate,*=store", "001.JPG", zip.Deflate},
+		{".xml=store", "ComicInfo.xml", zip.Store},
+		{"xml=store", "001.png", zip.Deflate},
+		{"*=store", "ComicInfo.xml", zip.Store},
+	}
+
+	for _, tt := range tests {
+		rules, err := compressionRules(tt.rules)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if method := zipMethod(rules, tt.name); method != tt.expected {
+			t.Errorf("%q: %s method %d, expected %d", tt.rules, tt.name, method, tt.expected)
+		}
+	}
+
+	for _, rules := range []string{"jpg", "jpg=zstd"} {
+		if _, err := compressionRules(rules); err == nil {
+			t.Errorf("%q: expected error", rules)
+		}
+	}
+
+	fileName := filepath.Join("testdata", "test.cbz")
+
+	stat, err := os.Stat(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts := NewOptions()
+	opts.NoConvert = true
+	opts.OutDir = t.TempDir()
+	opts.Compression = "jpg=deflate"
+
+	res, err := New(opts).Convert(fileName, stat)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	zr, err := zip.OpenReader(res.Output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer zr.Close()
+
+	for _, item := range zr.File {
+		if item.Method != zip.Deflate {
+			t.Errorf("%s method %d, expected deflate", item.Name, item.Method)
+		}
+	}
+}
+
+func TestWorkdirFiles(t *testing.T) {
+	c := New(NewOptions())
+	c.job.workdir = t.TempDir()
+
+	for _, name := range []string{"10.png", "2.png", "1.png", "ComicInfo.xml"} {
+		if err := os.WriteFile(filepath.Join(c.job.workdir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	names := func() []string {
+		files, err := c.workdirFiles()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var names []string
+		for _, file := range files {
+			names = append(names, file.Name())
+		}
+
+		return names
+	}
+
+	if got, expected := names(), []string{"1.png", "2.png", "10.png", "ComicInfo.xml"}; fmt.Sprint(got) != fmt.Sprint(expected) {
+		t.Errorf("natural order %v, expected %v", got, expected)
+	}
+
+	c.Less = func(a, b string) bool {
+		return a > b
+	}
+
+	if got, expected := names(), []string{"ComicInfo.xml", "2.png", "10.png", "1.png"}; fmt.Sprint(got) != fmt.Sprint(expected) {
+		t.Errorf("custom order %v, expected %v", got, expected)
+	}
+}
+
+func TestTarHeaders(t *testing.T) {
+	opts := NewOptions()
+	opts.Archive = "tar"
+	opts.TarNormalize = true
+	opts.TarPAX = true
+
+	c := New(opts)
+	c.job.workdir = t.TempDir()
+
+	long := strings.Repeat("страница ", 12) + "001.png"
+	for _, name := range []string{"001.png", long} {
+		if err := os.WriteFile(filepath.Join(c.job.workdir, name), []byte("data"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tarName := filepath.Join(t.TempDir(), "test.cbt")
+	if err := c.archiveSaveTar(tarName); err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := os.Open(tarName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	var names []string
+
+	tr := tar.NewReader(file)
+	for {
+		header, err := tr.Next()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+
+			t.Fatal(err)
+		}
+
+		names = append(names, header.Name)
+
+		if header.Uid != 0 || header.Gid != 0 || header.Uname != "" || header.Gname != "" || header.Mode != 0644 || !header.ModTime.Equal(reproducibleTime) {
+			t.Errorf("%s: header not normalized: %+v", header.Name, header)
+		}
+
+		if header.Name == long && header.Format != tar.FormatPAX {
+			t.Errorf("long name format %s, expected PAX", header.Format)
+		}
+	}
+
+	if expected := []string{"001.png", long}; fmt.Sprint(names) != fmt.Sprint(expected) {
+		t.Errorf("names %q, expected %q", names, expected)
+	}
+}
+
+func TestReproducible(t *testing.T) {
+	fileName := filepath.Join("testdata", "test.cbz")
+
+	stat, err := os.Stat(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var outputs [][]byte
+	for i := 0; i < 2; i++ {
+		opts := NewOptions()
+		opts.NoConvert = true
+		opts.OutDir = t.TempDir()
+		opts.Reproducible = true
+		opts.CommentTemplate = "{date}"
+
+		res, err := New(opts).Convert(fileName, stat)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		data, err := os.ReadFile(res.Output)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		outputs = append(outputs, data)
+	}
+
+	if !bytes.Equal(outputs[0], outputs[1]) {
+		t.Errorf("outputs differ")
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(outputs[0]), int64(len(outputs[0])))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, item := range zr.File {
+		if !item.Modified.Equal(reproducibleTime) || item.Mode() != 0644 {
+			t.Errorf("%s: modified %s, mode %s", item.Name, item.Modified, item.Mode())
+		}
+	}
+}
+
+func TestLogLevel(t *testing.T) {
+	for _, name := range []string{"silent", "errors", "normal", "verbose", "debug"} {
+		level, err := ParseLogLevel(strings.ToUpper(name))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if level.String() != name {
+			t.Errorf("%s parsed as %s", name, level)
+		}
+	}
+
+	if _, err := ParseLogLevel("loud"); err == nil {
+		t.Errorf("invalid level parsed")
+	}
+
+	fileName := filepath.Join("testdata", "test.cbz")
+
+	stat, err := os.Stat(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		level   LogLevel
+		want    []string
+		notWant []string
+	}{
+		{LogSilent, nil, []string{"converting", "converted", "saving"}},
+		{LogErrors, nil, []string{"converting", "converted", "saving"}},
+		{LogVerbose, []string{"converting", "converted"}, []string{"saving"}},
+		{LogDebug, []string{"converting", "converted", "saving"}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.level.String(), func(t *testing.T) {
+			opts := NewOptions()
+			opts.NoConvert = true
+			opts.OutDir = t.TempDir()
+			opts.LogLevel = tt.level
+
+			var buf bytes.Buffer
+			conv := New(opts)
+			conv.Logger = slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+			if _, err := conv.Convert(fileName, stat); err != nil {
+				t.Fatal(err)
+			}
+
+			for _, msg := range tt.want {
+				if !strings.Contains(buf.String(), "msg="+msg+" ") {
+					t.Errorf("message %q not logged", msg)
+				}
+			}
+
+			for _, msg := range tt.notWant {
+				if strings.Contains(buf.String(), "msg="+msg+" ") {
+					t.Errorf("message %q logged", msg)
+				}
+			}
+		})
+	}
+}
+
+func TestEstimate(t *testing.T) {
+	pages := make([]PageInfo, 10)
+	for i := range pages {
+		pages[i].Name = fmt.Sprintf("%02d.jpg", i)
+	}
+
+	sampled := samplePages(pages, 3)
+	if len(sampled) != 3 || sampled[0].Name != "00.jpg" || sampled[1].Name != "03.jpg" || sampled[2].Name != "06.jpg" {
+		t.Errorf("sampled pages %v", sampled)
+	}
+
+	if len(samplePages(pages, 0)) != 10 || len(samplePages(pages, 20)) != 10 {
+		t.Errorf("all pages are not sampled")
+	}
+
+	fileName := filepath.Join("testdata", "test.cbz")
+
+	stat, err := os.Stat(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts := NewOptions()
+	opts.NoConvert = true
+
+	est, err := New(opts).Estimate(fileName, stat, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the first page is sampled, the size is projected for both pages
+	if est.Pages != 2 || est.Samples != 1 || est.InputSize != stat.Size() || est.OutputSize != 2*234056 {
+		t.Errorf("estimate %+v", est)
+	}
+
+	opts = NewOptions()
+	opts.Format = "png"
+	opts.Width = 100
+
+	est, err = New(opts).Estimate(fileName, stat, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if est.Pages != 2 || est.Samples != 2 || est.OutputSize <= 0 || est.OutputSize >= est.InputSize {
+		t.Errorf("estimate %+v", est)
+	}
+}
+
+func TestImageFormatAuto(t *testing.T) {
+	flat := image.NewRGBA(image.Rect(0, 0, 64, 64))
+	for y := 0; y < 64; y++ {
+		for x := 0; x < 64; x++ {
+			if (x/8+y/8)%2 == 0 {
+				flat.Set(x, y, color.RGBA{R: 200, A: 255})
+			} else {
+				flat.Set(x, y, color.White)
+			}
+		}
+	}
+
+	photo := image.NewRGBA(image.Rect(0, 0, 64, 64))
+	for y := 0; y < 64; y++ {
+		for x := 0; x < 64; x++ {
+			photo.Set(x, y, color.RGBA{R: uint8(x * 4), G: uint8(y * 4), B: uint8(x * y), A: 255})
+		}
+	}
+
+	opts := NewOptions()
+	opts.Format = "auto"
+	conv := New(opts)
+
+	if format := conv.imageFormat(flat); format != "png" {
+		t.Errorf("flat image format %s, expected png", format)
+	}
+
+	want := "webp"
+	if encoderFor("webp") == nil {
+		want = "jpeg"
+	}
+
+	if format := conv.imageFormat(photo); format != want {
+		t.Errorf("photo format %s, expected %s", format, want)
+	}
+
+	var buf bytes.Buffer
+	if err := conv.imageEncode(flat, &buf); err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.HasPrefix(buf.Bytes(), []byte("\x89PNG")) {
+		t.Errorf("flat image is not encoded as png")
+	}
+
+	conv.Opts.Format = "png"
+	if format := conv.imageFormat(photo); format != "png" {
+		t.Errorf("format %s, expected png", format)
+	}
+}
+
+func TestGrayscaleAuto(t *testing.T) {
+	fill := func(c color.Color) image.Image {
+		img := image.NewRGBA(image.Rect(0, 0, 32, 32))
+		for y := 0; y < 32; y++ {
+			for x := 0; x < 32; x++ {
+				img.Set(x, y, c)
+			}
+		}
+
+		return img
+	}
+
+	// scanned black and white page with a slightly yellow paper tone
+	paper := fill(color.RGBA{R: 240, G: 235, B: 225, A: 255})
+	red := fill(color.RGBA{R: 255, A: 255})
+
+	if s := saturation(fill(color.Gray{Y: 128})); s != 0 {
+		t.Errorf("gray saturation %f", s)
+	}
+
+	if s := saturation(red); s != 100 {
+		t.Errorf("red saturation %f", s)
+	}
+
+	opts := NewOptions()
+	opts.GrayscaleAuto = 10
+	conv := New(opts)
+
+	if img := conv.imageTransform(paper); !isGrayScale(img) {
+		t.Errorf("low saturation page not converted to grayscale")
+	}
+
+	if img := conv.imageTransform(red); isGrayScale(img) {
+		t.Errorf("color page converted to grayscale")
+	}
+
+	conv.Opts.GrayscaleAuto = 0
+	if img := conv.imageTransform(paper); isGrayScale(img) {
+		t.Errorf("page converted to grayscale with disabled threshold")
+	}
+}
+
+func TestImageAlpha(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 4, 4))
+	img.Set(1, 1, color.NRGBA{B: 255, A: 255})
+
+	tests := []struct {
+		format     string
+		alpha      string
+		background string
+		want       color.RGBA
+	}{
+		{"png", "preserve", "", color.RGBA{}},
+		{"webp", "preserve", "", color.RGBA{}},
+		{"png", "flatten", "ff0000", color.RGBA{R: 255, A: 255}},
+		{"jpeg", "preserve", "", color.RGBA{R: 255, G: 255, B: 255, A: 255}},
+		{"bmp", "preserve", "#00ff00", color.RGBA{G: 255, A: 255}},
+	}
+
+	for _, tt := range tests {
+		opts := NewOptions()
+		opts.Alpha = tt.alpha
+		opts.Background = tt.background
+
+		i, err := New(opts).imageAlpha(img, tt.format)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got := color.RGBAModel.Convert(i.At(0, 0)); got != tt.want {
+			t.Errorf("%s %s: background %v, expected %v", tt.format, tt.alpha, got, tt.want)
+		}
+
+		if got := color.RGBAModel.Convert(i.At(1, 1)); got != (color.RGBA{B: 255, A: 255}) {
+			t.Errorf("%s %s: opaque pixel changed to %v", tt.format, tt.alpha, got)
+		}
+	}
+
+	opts := NewOptions()
+	opts.Background = "white"
+	if _, err := New(opts).imageAlpha(img, "jpeg"); err == nil {
+		t.Errorf("invalid background color accepted")
+	}
+
+	var buf bytes.Buffer
+	opts = NewOptions()
+	opts.Alpha = "flatten"
+	if err := New(opts).imageEncodeFormat(img, "png", &buf); err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := png.Decode(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !isOpaque(out) {
+		t.Errorf("flattened png has alpha")
+	}
+}
+
+func TestAnimation(t *testing.T) {
+	palette := color.Palette{color.Transparent, color.RGBA{R: 255, A: 255}, color.RGBA{B: 255, A: 255}}
+
+	g := &gif.GIF{Config: image.Config{Width: 8, Height: 8, ColorModel: palette}}
+	for idx, rect := range []image.Rectangle{image.Rect(0, 0, 8, 8), image.Rect(0, 0, 4, 4), image.Rect(4, 4, 8, 8)} {
+		frame := image.NewPaletted(rect, palette)
+		for i := range frame.Pix {
+			frame.Pix[i] = uint8(idx%2 + 1)
+		}
+
+		g.Image = append(g.Image, frame)
+		g.Delay = append(g.Delay, 10)
+		g.Disposal = append(g.Disposal, gif.DisposalNone)
+	}
+
+	var data bytes.Buffer
+	if err := gif.EncodeAll(&data, g); err != nil {
+		t.Fatal(err)
+	}
+
+	// frames are composed, partial frames are drawn over the previous ones
+	frames := gifFrames(g)
+	if len(frames) != 3 {
+		t.Fatalf("%d frames, expected 3", len(frames))
+	}
+
+	if got := color.RGBAModel.Convert(frames[1].At(6, 6)); got != (color.RGBA{R: 255, A: 255}) {
+		t.Errorf("frame 2 not composed over frame 1, pixel %v", got)
+	}
+
+	if got := color.RGBAModel.Convert(frames[2].At(1, 1)); got != (color.RGBA{B: 255, A: 255}) {
+		t.Errorf("frame 3 not composed over frame 2, pixel %v", got)
+	}
+
+	fileName := filepath.Join(t.TempDir(), "anim.cbz")
+
+	f, err := os.Create(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	zw := zip.NewWriter(f)
+	w, err := zw.Create("01.gif")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = w.Write(data.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	stat, err := os.Stat(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		animation string
+		want      []string
+	}{
+		{"first", []string{"01.png"}},
+		{"keep", []string{"01.gif"}},
+		{"expand", []string{"01_001.png", "01_002.png", "01_003.png"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.animation, func(t *testing.T) {
+			opts := NewOptions()
+			opts.Format = "png"
+			opts.Animation = tt.animation
+			opts.OutDir = t.TempDir()
+
+			res, err := New(opts).Convert(fileName, stat)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			zr, err := zip.OpenReader(res.Output)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer zr.Close()
+
+			var names []string
+			for _, item := range zr.File {
+				names = append(names, item.Name)
+			}
+
+			if strings.Join(names, " ") != strings.Join(tt.want, " ") {
+				t.Errorf("entries %v, expected %v", names, tt.want)
+			}
+
+			if tt.animation == "keep" {
+				rc, err := zr.File[0].Open()
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer rc.Close()
+
+				kept, err := io.ReadAll(rc)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if !bytes.Equal(kept, data.Bytes()) {
+					t.Errorf("animated image modified")
+				}
+			}
+		})
+	}
+}
+
+func TestImageMetadata(t *testing.T) {
+	// little endian TIFF header and IFD with orientation 6 (rotated 90 degrees clockwise)
+	exif := []byte("II*\x00\x08\x00\x00\x00\x01\x00\x12\x01\x03\x00\x01\x00\x00\x00\x06\x00\x00\x00\x00\x00\x00\x00")
+	xmp := []byte(`<x:xmpmeta xmlns:x="adobe:ns:meta/"></x:xmpmeta>`)
+
+	if orientation, _ := exifOrientation(exif); orientation != 6 {
+		t.Fatalf("orientation %d, expected 6", orientation)
+	}
+
+	// left half is red, right half is blue
+	img := image.NewRGBA(image.Rect(0, 0, 20, 10))
+	for y := 0; y < 10; y++ {
+		for x := 0; x < 20; x++ {
+			if x < 10 {
+				img.Set(x, y, color.RGBA{R: 255, A: 255})
+			} else {
+				img.Set(x, y, color.RGBA{B: 255, A: 255})
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+
+	data := writeMeta(buf.Bytes(), "png", imageMeta{exif: exif, xmp: xmp}, img)
+	if meta := readMeta(data); !bytes.Equal(meta.exif, exif) || !bytes.Equal(meta.xmp, xmp) {
+		t.Fatalf("metadata not written")
+	}
+
+	tests := []struct {
+		policy      string
+		size        image.Point
+		orientation int
+	}{
+		{"strip", image.Pt(20, 10), 0},
+		{"orientation", image.Pt(10, 20), 0},
+		{"keep", image.Pt(10, 20), 1},
+	}
+
+	for _, tt := range tests {
+		opts := NewOptions()
+		opts.Metadata = tt.policy
+		conv := New(opts)
+
+		i, err := conv.imageDecode(bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if size := i.Bounds().Size(); size != tt.size {
+			t.Errorf("%s: size %v, expected %v", tt.policy, size, tt.size)
+		}
+
+		// the left half is on top after rotation
+		if got := color.RGBAModel.Convert(i.At(5, 5)); got != (color.RGBA{R: 255, A: 255}) {
+			t.Errorf("%s: top left pixel %v", tt.policy, got)
+		}
+
+		var out bytes.Buffer
+		if err = conv.imageEncodeFormat(i, "png", &out); err != nil {
+			t.Fatal(err)
+		}
+
+		meta := readMeta(out.Bytes())
+		if orientation, _ := exifOrientation(meta.exif); orientation != tt.orientation {
+			t.Errorf("%s: orientation %d, expected %d", tt.policy, orientation, tt.orientation)
+		}
+
+		if keep := tt.policy == "keep"; keep != bytes.Equal(meta.xmp, xmp) {
+			t.Errorf("%s: xmp %q", tt.policy, meta.xmp)
+		}
+	}
+}
+
+func TestReviewSample(t *testing.T) {
+	fileName := filepath.Join("testdata", "test.cbz")
+
+	stat, err := os.Stat(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	zr, err := zip.OpenReader(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer zr.Close()
+
+	original := make(map[string][]byte)
+	for _, item := range zr.File {
+		rc, err := item.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		original[item.Name], err = io.ReadAll(rc)
+		_ = rc.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, sample := range []int{1, 5} {
+		opts := NewOptions()
+		opts.Format = "png"
+		opts.Width = 100
+		opts.OutDir = t.TempDir()
+		opts.SampleDir = t.TempDir()
+		opts.Sample = sample
+
+		if _, err := New(opts).Convert(fileName, stat); err != nil {
+			t.Fatal(err)
+		}
+
+		dir := filepath.Join(opts.SampleDir, "test")
+
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// pairs for every page if there are fewer pages than samples
+		if want := 2 * min(sample, len(original)); len(entries) != want {
+			t.Errorf("sample %d: %d files, expected %d", sample, len(entries), want)
+		}
+
+		for _, entry := range entries {
+			data, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			base, kind, _ := strings.Cut(baseNoExt(entry.Name()), "_")
+			switch kind {
+			case "before":
+				if !bytes.Equal(data, original[base+".jpg"]) {
+					t.Errorf("%s differs from the original page", entry.Name())
+				}
+			case "after":
+				img, err := png.Decode(bytes.NewReader(data))
+				if err != nil {
+					t.Fatalf("%s: %v", entry.Name(), err)
+				}
+
+				if img.Bounds().Dx() != 100 {
+					t.Errorf("%s is not the converted page", entry.Name())
+				}
+			default:
+				t.Errorf("unexpected file %s", entry.Name())
+			}
+		}
+	}
+}
+
+func TestStats(t *testing.T) {
+	fileName := filepath.Join("testdata", "test.cbz")
+
+	stat, err := os.Stat(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts := NewOptions()
+	opts.Format = "png"
+	opts.Width = 100
+	opts.OutDir = t.TempDir()
+
+	conv := New(opts)
+	if s := conv.Stats(); s != (Stats{}) {
+		t.Errorf("stats before conversion %+v", s)
+	}
+
+	res, err := conv.Convert(fileName, stat)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := os.Stat(res.Output)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := conv.Stats()
+	if s.Files != 1 || s.Pages != 2 || s.Fallbacks != 0 || s.BytesIn != stat.Size() || s.BytesOut != out.Size() {
+		t.Errorf("stats %+v", s)
+	}
+
+	if s.Decode <= 0 || s.Encode <= 0 || s.Save <= 0 || s.Elapsed < s.Save || s.PagesPerSecond() <= 0 {
+		t.Errorf("stage timings %+v", s)
+	}
+
+	var buf bytes.Buffer
+	if err = s.WritePrometheus(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, line := range []string{
+		"# TYPE cbconvert_files_total counter\ncbconvert_files_total 1\n",
+		"cbconvert_pages_total 2\n",
+		fmt.Sprintf("cbconvert_written_bytes_total %g\n", float64(out.Size())),
+		"\ncbconvert_stage_seconds_total{stage=\"encode\"} ",
+		"# TYPE cbconvert_pages_per_second gauge\n",
+	} {
+		if !strings.Contains(buf.String(), line) {
+			t.Errorf("metrics do not contain %q", line)
+		}
+	}
+
+	if n := strings.Count(buf.String(), "# TYPE cbconvert_stage_seconds_total"); n != 1 {
+		t.Errorf("stage metric type written %d times", n)
+	}
+}
+
+func TestOptionsRuntime(t *testing.T) {
+	// fields that affect the converted output, every other field must be copied by runtime
+	output := []string{"Format", "Fallback", "Archive", "RightToLeft", "Quality", "AdaptiveQuality", "QualityMin", "QualityMax",
+		"TargetSize", "Width", "Height", "Fit", "AutoWidth", "Filter", "Resizer", "NoCover", "NoRGB", "NoNonImage", "NoComicInfo",
+		"Reproducible", "TarNormalize", "TarPAX", "Compression", "ZipLevel", "NoConvert", "ComicInfo", "ComicInfoName", "Fetch",
+		"Suffix", "EmbedOptions", "Grayscale", "GrayscaleAuto", "GrayDepth", "Gamma", "Alpha", "Background", "Metadata", "Salvage",
+		"Animation", "TileHeight", "Rotate", "Brightness", "Contrast", "AltText"}
+
+	var src Options
+	v := reflect.ValueOf(&src).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		switch f := v.Field(i); f.Kind() {
+		case reflect.Bool:
+			f.SetBool(true)
+		case reflect.Int, reflect.Int64:
+			f.SetInt(1)
+		case reflect.Float64:
+			f.SetFloat(1)
+		case reflect.String:
+			f.SetString("x")
+		default:
+			t.Fatalf("%s: unsupported kind %s", v.Type().Field(i).Name, f.Kind())
+		}
+	}
+
+	var dst Options
+	dst.runtime(src)
+
+	d := reflect.ValueOf(dst)
+	for i := 0; i < d.NumField(); i++ {
+		name := d.Type().Field(i).Name
+		copied := !d.Field(i).IsZero()
+
+		if copied && slices.Contains(output, name) {
+			t.Errorf("output option %s is copied by runtime", name)
+		}
+
+		if !copied && !slices.Contains(output, name) {
+			t.Errorf("option %s is neither copied by runtime nor listed as output option", name)
+		}
+	}
+
+	opts := NewOptions()
+	hash := opts.Hash()
+
+	opts.OutDir = t.TempDir()
+	opts.Workers = 4
+	opts.Password = "secret"
+	if opts.Hash() != hash {
+		t.Errorf("hash changed by runtime options")
+	}
+
+	preset := NewOptions()
+	preset.Format = "png"
+	preset.OutDir = "preset"
+
+	if got := opts.Preset(preset); got.Format != "png" || got.OutDir != opts.OutDir || got.Password != "secret" {
+		t.Errorf("preset options %+v", got)
+	}
+
+	if data, _ := json.Marshal(opts.output()); bytes.Contains(data, []byte("secret")) {
+		t.Errorf("password in output options")
+	}
+}
+
+func TestReadOptions(t *testing.T) {
+	fileName := filepath.Join("testdata", "test.cbz")
+
+	stat, err := os.Stat(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts := NewOptions()
+	opts.NoConvert = true
+	opts.Format = "webp"
+	opts.Grayscale = true
+	opts.OutDir = t.TempDir()
+	opts.Password = "secret"
+	opts.EmbedOptions = true
+
+	conv := New(opts)
+
+	res, err := conv.Convert(fileName, stat)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	preset, err := conv.ReadOptions(res.Output)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(preset, opts.output()) {
+		t.Errorf("read options %+v, expected %+v", preset, opts.output())
+	}
+
+	if preset.Hash() != opts.Hash() {
+		t.Errorf("hash of read options differs")
+	}
+
+	if _, err := conv.ReadOptions(fileName); err == nil {
+		t.Errorf("options read from archive without %s", optionsName)
+	}
+}
+
+func TestTargetQuality(t *testing.T) {
+	// encoded size grows with quality, 100 bytes per quality step
+	codecs["lossy"] = &codec{encode: func(w io.Writer, _ image.Image, quality int) error {
+		_, err := w.Write(make([]byte, quality*100))
+
+		return err
+	}}
+	t.Cleanup(func() {
+		delete(codecs, "lossy")
+	})
+
+	fileName := filepath.Join("testdata", "test.cbz")
+
+	stat, err := os.Stat(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		format     string
+		targetSize int
+		adaptive   bool
+		want       int
+	}{
+		{"lossy", 5, false, 51},
+		{"lossy", 1, false, targetMinQuality},
+		{"lossy", 100, false, 100},
+		{"lossy", 5, true, 75},
+		{"png", 5, false, 75},
+	}
+
+	for _, tt := range tests {
+		opts := NewOptions()
+		opts.Format = tt.format
+		opts.Width = 100
+		opts.TargetSize = tt.targetSize
+		opts.AdaptiveQuality = tt.adaptive
+		conv := New(opts)
+
+		quality, err := conv.targetQuality(fileName, stat)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if quality != tt.want {
+			t.Errorf("%s %d KB: quality %d, expected %d", tt.format, tt.targetSize, quality, tt.want)
+		}
+
+		if conv.Opts.Quality != 75 {
+			t.Errorf("quality option changed to %d", conv.Opts.Quality)
+		}
+	}
+}
+
+func TestAdaptiveQuality(t *testing.T) {
+	flat := image.NewGray(image.Rect(0, 0, 64, 64))
+	for i := range flat.Pix {
+		flat.Pix[i] = 255
+	}
+
+	// checkerboard of single pixels, the highest detail
+	detailed := image.NewGray(image.Rect(0, 0, 64, 64))
+	for y := 0; y < 64; y++ {
+		for x := 0; x < 64; x++ {
+			if (x+y)%2 == 0 {
+				detailed.SetGray(x, y, color.Gray{Y: 255})
+			}
+		}
+	}
+
+	if c := complexity(flat); c != 0 {
+		t.Errorf("flat image complexity %f", c)
+	}
+
+	if c := complexity(detailed); c != 1 {
+		t.Errorf("detailed image complexity %f", c)
+	}
+
+	var quality int
+	codecs["lossy"] = &codec{encode: func(_ io.Writer, _ image.Image, q int) error {
+		quality = q
+
+		return nil
+	}}
+	t.Cleanup(func() {
+		delete(codecs, "lossy")
+	})
+
+	tests := []struct {
+		img      image.Image
+		adaptive bool
+		min, max int
+		want     int
+	}{
+		{flat, true, 40, 90, 40},
+		{detailed, true, 40, 90, 90},
+		{detailed, true, 90, 40, 90},
+		{detailed, false, 40, 90, 75},
+	}
+
+	for _, tt := range tests {
+		opts := NewOptions()
+		opts.AdaptiveQuality = tt.adaptive
+		opts.QualityMin = tt.min
+		opts.QualityMax = tt.max
+
+		if err := New(opts).imageEncodeFormat(tt.img, "lossy", io.Discard); err != nil {
+			t.Fatal(err)
+		}
+
+		if quality != tt.want {
+			t.Errorf("adaptive %v, %d-%d: quality %d, expected %d", tt.adaptive, tt.min, tt.max, quality, tt.want)
+		}
+	}
+}
+
+func TestMmap(t *testing.T) {
+	fileName := filepath.Join("testdata", "test.cbz")
+
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mapped, unmap, err := mmapFile(fileName)
+	if err != nil {
+		t.Skipf("mmap not supported: %v", err)
+	}
+
+	if !bytes.Equal(mapped, data) {
+		t.Errorf("mapped data differs from the file")
+	}
+
+	if err = unmap(); err != nil {
+		t.Error(err)
+	}
+
+	empty := filepath.Join(t.TempDir(), "empty.cbz")
+	if err = os.WriteFile(empty, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err = mmapFile(empty); err == nil {
+		t.Errorf("empty file mapped")
+	}
+
+	stat, err := os.Stat(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var outputs [][]byte
+	for _, mmap := range []bool{false, true} {
+		opts := NewOptions()
+		opts.NoConvert = true
+		opts.Reproducible = true
+		opts.OutDir = t.TempDir()
+		opts.Mmap = mmap
+		conv := New(opts)
+
+		archive, err := conv.archiveOpen(fileName)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if mapped := archive.unmap != nil; mapped != mmap {
+			t.Errorf("mmap %v: archive mapped %v", mmap, mapped)
+		}
+
+		if err = archive.Close(); err != nil {
+			t.Error(err)
+		}
+
+		res, err := conv.Convert(fileName, stat)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		out, err := os.ReadFile(res.Output)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		outputs = append(outputs, out)
+	}
+
+	if !bytes.Equal(outputs[0], outputs[1]) {
+		t.Errorf("output of memory-mapped archive differs")
+	}
+}
+
+func TestCache(t *testing.T) {
+	dir := t.TempDir()
+
+	var files []string
+	for i := 0; i < cacheSize+1; i++ {
+		fileName := filepath.Join(dir, fmt.Sprintf("%d.cbz", i))
+		if err := os.WriteFile(fileName, []byte{byte(i)}, 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		files = append(files, fileName)
+	}
+
+	var c cache
+	for i, fileName := range files {
+		c.setContents(fileName, []string{strconv.Itoa(i)})
+	}
+
+	// the oldest file is evicted
+	if contents := c.contents(files[0]); contents != nil {
+		t.Errorf("evicted file cached %v", contents)
+	}
+
+	if contents := c.contents(files[1]); len(contents) != 1 || contents[0] != "1" {
+		t.Errorf("contents %v", contents)
+	}
+
+	// modified file is a new entry
+	if err := os.WriteFile(files[1], []byte("modified"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if contents := c.contents(files[1]); contents != nil {
+		t.Errorf("modified file cached %v", contents)
+	}
+
+	c.setContents(dir, []string{"dir"})
+	if contents := c.contents(dir); contents != nil {
+		t.Errorf("directory cached %v", contents)
+	}
+
+	cover := image.NewGray(image.Rect(0, 0, 1, 1))
+	c.setCover(files[2], "strip/", cover)
+	if c.cover(files[2], "strip/") != cover || c.cover(files[2], "keep/") != nil {
+		t.Errorf("cover is not cached by policy")
+	}
+
+	fileName := filepath.Join(dir, "test.cbz")
+
+	data, err := os.ReadFile(filepath.Join("testdata", "test.cbz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = os.WriteFile(fileName, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	stat, err := os.Stat(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conv := New(NewOptions())
+
+	first, err := conv.coverImage(fileName, stat)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Cover, Thumbnail and Convert of the same file reuse the decoded cover
+	if second, err := conv.coverImage(fileName, stat); err != nil || second != first {
+		t.Errorf("cover decoded again")
+	}
+
+	conv.Opts.CoverPage = "auto"
+	if other, err := conv.coverImage(fileName, stat); err != nil || other == first {
+		t.Errorf("cover reused with other cover page")
+	}
+
+	conv.Opts.CoverPage = ""
+	conv.Opts.Salvage = true
+	if other, err := conv.coverImage(fileName, stat); err != nil || other == first {
+		t.Errorf("cover reused with other decode options")
+	}
+
+	// limits are checked for the cached contents too
+	conv.Opts.Salvage = false
+	if _, err := conv.archiveList(fileName); err != nil {
+		t.Fatal(err)
+	}
+
+	conv.Opts.MaxEntries = 1
+	if _, err := conv.archiveList(fileName); !errors.Is(err, ErrLimit) {
+		t.Errorf("cached contents: got %v, want ErrLimit", err)
+	}
+
+	conv.Opts.MaxEntries = NewOptions().MaxEntries
+	if err = os.Chtimes(fileName, time.Now(), stat.ModTime().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	if other, err := conv.coverImage(fileName, stat); err != nil || other == first {
+		t.Errorf("cover of modified file reused")
+	}
+}
+
+func TestExcludedFormat(t *testing.T) {
+	webp, ok := codecs["webp"]
+	if !ok {
+		t.Skip("built with nowebp")
+	}
+
+	// formats excluded with build tags are not registered
+	delete(codecs, "webp")
+	t.Cleanup(func() {
+		codecs["webp"] = webp
+	})
+
+	for _, f := range SupportedFormats() {
+		if f.Name == "webp" {
+			t.Errorf("excluded format is supported")
+		}
+	}
+
+	photo := image.NewRGBA(image.Rect(0, 0, 64, 64))
+	for y := 0; y < 64; y++ {
+		for x := 0; x < 64; x++ {
+			photo.Set(x, y, color.RGBA{R: uint8(x * 4), G: uint8(y * 4), B: uint8(x * y), A: 255})
+		}
+	}
+
+	opts := NewOptions()
+	opts.Format = "auto"
+	conv := New(opts)
+
+	if format := conv.imageFormat(photo); format != "jpeg" {
+		t.Errorf("auto format %s, expected jpeg", format)
+	}
+
+	err := conv.imageEncodeFormat(photo, "webp", io.Discard)
+	if err == nil || !strings.Contains(err.Error(), "unsupported format") {
+		t.Errorf("excluded format encoded, error %v", err)
+	}
+}
+
+func TestSupportedFormats(t *testing.T) {
+	formats := SupportedFormats()
+	if len(formats) == 0 || formats[len(formats)-1].Name != "auto" {
+		t.Fatalf("auto format is not last in %v", formats)
+	}
+
+	names := make(map[string]Codec)
+	for _, f := range formats {
+		if !f.Encode {
+			t.Errorf("%s can not be encoded", f.Name)
+		}
+
+		names[f.Name] = f
+	}
+
+	for _, name := range []string{"jpeg", "png", "tiff", "bmp"} {
+		if _, ok := names[name]; !ok {
+			t.Errorf("format %s is not supported", name)
+		}
+	}
+
+	if _, ok := names["gif"]; ok {
+		t.Errorf("gif is supported as output format")
+	}
+
+	if png := names["png"]; png.Module != "std" || png.Version != runtime.Version() || !slices.Equal(png.Extensions, []string{".png"}) {
+		t.Errorf("png codec %+v", png)
+	}
+
+	inputs := make(map[string]Codec)
+	for _, in := range SupportedInputs() {
+		if !in.Decode {
+			t.Errorf("input %s can not be decoded", in.Name)
+		}
+
+		inputs[in.Name] = in
+	}
+
+	if gif, ok := inputs["gif"]; !ok || gif.Encode {
+		t.Errorf("gif input %+v", gif)
+	}
+
+	if archive := inputs["archive"]; !slices.Contains(archive.Extensions, ".cbz") || !slices.Contains(archive.Extensions, ".cbr") {
+		t.Errorf("archive input %+v", archive)
+	}
+
+	if _, ok := inputs["document"]; ok != documents {
+		t.Errorf("document input %v, documents %v", ok, documents)
+	}
+
+	// returned extensions are copies
+	inputs["jpeg"].Extensions[0] = ".modified"
+	if codecs["jpeg"].extensions[0] != ".jpg" {
+		t.Errorf("codec extensions modified")
+	}
+}
+
+func TestFallbackFormats(t *testing.T) {
+	opts := NewOptions()
+	opts.Fallback = " WEBP, png ,auto,,jpeg"
+	conv := New(opts)
+
+	if got := conv.fallbackFormats("png"); !slices.Equal(got, []string{"webp", "jpeg", ""}) {
+		t.Errorf("fallback formats %q", got)
+	}
+
+	codecs["broken"] = &codec{maxSize: 100, encode: func(io.Writer, image.Image, int) error {
+		return errors.New("broken encoder")
+	}}
+	t.Cleanup(func() {
+		delete(codecs, "broken")
+	})
+
+	img := image.NewGray(image.Rect(0, 0, 8, 8))
+
+	for _, fallback := range []string{"", "broken,png"} {
+		opts := NewOptions()
+		opts.Fallback = fallback
+		conv := New(opts)
+		conv.job.workdir = t.TempDir()
+
+		err := conv.imageWrite(img, "broken", 1, "page.jpg")
+		if fallback == "" {
+			if err == nil {
+				t.Errorf("page written without fallback")
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := os.Stat(filepath.Join(conv.job.workdir, "page.png")); err != nil {
+			t.Errorf("page not written with fallback format: %v", err)
+		}
+
+		if n := conv.Stats().Fallbacks; n != 1 {
+			t.Errorf("%d fallbacks, expected 1", n)
+		}
+
+		// pages are split for the smallest limit of the format and fallback formats
+		conv.Opts.Fallback = "png,broken"
+		if height := conv.tileHeight("png"); height != 100 {
+			t.Errorf("tile height %d, expected 100", height)
+		}
+	}
+}
+
+func TestSalvage(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 64, 256))
+	for y := 0; y < 256; y++ {
+		for x := 0; x < 64; x++ {
+			img.Set(x, y, color.RGBA{R: uint8(x * 4), G: uint8(y), B: uint8(x ^ y), A: 255})
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 90}); err != nil {
+		t.Fatal(err)
+	}
+
+	// download interrupted in the middle of the scan
+	data := buf.Bytes()[:buf.Len()/2]
+
+	opts := NewOptions()
+	if _, err := New(opts).imageDecode(bytes.NewReader(data)); err == nil {
+		t.Fatalf("truncated image decoded without salvage")
+	}
+
+	opts.Salvage = true
+	salvaged, err := New(opts).imageDecode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if salvaged.Bounds() != img.Bounds() {
+		t.Fatalf("salvaged image bounds %v", salvaged.Bounds())
+	}
+
+	// decoded rows are kept, the missing rows are gray
+	r, g, _, _ := salvaged.At(60, 2).RGBA()
+	if r>>8 < 220 || g>>8 > 20 {
+		t.Errorf("decoded row not kept, pixel %v", salvaged.At(60, 2))
+	}
+
+	if got := color.RGBAModel.Convert(salvaged.At(0, 255)); got != (color.RGBA{R: 0x80, G: 0x80, B: 0x80, A: 0xff}) {
+		t.Errorf("missing row pixel %v, expected gray", got)
+	}
+
+	if _, err := salvageJPEG([]byte("GIF89a")); err == nil {
+		t.Errorf("salvaged image that is not a JPEG")
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	slow := func() (int, error) {
+		time.Sleep(200 * time.Millisecond)
+
+		return 1, nil
+	}
+
+	fast := func() (int, error) {
+		return 2, nil
+	}
+
+	if v, err := pageTimeout(context.Background(), 0, "1", slow); err != nil || v != 1 {
+		t.Errorf("without timeout: %d, %v", v, err)
+	}
+
+	if v, err := pageTimeout(context.Background(), time.Second, "2", fast); err != nil || v != 2 {
+		t.Errorf("fast page: %d, %v", v, err)
+	}
+
+	if _, err := pageTimeout(context.Background(), 10*time.Millisecond, "3", slow); !errors.Is(err, ErrTimeout) {
+		t.Errorf("page timeout: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	if _, err := pageTimeout(ctx, 0, "4", slow); !errors.Is(err, ErrTimeout) {
+		t.Errorf("file deadline: %v", err)
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := pageTimeout(ctx, time.Second, "5", slow); !errors.Is(err, context.Canceled) || errors.Is(err, ErrTimeout) {
+		t.Errorf("canceled: %v", err)
+	}
+
+	// document is closed after the abandoned render returns
+	rendered := make(chan struct{})
+	doc := closingDocument{closed: make(chan struct{})}
+	closeDocument(doc, rendered)
+
+	select {
+	case <-doc.closed:
+		t.Errorf("document closed while rendering")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	close(rendered)
+
+	select {
+	case <-doc.closed:
+	case <-time.After(time.Second):
+		t.Errorf("document not closed after rendering")
+	}
+
+	fileName := filepath.Join("testdata", "test.cbz")
+
+	stat, err := os.Stat(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, page := range []bool{true, false} {
+		opts := NewOptions()
+		opts.Format = "png"
+		opts.OutDir = t.TempDir()
+		if page {
+			opts.PageTimeout = time.Nanosecond
+		} else {
+			opts.FileTimeout = time.Nanosecond
+		}
+
+		if _, err := New(opts).Convert(fileName, stat); !errors.Is(err, ErrTimeout) {
+			t.Errorf("page %v: error %v, expected timeout", page, err)
+		}
+
+		if entries, _ := os.ReadDir(opts.OutDir); len(entries) != 0 {
+			t.Errorf("page %v: output written after timeout", page)
+		}
+	}
+}
+
+// pagesDocument type, document with pre-rendered pages.
+type pagesDocument []image.Image
+
+func (d pagesDocument) NumPage() int                     { return len(d) }
+func (d pagesDocument) Image(n int) (image.Image, error) { return d[n], nil }
+func (d pagesDocument) Size(n int) (image.Point, error)  { return d[n].Bounds().Size(), nil }
+func (d pagesDocument) ToC() ([]outline, error)          { return nil, nil }
+func (d pagesDocument) Close() error                     { return nil }
+
+// closingDocument type, document that reports when it is closed.
+type closingDocument struct {
+	pagesDocument
+	closed chan struct{}
+}
+
+func (d closingDocument) Close() error {
+	close(d.closed)
+
+	return nil
+}
+
+func TestCoverPage(t *testing.T) {
+	page := func(lines int) image.Image {
+		img := image.NewGray(image.Rect(0, 0, 200, 300))
+		for i := range img.Pix {
+			img.Pix[i] = 250
+		}
+
+		// lines of text, 2 pixels tall
+		for l := 0; l < lines; l++ {
+			for x := 20; x < 180; x++ {
+				img.SetGray(x, 10+l*4, color.Gray{})
+				img.SetGray(x, 11+l*4, color.Gray{})
+			}
+		}
+
+		return img
+	}
+
+	blank, legal, content := page(0), page(2), page(60)
+
+	if !isBlank(blank) || !isBlank(legal) || isBlank(content) {
+		t.Fatalf("blank pages not detected")
+	}
+
+	doc := pagesDocument{blank, legal, content}
+
+	tests := []struct {
+		coverPage string
+		doc       pagesDocument
+		want      image.Image
+	}{
+		{"", doc, blank},
+		{"auto", doc, content},
+		{"2", doc, legal},
+		{"auto", pagesDocument{legal, blank}, legal},
+	}
+
+	for _, tt := range tests {
+		opts := NewOptions()
+		opts.CoverPage = tt.coverPage
+
+		img, err := New(opts).coverDocumentPage(tt.doc)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if img != tt.want {
+			t.Errorf("cover page %q: wrong page", tt.coverPage)
+		}
+	}
+
+	for _, coverPage := range []string{"0", "4", "first"} {
+		opts := NewOptions()
+		opts.CoverPage = coverPage
+
+		if _, err := New(opts).coverDocumentPage(doc); err == nil {
+			t.Errorf("invalid cover page %q accepted", coverPage)
+		}
+	}
+}
+
+func TestCoverThumbnail(t *testing.T) {
+	fileName := filepath.Join("testdata", "test.cbz")
+
+	stat, err := os.Stat(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts := NewOptions()
+	opts.Format = "png"
+	opts.Width = 600
+	opts.OutDir = t.TempDir()
+
+	if err = New(opts).CoverThumbnail(fileName, stat); err != nil {
+		t.Fatal(err)
+	}
+
+	size := func(name string) (int, []byte) {
+		data, err := os.ReadFile(filepath.Join(opts.OutDir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		config, err := png.DecodeConfig(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		return config.Width, data
+	}
+
+	if width, _ := size("test.png"); width != 600 {
+		t.Errorf("cover width %d, expected 600", width)
+	}
+
+	uri := "file://" + fileName
+	width, data := size(fmt.Sprintf("%x.png", md5.Sum([]byte(uri))))
+	if width != 256 {
+		t.Errorf("thumbnail width %d, expected 256", width)
+	}
+
+	if n := bytes.Count(data, []byte("\x89PNG")); n != 1 {
+		t.Errorf("thumbnail file has %d images", n)
+	}
+
+	for _, text := range []string{"Thumb::URI\x00" + uri, "Thumb::MTime\x00" + strconv.FormatInt(stat.ModTime().Unix(), 10)} {
+		if !bytes.Contains(data, []byte(text)) {
+			t.Errorf("thumbnail has no %q", text)
+		}
+	}
+}
+
+func TestDeleteOriginal(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("XDG trash")
+	}
+
+	data, err := os.ReadFile(filepath.Join("testdata", "test.cbz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_DATA_HOME", filepath.Join(home, "data"))
+
+	trashDir := filepath.Join(home, "data", "Trash")
+
+	for _, permanent := range []bool{false, false, true} {
+		fileName := filepath.Join(t.TempDir(), "test.cbz")
+		if err := os.WriteFile(fileName, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		stat, err := os.Stat(fileName)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		opts := NewOptions()
+		opts.NoConvert = true
+		opts.OutDir = t.TempDir()
+		opts.DeleteOriginal = true
+		opts.Permanent = permanent
+		conv := New(opts)
+
+		res, err := conv.Convert(fileName, stat)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := os.Stat(res.Output); err != nil {
+			t.Errorf("output: %v", err)
+		}
+
+		if _, err := os.Stat(fileName); !os.IsNotExist(err) {
+			t.Errorf("original is not removed: %v", err)
+		}
+	}
+
+	// same name is trashed twice, permanent delete does not use the trash
+	for _, name := range []string{"test.cbz", "test.2.cbz"} {
+		b, err := os.ReadFile(filepath.Join(trashDir, "files", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(b, data) {
+			t.Errorf("%s: content differs", name)
+		}
+
+		info, err := os.ReadFile(filepath.Join(trashDir, "info", name+".trashinfo"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !strings.HasPrefix(string(info), "[Trash Info]\nPath=/") || !strings.Contains(string(info), "/test.cbz\nDeletionDate=") {
+			t.Errorf("%s: info %q", name, info)
+		}
+	}
+
+	if entries, _ := os.ReadDir(filepath.Join(trashDir, "files")); len(entries) != 2 {
+		t.Errorf("trash: got %d files, want 2", len(entries))
+	}
+}
+
+func TestOverwrite(t *testing.T) {
+	fileName := filepath.Join("testdata", "test.cbz")
+
+	stat, err := os.Stat(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	marker := []byte("existing")
+
+	tests := []struct {
+		policy string
+		output string
+		kept   bool
+	}{
+		{"overwrite", "test_x.cbz", false},
+		{"skip", "", true},
+		{"rename", "test_x.2.cbz", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.policy, func(t *testing.T) {
+			opts := NewOptions()
+			opts.NoConvert = true
+			opts.OutDir = t.TempDir()
+			opts.Suffix = "_x"
+			opts.Overwrite = tt.policy
+			conv := New(opts)
+
+			existing := conv.OutputName(fileName)
+			if existing != filepath.Join(opts.OutDir, "test_x.cbz") {
+				t.Fatalf("output name %s", existing)
+			}
+
+			if err := os.WriteFile(existing, marker, 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			res, err := conv.Convert(fileName, stat)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			want := ""
+			if tt.output != "" {
+				want = filepath.Join(opts.OutDir, tt.output)
+			}
+
+			if res.Output != want {
+				t.Errorf("output: got %q, want %q", res.Output, want)
+			}
+
+			b, err := os.ReadFile(existing)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if bytes.Equal(b, marker) != tt.kept {
+				t.Errorf("existing output kept: got %v, want %v", !tt.kept, tt.kept)
+			}
+
+			if res.Output != "" {
+				zr, err := zip.OpenReader(res.Output)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				_ = zr.Close()
+			}
+		})
+	}
+}
+
+func TestETA(t *testing.T) {
+	var r rate
+	if perSecond, count := r.perSecond(); perSecond != 0 || count != 0 {
+		t.Errorf("empty rate: %v, %d", perSecond, count)
+	}
+
+	// 10 pages per second, only the recent pages are used after the window is full
+	start := time.Now()
+	for i := range rateWindow + 8 {
+		step := 100 * time.Millisecond
+		if i < 8 {
+			step = time.Second
+		}
+
+		r.times[r.count%rateWindow] = start.Add(time.Duration(i) * step)
+		r.count++
+	}
+
+	perSecond, count := r.perSecond()
+	if count != rateWindow+8 {
+		t.Errorf("count: got %d, want %d", count, rateWindow+8)
+	}
+
+	if perSecond < 9.99 || perSecond > 10.01 {
+		t.Errorf("rate: got %v, want 10", perSecond)
+	}
+
+	// 40 pages converted, 10 pages of the current file (second of four files) remain
+	job := &Job{Nfiles: 4, CurrFile: 2, Ncontents: 30, CurrContent: 20, rate: &r}
+
+	eta := job.ETA()
+	if eta.File != time.Second {
+		t.Errorf("file: got %v, want 1s", eta.File)
+	}
+
+	// average is 25 pages per file
+	if eta.Batch < 5990*time.Millisecond || eta.Batch > 6010*time.Millisecond {
+		t.Errorf("batch: got %v, want 6s", eta.Batch)
+	}
+
+	if eta := (&Job{}).ETA(); eta != (ETA{}) {
+		t.Errorf("job without rate: %+v", eta)
+	}
+
+	fileName := filepath.Join("testdata", "test.cbz")
+
+	stat, err := os.Stat(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts := NewOptions()
+	opts.Format = "png"
+	opts.OutDir = t.TempDir()
+	conv := New(opts)
+
+	if _, err := conv.Convert(fileName, stat); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, count := conv.shared.rate.perSecond(); count != 2 {
+		t.Errorf("converted pages: got %d, want 2", count)
+	}
+}
+
+func TestCheckpoint(t *testing.T) {
+	t.Setenv("TMPDIR", t.TempDir())
+
+	fileName := filepath.Join("testdata", "test.cbz")
+
+	stat, err := os.Stat(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts := NewOptions()
+	opts.Format = "png"
+	opts.OutDir = t.TempDir()
+	opts.Workers = 1
+	opts.Checkpoint = true
+
+	conv := New(opts)
+	conv.OnProgress = func(*Job) {
+		conv.Cancel()
+	}
+
+	res, err := conv.Convert(fileName, stat)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("got %v, want canceled", err)
+	}
+
+	data, err := os.ReadFile(res.Workdir + ".json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var cf checkpointFile
+	if err := json.Unmarshal(data, &cf); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(cf.Pages) != 1 || cf.Input != fileName {
+		t.Fatalf("checkpoint: %+v", cf)
+	}
+
+	if _, err := os.Stat(filepath.Join(res.Workdir, strings.TrimSuffix(cf.Pages[0], ".jpg")+".png")); err != nil {
+		t.Errorf("converted page is not kept: %v", err)
+	}
+
+	// only the remaining page is converted
+	conv = New(opts)
+
+	res, err = conv.Convert(fileName, stat)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, count := conv.shared.rate.perSecond(); count != 1 {
+		t.Errorf("converted pages: got %d, want 1", count)
+	}
+
+	zr, err := zip.OpenReader(res.Output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer zr.Close()
+
+	if len(zr.File) != 2 {
+		t.Errorf("output pages: got %d, want 2", len(zr.File))
+	}
+
+	if _, err := os.Stat(res.Workdir + ".json"); !os.IsNotExist(err) {
+		t.Errorf("checkpoint is not removed: %v", err)
+	}
+
+	if _, err := os.Stat(res.Workdir); !os.IsNotExist(err) {
+		t.Errorf("workdir is not removed: %v", err)
+	}
+}
+
+func TestSelfTests(t *testing.T) {
+	var names []string
+	for _, st := range SelfTests() {
+		names = append(names, st.Name)
+
+		// wasm and native encoders are not run here
+		if !slices.Contains([]string{"encode png", "encode tiff", "encode bmp", "decode gif", "archive", "document"}, st.Name) {
+			continue
+		}
+
+		if err := st.Run(); err != nil {
+			t.Errorf("%s: %v", st.Name, err)
+		}
+	}
+
+	for _, name := range []string{"encode jpeg", "encode png", "decode gif", "archive"} {
+		if !slices.Contains(names, name) {
+			t.Errorf("%s: missing in %q", name, names)
+		}
+	}
+
+	if slices.Contains(names, "document") != documents {
+		t.Errorf("document check: got %v, want %v", !documents, documents)
+	}
+
+	// encoder that writes the wrong format or size fails the check
+	wrongFormat := func(w io.Writer, img image.Image, quality int) error {
+		return jpeg.Encode(w, img, &jpeg.Options{Quality: quality})
+	}
+
+	if err := selftestCodec("png", wrongFormat); err == nil || !strings.Contains(err.Error(), "format") {
+		t.Errorf("wrong format: %v", err)
+	}
+
+	wrongSize := func(w io.Writer, img image.Image, _ int) error {
+		return png.Encode(w, img.(*image.NRGBA).SubImage(image.Rect(0, 0, 8, 8)))
+	}
+
+	if err := selftestCodec("png", wrongSize); err == nil || !strings.Contains(err.Error(), "size") {
+		t.Errorf("wrong size: %v", err)
+	}
+
+	failing := func(io.Writer, image.Image, int) error {
+		return errors.New("broken")
+	}
+
+	if err := selftestCodec("png", failing); err == nil || !strings.Contains(err.Error(), "encode: broken") {
+		t.Errorf("failing encoder: %v", err)
+	}
+}
+
+func TestArchiveStreamLimit(t *testing.T) {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+
+	// zeros, the archive is small and decompresses over the limit
+	data := make([]byte, 3*1024*1024)
+	for _, name := range []string{"001.png", "002.png"} {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(data))}); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := tw.Write(data); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	fileName := filepath.Join(t.TempDir(), "test.tgz")
+	if err := os.WriteFile(fileName, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		entries, size int
+		limit         bool
+	}{
+		{2, 4, false},
+		{2, 2, true},
+		{1, 4, true},
+		{0, 1, false},
+		{2, 0, false},
+	}
+
+	for _, tt := range tests {
+		opts := NewOptions()
+		opts.MaxEntries = tt.entries
+		opts.MaxEntrySize = tt.size
+
+		archive, err := New(opts).archiveOpen(fileName)
+		if tt.limit {
+			if !errors.Is(err, ErrLimit) {
+				t.Errorf("%d entries of %d MB: got %v, want ErrLimit", tt.entries, tt.size, err)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%d entries of %d MB: %v", tt.entries, tt.size, err)
+
+			continue
+		}
+
+		if err := archive.EntryFor("002.png"); err != nil {
+			t.Errorf("%d entries of %d MB: %v", tt.entries, tt.size, err)
+		}
+
+		archive.Close()
+	}
+}
+
+func BenchmarkResize(b *testing.B) {
+	src := image.NewYCbCr(image.Rect(0, 0, 1800, 2700), image.YCbCrSubsampleRatio420)
+	for i := range src.Y {
+		src.Y[i] = uint8(i * 7)
+	}
+
+	for _, resizer := range []string{"bild", "draw"} {
+		for _, filter := range []int{linear, catmullRom, lanczos} {
+			opts := NewOptions()
+			opts.Resizer = resizer
+
+			resample := New(opts).resizer(filter)
+
+			b.Run(fmt.Sprintf("%s/filter=%d", resizer, filter), func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					resize(src, 1200, 0, resample)
+				}
+			})
+		}
+	}
+}
+
+func TestMakeTest(t *testing.T) {
+	opts := NewOptions()
+	opts.Format = "png"
+
+	conv := New(opts)
+	tmpDir := t.TempDir()
+
+	for _, pattern := range TestPatterns {
+		t.Run(pattern, func(t *testing.T) {
+			var archives [][]byte
+
+			for i := range 2 {
+				fName := filepath.Join(tmpDir, fmt.Sprintf("%s%d.cbz", pattern, i))
+				if err := conv.MakeTest(fName, 3, 120, 180, pattern, 1); err != nil {
+					t.Fatal(err)
+				}
+
+				data, err := os.ReadFile(fName)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				archives = append(archives, data)
+			}
+
+			if !bytes.Equal(archives[0], archives[1]) {
+				t.Errorf("archives with the same seed differ")
+			}
+
+			z, err := zip.NewReader(bytes.NewReader(archives[0]), int64(len(archives[0])))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(z.File) != 3 {
+				t.Fatalf("got %d pages, expected 3", len(z.File))
+			}
+
+			r, err := z.File[0].Open()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer r.Close()
+
+			cfg, err := png.DecodeConfig(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if cfg.Width != 120 || cfg.Height != 180 {
+				t.Errorf("page size %dx%d, expected 120x180", cfg.Width, cfg.Height)
+			}
+		})
+	}
+
+	if err := conv.MakeTest(filepath.Join(tmpDir, "invalid.cbz"), 1, 10, 10, "invalid", 1); err == nil {
+		t.Errorf("expected error for invalid pattern")
+	}
+}
+
+func TestConvertConcurrent(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	opts := NewOptions()
+	opts.Format = "png"
+	opts.NoConvert = true
+	opts.OutDir = filepath.Join(tmpDir, "out")
+
+	if err := os.MkdirAll(opts.OutDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	conv := New(opts)
+	conv.Nfiles = 4
+
+	var mu sync.Mutex
+	jobs := make(map[*Job]string)
+
+	conv.OnStart = func(job *Job) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		jobs[job] = job.File
+	}
+
+	var fileNames []string
+	for i := range conv.Nfiles {
+		fileName := filepath.Join(tmpDir, fmt.Sprintf("%d.cbz", i))
+		if err := conv.MakeTest(fileName, 3, 60, 90, "gradient", uint64(i)); err != nil {
+			t.Fatal(err)
+		}
+
+		fileNames = append(fileNames, fileName)
+	}
+
+	var wg sync.WaitGroup
+	for _, fileName := range fileNames {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			stat, err := os.Stat(fileName)
+			if err != nil {
+				t.Error(err)
+
+				return
+			}
+
+			if _, err := conv.Convert(fileName, stat); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	if len(jobs) != len(fileNames) {
+		t.Errorf("got %d jobs, expected %d", len(jobs), len(fileNames))
+	}
+
+	if n := len(conv.Jobs()); n != 0 {
+		t.Errorf("%d jobs still running", n)
+	}
+
+	for _, fileName := range fileNames {
+		z, err := zip.OpenReader(filepath.Join(opts.OutDir, filepath.Base(fileName)))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(z.File) != 3 {
+			t.Errorf("%s: got %d pages, expected 3", fileName, len(z.File))
+		}
+
+		_ = z.Close()
+	}
+}
+
+func TestConvertCleanup(t *testing.T) {
+	tmpDir := t.TempDir()
+	t.Setenv("TMPDIR", filepath.Join(tmpDir, "tmp"))
+
+	if err := os.MkdirAll(os.TempDir(), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := NewOptions()
+	opts.Format = "png"
+	opts.NoConvert = true
+	opts.OutDir = filepath.Join(tmpDir, "out")
+
+	if err := os.MkdirAll(opts.OutDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	fileName := filepath.Join(tmpDir, "test.cbz")
+	if err := New(opts).MakeTest(fileName, 2, 60, 90, "noise", 1); err != nil {
+		t.Fatal(err)
+	}
+
+	badName := filepath.Join(tmpDir, "bad.cbz")
+	if err := os.WriteFile(badName, []byte("not an archive"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	convert := func(conv *Converter, fileName string) (res Result, err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				err = fmt.Errorf("panic: %v", r)
+			}
+		}()
+
+		stat, err := os.Stat(fileName)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return conv.Convert(fileName, stat)
+	}
+
+	tests := []struct {
+		name    string
+		file    string
+		onStart func(job *Job)
+		wantErr bool
+	}{
+		{"ok", fileName, nil, false},
+		{"error", badName, nil, true},
+		{"panic", fileName, func(job *Job) { panic("test") }, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conv := New(opts)
+			conv.OnStart = tt.onStart
+
+			res, err := convert(conv, tt.file)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+
+			if !tt.wantErr {
+				if res.Workdir == "" {
+					t.Errorf("workdir is empty")
+				}
+
+				if _, err := os.Stat(res.Output); err != nil {
+					t.Error(err)
+				}
+			}
+
+			entries, err := os.ReadDir(os.TempDir())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, entry := range entries {
+				t.Errorf("workdir not removed: %s", entry.Name())
+			}
+		})
+	}
+}
+
+func TestMetaArchives(t *testing.T) {
+	tests := []struct {
+		file   string
+		output string
+		kind   string
+	}{
+		{"test.cbt", "test.cbt", "tar"},
+		{"test.cb7", "test.cbz", "zip"},
+		{"test.cbr", "test.cbz", "zip"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			data, err := os.ReadFile(filepath.Join("testdata", tt.file))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			tmpDir := t.TempDir()
+			fileName := filepath.Join(tmpDir, tt.file)
+			if err := os.WriteFile(fileName, data, 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			opts := NewOptions()
+			opts.Comment = true
+
+			comment, err := New(opts).Meta(fileName)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if comment != "" {
+				t.Errorf("got comment %q", comment)
+			}
+
+			stat, err := os.Stat(fileName)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			pages, err := New(NewOptions()).PageCount(fileName, stat)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			opts = NewOptions()
+			opts.SetSeries = "Series"
+			opts.SetNumber = "12"
+
+			if _, err := New(opts).Meta(fileName); err != nil {
+				t.Fatal(err)
+			}
+
+			output := filepath.Join(tmpDir, tt.output)
+			if output != fileName {
+				if _, err := os.Stat(fileName); !os.IsNotExist(err) {
+					t.Errorf("original archive not removed")
+				}
+			}
+
+			opts = NewOptions()
+			opts.FileGet = "ComicInfo.xml"
+
+			ci, err := New(opts).Meta(output)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var info ComicInfo
+			if err := xml.Unmarshal(ci.([]byte), &info); err != nil {
+				t.Fatal(err)
+			}
+
+			if info.Series != "Series" || info.Number != "12" {
+				t.Errorf("got %+v", info)
+			}
+
+			opts = NewOptions()
+			opts.FileRemove = "*.xml"
+
+			if _, err := New(opts).Meta(output); err != nil {
+				t.Fatal(err)
+			}
+
+			contents, err := New(NewOptions()).archiveList(output)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if entryMatch(contents, "ComicInfo.xml") != "" {
+				t.Errorf("ComicInfo.xml not removed")
+			}
+
+			if n := len(imagesFromSlice(contents)); n != pages {
+				t.Errorf("got %d pages, expected %d", n, pages)
+			}
+
+			if kind := archiveKind(output); kind != tt.kind {
+				t.Errorf("got %q archive, expected %q", kind, tt.kind)
+			}
+		})
+	}
+}
+
+func TestSetCover(t *testing.T) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, 10, 20))); err != nil {
+		t.Fatal(err)
+	}
+
+	fileName := filepath.Join(t.TempDir(), "test.cbz")
+
+	f, err := os.Create(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := `<ComicInfo><PageCount>2</PageCount><Pages><Page Image="0" Type="FrontCover" />` +
+		`<Page Image="1" Type="Story" Bookmark="Chapter 1" /></Pages></ComicInfo>`
+
+	zw := zip.NewWriter(f)
+	for _, name := range []string{"book/001.png", "book/002.png", "ComicInfo.xml"} {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		content := buf.Bytes()
+		if name == "ComicInfo.xml" {
+			content = []byte(data)
+		}
+
+		if _, err := w.Write(content); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	conv := New(NewOptions())
+	if err := conv.SetCover(fileName, buf.Bytes(), ".PNG"); err != nil {
+		t.Fatal(err)
+	}
+
+	zr, err := zip.OpenReader(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	images := archivePages(&zr.Reader)
+	first := zr.File[0].Name
+	_ = zr.Close()
+
+	expected := []string{"book/0_cover.png", "book/001.png", "book/002.png"}
+	if fmt.Sprint(images) != fmt.Sprint(expected) || first != expected[0] {
+		t.Errorf("pages %v, first entry %s, expected %v", images, first, expected)
+	}
+
+	if cover := conv.coverName(images); cover != expected[0] {
+		t.Errorf("got cover %s", cover)
+	}
+
+	out, err := conv.archiveFileGet(fileName, "ComicInfo.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var info ComicInfo
+	if err := xml.Unmarshal(out, &info); err != nil {
+		t.Fatal(err)
+	}
+
+	pages := []ComicInfoPage{
+		{Image: 0, Type: "FrontCover", ImageSize: int64(buf.Len()), ImageWidth: 10, ImageHeight: 20},
+		{Image: 1},
+		{Image: 2, Type: "Story", Bookmark: "Chapter 1"},
+	}
+
+	if fmt.Sprint(info.Pages) != fmt.Sprint(pages) || info.PageCount != 3 {
+		t.Errorf("pages %v, page count %d, expected %v", info.Pages, info.PageCount, pages)
+	}
+
+	names := []struct {
+		first string
+		name  string
+	}{
+		{"art/page.jpg", "art/cover.jpg"},
+		{"a.jpg", "0_cover.jpg"},
+		{"0.jpg", "0!_cover.jpg"},
+		{"0_cover.jpg", "0!_cover.jpg"},
+	}
+
+	for _, tt := range names {
+		if name := coverEntryName([]string{tt.first}, ".jpg"); name != tt.name {
+			t.Errorf("%s: got cover name %s, expected %s", tt.first, name, tt.name)
+		}
+	}
+}
+
+func TestDocumentLimits(t *testing.T) {
+	if !documents {
+		t.Skip("documents are not supported")
+	}
+
+	fileName := filepath.Join("testdata", "test.pdf")
+
+	stat, err := os.Stat(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts := NewOptions()
+	opts.MaxPagePixels = 1
+
+	if _, err := New(opts).CoverImage(fileName, stat); !errors.Is(err, ErrLimit) {
+		t.Errorf("got error %v, expected ErrLimit", err)
+	}
+
+	opts.OutDir = t.TempDir()
+	opts.NoConvert = true
+
+	if _, err := New(opts).Convert(fileName, stat); !errors.Is(err, ErrLimit) {
+		t.Errorf("got error %v, expected ErrLimit", err)
+	}
+
+	opts.MaxPagePixels = 0
+
+	img, err := New(opts).CoverImage(fileName, stat)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if pixels := img.Bounds().Dx() * img.Bounds().Dy(); pixels <= 1e6 {
+		t.Errorf("page has %d pixels, test expects more than the limit", pixels)
+	}
+}
@@ -0,0 +1,163 @@
+//go:build unix
+
+package cbconvert
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// trash moves file to the trash, the XDG Trash specification is followed, ~/.Trash is used on macOS.
+func trash(fileName string) error {
+	path, err := filepath.Abs(fileName)
+	if err != nil {
+		return fmt.Errorf("trash: %w", err)
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return fmt.Errorf("trash: %w", err)
+	}
+
+	if runtime.GOOS == "darwin" {
+		dir := filepath.Join(home, ".Trash")
+
+		name, err := trashName(dir, filepath.Base(path), func(string) error { return nil })
+		if err != nil {
+			return fmt.Errorf("trash: %w", err)
+		}
+
+		if err := os.Rename(path, filepath.Join(dir, name)); err != nil {
+			return fmt.Errorf("trash: %w", err)
+		}
+
+		return nil
+	}
+
+	dataHome := os.Getenv("XDG_DATA_HOME")
+	if dataHome == "" {
+		dataHome = filepath.Join(home, ".local", "share")
+	}
+
+	err = trashMove(filepath.Join(dataHome, "Trash"), path, path)
+	if errors.Is(err, syscall.EXDEV) {
+		// file is on another device, $topdir/.Trash-$uid is used
+		top, e := mountPoint(path)
+		if e != nil {
+			return fmt.Errorf("trash: %w", e)
+		}
+
+		rel, e := filepath.Rel(top, path)
+		if e != nil {
+			return fmt.Errorf("trash: %w", e)
+		}
+
+		err = trashMove(filepath.Join(top, fmt.Sprintf(".Trash-%d", os.Getuid())), path, rel)
+	}
+
+	if err != nil {
+		return fmt.Errorf("trash: %w", err)
+	}
+
+	return nil
+}
+
+// trashMove writes info file and moves file to the trash directory, infoPath is recorded as the original location.
+func trashMove(dir, path, infoPath string) error {
+	for _, d := range []string{"files", "info"} {
+		if err := os.MkdirAll(filepath.Join(dir, d), 0700); err != nil {
+			return err
+		}
+	}
+
+	info := fmt.Sprintf("[Trash Info]\nPath=%s\nDeletionDate=%s\n", (&url.URL{Path: infoPath}).EscapedPath(),
+		time.Now().Format("2006-01-02T15:04:05"))
+
+	// info file is created exclusively, that reserves the name
+	var infoFile string
+	name, err := trashName(filepath.Join(dir, "files"), filepath.Base(path), func(name string) error {
+		infoFile = filepath.Join(dir, "info", name+".trashinfo")
+
+		f, err := os.OpenFile(infoFile, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if err != nil {
+			return err
+		}
+
+		_, err = f.WriteString(info)
+		if e := f.Close(); err == nil {
+			err = e
+		}
+
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := os.Rename(path, filepath.Join(dir, "files", name)); err != nil {
+		_ = os.Remove(infoFile)
+
+		return err
+	}
+
+	return nil
+}
+
+// trashName returns unique name in the trash directory, reserve is called for each candidate name.
+func trashName(dir, base string, reserve func(name string) error) (string, error) {
+	ext := filepath.Ext(base)
+	stem := strings.TrimSuffix(base, ext)
+
+	name := base
+	for i := 2; i < 1000; i++ {
+		if _, err := os.Lstat(filepath.Join(dir, name)); os.IsNotExist(err) {
+			err := reserve(name)
+			if err == nil {
+				return name, nil
+			}
+			if !os.IsExist(err) {
+				return "", err
+			}
+		}
+
+		name = fmt.Sprintf("%s.%d%s", stem, i, ext)
+	}
+
+	return "", fmt.Errorf("%s: no free name in trash", base)
+}
+
+// mountPoint returns top directory of the mount that contains path.
+func mountPoint(path string) (string, error) {
+	stat, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+
+	dev := stat.Sys().(*syscall.Stat_t).Dev
+
+	dir := filepath.Dir(path)
+	for {
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return dir, nil
+		}
+
+		pstat, err := os.Stat(parent)
+		if err != nil {
+			return "", err
+		}
+
+		if pstat.Sys().(*syscall.Stat_t).Dev != dev {
+			return dir, nil
+		}
+
+		dir = parent
+	}
+}
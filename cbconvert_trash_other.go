@@ -0,0 +1,12 @@
+//go:build !unix && !windows
+
+package cbconvert
+
+import (
+	"errors"
+)
+
+// trash is not supported on this platform.
+func trash(fileName string) error {
+	return errors.New("trash: not supported")
+}
@@ -0,0 +1,62 @@
+package cbconvert
+
+import (
+	"fmt"
+	"path/filepath"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	foDelete          = 0x0003
+	fofSilent         = 0x0004
+	fofNoConfirmation = 0x0010
+	fofAllowUndo      = 0x0040
+	fofNoErrorUI      = 0x0400
+)
+
+// shFileOpStruct is SHFILEOPSTRUCTW.
+type shFileOpStruct struct {
+	hwnd                  uintptr
+	wFunc                 uint32
+	pFrom                 *uint16
+	pTo                   *uint16
+	fFlags                uint16
+	fAnyOperationsAborted int32
+	hNameMappings         uintptr
+	lpszProgressTitle     *uint16
+}
+
+var procSHFileOperation = syscall.NewLazyDLL("shell32.dll").NewProc("SHFileOperationW")
+
+// trash moves file to the Recycle Bin.
+func trash(fileName string) error {
+	path, err := filepath.Abs(fileName)
+	if err != nil {
+		return fmt.Errorf("trash: %w", err)
+	}
+
+	from, err := syscall.UTF16FromString(path)
+	if err != nil {
+		return fmt.Errorf("trash: %w", err)
+	}
+	// list of files is double null-terminated
+	from = append(from, 0)
+
+	op := shFileOpStruct{
+		wFunc:  foDelete,
+		pFrom:  &from[0],
+		fFlags: fofAllowUndo | fofNoConfirmation | fofSilent | fofNoErrorUI,
+	}
+
+	ret, _, _ := procSHFileOperation.Call(uintptr(unsafe.Pointer(&op)))
+	if ret != 0 {
+		return fmt.Errorf("trash: SHFileOperation failed with code 0x%x", ret)
+	}
+
+	if op.fAnyOperationsAborted != 0 {
+		return fmt.Errorf("trash: operation aborted")
+	}
+
+	return nil
+}
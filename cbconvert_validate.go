@@ -0,0 +1,165 @@
+package cbconvert
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/gen2brain/cbconvert/meta"
+)
+
+// archiveValidate validates ComicInfo.xml in the archive, with Options.Fix the fixed file is written to the archive.
+func (c *Converter) archiveValidate(fileName string) ([]string, error) {
+	contents, err := c.archiveList(fileName)
+	if err != nil {
+		return nil, fmt.Errorf("archiveValidate: %w", err)
+	}
+
+	entry := entryMatch(contents, "ComicInfo.xml")
+	if entry == "" {
+		return []string{fmt.Sprintf("%s: no ComicInfo.xml", fileName)}, nil
+	}
+
+	data, err := c.archiveFileGet(fileName, entry)
+	if err != nil {
+		return nil, fmt.Errorf("archiveValidate: %w", err)
+	}
+
+	issues, version, fixed, err := meta.Validate(data, c.Opts.Fix)
+	if err != nil {
+		return []string{fmt.Sprintf("%s: %s: %v", fileName, entry, err)}, nil
+	}
+
+	if c.Opts.Fix && !bytes.Equal(data, fixed) {
+		if err = c.archiveFileReplace(fileName, entry, fixed); err != nil {
+			return nil, fmt.Errorf("archiveValidate: %w", err)
+		}
+	}
+
+	if len(issues) == 0 {
+		return []string{fmt.Sprintf("%s: %s: valid, schema %s", fileName, entry, version)}, nil
+	}
+
+	lines := make([]string, 0, len(issues))
+	for _, issue := range issues {
+		lines = append(lines, fmt.Sprintf("%s: %s: %s", fileName, entry, issue))
+	}
+
+	return lines, nil
+}
+
+// archiveFileReplace replaces file in ZIP archive with data, the entry keeps its position, compression method and time.
+// The file is added to the end of the archive if there is no such entry. TAR archives are rewritten and other
+// archives are converted to CBZ.
+func (c *Converter) archiveFileReplace(fileName, name string, data []byte) error {
+	if archiveKind(fileName) == "tar" {
+		if err := c.archiveTarRewrite(fileName, map[string][]byte{name: data}, nil); err != nil {
+			return fmt.Errorf("archiveFileReplace: %w", err)
+		}
+
+		return nil
+	}
+
+	fileName, err := c.archiveZip(fileName)
+	if err != nil {
+		return fmt.Errorf("archiveFileReplace: %w", err)
+	}
+
+	zr, err := zip.OpenReader(fileName)
+	if err != nil {
+		return fmt.Errorf("archiveFileReplace: %w", err)
+	}
+	defer zr.Close()
+
+	zf, err := os.CreateTemp(os.TempDir(), "cbc")
+	if err != nil {
+		return fmt.Errorf("archiveFileReplace: %w", err)
+	}
+
+	tmpName := zf.Name()
+	defer os.Remove(tmpName)
+
+	zw := zip.NewWriter(zf)
+
+	if err = zw.SetComment(zr.Comment); err != nil {
+		return fmt.Errorf("archiveFileReplace: %w", err)
+	}
+
+	found := false
+
+	for _, item := range zr.File {
+		if item.Name == name {
+			found = true
+
+			header := &zip.FileHeader{Name: item.Name, Comment: item.Comment, Method: item.Method, Modified: item.Modified}
+			header.SetMode(item.Mode())
+
+			w, err := zw.CreateHeader(header)
+			if err != nil {
+				return fmt.Errorf("archiveFileReplace: %w", err)
+			}
+
+			if _, err = w.Write(data); err != nil {
+				return fmt.Errorf("archiveFileReplace: %w", err)
+			}
+
+			continue
+		}
+
+		ir, err := item.OpenRaw()
+		if err != nil {
+			return fmt.Errorf("archiveFileReplace: %w", err)
+		}
+
+		item := item
+
+		it, err := zw.CreateRaw(&item.FileHeader)
+		if err != nil {
+			return fmt.Errorf("archiveFileReplace: %w", err)
+		}
+
+		_, err = io.Copy(it, ir)
+		if err != nil {
+			return fmt.Errorf("archiveFileReplace: %w", err)
+		}
+	}
+
+	if !found {
+		header := &zip.FileHeader{Name: name, Method: zip.Deflate, Modified: time.Now()}
+		header.SetMode(0644)
+
+		w, err := zw.CreateHeader(header)
+		if err != nil {
+			return fmt.Errorf("archiveFileReplace: %w", err)
+		}
+
+		if _, err = w.Write(data); err != nil {
+			return fmt.Errorf("archiveFileReplace: %w", err)
+		}
+	}
+
+	err = zw.Close()
+	if err != nil {
+		return fmt.Errorf("archiveFileReplace: %w", err)
+	}
+
+	err = zf.Close()
+	if err != nil {
+		return fmt.Errorf("archiveFileReplace: %w", err)
+	}
+
+	out, err := os.ReadFile(tmpName)
+	if err != nil {
+		return fmt.Errorf("archiveFileReplace: %w", err)
+	}
+
+	err = os.WriteFile(fileName, out, 0644)
+	if err != nil {
+		return fmt.Errorf("archiveFileReplace: %w", err)
+	}
+
+	return nil
+}
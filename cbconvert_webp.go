@@ -0,0 +1,26 @@
+//go:build !nowebp
+
+package cbconvert
+
+import (
+	"bytes"
+	"image"
+	"io"
+
+	"github.com/gen2brain/webp"
+)
+
+func init() {
+	codecs["webp"] = &codec{[]string{".webp"}, "github.com/gen2brain/webp", 16383, func(w io.Writer, img image.Image, quality int) error {
+		return webp.Encode(w, img, webp.Options{Quality: quality, Method: webp.DefaultMethod})
+	}}
+
+	webpFrames = func(data []byte) ([]image.Image, error) {
+		w, err := webp.DecodeAll(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+
+		return w.Image, nil
+	}
+}
@@ -6,6 +6,7 @@ require (
 	github.com/gen2brain/cbconvert v1.0.5-0.20241106192421-4d845afa43ca
 	github.com/gen2brain/iup-go/iup v0.0.0-20241106050025-0f971ac33ed4
 	github.com/godbus/dbus/v5 v5.1.0
+	golang.org/x/image v0.21.0
 )
 
 require (
@@ -28,8 +29,9 @@ require (
 	github.com/google/uuid v1.6.0 // indirect
 	github.com/jsummers/gobmp v0.0.0-20230614200233-a9de23ed2e25 // indirect
 	github.com/jupiterrider/ffi v0.2.1 // indirect
+	github.com/klauspost/compress v1.17.11 // indirect
 	github.com/tetratelabs/wazero v1.8.1 // indirect
-	golang.org/x/image v0.21.0 // indirect
+	github.com/ulikunitz/xz v0.5.12 // indirect
 	golang.org/x/net v0.30.0 // indirect
 	golang.org/x/sync v0.8.0 // indirect
 	gopkg.in/yaml.v2 v2.4.0 // indirect
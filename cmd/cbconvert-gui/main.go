@@ -7,6 +7,7 @@ import (
 	"errors"
 	"flag"
 	"fmt"
+	"image"
 	"image/gif"
 	"image/png"
 	"net/url"
@@ -16,6 +17,7 @@ import (
 	"slices"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gen2brain/cbconvert"
 	"github.com/gen2brain/iup-go/iup"
@@ -34,6 +36,11 @@ var appVersion string
 var (
 	index = -1
 	files []cbconvert.File
+
+	// sources are paths added to the queue, directories are scanned again when Recursive is toggled
+	sources []string
+	// removed are files removed from the queue, they are skipped on rescan
+	removed = make(map[string]bool)
 )
 
 func init() {
@@ -64,7 +71,15 @@ func init() {
 }
 
 func main() {
-	parseFlags()
+	args := parseFlags()
+
+	if ipcSend(args) {
+		return
+	}
+
+	if err := loadConfig(); err != nil {
+		fmt.Println(err)
+	}
 
 	iup.Open()
 	defer iup.Close()
@@ -78,6 +93,17 @@ func main() {
 	dlg := iup.Dialog(layout()).SetAttributes(fmt.Sprintf(`TITLE="CBconvert %s", ICON=logo`, appVersion)).SetHandle("dlg")
 
 	dlg.SetCallback("POSTMESSAGE_CB", iup.PostMessageFunc(func(ih iup.Ihandle, s string, i int, p any) int {
+		switch s {
+		case "raise":
+			iup.Show(ih)
+
+			return iup.DEFAULT
+		case "history":
+			historyAdd(p.(historyEntry))
+
+			return iup.DEFAULT
+		}
+
 		sp := strings.Split(s, ": ")
 		if len(sp) > 1 {
 			iup.MessageError(ih, fmt.Sprintf("%s\n\n%s", sp[0], strings.Join(sp[1:], ": ")))
@@ -98,13 +124,26 @@ func main() {
 	iup.Map(dlg)
 	setActive()
 
+	go thumbWorker()
+
+	ln, err := ipcListen()
+	if err != nil {
+		fmt.Println(err)
+	} else {
+		defer ln.Close()
+	}
+
+	if len(args) > 0 {
+		iup.PostMessage(iup.GetHandle("List"), strings.Join(args, "\n"), 0, 0)
+	}
+
 	iup.ShowXY(dlg, iup.CENTER, iup.CENTER)
 	iup.MainLoop()
 }
 
-func parseFlags() {
+func parseFlags() []string {
 	flag.Usage = func() {
-		fmt.Fprintf(os.Stderr, "Usage: %s <command> [<flags>]\n\n", filepath.Base(os.Args[0]))
+		fmt.Fprintf(os.Stderr, "Usage: %s [<command>] [file1 dir1 ... fileOrDirN]\n\n", filepath.Base(os.Args[0]))
 		fmt.Fprintf(os.Stderr, "\nCommands:\n")
 		fmt.Fprintf(os.Stderr, "\n  version\n    \tPrint version\n\n")
 	}
@@ -112,15 +151,12 @@ func parseFlags() {
 	flag.NewFlagSet("version", flag.ExitOnError)
 	flag.Parse()
 
-	if flag.NArg() >= 1 {
-		if flag.Arg(0) == "version" {
-			fmt.Println(filepath.Base(os.Args[0]), appVersion)
-			os.Exit(0)
-		} else {
-			flag.Usage()
-			os.Exit(1)
-		}
+	if flag.NArg() >= 1 && flag.Arg(0) == "version" {
+		fmt.Println(filepath.Base(os.Args[0]), appVersion)
+		os.Exit(0)
 	}
+
+	return flag.Args()
 }
 
 func options() cbconvert.Options {
@@ -133,7 +169,9 @@ func options() cbconvert.Options {
 	opts.Suffix = iup.GetHandle("Suffix").GetAttribute("VALUE")
 	opts.NoConvert = iup.GetHandle("NoConvert").GetAttribute("VALUE") == "ON"
 	opts.NoNonImage = iup.GetHandle("NoNonImage").GetAttribute("VALUE") == "ON"
+	opts.ComicInfo = iup.GetHandle("ComicInfo").GetAttribute("VALUE") == "ON"
 	opts.Archive = strings.ToLower(iup.GetHandle("Archive").GetAttribute("VALUESTRING"))
+	opts.RightToLeft = iup.GetHandle("RightToLeft").GetAttribute("VALUE") == "ON"
 	opts.Format = strings.ToLower(iup.GetHandle("Format").GetAttribute("VALUESTRING"))
 	opts.Width = iup.GetHandle("Width").GetInt("VALUE")
 	opts.Height = iup.GetHandle("Height").GetInt("VALUE")
@@ -148,6 +186,20 @@ func options() cbconvert.Options {
 	return opts
 }
 
+// formatItems returns list attributes with image formats available in this build.
+func formatItems() map[string]string {
+	items := map[string]string{
+		"DROPDOWN": "YES",
+		"VALUE":    "1",
+	}
+
+	for idx, format := range cbconvert.SupportedFormats() {
+		items[strconv.Itoa(idx+1)] = strings.ToUpper(format.Name)
+	}
+
+	return items
+}
+
 func setActive() {
 	opts := options()
 	count := iup.GetHandle("List").GetInt("COUNT")
@@ -155,6 +207,7 @@ func setActive() {
 	if count == 0 {
 		iup.GetHandle("Remove").SetAttribute("ACTIVE", "NO")
 		iup.GetHandle("RemoveAll").SetAttribute("ACTIVE", "NO")
+		iup.GetHandle("QueueExport").SetAttribute("ACTIVE", "NO")
 
 		iup.GetHandle("Preview").SetAttribute("IMAGE", "logo")
 		iup.GetHandle("PreviewInfo").SetAttribute("TITLE", "")
@@ -163,25 +216,30 @@ func setActive() {
 			iup.GetHandle("Remove").SetAttribute("ACTIVE", "YES")
 		}
 		iup.GetHandle("RemoveAll").SetAttribute("ACTIVE", "YES")
+		iup.GetHandle("QueueExport").SetAttribute("ACTIVE", "YES")
 	}
 
 	if opts.OutDir == "" {
 		iup.GetHandle("Thumbnail").SetAttributes(`ACTIVE=NO`)
 		iup.GetHandle("Cover").SetAttributes(`ACTIVE=NO`)
+		iup.GetHandle("CoverThumbnail").SetAttributes(`ACTIVE=NO`)
 		iup.GetHandle("Convert").SetAttributes(`ACTIVE=NO`)
 		if count > 0 {
 			iup.GetHandle("Thumbnail").SetAttributes(`ACTIVE=NO, TIP="Set Output Directory"`)
 			iup.GetHandle("Cover").SetAttributes(`ACTIVE=NO, TIP="Set Output Directory"`)
+			iup.GetHandle("CoverThumbnail").SetAttributes(`ACTIVE=NO, TIP="Set Output Directory"`)
 			iup.GetHandle("Convert").SetAttributes(`ACTIVE=NO, TIP="Set Output Directory"`)
 		}
 	} else {
 		if count > 0 {
 			iup.GetHandle("Thumbnail").SetAttributes(`ACTIVE=YES, TIP=""`)
 			iup.GetHandle("Cover").SetAttributes(`ACTIVE=YES, TIP=""`)
+			iup.GetHandle("CoverThumbnail").SetAttributes(`ACTIVE=YES, TIP=""`)
 			iup.GetHandle("Convert").SetAttributes(`ACTIVE=YES, TIP=""`)
 		} else {
 			iup.GetHandle("Thumbnail").SetAttributes(`ACTIVE=NO`)
 			iup.GetHandle("Cover").SetAttributes(`ACTIVE=NO`)
+			iup.GetHandle("CoverThumbnail").SetAttributes(`ACTIVE=NO`)
 			iup.GetHandle("Convert").SetAttributes(`ACTIVE=NO`)
 		}
 	}
@@ -194,7 +252,7 @@ func setActive() {
 		iup.GetHandle("VboxTransform").SetAttribute("ACTIVE", "YES")
 	}
 
-	if (opts.Format == "jpeg" || opts.Format == "webp" || opts.Format == "avif" || opts.Format == "jxl") && !opts.NoConvert {
+	if (opts.Format == "jpeg" || opts.Format == "webp" || opts.Format == "avif" || opts.Format == "jxl" || opts.Format == "auto") && !opts.NoConvert {
 		iup.GetHandle("VboxQuality").SetAttribute("ACTIVE", "YES")
 	} else {
 		iup.GetHandle("VboxQuality").SetAttribute("ACTIVE", "NO")
@@ -227,7 +285,7 @@ func layout() iup.Ihandle {
 
 func list() iup.Ihandle {
 	return iup.Vbox(
-		iup.List().SetAttributes("EXPAND=YES, VISIBLECOLUMNS=16, VISIBLELINES=5").SetHandle("List").
+		iup.List().SetAttributes("EXPAND=YES, VISIBLECOLUMNS=16, VISIBLELINES=5, SHOWIMAGE=YES").SetHandle("List").
 			SetCallback("ACTION", iup.ListActionFunc(func(ih iup.Ihandle, text string, item int, state int) int {
 				if state == 1 {
 					index = item - 1
@@ -246,34 +304,95 @@ func list() iup.Ihandle {
 					return iup.DEFAULT
 				}
 
-				conv := cbconvert.New(options())
-
-				fs, err := conv.Files([]string{dec})
-				if err != nil {
-					iup.PostMessage(iup.GetHandle("dlg"), err.Error(), 0, 0)
-					fmt.Println(err)
-
-					return iup.DEFAULT
-				}
+				addFiles([]string{dec})
 
-				for _, file := range fs {
-					iup.SetAttribute(iup.GetHandle("List"), "APPENDITEM", fmt.Sprintf("%s (%s)", file.Name, file.SizeHuman))
-					files = append(files, file)
+				return iup.DEFAULT
+			})).
+			SetCallback("POSTMESSAGE_CB", iup.PostMessageFunc(func(ih iup.Ihandle, s string, i int, p any) int {
+				switch p.(type) {
+				case string, image.Image:
+					thumbSet(s, p)
+				default:
+					addFiles(strings.Split(s, "\n"))
 				}
 
-				setActive()
-
 				return iup.DEFAULT
 			})),
 	)
 }
 
+func addFiles(args []string) {
+	conv := cbconvert.New(options())
+
+	fs, err := conv.Files(args)
+	if err != nil {
+		iup.PostMessage(iup.GetHandle("dlg"), err.Error(), 0, 0)
+		fmt.Println(err)
+
+		return
+	}
+
+	recentAdd(args, "")
+	sources = append(sources, args...)
+
+	for _, file := range fs {
+		delete(removed, file.Path)
+		listAppend(file)
+	}
+
+	setActive()
+}
+
+// rescan scans queued directories again, i.e. after the Recursive toggle changed.
+func rescan() {
+	if !slices.ContainsFunc(sources, func(path string) bool {
+		stat, err := os.Stat(path)
+		return err == nil && stat.IsDir()
+	}) {
+		return
+	}
+
+	conv := cbconvert.New(options())
+
+	fs, err := conv.Files(sources)
+	if err != nil {
+		iup.PostMessage(iup.GetHandle("dlg"), err.Error(), 0, 0)
+		fmt.Println(err)
+
+		return
+	}
+
+	index = -1
+	files = make([]cbconvert.File, 0)
+	iup.GetHandle("List").SetAttribute("REMOVEITEM", "ALL")
+
+	seen := make(map[string]bool)
+	for _, file := range fs {
+		if removed[file.Path] || seen[file.Path] {
+			continue
+		}
+
+		seen[file.Path] = true
+		listAppend(file)
+	}
+
+	setActive()
+}
+
+func listAppend(file cbconvert.File) {
+	iup.SetAttribute(iup.GetHandle("List"), "APPENDITEM", fmt.Sprintf("%s (%s)", file.Name, file.SizeHuman))
+	files = append(files, file)
+
+	thumbAdd(file)
+}
+
 func previewPost() {
 	if index == -1 || len(files) == 0 {
 		return
 	}
 
 	width, height := previewSize()
+	zoom := previewZoom()
 	iup.GetHandle("Loading").SetAttributes("VISIBLE=YES, START=YES")
 	if strings.ToLower(iup.GetGlobal("DRIVER")) == "motif" {
 		iup.GetHandle("Preview").SetAttribute("IMAGE", "")
@@ -287,7 +406,7 @@ func previewPost() {
 		var s string
 		file := files[index]
 
-		img, err := conv.Preview(file.Path, file.Stat, width, height)
+		img, err := conv.PreviewZoom(file.Path, file.Stat, width, height, zoom)
 		if err != nil {
 			s = err.Error()
 			fmt.Println(err)
@@ -299,7 +418,7 @@ func previewPost() {
 
 func previewSize() (int, int) {
 	var width, height int
-	sp := strings.Split(iup.GetHandle("Preview").GetAttribute("RASTERSIZE"), "x")
+	sp := strings.Split(iup.GetHandle("PreviewBox").GetAttribute("RASTERSIZE"), "x")
 	if len(sp) == 2 {
 		width, _ = strconv.Atoi(sp[0])
 		height, _ = strconv.Atoi(sp[1])
@@ -311,45 +430,81 @@ func previewSize() (int, int) {
 func preview() iup.Ihandle {
 	return iup.Frame(
 		iup.Vbox(
-			iup.Label("").SetAttributes("EXPAND=YES, ALIGNMENT=ACENTER, MINSIZE=400x, IMAGE=cover").SetHandle("Preview").
-				SetCallback("POSTMESSAGE_CB", iup.PostMessageFunc(func(ih iup.Ihandle, s string, i int, p any) int {
-					img := p.(cbconvert.Image)
+			iup.ScrollBox(
+				iup.Label("").SetAttributes("EXPAND=YES, ALIGNMENT=ACENTER, MINSIZE=400x, IMAGE=cover").SetHandle("Preview").
+					SetCallback("POSTMESSAGE_CB", iup.PostMessageFunc(func(ih iup.Ihandle, s string, i int, p any) int {
+						img := p.(cbconvert.Image)
+
+						iup.GetHandle("Loading").SetAttributes("VISIBLE=NO, STOP=YES")
+
+						if img.Image != nil && len(s) == 0 {
+							iup.Destroy(iup.GetHandle("cover"))
+							iup.ImageFromImage(img.Image).SetHandle("cover")
+
+							ih.SetAttribute("IMAGE", "cover")
+							iup.GetHandle("PreviewInfo").SetAttribute("TITLE", fmt.Sprintf("%s (%dx%d)", img.SizeHuman, img.Width, img.Height))
+						} else {
+							ih.SetAttribute("IMAGE", "logo")
+							iup.GetHandle("PreviewInfo").SetAttribute("TITLE", "")
+
+							sp := strings.Split(s, ": ")
+							if len(sp) > 1 {
+								iup.MessageError(ih, fmt.Sprintf("%s\n\n%s", sp[0], strings.Join(sp[1:], ": ")))
+							}
+						}
 
-					iup.GetHandle("Loading").SetAttributes("VISIBLE=NO, STOP=YES")
+						return iup.DEFAULT
+					})),
+			).SetAttributes("EXPAND=YES").SetHandle("PreviewBox"),
+			iup.Hbox(
+				iup.Label("").SetAttributes("EXPAND=HORIZONTAL, ALIGNMENT=ACENTER").SetHandle("PreviewInfo"),
+				iup.List().SetAttributes(map[string]string{
+					"DROPDOWN": "YES",
+					"VALUE":    "1",
+					"TIP":      "Preview zoom, zoom in for pixel-accurate inspection of the output",
+					"1":        "Fit",
+					"2":        "50%",
+					"3":        "100%",
+					"4":        "200%",
+					"5":        "400%",
+				}).SetHandle("Zoom").
+					SetCallback("VALUECHANGED_CB", iup.ValueChangedFunc(func(ih iup.Ihandle) int {
+						previewPost()
 
-					if img.Image != nil && len(s) == 0 {
-						iup.Destroy(iup.GetHandle("cover"))
-						iup.ImageFromImage(img.Image).SetHandle("cover")
+						return iup.DEFAULT
+					})),
+			).SetAttributes("ALIGNMENT=ACENTER, MARGIN=0"),
+		),
+	)
+}
 
-						ih.SetAttribute("IMAGE", "cover")
-						iup.GetHandle("PreviewInfo").SetAttribute("TITLE", fmt.Sprintf("%s (%dx%d)", img.SizeHuman, img.Width, img.Height))
-					} else {
-						ih.SetAttribute("IMAGE", "logo")
-						iup.GetHandle("PreviewInfo").SetAttribute("TITLE", "")
+// previewZoom returns selected preview zoom factor, 0 means fit.
+func previewZoom() float64 {
+	value := strings.TrimSuffix(iup.GetHandle("Zoom").GetAttribute("VALUESTRING"), "%")
 
-						sp := strings.Split(s, ": ")
-						if len(sp) > 1 {
-							iup.MessageError(ih, fmt.Sprintf("%s\n\n%s", sp[0], strings.Join(sp[1:], ": ")))
-						}
-					}
+	zoom, err := strconv.Atoi(value)
+	if err != nil {
+		return 0
+	}
 
-					return iup.DEFAULT
-				})),
-			iup.Label("").SetAttributes("EXPAND=HORIZONTAL, ALIGNMENT=ACENTER").SetHandle("PreviewInfo"),
-		),
-	)
+	return float64(zoom) / 100
 }
 
 func tabs() iup.Ihandle {
 	vboxInput := iup.Vbox(
 		iup.Toggle(" Recurse SubDirectories").SetHandle("Recursive").
-			SetAttributes(`TIP="Process subdirectories recursively"`),
+			SetAttributes(`TIP="Process subdirectories recursively"`).
+			SetCallback("VALUECHANGED_CB", iup.ValueChangedFunc(func(ih iup.Ihandle) int {
+				rescan()
+
+				return iup.DEFAULT
+			})),
 		iup.Toggle(" Only Grayscale Images").SetHandle("NoRGB").
 			SetAttributes(`TIP="Do not convert images that have RGB colorspace"`),
 		iup.Toggle(" Exclude Cover").SetHandle("NoCover").
 			SetAttributes(`TIP="Do not convert the cover image"`),
 		iup.Toggle(" Remove Non-Image Files from the Archive").SetHandle("NoNonImage").
-			SetAttribute("TIP", "Remove .nfo, .xml, .txt files from the archive"),
+			SetAttribute("TIP", "Remove .nfo, .xml, .txt files from the archive, ComicInfo.xml is kept"),
 		iup.Toggle(" Do not Transform or Convert Images").SetHandle("NoConvert").
 			SetAttributes(`TIP="Copy images from archive or directory without modifications"`).
 			SetCallback("VALUECHANGED_CB", iup.ValueChangedFunc(func(ih iup.Ihandle) int {
@@ -357,6 +512,8 @@ func tabs() iup.Ihandle {
 
 				return iup.DEFAULT
 			})),
+		iup.Toggle(" Write ComicInfo.xml Page Table").SetHandle("ComicInfo").
+			SetAttributes(`TIP="Write ComicInfo.xml with page sizes and dimensions, existing file is updated"`),
 		iup.Vbox(
 			iup.Label("Minimum Size (MiB):"),
 			iup.Text().SetAttributes(`SPIN=YES, SPINMAX=2048, VISIBLECOLUMNS=4, MASK="/d*"`).SetHandle("Size").
@@ -390,24 +547,23 @@ func tabs() iup.Ihandle {
 				"VALUE":    "1",
 				"1":        "ZIP",
 				"2":        "TAR",
+				"3":        "MOBI",
+				"4":        "7Z",
+				"5":        "EPUB",
+				"6":        "NONE",
+				"7":        "TAR.GZ",
+				"8":        "TAR.ZST",
+				"9":        "TAR.XZ",
 			}).SetHandle("Archive"),
 		),
+		iup.Toggle(" Right-to-Left Reading Direction").SetHandle("RightToLeft").
+			SetAttributes(`TIP="Right-to-left page progression of the EPUB output (manga)"`),
 	).SetHandle("VboxOutput").SetAttributes("MARGIN=5x5, GAP=5")
 
 	vboxImage := iup.Vbox(
 		iup.Vbox(
 			iup.Label("Format:"),
-			iup.List().SetAttributes(map[string]string{
-				"DROPDOWN": "YES",
-				"VALUE":    "1",
-				"1":        "JPEG",
-				"2":        "PNG",
-				"3":        "TIFF",
-				"4":        "BMP",
-				"5":        "WEBP",
-				"6":        "AVIF",
-				"7":        "JXL",
-			}).SetHandle("Format").
+			iup.List().SetAttributes(formatItems()).SetHandle("Format").
 				SetCallback("VALUECHANGED_CB", iup.ValueChangedFunc(func(ih iup.Ihandle) int {
 					setActive()
 					previewPost()
@@ -584,6 +740,15 @@ func buttons() iup.Ihandle {
 					SetCallback("ACTION", iup.ActionFunc(onAddFiles)),
 				iup.Button("Add &Dir...").SetHandle("AddDir").SetAttributes("EXPAND=HORIZONTAL, PADDING=DEFAULTBUTTONPADDING").
 					SetCallback("ACTION", iup.ActionFunc(onAddDir)),
+				iup.Button("Recent").SetHandle("Recent").SetAttributes("EXPAND=HORIZONTAL, PADDING=DEFAULTBUTTONPADDING").
+					SetAttribute("TIP", "Recent input files and output directories").
+					SetCallback("ACTION", iup.ActionFunc(onRecent)),
+				iup.Button("Import...").SetHandle("QueueImport").SetAttributes("EXPAND=HORIZONTAL, PADDING=DEFAULTBUTTONPADDING").
+					SetAttribute("TIP", "Load saved queue, or list of input files (one path per line)").
+					SetCallback("ACTION", iup.ActionFunc(onQueueImport)),
+				iup.Button("Export...").SetHandle("QueueExport").SetAttributes("EXPAND=HORIZONTAL, PADDING=DEFAULTBUTTONPADDING").
+					SetAttribute("TIP", "Save queue of input files and output directory").
+					SetCallback("ACTION", iup.ActionFunc(onQueueExport)),
 				iup.Button("Remove").SetHandle("Remove").SetAttributes("EXPAND=HORIZONTAL, PADDING=DEFAULTBUTTONPADDING").
 					SetCallback("ACTION", iup.ActionFunc(onRemove)),
 				iup.Button("Remove All").SetHandle("RemoveAll").SetAttributes("EXPAND=HORIZONTAL, PADDING=DEFAULTBUTTONPADDING").
@@ -596,13 +761,18 @@ func buttons() iup.Ihandle {
 					SetCallback("ACTION", iup.ActionFunc(onThumbnail)),
 				iup.Button("Cover").SetHandle("Cover").SetAttributes("EXPAND=HORIZONTAL, PADDING=DEFAULTBUTTONPADDING").
 					SetCallback("ACTION", iup.ActionFunc(onCover)),
+				iup.Button("Cover + Thumbnail").SetHandle("CoverThumbnail").SetAttributes("EXPAND=HORIZONTAL, PADDING=DEFAULTBUTTONPADDING").
+					SetCallback("ACTION", iup.ActionFunc(onCoverThumbnail)),
 			).SetAttributes("NGAP=5"),
 		),
 		iup.Frame(
 			iup.Vbox(
 				iup.Button("&Convert").SetHandle("Convert").SetAttributes("EXPAND=HORIZONTAL, PADDING=DEFAULTBUTTONPADDING").
 					SetCallback("ACTION", iup.ActionFunc(onConvert)),
-			),
+				iup.Button("History").SetHandle("History").SetAttributes("EXPAND=HORIZONTAL, PADDING=DEFAULTBUTTONPADDING").
+					SetAttribute("TIP", "Completed conversions, convert again with the same settings").
+					SetCallback("ACTION", iup.ActionFunc(onHistory)),
+			).SetAttributes("NGAP=5"),
 		),
 	).SetHandle("Buttons").SetAttributes("ALIGNMENT=ACENTER, NGAP=10")
 }
@@ -619,15 +789,15 @@ func status() iup.Ihandle {
 			SetCallback("POSTMESSAGE_CB", iup.PostMessageFunc(func(ih iup.Ihandle, s string, i int, p any) int {
 				switch s {
 				case "convert":
-					conv := p.(*cbconvert.Converter)
+					job := p.(*cbconvert.Job)
 					ih.SetAttributes("VALUE=0, VISIBLE=YES")
-					ih.SetAttribute("MAX", conv.Ncontents)
+					ih.SetAttribute("MAX", job.Ncontents)
 
 					iup.GetHandle("List").SetAttributes("ACTIVE=NO")
 					iup.GetHandle("Tabs").SetAttributes("ACTIVE=NO")
 					iup.GetHandle("Buttons").SetAttributes("ACTIVE=NO")
 
-					iup.GetHandle("LabelStatus1").SetAttribute("TITLE", fmt.Sprintf("File %d of %d", conv.CurrFile, conv.Nfiles))
+					iup.GetHandle("LabelStatus1").SetAttribute("TITLE", fmt.Sprintf("File %d of %d", job.CurrFile, job.Nfiles))
 					iup.GetHandle("LabelStatus1").SetAttributes("VISIBLE=YES")
 					iup.GetHandle("LabelStatus2").SetAttributes("VISIBLE=YES")
 
@@ -643,15 +813,20 @@ func status() iup.Ihandle {
 
 					iup.GetHandle("LabelStatus2").SetAttributes("VISIBLE=YES")
 				case "progress":
-					conv := p.(*cbconvert.Converter)
-					ih.SetAttribute("VALUE", conv.CurrContent)
-					iup.GetHandle("LabelStatus2").SetAttribute("TITLE", fmt.Sprintf("(%03d/%03d)", conv.CurrContent, conv.Ncontents))
+					job := p.(*cbconvert.Job)
+					ih.SetAttribute("VALUE", job.CurrContent)
+					iup.GetHandle("LabelStatus2").SetAttribute("TITLE", fmt.Sprintf("(%03d/%03d)", job.CurrContent, job.Ncontents))
+
+					if eta := job.ETA(); eta.Rate > 0 {
+						iup.GetHandle("LabelStatus1").SetAttribute("TITLE", fmt.Sprintf("File %d of %d, %s left (%.1f pages/s)",
+							job.CurrFile, job.Nfiles, eta.Batch.Round(time.Second), eta.Rate))
+					}
 
 					iup.Refresh(iup.GetHandle("StatusBar"))
 				case "progress2":
-					conv := p.(*cbconvert.Converter)
-					ih.SetAttribute("VALUE", conv.CurrFile)
-					iup.GetHandle("LabelStatus2").SetAttribute("TITLE", fmt.Sprintf("(%03d/%03d)", conv.CurrFile, conv.Nfiles))
+					job := p.(*cbconvert.Job)
+					ih.SetAttribute("VALUE", job.CurrFile)
+					iup.GetHandle("LabelStatus2").SetAttribute("TITLE", fmt.Sprintf("(%03d/%03d)", job.CurrFile, job.Nfiles))
 
 					iup.Refresh(iup.GetHandle("StatusBar"))
 				case "finish":
@@ -698,22 +873,7 @@ func onAddFiles(ih iup.Ihandle) int {
 	}
 
 	if len(args) > 0 {
-		conv := cbconvert.New(options())
-
-		fs, err := conv.Files(args)
-		if err != nil {
-			iup.PostMessage(iup.GetHandle("dlg"), err.Error(), 0, 0)
-			fmt.Println(err)
-
-			return iup.DEFAULT
-		}
-
-		for _, file := range fs {
-			iup.SetAttribute(iup.GetHandle("List"), "APPENDITEM", fmt.Sprintf("%s (%s)", file.Name, file.SizeHuman))
-			files = append(files, file)
-		}
-
-		setActive()
+		addFiles(args)
 	}
 
 	return iup.DEFAULT
@@ -729,22 +889,7 @@ func onAddDir(ih iup.Ihandle) int {
 	}
 
 	if len(args) > 0 {
-		conv := cbconvert.New(options())
-
-		fs, err := conv.Files(args)
-		if err != nil {
-			iup.PostMessage(iup.GetHandle("dlg"), err.Error(), 0, 0)
-			fmt.Println(err)
-
-			return iup.DEFAULT
-		}
-
-		for _, file := range fs {
-			iup.SetAttribute(iup.GetHandle("List"), "APPENDITEM", fmt.Sprintf("%s (%s)", file.Name, file.SizeHuman))
-			files = append(files, file)
-		}
-
-		setActive()
+		addFiles(args)
 	}
 
 	return iup.DEFAULT
@@ -755,10 +900,12 @@ func onRemove(ih iup.Ihandle) int {
 		return iup.IGNORE
 	}
 
+	removed[files[index].Path] = true
+
 	if len(files) == 1 {
 		files = make([]cbconvert.File, 0)
 	} else {
-		files = slices.Delete(files, index, index)
+		files = slices.Delete(files, index, index+1)
 	}
 
 	iup.GetHandle("List").SetAttribute("REMOVEITEM", iup.GetHandle("List").GetAttribute("VALUE"))
@@ -770,6 +917,8 @@ func onRemove(ih iup.Ihandle) int {
 func onRemoveAll(ih iup.Ihandle) int {
 	index = -1
 	files = make([]cbconvert.File, 0)
+	sources = nil
+	clear(removed)
 
 	iup.GetHandle("List").SetAttribute("REMOVEITEM", "ALL")
 	setActive()
@@ -778,11 +927,29 @@ func onRemoveAll(ih iup.Ihandle) int {
 }
 
 func onThumbnail(ih iup.Ihandle) int {
+	return extract((*cbconvert.Converter).Thumbnail)
+}
+
+func onCover(ih iup.Ihandle) int {
+	return extract((*cbconvert.Converter).Cover)
+}
+
+func onCoverThumbnail(ih iup.Ihandle) int {
+	return extract((*cbconvert.Converter).CoverThumbnail)
+}
+
+// extract runs fn for all files in a goroutine, used for thumbnail and cover extraction.
+func extract(fn func(c *cbconvert.Converter, fileName string, fileInfo os.FileInfo) error) int {
+	pw, fs, ok := passwords(options(), files)
+	if !ok {
+		return iup.DEFAULT
+	}
+
 	conv := cbconvert.New(options())
-	conv.Nfiles = len(files)
+	conv.Nfiles = len(fs)
 
-	conv.OnProgress = func() {
-		iup.PostMessage(iup.GetHandle("ProgressBar"), "progress2", 0, conv)
+	conv.OnProgress = func(job *cbconvert.Job) {
+		iup.PostMessage(iup.GetHandle("ProgressBar"), "progress2", 0, job)
 	}
 
 	var canceled = false
@@ -801,12 +968,13 @@ func onThumbnail(ih iup.Ihandle) int {
 	iup.PostMessage(iup.GetHandle("ProgressBar"), "start", 0, conv)
 
 	go func(c *cbconvert.Converter) {
-		for _, file := range files {
+		for _, file := range fs {
 			if canceled {
 				break
 			}
 
-			if err := c.Thumbnail(file.Path, file.Stat); err != nil {
+			c.Opts.Password = pw[file.Path]
+			if err := fn(c, file.Path, file.Stat); err != nil {
 				iup.PostMessage(iup.GetHandle("dlg"), err.Error(), 0, 0)
 				fmt.Println(err)
 
@@ -820,59 +988,46 @@ func onThumbnail(ih iup.Ihandle) int {
 	return iup.DEFAULT
 }
 
-func onCover(ih iup.Ihandle) int {
-	conv := cbconvert.New(options())
-	conv.Nfiles = len(files)
+func onConvert(ih iup.Ihandle) int {
+	opts := options()
 
-	conv.OnProgress = func() {
-		iup.PostMessage(iup.GetHandle("ProgressBar"), "progress2", 0, conv)
-	}
+	// i.e. directory from the recent list that the sandbox no longer has access to
+	if err := dirWritable(opts.OutDir); err != nil {
+		iup.PostMessage(iup.GetHandle("dlg"), err.Error(), 0, 0)
+		fmt.Println(err)
 
-	var canceled = false
-	conv.OnCancel = func() {
-		canceled = true
+		return iup.DEFAULT
 	}
 
-	iup.GetHandle("dlg").SetCallback("K_ANY", iup.KAnyFunc(func(ih iup.Ihandle, c int) int {
-		if c == iup.K_ESC {
-			conv.Cancel()
-		}
-
+	pw, fs, ok := passwords(opts, files)
+	if !ok {
 		return iup.DEFAULT
-	}))
-
-	iup.PostMessage(iup.GetHandle("ProgressBar"), "start", 0, conv)
-
-	go func(c *cbconvert.Converter) {
-		for _, file := range files {
-			if canceled {
-				break
-			}
-
-			if err := c.Cover(file.Path, file.Stat); err != nil {
-				iup.PostMessage(iup.GetHandle("dlg"), err.Error(), 0, 0)
-				fmt.Println(err)
+	}
 
-				continue
-			}
+	policies := make(map[string]string)
+	if cs := collisions(opts, fs); len(cs) > 0 {
+		if policies, ok = collisionDlg(cs); !ok {
+			return iup.DEFAULT
 		}
+	}
 
-		iup.PostMessage(iup.GetHandle("ProgressBar"), "finish", 0, 0)
-	}(conv)
+	convert(opts, fs, policies, pw)
 
 	return iup.DEFAULT
 }
 
-func onConvert(ih iup.Ihandle) int {
-	conv := cbconvert.New(options())
-	conv.Nfiles = len(files)
+// convert converts files in a goroutine, policies override Options.Overwrite and passwords set Options.Password
+// per input path.
+func convert(opts cbconvert.Options, fs []cbconvert.File, policies, passwords map[string]string) {
+	conv := cbconvert.New(opts)
+	conv.Nfiles = len(fs)
 
-	conv.OnStart = func() {
-		iup.PostMessage(iup.GetHandle("ProgressBar"), "convert", 0, conv)
+	conv.OnStart = func(job *cbconvert.Job) {
+		iup.PostMessage(iup.GetHandle("ProgressBar"), "convert", 0, job)
 	}
 
-	conv.OnProgress = func() {
-		iup.PostMessage(iup.GetHandle("ProgressBar"), "progress", 0, conv)
+	conv.OnProgress = func(job *cbconvert.Job) {
+		iup.PostMessage(iup.GetHandle("ProgressBar"), "progress", 0, job)
 	}
 
 	iup.GetHandle("dlg").SetCallback("K_ANY", iup.KAnyFunc(func(ih iup.Ihandle, c int) int {
@@ -882,7 +1037,7 @@ func onConvert(ih iup.Ihandle) int {
 
 		return iup.DEFAULT
 	})).SetCallback("CLOSE_CB", iup.CloseFunc(func(ih iup.Ihandle) int {
-		if err := os.RemoveAll(conv.Workdir); err != nil {
+		if err := conv.Cleanup(); err != nil {
 			fmt.Println(err)
 		}
 
@@ -890,12 +1045,19 @@ func onConvert(ih iup.Ihandle) int {
 	}))
 
 	go func(c *cbconvert.Converter) {
-		for _, file := range files {
-			if err := c.Convert(file.Path, file.Stat); err != nil {
+		entry := newHistoryEntry(opts, fs)
+
+		for _, file := range fs {
+			c.Opts.Overwrite = opts.Overwrite
+			if policy, ok := policies[file.Path]; ok {
+				c.Opts.Overwrite = policy
+			}
+
+			c.Opts.Password = passwords[file.Path]
+
+			if _, err := c.Convert(file.Path, file.Stat); err != nil {
 				if errors.Is(err, context.Canceled) {
-					if err := os.RemoveAll(c.Workdir); err != nil {
-						fmt.Println(err)
-					}
+					entry.Canceled = true
 
 					break
 				}
@@ -903,22 +1065,21 @@ func onConvert(ih iup.Ihandle) int {
 				iup.PostMessage(iup.GetHandle("dlg"), err.Error(), 0, 0)
 				fmt.Println(err)
 
-				if err := os.RemoveAll(c.Workdir); err != nil {
-					fmt.Println(err)
-				}
+				entry.Errors++
 
 				continue
 			}
 		}
 
+		entry.Duration = time.Since(entry.Time)
+
+		iup.PostMessage(iup.GetHandle("dlg"), "history", 0, entry)
 		iup.PostMessage(iup.GetHandle("ProgressBar"), "finish", 0, 0)
 	}(conv)
-
-	return iup.DEFAULT
 }
 
 func onOutputDirectory(ih iup.Ihandle) int {
-	args, err := fileDlg("Output Directory", false, true)
+	dir, err := dirDlg("Output Directory", iup.GetHandle("OutDir").GetAttribute("VALUE"))
 	if err != nil {
 		iup.PostMessage(iup.GetHandle("dlg"), err.Error(), 0, 0)
 		fmt.Println(err)
@@ -926,8 +1087,16 @@ func onOutputDirectory(ih iup.Ihandle) int {
 		return iup.DEFAULT
 	}
 
-	if len(args) == 1 {
-		iup.GetHandle("OutDir").SetAttribute("VALUE", args[0])
+	if dir != "" {
+		if err := dirWritable(dir); err != nil {
+			iup.PostMessage(iup.GetHandle("dlg"), err.Error(), 0, 0)
+			fmt.Println(err)
+
+			return iup.DEFAULT
+		}
+
+		iup.GetHandle("OutDir").SetAttribute("VALUE", dir)
+		recentAdd(nil, dir)
 	}
 
 	setActive()
@@ -935,6 +1104,18 @@ func onOutputDirectory(ih iup.Ihandle) int {
 	return iup.DEFAULT
 }
 
+// dirWritable checks if files can be created in the directory.
+func dirWritable(dir string) error {
+	f, err := os.CreateTemp(dir, ".cbconvert")
+	if err != nil {
+		return fmt.Errorf("%s: output directory is not writable: %w", dir, err)
+	}
+
+	_ = f.Close()
+
+	return os.Remove(f.Name())
+}
+
 func onFilterChanged(ih iup.Ihandle) int {
 	switch ih.GetInt("VALUE") {
 	case 1:
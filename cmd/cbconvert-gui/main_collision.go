@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/gen2brain/cbconvert"
+	"github.com/gen2brain/iup-go/iup"
+)
+
+// collision type, input whose output file already exists.
+type collision struct {
+	File   cbconvert.File
+	Output string
+	Policy string
+}
+
+// String returns the list item title.
+func (c collision) String() string {
+	return fmt.Sprintf("[%s]  %s  →  %s", c.Policy, c.File.Name, filepath.Base(c.Output))
+}
+
+// collisions returns inputs that would overwrite existing files.
+func collisions(opts cbconvert.Options, fs []cbconvert.File) []collision {
+	conv := cbconvert.New(opts)
+
+	ret := make([]collision, 0)
+	for _, file := range fs {
+		output := conv.OutputName(file.Path)
+		if _, err := os.Stat(output); err == nil {
+			ret = append(ret, collision{File: file, Output: output, Policy: "overwrite"})
+		}
+	}
+
+	return ret
+}
+
+// collisionDlg lets the user choose overwrite policy per file, it returns policies by input path
+// and false if the conversion is canceled.
+func collisionDlg(cs []collision) (map[string]string, bool) {
+	list := iup.List().SetAttributes("EXPAND=YES, MULTIPLE=YES, VISIBLECOLUMNS=40, VISIBLELINES=10")
+
+	update := func() {
+		for idx, c := range cs {
+			list.SetAttribute(fmt.Sprintf("%d", idx+1), c.String())
+		}
+	}
+	update()
+
+	// policy is set for the selected items, all items if nothing is selected
+	setPolicy := func(policy string) iup.ActionFunc {
+		return func(ih iup.Ihandle) int {
+			value := list.GetAttribute("VALUE")
+
+			selected := false
+			for idx := range cs {
+				if idx < len(value) && value[idx] == '+' {
+					selected = true
+					cs[idx].Policy = policy
+				}
+			}
+
+			if !selected {
+				for idx := range cs {
+					cs[idx].Policy = policy
+				}
+			}
+
+			update()
+
+			return iup.DEFAULT
+		}
+	}
+
+	ok := false
+
+	dlg := iup.Dialog(
+		iup.Vbox(
+			iup.Label(fmt.Sprintf("%d output file(s) already exist:", len(cs))),
+			list,
+			iup.Hbox(
+				iup.Button("Skip").SetAttributes("PADDING=DEFAULTBUTTONPADDING").
+					SetAttribute("TIP", "Do not convert the selected files").
+					SetCallback("ACTION", setPolicy("skip")),
+				iup.Button("Overwrite").SetAttributes("PADDING=DEFAULTBUTTONPADDING").
+					SetAttribute("TIP", "Replace the existing files").
+					SetCallback("ACTION", setPolicy("overwrite")),
+				iup.Button("Rename").SetAttributes("PADDING=DEFAULTBUTTONPADDING").
+					SetAttribute("TIP", "Keep the existing files, a number is appended to the new names").
+					SetCallback("ACTION", setPolicy("rename")),
+				iup.Fill(),
+				iup.Button("Convert").SetAttributes("PADDING=DEFAULTBUTTONPADDING").
+					SetCallback("ACTION", iup.ActionFunc(func(ih iup.Ihandle) int {
+						ok = true
+
+						return iup.CLOSE
+					})),
+				iup.Button("Cancel").SetAttributes("PADDING=DEFAULTBUTTONPADDING").
+					SetCallback("ACTION", iup.ActionFunc(func(ih iup.Ihandle) int {
+						return iup.CLOSE
+					})),
+			).SetAttributes("NGAP=5"),
+		).SetAttributes("NMARGIN=5x5, NGAP=5"),
+	).SetAttributes(`TITLE="Existing Files", ICON=logo`)
+	defer dlg.Destroy()
+
+	iup.Popup(dlg, iup.CENTERPARENT, iup.CENTERPARENT)
+
+	policies := make(map[string]string)
+	for _, c := range cs {
+		policies[c.File.Path] = c.Policy
+	}
+
+	return policies, ok
+}
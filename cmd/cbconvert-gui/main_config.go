@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+const (
+	maxRecent  = 10
+	maxHistory = 100
+)
+
+// config type, persisted in the user config directory.
+type config struct {
+	RecentFiles []string       `json:"recentFiles"`
+	RecentDirs  []string       `json:"recentDirs"`
+	History     []historyEntry `json:"history"`
+}
+
+var conf config
+
+// configPath returns path of the config file.
+func configPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("configPath: %w", err)
+	}
+
+	return filepath.Join(dir, "cbconvert", "cbconvert-gui.json"), nil
+}
+
+// loadConfig loads config from the user config directory.
+func loadConfig() error {
+	path, err := configPath()
+	if err != nil {
+		return fmt.Errorf("loadConfig: %w", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+
+		return fmt.Errorf("loadConfig: %w", err)
+	}
+
+	if err := json.Unmarshal(data, &conf); err != nil {
+		return fmt.Errorf("loadConfig: %w", err)
+	}
+
+	historyClean(conf.History)
+
+	return nil
+}
+
+// saveConfig saves config to the user config directory.
+func saveConfig() error {
+	path, err := configPath()
+	if err != nil {
+		return fmt.Errorf("saveConfig: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("saveConfig: %w", err)
+	}
+
+	data, err := json.MarshalIndent(conf, "", "  ")
+	if err != nil {
+		return fmt.Errorf("saveConfig: %w", err)
+	}
+
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("saveConfig: %w", err)
+	}
+
+	return nil
+}
+
+// addRecent moves value to the front of the list, keeping at most maxRecent values.
+func addRecent(list []string, value string) []string {
+	if idx := slices.Index(list, value); idx != -1 {
+		list = slices.Delete(list, idx, idx+1)
+	}
+
+	list = slices.Insert(list, 0, value)
+	if len(list) > maxRecent {
+		list = list[:maxRecent]
+	}
+
+	return list
+}
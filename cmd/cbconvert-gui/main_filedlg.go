@@ -24,7 +24,7 @@ func fileDlg(title string, multiple, directory bool) ([]string, error) {
 		dlg.SetAttributes(map[string]string{
 			"DIALOGTYPE":    "OPEN",
 			"MULTIPLEFILES": mf,
-			"EXTFILTER":     "Comic Files|*.rar;*.zip;*.7z;*.tar;*.cbr;*.cbz;*.cb7;*.cbt;*.pdf;*.epub;*.mobi;*.docx;*.pptx|",
+			"EXTFILTER":     "Comic Files|*.rar;*.zip;*.7z;*.tar;*.cbr;*.cbz;*.cb7;*.cbt;*.cba;*.gz;*.bz2;*.tgz;*.zst;*.xz;*.pdf;*.epub;*.mobi;*.docx;*.pptx;*.djvu|",
 			"FILTER":        "*.cb*", // for Motif
 			"TITLE":         title,
 		})
@@ -63,3 +63,50 @@ func fileDlg(title string, multiple, directory bool) ([]string, error) {
 
 	return ret, nil
 }
+
+func queueDlg(title string, save bool) (string, error) {
+	dlg := iup.FileDlg()
+	defer dlg.Destroy()
+
+	dialogType := "OPEN"
+	extFilter := "Queue Files|*.json|List Files|*.txt;*.m3u;*.m3u8|"
+	if save {
+		dialogType = "SAVE"
+		extFilter = "Queue Files|*.json|"
+	}
+
+	dlg.SetAttributes(map[string]string{
+		"DIALOGTYPE": dialogType,
+		"EXTFILTER":  extFilter,
+		"FILTER":     "*.json", // for Motif
+		"FILE":       "queue.json",
+		"TITLE":      title,
+	})
+
+	iup.Popup(dlg, iup.CENTERPARENT, iup.CENTERPARENT)
+
+	if dlg.GetInt("STATUS") == -1 {
+		return "", nil
+	}
+
+	return dlg.GetAttribute("VALUE"), nil
+}
+
+func dirDlg(title, current string) (string, error) {
+	dlg := iup.FileDlg()
+	defer dlg.Destroy()
+
+	dlg.SetAttributes(map[string]string{
+		"DIALOGTYPE": "DIR",
+		"DIRECTORY":  current,
+		"TITLE":      title,
+	})
+
+	iup.Popup(dlg, iup.CENTERPARENT, iup.CENTERPARENT)
+
+	if dlg.GetInt("STATUS") == -1 {
+		return "", nil
+	}
+
+	return dlg.GetAttribute("VALUE"), nil
+}
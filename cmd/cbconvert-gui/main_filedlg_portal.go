@@ -9,29 +9,6 @@ import (
 )
 
 func fileDlg(title string, multiple, directory bool) ([]string, error) {
-	ret := make([]string, 0)
-
-	conn, err := dbus.ConnectSessionBus()
-	if err != nil {
-		return ret, err
-	}
-	defer conn.Close()
-
-	dest := "org.freedesktop.portal.Desktop"
-	path := "/org/freedesktop/portal/desktop"
-	resp := "org.freedesktop.portal.Request.Response"
-
-	if err = conn.AddMatchSignal(
-		dbus.WithMatchInterface(dest),
-		dbus.WithMatchObjectPath(dbus.ObjectPath(path)),
-		dbus.WithMatchSender(conn.Names()[0]),
-	); err != nil {
-		return ret, err
-	}
-
-	c := make(chan *dbus.Signal, 10)
-	conn.Signal(c)
-
 	type Item struct {
 		Index  uint32
 		Filter string
@@ -54,11 +31,18 @@ func fileDlg(title string, multiple, directory bool) ([]string, error) {
 				Item{0, "*.cbz"},
 				Item{0, "*.cb7"},
 				Item{0, "*.cbt"},
+				Item{0, "*.cba"},
+				Item{0, "*.gz"},
+				Item{0, "*.bz2"},
+				Item{0, "*.tgz"},
+				Item{0, "*.zst"},
+				Item{0, "*.xz"},
 				Item{0, "*.pdf"},
 				Item{0, "*.epub"},
 				Item{0, "*.mobi"},
 				Item{0, "*.docx"},
 				Item{0, "*.pptx"},
+				Item{0, "*.djvu"},
 			},
 		},
 	}
@@ -72,8 +56,93 @@ func fileDlg(title string, multiple, directory bool) ([]string, error) {
 		opts["filters"] = filters
 	}
 
+	return portalFileChooser("OpenFile", title, opts)
+}
+
+func queueDlg(title string, save bool) (string, error) {
+	type Item struct {
+		Index  uint32
+		Filter string
+	}
+
+	type Filter struct {
+		Title   string
+		Filters []Item
+	}
+
+	filters := []Filter{
+		{"Queue Files", []Item{Item{0, "*.json"}}},
+	}
+
+	method := "OpenFile"
+	if !save {
+		filters = append(filters, Filter{"List Files", []Item{Item{0, "*.txt"}, Item{0, "*.m3u"}, Item{0, "*.m3u8"}}})
+	}
+
+	opts := map[string]any{
+		"filters": filters,
+	}
+
+	if save {
+		method = "SaveFile"
+		opts["current_name"] = "queue.json"
+	}
+
+	ret, err := portalFileChooser(method, title, opts)
+	if err != nil || len(ret) == 0 {
+		return "", err
+	}
+
+	return ret[0], nil
+}
+
+// dirDlg selects directory, the portal grants access to it for the sandboxed application.
+func dirDlg(title, current string) (string, error) {
+	opts := map[string]any{
+		"directory":    true,
+		"accept_label": "Select",
+	}
+
+	if current != "" {
+		// path is a null-terminated byte array
+		opts["current_folder"] = append([]byte(current), 0)
+	}
+
+	ret, err := portalFileChooser("OpenFile", title, opts)
+	if err != nil || len(ret) == 0 {
+		return "", err
+	}
+
+	return ret[0], nil
+}
+
+// portalFileChooser calls FileChooser method and waits for the response with selected paths.
+func portalFileChooser(method, title string, opts map[string]any) ([]string, error) {
+	ret := make([]string, 0)
+
+	conn, err := dbus.ConnectSessionBus()
+	if err != nil {
+		return ret, err
+	}
+	defer conn.Close()
+
+	dest := "org.freedesktop.portal.Desktop"
+	path := "/org/freedesktop/portal/desktop"
+	resp := "org.freedesktop.portal.Request.Response"
+
+	if err = conn.AddMatchSignal(
+		dbus.WithMatchInterface(dest),
+		dbus.WithMatchObjectPath(dbus.ObjectPath(path)),
+		dbus.WithMatchSender(conn.Names()[0]),
+	); err != nil {
+		return ret, err
+	}
+
+	c := make(chan *dbus.Signal, 10)
+	conn.Signal(c)
+
 	obj := conn.Object(dest, dbus.ObjectPath(path))
-	call := obj.Call("org.freedesktop.portal.FileChooser.OpenFile", 0, "", title, opts)
+	call := obj.Call("org.freedesktop.portal.FileChooser."+method, 0, "", title, opts)
 	if call.Err != nil {
 		return ret, call.Err
 	}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/gen2brain/cbconvert"
+	"github.com/gen2brain/iup-go/iup"
+)
+
+// historyEntry type, completed conversion job. Only the options that affect the output are saved, credentials
+// (Password, ComicVineKey) and other runtime options are taken from the current settings on Convert Again.
+type historyEntry struct {
+	Time     time.Time         `json:"time"`
+	Files    []string          `json:"files"`
+	Options  cbconvert.Options `json:"options"`
+	OutDir   string            `json:"outDir"`
+	Errors   int               `json:"errors"`
+	Canceled bool              `json:"canceled"`
+	Duration time.Duration     `json:"duration"`
+}
+
+func newHistoryEntry(opts cbconvert.Options, fs []cbconvert.File) historyEntry {
+	entry := historyEntry{
+		Time:    time.Now(),
+		Files:   make([]string, 0, len(fs)),
+		Options: outputOptions(opts),
+		OutDir:  opts.OutDir,
+	}
+
+	for _, file := range fs {
+		entry.Files = append(entry.Files, file.Path)
+	}
+
+	return entry
+}
+
+// outputOptions returns options that affect the converted output, other options are zero.
+func outputOptions(opts cbconvert.Options) cbconvert.Options {
+	return cbconvert.Options{}.Preset(opts)
+}
+
+// historyClean drops the options that are not saved from entries written by older versions.
+func historyClean(history []historyEntry) {
+	for idx, entry := range history {
+		if entry.OutDir == "" {
+			entry.OutDir = entry.Options.OutDir
+		}
+
+		entry.Options = outputOptions(entry.Options)
+		history[idx] = entry
+	}
+}
+
+// String returns the list item title.
+func (h historyEntry) String() string {
+	result := "OK"
+	switch {
+	case h.Canceled:
+		result = "Canceled"
+	case h.Errors > 0:
+		result = fmt.Sprintf("%d Failed", h.Errors)
+	}
+
+	return fmt.Sprintf("%s  %d file(s)  %s/%s  %s (%s)", h.Time.Format("2006-01-02 15:04"), len(h.Files),
+		h.Options.Format, h.Options.Archive, result, h.Duration.Round(time.Second))
+}
+
+// historyAdd adds entry to the history and saves the config.
+func historyAdd(entry historyEntry) {
+	conf.History = append([]historyEntry{entry}, conf.History...)
+	if len(conf.History) > maxHistory {
+		conf.History = conf.History[:maxHistory]
+	}
+
+	if err := saveConfig(); err != nil {
+		fmt.Println(err)
+	}
+}
+
+func onHistory(ih iup.Ihandle) int {
+	list := iup.List().SetAttributes("EXPAND=YES, VISIBLECOLUMNS=40, VISIBLELINES=10").SetHandle("HistoryList")
+	for idx, entry := range conf.History {
+		list.SetAttribute(fmt.Sprintf("%d", idx+1), entry.String())
+	}
+
+	dlg := iup.Dialog(
+		iup.Vbox(
+			list,
+			iup.Label("").SetAttributes("EXPAND=HORIZONTAL").SetHandle("HistoryInfo"),
+			iup.Hbox(
+				iup.Fill(),
+				iup.Button("Convert Again").SetAttributes("PADDING=DEFAULTBUTTONPADDING").
+					SetAttribute("TIP", "Convert the same files with the same settings").
+					SetCallback("ACTION", iup.ActionFunc(func(ih iup.Ihandle) int {
+						idx := list.GetInt("VALUE") - 1
+						if idx < 0 || idx >= len(conf.History) {
+							return iup.DEFAULT
+						}
+
+						entry := conf.History[idx]
+
+						opts := options().Preset(entry.Options)
+						opts.OutDir = entry.OutDir
+
+						conv := cbconvert.New(opts)
+						fs, err := conv.Files(entry.Files)
+						if err != nil {
+							iup.PostMessage(iup.GetHandle("dlg"), err.Error(), 0, 0)
+							fmt.Println(err)
+
+							return iup.CLOSE
+						}
+
+						if _, err := os.Stat(opts.OutDir); err != nil {
+							iup.PostMessage(iup.GetHandle("dlg"), err.Error(), 0, 0)
+							fmt.Println(err)
+
+							return iup.CLOSE
+						}
+
+						pw, fs, ok := passwords(opts, fs)
+						if !ok {
+							return iup.CLOSE
+						}
+
+						convert(opts, fs, nil, pw)
+
+						return iup.CLOSE
+					})),
+				iup.Button("Clear").SetAttributes("PADDING=DEFAULTBUTTONPADDING").
+					SetCallback("ACTION", iup.ActionFunc(func(ih iup.Ihandle) int {
+						conf.History = nil
+						list.SetAttribute("REMOVEITEM", "ALL")
+
+						if err := saveConfig(); err != nil {
+							fmt.Println(err)
+						}
+
+						return iup.DEFAULT
+					})),
+				iup.Button("Close").SetAttributes("PADDING=DEFAULTBUTTONPADDING").
+					SetCallback("ACTION", iup.ActionFunc(func(ih iup.Ihandle) int {
+						return iup.CLOSE
+					})),
+			).SetAttributes("NGAP=5"),
+		).SetAttributes("NMARGIN=5x5, NGAP=5"),
+	).SetAttributes(`TITLE="History", ICON=logo`)
+	defer dlg.Destroy()
+
+	list.SetCallback("ACTION", iup.ListActionFunc(func(ih iup.Ihandle, text string, item int, state int) int {
+		if state == 1 && item-1 < len(conf.History) {
+			entry := conf.History[item-1]
+			iup.GetHandle("HistoryInfo").SetAttribute("TITLE", fmt.Sprintf("Output: %s", entry.OutDir))
+		}
+
+		return iup.DEFAULT
+	}))
+
+	iup.Popup(dlg, iup.CENTERPARENT, iup.CENTERPARENT)
+
+	return iup.DEFAULT
+}
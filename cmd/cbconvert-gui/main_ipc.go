@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gen2brain/iup-go/iup"
+)
+
+// ipcAddr returns the path of the local socket used by the running instance.
+func ipcAddr() string {
+	return filepath.Join(os.TempDir(), fmt.Sprintf("cbconvert-gui-%d.sock", os.Getuid()))
+}
+
+// ipcSend forwards file arguments to the running instance, it returns false if there is no running instance.
+func ipcSend(args []string) bool {
+	conn, err := net.DialTimeout("unix", ipcAddr(), time.Second)
+	if err != nil {
+		return false
+	}
+	defer conn.Close()
+
+	w := bufio.NewWriter(conn)
+	for _, arg := range args {
+		path, err := filepath.Abs(arg)
+		if err != nil {
+			path = arg
+		}
+
+		if _, err := w.WriteString(path + "\n"); err != nil {
+			fmt.Println(err)
+
+			return false
+		}
+	}
+
+	if err := w.Flush(); err != nil {
+		fmt.Println(err)
+
+		return false
+	}
+
+	return true
+}
+
+// ipcListen listens for file arguments sent by other instances and appends them to the queue.
+func ipcListen() (net.Listener, error) {
+	addr := ipcAddr()
+
+	// remove stale socket left by a crashed instance
+	if err := os.Remove(addr); err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("ipcListen: %w", err)
+	}
+
+	ln, err := net.Listen("unix", addr)
+	if err != nil {
+		return nil, fmt.Errorf("ipcListen: %w", err)
+	}
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+
+			args := make([]string, 0)
+			scanner := bufio.NewScanner(conn)
+			for scanner.Scan() {
+				if text := strings.TrimSpace(scanner.Text()); text != "" {
+					args = append(args, text)
+				}
+			}
+
+			_ = conn.Close()
+
+			if len(args) > 0 {
+				iup.PostMessage(iup.GetHandle("List"), strings.Join(args, "\n"), 0, 0)
+			}
+
+			iup.PostMessage(iup.GetHandle("dlg"), "raise", 0, 0)
+		}
+	}()
+
+	return ln, nil
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/gen2brain/cbconvert"
+	"github.com/gen2brain/iup-go/iup"
+)
+
+// protectable checks if the input can be password protected (ZIP and RAR archives).
+func protectable(fileName string) bool {
+	return slices.Contains([]string{".cbz", ".zip", ".cbr", ".rar"}, strings.ToLower(filepath.Ext(fileName)))
+}
+
+// passwords asks for passwords of the protected archives, password applied to all is tried first for the rest of
+// the batch. It returns passwords by input path, the files without skipped archives and false if the conversion
+// is canceled.
+func passwords(opts cbconvert.Options, fs []cbconvert.File) (map[string]string, []cbconvert.File, bool) {
+	conv := cbconvert.New(opts)
+
+	ret := make(map[string]string)
+	queued := make([]cbconvert.File, 0, len(fs))
+
+	var all string
+	for _, file := range fs {
+		if !protectable(file.Path) {
+			queued = append(queued, file)
+
+			continue
+		}
+
+		password, skip := all, false
+		for {
+			conv.Opts.Password = password
+
+			// other errors are reported by the conversion
+			_, err := conv.ListPages(file.Path, file.Stat, false)
+			if !errors.Is(err, cbconvert.ErrEncrypted) && !errors.Is(err, cbconvert.ErrPassword) {
+				break
+			}
+
+			p, applyAll, ok := passwordDlg(file.Name, errors.Is(err, cbconvert.ErrPassword))
+			if !ok {
+				return nil, nil, false
+			}
+
+			if p == "" {
+				skip = true
+
+				break
+			}
+
+			if applyAll {
+				all = p
+			}
+
+			password = p
+		}
+
+		if skip {
+			continue
+		}
+
+		if password != "" {
+			ret[file.Path] = password
+		}
+
+		queued = append(queued, file)
+	}
+
+	return ret, queued, true
+}
+
+// passwordDlg asks for the password of the protected archive, it returns the password, if it applies to all
+// protected archives of the batch and false if the conversion is canceled. Empty password skips the archive.
+func passwordDlg(name string, wrong bool) (string, bool, bool) {
+	text := iup.Text().SetAttributes("PASSWORD=YES, VISIBLECOLUMNS=30, EXPAND=HORIZONTAL")
+	all := iup.Toggle(" Apply to All").SetAttribute("TIP", "Use the password for the other protected archives of the batch")
+
+	label := fmt.Sprintf("%s is password protected:", name)
+	if wrong {
+		label = fmt.Sprintf("Wrong password for %s:", name)
+	}
+
+	var password string
+	ok := false
+
+	dlg := iup.Dialog(
+		iup.Vbox(
+			iup.Label(label),
+			text,
+			all,
+			iup.Hbox(
+				iup.Fill(),
+				iup.Button("OK").SetAttributes("PADDING=DEFAULTBUTTONPADDING").
+					SetCallback("ACTION", iup.ActionFunc(func(ih iup.Ihandle) int {
+						password = text.GetAttribute("VALUE")
+						ok = true
+
+						return iup.CLOSE
+					})),
+				iup.Button("Skip").SetAttributes("PADDING=DEFAULTBUTTONPADDING").
+					SetAttribute("TIP", "Do not convert the archive").
+					SetCallback("ACTION", iup.ActionFunc(func(ih iup.Ihandle) int {
+						ok = true
+
+						return iup.CLOSE
+					})),
+				iup.Button("Cancel").SetAttributes("PADDING=DEFAULTBUTTONPADDING").
+					SetCallback("ACTION", iup.ActionFunc(func(ih iup.Ihandle) int {
+						return iup.CLOSE
+					})),
+			).SetAttributes("NGAP=5"),
+		).SetAttributes("NMARGIN=5x5, NGAP=5"),
+	).SetAttributes(`TITLE="Password", ICON=logo`)
+	defer dlg.Destroy()
+
+	iup.Popup(dlg, iup.CENTERPARENT, iup.CENTERPARENT)
+
+	return password, ok && password != "" && all.GetAttribute("VALUE") == "ON", ok
+}
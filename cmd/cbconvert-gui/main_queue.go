@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gen2brain/cbconvert"
+	"github.com/gen2brain/iup-go/iup"
+)
+
+// queue type, saved queue of input files.
+type queue struct {
+	Files  []string `json:"files"`
+	OutDir string   `json:"outDir,omitempty"`
+}
+
+// queueSave writes current queue to the file.
+func queueSave(path string) error {
+	q := queue{
+		Files:  make([]string, 0, len(files)),
+		OutDir: iup.GetHandle("OutDir").GetAttribute("VALUE"),
+	}
+
+	for _, file := range files {
+		q.Files = append(q.Files, file.Path)
+	}
+
+	data, err := json.MarshalIndent(q, "", "  ")
+	if err != nil {
+		return fmt.Errorf("queueSave: %w", err)
+	}
+
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("queueSave: %w", err)
+	}
+
+	return nil
+}
+
+// queueLoad adds files from the queue file, or from the list file with one path per line (i.e. list.txt, M3U playlist),
+// files that no longer exist are returned.
+func queueLoad(path string) ([]string, error) {
+	var q queue
+	if strings.ToLower(filepath.Ext(path)) == ".json" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("queueLoad: %w", err)
+		}
+
+		if err := json.Unmarshal(data, &q); err != nil {
+			return nil, fmt.Errorf("queueLoad: %w", err)
+		}
+	} else {
+		paths, err := cbconvert.ReadListFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("queueLoad: %w", err)
+		}
+
+		q.Files = paths
+	}
+
+	args := make([]string, 0, len(q.Files))
+	missing := make([]string, 0)
+	for _, file := range q.Files {
+		if _, err := os.Stat(file); err != nil {
+			missing = append(missing, file)
+
+			continue
+		}
+
+		args = append(args, file)
+	}
+
+	if len(args) > 0 {
+		addFiles(args)
+	}
+
+	if q.OutDir != "" {
+		if _, err := os.Stat(q.OutDir); err == nil {
+			iup.GetHandle("OutDir").SetAttribute("VALUE", q.OutDir)
+			setActive()
+		}
+	}
+
+	return missing, nil
+}
+
+func onQueueExport(ih iup.Ihandle) int {
+	path, err := queueDlg("Export Queue", true)
+	if err != nil {
+		iup.PostMessage(iup.GetHandle("dlg"), err.Error(), 0, 0)
+		fmt.Println(err)
+
+		return iup.DEFAULT
+	}
+
+	if path == "" {
+		return iup.DEFAULT
+	}
+
+	if err := queueSave(path); err != nil {
+		iup.PostMessage(iup.GetHandle("dlg"), err.Error(), 0, 0)
+		fmt.Println(err)
+	}
+
+	return iup.DEFAULT
+}
+
+func onQueueImport(ih iup.Ihandle) int {
+	path, err := queueDlg("Import Queue", false)
+	if err != nil {
+		iup.PostMessage(iup.GetHandle("dlg"), err.Error(), 0, 0)
+		fmt.Println(err)
+
+		return iup.DEFAULT
+	}
+
+	if path == "" {
+		return iup.DEFAULT
+	}
+
+	missing, err := queueLoad(path)
+	if err != nil {
+		iup.PostMessage(iup.GetHandle("dlg"), err.Error(), 0, 0)
+		fmt.Println(err)
+
+		return iup.DEFAULT
+	}
+
+	if len(missing) > 0 {
+		iup.MessageError(iup.GetHandle("dlg"), fmt.Sprintf("Files not found:\n\n%s", strings.Join(missing, "\n")))
+	}
+
+	return iup.DEFAULT
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/gen2brain/iup-go/iup"
+)
+
+// recentAdd remembers input files and output directory.
+func recentAdd(args []string, outDir string) {
+	for _, arg := range args {
+		conf.RecentFiles = addRecent(conf.RecentFiles, arg)
+	}
+
+	if outDir != "" {
+		conf.RecentDirs = addRecent(conf.RecentDirs, outDir)
+	}
+
+	if err := saveConfig(); err != nil {
+		fmt.Println(err)
+	}
+}
+
+// recentMenu returns popup menu with recent input files and output directories.
+func recentMenu() iup.Ihandle {
+	itemsFiles := make([]iup.Ihandle, 0)
+	for _, file := range conf.RecentFiles {
+		itemsFiles = append(itemsFiles, iup.Item(file).SetCallback("ACTION", iup.ActionFunc(func(ih iup.Ihandle) int {
+			if _, err := os.Stat(file); err != nil {
+				iup.PostMessage(iup.GetHandle("dlg"), err.Error(), 0, 0)
+				fmt.Println(err)
+
+				return iup.DEFAULT
+			}
+
+			addFiles([]string{file})
+
+			return iup.DEFAULT
+		})))
+	}
+
+	itemsDirs := make([]iup.Ihandle, 0)
+	for _, dir := range conf.RecentDirs {
+		itemsDirs = append(itemsDirs, iup.Item(dir).SetCallback("ACTION", iup.ActionFunc(func(ih iup.Ihandle) int {
+			iup.GetHandle("OutDir").SetAttribute("VALUE", dir)
+			recentAdd(nil, dir)
+			setActive()
+
+			return iup.DEFAULT
+		})))
+	}
+
+	if len(itemsFiles) == 0 {
+		itemsFiles = append(itemsFiles, iup.Item("(Empty)").SetAttribute("ACTIVE", "NO"))
+	}
+
+	if len(itemsDirs) == 0 {
+		itemsDirs = append(itemsDirs, iup.Item("(Empty)").SetAttribute("ACTIVE", "NO"))
+	}
+
+	return iup.Menu(
+		iup.Submenu("Files", iup.Menu(itemsFiles...)),
+		iup.Submenu("Output Directories", iup.Menu(itemsDirs...)),
+		iup.Separator(),
+		iup.Item("Clear Recent").SetCallback("ACTION", iup.ActionFunc(func(ih iup.Ihandle) int {
+			conf.RecentFiles = nil
+			conf.RecentDirs = nil
+
+			if err := saveConfig(); err != nil {
+				fmt.Println(err)
+			}
+
+			return iup.DEFAULT
+		})),
+	)
+}
+
+func onRecent(ih iup.Ihandle) int {
+	menu := recentMenu()
+	defer menu.Destroy()
+
+	iup.Popup(menu, iup.MOUSEPOS, iup.MOUSEPOS)
+
+	return iup.DEFAULT
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"crypto/md5"
+	"fmt"
+	"image"
+	"sync"
+
+	"github.com/gen2brain/cbconvert"
+	"github.com/gen2brain/iup-go/iup"
+	"golang.org/x/image/draw"
+)
+
+const thumbSize = 32
+
+var (
+	thumbMu    sync.Mutex
+	thumbQueue []cbconvert.File
+	thumbWake  = make(chan struct{}, 1)
+	thumbCache sync.Map
+)
+
+// thumbAdd queues the file for thumbnail extraction, it never blocks and files are not dropped.
+func thumbAdd(file cbconvert.File) {
+	thumbMu.Lock()
+	thumbQueue = append(thumbQueue, file)
+	thumbMu.Unlock()
+
+	// wake-ups are coalesced, the worker drains the whole queue
+	select {
+	case thumbWake <- struct{}{}:
+	default:
+	}
+}
+
+// thumbNext returns the next queued file.
+func thumbNext() (cbconvert.File, bool) {
+	thumbMu.Lock()
+	defer thumbMu.Unlock()
+
+	if len(thumbQueue) == 0 {
+		return cbconvert.File{}, false
+	}
+
+	file := thumbQueue[0]
+	thumbQueue = thumbQueue[1:]
+
+	return file, true
+}
+
+// thumbImage scales the cover to fit in thumbSize.
+func thumbImage(img image.Image) image.Image {
+	b := img.Bounds()
+	if b.Empty() {
+		return img
+	}
+
+	width, height := thumbSize, thumbSize
+	if b.Dx() > b.Dy() {
+		height = max(1, b.Dy()*thumbSize/b.Dx())
+	} else {
+		width = max(1, b.Dx()*thumbSize/b.Dy())
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.ApproxBiLinear.Scale(dst, dst.Bounds(), img, b, draw.Src, nil)
+
+	return dst
+}
+
+// thumbName returns IUP image handle name for the file.
+func thumbName(file cbconvert.File) string {
+	return fmt.Sprintf("thumb%x", md5.Sum([]byte(fmt.Sprintf("%s%d", file.Path, file.Stat.ModTime().UnixNano()))))
+}
+
+// thumbWorker extracts cover thumbnails of queued files in the background, covers are read through the converter cover cache.
+func thumbWorker() {
+	conv := cbconvert.New(cbconvert.NewOptions())
+
+	for range thumbWake {
+		for {
+			file, ok := thumbNext()
+			if !ok {
+				break
+			}
+
+			name := thumbName(file)
+			if _, ok := thumbCache.Load(name); ok {
+				iup.PostMessage(iup.GetHandle("List"), file.Path, 0, name)
+
+				continue
+			}
+
+			img, err := conv.CoverImage(file.Path, file.Stat)
+			if err != nil {
+				fmt.Println(err)
+
+				continue
+			}
+
+			iup.PostMessage(iup.GetHandle("List"), file.Path, 0, thumbImage(img))
+		}
+	}
+}
+
+// thumbSet sets the list item image for the file.
+func thumbSet(path string, p any) {
+	var name string
+
+	switch v := p.(type) {
+	case string:
+		name = v
+	case image.Image:
+		for _, file := range files {
+			if file.Path == path {
+				name = thumbName(file)
+
+				break
+			}
+		}
+
+		if name == "" {
+			return
+		}
+
+		if _, ok := thumbCache.Load(name); !ok {
+			iup.ImageFromImage(v).SetHandle(name)
+			thumbCache.Store(name, true)
+		}
+	}
+
+	for idx, file := range files {
+		if file.Path == path {
+			iup.GetHandle("List").SetAttribute(fmt.Sprintf("IMAGE%d", idx+1), name)
+		}
+	}
+}
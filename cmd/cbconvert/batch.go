@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/gen2brain/cbconvert"
+)
+
+// Exit codes of batchdir command.
+const (
+	// All files are converted
+	batchOK = 0
+	// Some files failed
+	batchFailed = 1
+	// All files failed, or the batch could not start
+	batchFatal = 2
+)
+
+// runBatch converts all files found in dir to Options.OutDir keeping the relative structure,
+// JSON logs are written to stdout. It returns the exit code.
+func runBatch(conv *cbconvert.Converter, dir string) int {
+	out := conv.Opts.OutDir
+
+	logger := batchLogger(conv.Opts.LogLevel)
+	if conv.Opts.LogLevel > cbconvert.LogSilent {
+		conv.Logger = logger
+	}
+
+	start := time.Now()
+
+	root, err := filepath.Abs(dir)
+	if err != nil {
+		logger.Error("batch failed", "error", err)
+
+		return batchFatal
+	}
+
+	files, err := conv.Files([]string{root})
+	if err != nil {
+		logger.Error("batch failed", "input", root, "error", err)
+
+		return batchFatal
+	}
+
+	logger.Info("batch started", "input", root, "output", out, "files", len(files))
+
+	if dbusProgress {
+		hide, err := progressDBus(conv)
+		if err != nil {
+			logger.Error("progress failed", "error", err)
+		} else {
+			defer hide()
+		}
+	}
+
+	var failed int
+	for _, file := range files {
+		rel, err := filepath.Rel(root, filepath.Dir(file.Path))
+		if err != nil {
+			rel = "."
+		}
+
+		conv.Opts, err = sidecarOptions(file.Path)
+		if err == nil {
+			conv.Opts.OutDir = filepath.Join(out, rel)
+			// output directory is set per file, Recursive is only needed to find the files
+			conv.Opts.Recursive = false
+
+			err = os.MkdirAll(conv.Opts.OutDir, 0775)
+		}
+
+		if err == nil {
+			_, err = conv.Convert(file.Path, file.Stat)
+		}
+
+		if err != nil {
+			failed++
+			logger.Error("convert failed", "file", file.Path, "error", err)
+
+			if errors.Is(err, context.Canceled) {
+				break
+			}
+
+			continue
+		}
+
+		logger.Info("converted", "file", file.Path, "outdir", conv.Opts.OutDir)
+	}
+
+	stats := conv.Stats()
+	logger.Info("batch finished", "files", len(files), "converted", stats.Files, "failed", failed, "pages", stats.Pages,
+		"bytes_in", stats.BytesIn, "bytes_out", stats.BytesOut, "duration", time.Since(start).Round(time.Millisecond).String())
+
+	if metricsFile != "" {
+		if err := writeMetrics(stats, metricsFile); err != nil {
+			logger.Error("metrics failed", "error", err)
+		}
+	}
+
+	switch {
+	case failed == 0:
+		return batchOK
+	case failed < len(files):
+		return batchFailed
+	default:
+		return batchFatal
+	}
+}
+
+// batchLogger returns JSON logger writing to stdout, errors level prints only errors.
+func batchLogger(level cbconvert.LogLevel) *slog.Logger {
+	var w io.Writer = os.Stdout
+	if level == cbconvert.LogSilent {
+		w = io.Discard
+	}
+
+	opts := &slog.HandlerOptions{Level: slog.LevelInfo}
+	switch {
+	case level == cbconvert.LogErrors:
+		opts.Level = slog.LevelError
+	case level >= cbconvert.LogDebug:
+		opts.Level = slog.LevelDebug
+	}
+
+	return slog.New(slog.NewJSONHandler(w, opts))
+}
@@ -0,0 +1,285 @@
+// Package cli implements simple subcommand parsing on top of the flag package.
+package cli
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// ErrNoCommand is returned when the command is not found.
+var ErrNoCommand = errors.New("no command")
+
+// Command type.
+type Command struct {
+	// Command name
+	Name string
+	// Command aliases, i.e. c for convert
+	Aliases []string
+	// Short description
+	Description string
+	// Usage line, printed after the command name, App.UsageLine is used if empty
+	UsageLine string
+	// Flags, created by App.Add, flags are defined when the command is selected by App.Parse
+	Flags *flag.FlagSet
+	// Order of flags in usage, all flags are printed in lexical order if empty
+	Order []string
+
+	app     *App
+	fn      func(fs *flag.FlagSet)
+	defined bool
+}
+
+// shared type, flags added to the named commands.
+type shared struct {
+	fn    func(fs *flag.FlagSet)
+	names []string
+}
+
+func (s shared) match(name string) bool {
+	if len(s.names) == 0 {
+		return true
+	}
+
+	for _, n := range s.names {
+		if n == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// App type.
+type App struct {
+	// Program name
+	Name string
+	// Usage line, printed after the program name
+	UsageLine string
+	// Command used when the first argument is a flag
+	Default string
+	// Output for usage, os.Stderr if nil
+	Output io.Writer
+	// Prefix of environment variables used as flag defaults, i.e. APP_ for APP_OUTDIR
+	EnvPrefix string
+	// Translate function, returns translated usage text (descriptions, flag usage and labels), text is printed as is if nil
+	Translate func(s string) string
+
+	commands []*Command
+	shared   []shared
+}
+
+// New returns new App.
+func New(name, usageLine string) *App {
+	return &App{Name: name, UsageLine: usageLine}
+}
+
+// Shared registers function that adds flags to the named commands, or to every command if names are empty.
+// Flags are also added to matching commands added later.
+func (a *App) Shared(fn func(fs *flag.FlagSet), names ...string) {
+	s := shared{fn, names}
+	a.shared = append(a.shared, s)
+
+	for _, cmd := range a.commands {
+		if cmd.defined && s.match(cmd.Name) {
+			fn(cmd.Flags)
+		}
+	}
+}
+
+// Add adds command, flags are added with fn.
+// Flags are defined only for the command selected by Parse, flag defaults bound to the same variables
+// in other commands are not applied.
+func (a *App) Add(name, description string, aliases []string, fn func(fs *flag.FlagSet)) *Command {
+	cmd := &Command{Name: name, Aliases: aliases, Description: description, app: a, fn: fn}
+	cmd.Flags = flag.NewFlagSet(name, flag.ExitOnError)
+	cmd.Flags.Usage = func() {
+		usageLine := cmd.UsageLine
+		if usageLine == "" {
+			usageLine = a.UsageLine
+		}
+
+		fmt.Fprintf(a.output(), "%s %s %s %s\n", a.tr("Usage:"), a.Name, cmd.Name, a.tr(usageLine))
+		cmd.Usage(a.output())
+	}
+
+	a.commands = append(a.commands, cmd)
+
+	return cmd
+}
+
+// Commands returns all commands.
+func (a *App) Commands() []*Command {
+	return a.commands
+}
+
+// Lookup returns command by name or alias.
+func (a *App) Lookup(name string) *Command {
+	for _, cmd := range a.commands {
+		if cmd.Name == name {
+			return cmd
+		}
+
+		for _, alias := range cmd.Aliases {
+			if alias == name {
+				return cmd
+			}
+		}
+	}
+
+	return nil
+}
+
+// Parse parses arguments (without program name), it returns the command and remaining arguments.
+func (a *App) Parse(args []string) (*Command, []string, error) {
+	if len(args) == 0 {
+		return nil, nil, ErrNoCommand
+	}
+
+	name := args[0]
+	if strings.HasPrefix(name, "-") && name != "-h" && name != "--help" && name != "-help" && a.Default != "" {
+		name = a.Default
+	} else {
+		args = args[1:]
+	}
+
+	cmd := a.Lookup(name)
+	if cmd == nil {
+		return nil, nil, fmt.Errorf("%w: %s", ErrNoCommand, name)
+	}
+
+	cmd.define()
+
+	if err := a.setEnv(cmd); err != nil {
+		return cmd, nil, err
+	}
+
+	if err := cmd.Flags.Parse(args); err != nil {
+		return cmd, nil, err
+	}
+
+	return cmd, cmd.Flags.Args(), nil
+}
+
+// Usage prints usage for all commands, flags of all commands are defined.
+func (a *App) Usage() {
+	w := a.output()
+
+	fmt.Fprintf(w, "%s %s %s %s\n\n", a.tr("Usage:"), a.Name, a.tr("<command>"), a.tr(a.UsageLine))
+	fmt.Fprintf(w, "\n%s\n", a.tr("Commands:"))
+
+	for _, cmd := range a.commands {
+		cmd.Usage(w)
+	}
+}
+
+// Usage prints command description and flags.
+func (c *Command) Usage(w io.Writer) {
+	name := c.Name
+	if len(c.Aliases) > 0 {
+		name = fmt.Sprintf("%s (%s)", c.Name, strings.Join(c.Aliases, ", "))
+	}
+
+	fmt.Fprintf(w, "\n  %s\n    \t%s\n\n", name, c.app.tr(c.Description))
+
+	c.define()
+
+	printFlag := func(f *flag.Flag) {
+		fmt.Fprintf(w, "    --%s\n    \t", f.Name)
+		fmt.Fprintf(w, "%v (%s %q)\n", c.app.tr(f.Usage), c.app.tr("default"), f.DefValue)
+	}
+
+	if len(c.Order) == 0 {
+		c.Flags.VisitAll(printFlag)
+
+		return
+	}
+
+	for _, name := range c.Order {
+		if f := c.Flags.Lookup(name); f != nil {
+			printFlag(f)
+		}
+	}
+}
+
+// SetValues sets command flags from values, i.e. decoded from a config file. Values are formatted with fmt.Sprint,
+// source is used in error messages.
+func (c *Command) SetValues(values map[string]any, source string) error {
+	c.define()
+
+	for name, value := range values {
+		if c.Flags.Lookup(name) == nil {
+			return fmt.Errorf("%s: unknown flag %s", source, name)
+		}
+
+		if err := c.Flags.Set(name, fmt.Sprint(value)); err != nil {
+			return fmt.Errorf("%s: %s: %w", source, name, err)
+		}
+	}
+
+	return nil
+}
+
+// define adds command flags and shared flags, flag defaults are written to the bound variables once.
+func (c *Command) define() {
+	if c.defined {
+		return
+	}
+	c.defined = true
+
+	if c.fn != nil {
+		c.fn(c.Flags)
+	}
+
+	for _, s := range c.app.shared {
+		if s.match(c.Name) {
+			s.fn(c.Flags)
+		}
+	}
+}
+
+// EnvName returns environment variable name for the flag, i.e. APP_POST_CMD for post-cmd.
+func (a *App) EnvName(flagName string) string {
+	return a.EnvPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// setEnv sets command flags from environment variables, flags on the command line take precedence.
+func (a *App) setEnv(cmd *Command) error {
+	if a.EnvPrefix == "" {
+		return nil
+	}
+
+	var err error
+	cmd.Flags.VisitAll(func(f *flag.Flag) {
+		value, ok := os.LookupEnv(a.EnvName(f.Name))
+		if !ok || err != nil {
+			return
+		}
+
+		if e := cmd.Flags.Set(f.Name, value); e != nil {
+			err = fmt.Errorf("%s: %w", a.EnvName(f.Name), e)
+		}
+	})
+
+	return err
+}
+
+// tr returns translated text.
+func (a *App) tr(s string) string {
+	if a == nil || a.Translate == nil {
+		return s
+	}
+
+	return a.Translate(s)
+}
+
+func (a *App) output() io.Writer {
+	if a.Output == nil {
+		return os.Stderr
+	}
+
+	return a.Output
+}
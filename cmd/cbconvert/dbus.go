@@ -0,0 +1,70 @@
+//go:build linux || freebsd || netbsd || openbsd || dragonfly
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+
+	"github.com/gen2brain/cbconvert"
+	"github.com/godbus/dbus/v5"
+)
+
+// launcherURI is the desktop file ID used by docks and taskbars to match the launcher entry.
+const launcherURI = "application://cbconvert.desktop"
+
+// progressDBus reports conversion progress with com.canonical.Unity.LauncherEntry signals on the session bus,
+// supported by KDE Plasma and GNOME docks. It returns function that hides the progress.
+func progressDBus(conv *cbconvert.Converter) (func(), error) {
+	conn, err := dbus.ConnectSessionBus()
+	if err != nil {
+		return nil, fmt.Errorf("progressDBus: %w", err)
+	}
+
+	path := dbus.ObjectPath(fmt.Sprintf("/com/canonical/unity/launcherentry/%d", os.Getpid()))
+
+	update := func(progress float64, remaining int, visible bool) {
+		_ = conn.Emit(path, "com.canonical.Unity.LauncherEntry.Update", launcherURI, map[string]dbus.Variant{
+			"progress":         dbus.MakeVariant(progress),
+			"progress-visible": dbus.MakeVariant(visible),
+			"count":            dbus.MakeVariant(int64(max(remaining, 0))),
+			"count-visible":    dbus.MakeVariant(visible && conv.Nfiles > 1),
+		})
+	}
+
+	var mu sync.Mutex
+	last := -1
+
+	onProgress := conv.OnProgress
+	conv.OnProgress = func(job *cbconvert.Job) {
+		if onProgress != nil {
+			onProgress(job)
+		}
+
+		if job.Nfiles == 0 {
+			return
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		done := float64(job.CurrFile - 1)
+		if job.Ncontents > 0 {
+			done += float64(atomic.LoadInt32(&job.CurrContent)) / float64(job.Ncontents)
+		}
+		progress := min(done/float64(job.Nfiles), 1)
+
+		// signals are sent only when the percentage changes
+		if percent := int(progress * 100); percent != last {
+			last = percent
+			update(progress, job.Nfiles-job.CurrFile+1, true)
+		}
+	}
+
+	return func() {
+		update(0, 0, false)
+		_ = conn.Close()
+	}, nil
+}
@@ -0,0 +1,14 @@
+//go:build !linux && !freebsd && !netbsd && !openbsd && !dragonfly
+
+package main
+
+import (
+	"errors"
+
+	"github.com/gen2brain/cbconvert"
+)
+
+// progressDBus is not supported on this platform.
+func progressDBus(conv *cbconvert.Converter) (func(), error) {
+	return nil, errors.New("progressDBus: not supported")
+}
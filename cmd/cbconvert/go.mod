@@ -3,8 +3,12 @@ module github.com/gen2brain/cbconvert/cmd/cbconvert
 go 1.23
 
 require (
+	github.com/BurntSushi/toml v1.6.0
+	github.com/dustin/go-humanize v1.0.1
 	github.com/gen2brain/cbconvert v1.0.5-0.20241106192421-4d845afa43ca
+	github.com/godbus/dbus/v5 v5.1.0
 	github.com/schollz/progressbar/v3 v3.13.1
+	golang.org/x/term v0.25.0
 )
 
 require (
@@ -13,7 +17,6 @@ require (
 	github.com/dsoprea/go-logging v0.0.0-20200710184922-b02d349568dd // indirect
 	github.com/dsoprea/go-png-image-structure v0.0.0-20210512210324-29b889a6093d // indirect
 	github.com/dsoprea/go-utility v0.0.0-20221003172846-a3e1774ef349 // indirect
-	github.com/dustin/go-humanize v1.0.1 // indirect
 	github.com/ebitengine/purego v0.8.1 // indirect
 	github.com/fvbommel/sortorder v1.1.0 // indirect
 	github.com/gen2brain/avif v0.4.1 // indirect
@@ -26,14 +29,15 @@ require (
 	github.com/golang/geo v0.0.0-20230421003525-6adc56603217 // indirect
 	github.com/jsummers/gobmp v0.0.0-20230614200233-a9de23ed2e25 // indirect
 	github.com/jupiterrider/ffi v0.2.1 // indirect
+	github.com/klauspost/compress v1.17.11 // indirect
 	github.com/mattn/go-runewidth v0.0.15 // indirect
 	github.com/mitchellh/colorstring v0.0.0-20190213212951-d06e56a500db // indirect
 	github.com/rivo/uniseg v0.3.4 // indirect
 	github.com/tetratelabs/wazero v1.8.1 // indirect
+	github.com/ulikunitz/xz v0.5.12 // indirect
 	golang.org/x/image v0.21.0 // indirect
 	golang.org/x/net v0.30.0 // indirect
 	golang.org/x/sync v0.8.0 // indirect
 	golang.org/x/sys v0.26.0 // indirect
-	golang.org/x/term v0.25.0 // indirect
 	gopkg.in/yaml.v2 v2.4.0 // indirect
 )
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+// locales are message catalogs, JSON objects that map English text to the translation. Missing messages are printed in English.
+//
+//go:embed locales/*.json
+var locales embed.FS
+
+// catalog is the message catalog of the selected locale, nil for English.
+var catalog map[string]string
+
+// tr returns message translated to the selected locale.
+func tr(s string) string {
+	if t, ok := catalog[s]; ok && t != "" {
+		return t
+	}
+
+	return s
+}
+
+// localeNames returns names of the available locales, English included.
+func localeNames() []string {
+	names := []string{"en"}
+
+	entries, _ := fs.ReadDir(locales, "locales")
+	for _, entry := range entries {
+		names = append(names, strings.TrimSuffix(entry.Name(), ".json"))
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+// setLocale selects the locale, i.e. de, de_DE or de_DE.UTF-8, the language is used if there is no catalog for the territory.
+// Empty locale, C and POSIX select English.
+func setLocale(name string) error {
+	name, _, _ = strings.Cut(name, ".")
+	name, _, _ = strings.Cut(name, "@")
+	name = strings.ReplaceAll(name, "-", "_")
+
+	if name == "" || name == "C" || name == "POSIX" || name == "en" || strings.HasPrefix(name, "en_") {
+		catalog = nil
+
+		return nil
+	}
+
+	candidates := []string{name}
+	if lang, _, ok := strings.Cut(name, "_"); ok {
+		candidates = append(candidates, lang)
+	}
+
+	for _, candidate := range candidates {
+		data, err := locales.ReadFile(path.Join("locales", candidate+".json"))
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+
+			return err
+		}
+
+		messages := make(map[string]string)
+		if err := json.Unmarshal(data, &messages); err != nil {
+			return fmt.Errorf("%s: %w", candidate, err)
+		}
+
+		catalog = messages
+
+		return nil
+	}
+
+	return fmt.Errorf("unknown locale %q, valid values are %s", name, strings.Join(localeNames(), ", "))
+}
+
+// envLocale returns locale from the environment, CBCONVERT_LANG takes precedence over LC_ALL, LC_MESSAGES and LANG.
+func envLocale() string {
+	for _, key := range []string{"CBCONVERT_LANG", "LC_ALL", "LC_MESSAGES", "LANG"} {
+		if value := os.Getenv(key); value != "" {
+			return value
+		}
+	}
+
+	return ""
+}
@@ -2,21 +2,85 @@ package main
 
 import (
 	"bufio"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
+	"log/slog"
 	"os"
 	"os/signal"
 	"path/filepath"
 	"runtime/debug"
+	"strings"
+	"sync/atomic"
 	"syscall"
+	"text/tabwriter"
+	"time"
 
+	"github.com/BurntSushi/toml"
+	"github.com/dustin/go-humanize"
 	"github.com/gen2brain/cbconvert"
+	"github.com/gen2brain/cbconvert/cmd/cbconvert/cli"
 	pb "github.com/schollz/progressbar/v3"
+	"golang.org/x/term"
 )
 
 var appVersion string
 
+// infoPages lists pages in info command.
+var infoPages bool
+
+// jsonOutput prints output of meta and info commands as JSON.
+var jsonOutput bool
+
+// estimateSamples is number of sampled pages in estimate command.
+var estimateSamples int
+
+// metricsFile is file where conversion statistics are written.
+var metricsFile string
+
+// dbusProgress reports progress to the desktop over D-Bus.
+var dbusProgress bool
+
+// batch is set for batchdir command.
+var batch bool
+
+// formats is set for formats command.
+var formats bool
+
+// selftest is set for selftest command.
+var selftest bool
+
+// mktest is set for mktest command.
+var mktest bool
+
+// mktestPages is number of pages in mktest command.
+var mktestPages int
+
+// mktestSize is page size in mktest command, i.e. 1800x2700.
+var mktestSize string
+
+// mktestPattern is page pattern in mktest command.
+var mktestPattern string
+
+// mktestSeed is random seed in mktest command.
+var mktestSeed uint64
+
+// mktestFormat is page format in mktest command, copied to the options only for mktest.
+var mktestFormat string
+
+// reuseOptions is archive with recorded options that are applied to the inputs.
+var reuseOptions string
+
+// sidecarExt is extension of the sidecar option file, i.e. comic.cbz.cbconvert.toml.
+const sidecarExt = ".cbconvert.toml"
+
+// sidecarOptions returns options for the input, values from the sidecar file are merged over the command line options.
+var sidecarOptions = func(fileName string) (cbconvert.Options, error) {
+	return cbconvert.Options{}, nil
+}
+
 func init() {
 	if appVersion != "" {
 		return
@@ -45,6 +109,10 @@ func init() {
 }
 
 func main() {
+	if name := os.Getenv(selftestEnv); name != "" {
+		os.Exit(runSelfTest(name))
+	}
+
 	opts, args := parseFlags()
 
 	if opts.Version {
@@ -52,35 +120,87 @@ func main() {
 		os.Exit(0)
 	}
 
+	if formats {
+		printFormats()
+		os.Exit(0)
+	}
+
+	if selftest {
+		os.Exit(runSelfTests())
+	}
+
 	conv := cbconvert.New(opts)
+	if opts.LogLevel > cbconvert.LogSilent {
+		conv.Logger = slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	}
 
 	c := make(chan os.Signal, 2)
 	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
 	go func() {
+		canceled := false
 		for range c {
-			if err := os.RemoveAll(conv.Workdir); err != nil {
-				fmt.Println(err)
+			// with checkpoint the first signal cancels the conversion, pages in progress are finished and the workdir is kept
+			if opts.Checkpoint {
+				if !canceled {
+					canceled = true
+					conv.Cancel()
+
+					continue
+				}
+
+				os.Exit(1)
+			}
+
+			if err := conv.Cleanup(); err != nil {
+				printError(opts.LogLevel, err)
 			}
 			os.Exit(1)
 		}
 	}()
 
+	if batch {
+		os.Exit(runBatch(conv, args[0]))
+	}
+
+	if mktest {
+		var width, height int
+		if _, err := fmt.Sscanf(mktestSize, "%dx%d", &width, &height); err != nil {
+			printError(opts.LogLevel, fmt.Errorf(tr("invalid size %q, i.e. 1800x2700"), mktestSize))
+			os.Exit(1)
+		}
+
+		if err := conv.MakeTest(args[0], mktestPages, width, height, mktestPattern, mktestSeed); err != nil {
+			printError(opts.LogLevel, err)
+			os.Exit(1)
+		}
+
+		if opts.LogLevel >= cbconvert.LogNormal {
+			fmt.Println(args[0])
+		}
+
+		return
+	}
+
 	if _, err := os.Stat(opts.OutDir); err != nil {
 		if err := os.MkdirAll(opts.OutDir, 0775); err != nil {
-			fmt.Println(err)
+			printError(opts.LogLevel, err)
+			os.Exit(1)
 		}
-		os.Exit(1)
 	}
 
 	files, err := conv.Files(args)
 	if err != nil {
-		fmt.Println(err)
+		printError(opts.LogLevel, err)
 		os.Exit(1)
 	}
 
+	// plain lines are printed instead of progress bar if stdout is not a terminal, i.e. in logs
+	tty := term.IsTerminal(int(os.Stdout.Fd()))
+	progress := opts.LogLevel >= cbconvert.LogNormal && !jsonOutput
+
 	var bar *pb.ProgressBar
 	if opts.Cover || opts.Thumbnail || opts.Meta {
-		if !opts.Quiet {
+		if progress && tty {
 			bar = pb.NewOptions(conv.Nfiles,
 				pb.OptionShowCount(),
 				pb.OptionClearOnFinish(),
@@ -90,213 +210,735 @@ func main() {
 		}
 	}
 
-	conv.OnStart = func() {
-		if !opts.Quiet {
-			bar = pb.NewOptions(conv.Ncontents,
+	conv.OnStart = func(job *cbconvert.Job) {
+		if progress && tty {
+			bar = pb.NewOptions(job.Ncontents,
 				pb.OptionShowCount(),
 				pb.OptionClearOnFinish(),
 				pb.OptionUseANSICodes(true),
-				pb.OptionSetDescription(fmt.Sprintf("Converting %d of %d:", conv.CurrFile, conv.Nfiles)),
+				pb.OptionSetDescription(fmt.Sprintf(tr("Converting %d of %d:"), job.CurrFile, job.Nfiles)),
 				pb.OptionSetPredictTime(false),
 			)
 		}
 	}
 
-	conv.OnProgress = func() {
-		if !opts.Quiet {
+	conv.OnProgress = func(job *cbconvert.Job) {
+		eta := job.ETA()
+
+		switch {
+		case progress && tty:
+			if eta.Rate > 0 {
+				bar.Describe(fmt.Sprintf(tr("Converting %d of %d (%.1f pages/s, %s left):"), job.CurrFile, job.Nfiles, eta.Rate, eta.Batch.Round(time.Second)))
+			}
 			_ = bar.Add(1)
+		case progress:
+			if eta.Rate > 0 {
+				fmt.Printf("file %d/%d page %d/%d rate %.1f/s eta %s\n", job.CurrFile, job.Nfiles, atomic.LoadInt32(&job.CurrContent), job.Ncontents,
+					eta.Rate, eta.Batch.Round(time.Second))
+			} else {
+				fmt.Printf("file %d/%d page %d/%d\n", job.CurrFile, job.Nfiles, atomic.LoadInt32(&job.CurrContent), job.Ncontents)
+			}
 		}
 	}
 
-	conv.OnCompress = func() {
-		if !opts.Quiet {
-			fmt.Fprintf(os.Stderr, "Compressing %d of %d...\r", conv.CurrFile, conv.Nfiles)
+	conv.OnCompress = func(job *cbconvert.Job) {
+		switch {
+		case progress && tty:
+			fmt.Fprintf(os.Stderr, tr("Compressing %d of %d...")+"\r", job.CurrFile, job.Nfiles)
+		case progress:
+			fmt.Printf("file %d/%d compressing\n", job.CurrFile, job.Nfiles)
 		}
 	}
 
+	conv.OnCopy = func(job *cbconvert.Job, copied, total int64) {
+		if progress && tty && total > 0 {
+			fmt.Fprintf(os.Stderr, tr("Copying %d of %d... %d%%")+"\r", job.CurrFile, job.Nfiles, copied*100/total)
+		}
+	}
+
+	if dbusProgress {
+		hide, err := progressDBus(conv)
+		if err != nil {
+			printError(opts.LogLevel, err)
+		} else {
+			defer hide()
+		}
+	}
+
+	if opts.Montage {
+		fName, err := conv.MontageSave(files)
+		if err != nil {
+			printError(opts.LogLevel, err)
+			os.Exit(1)
+		}
+
+		if opts.LogLevel >= cbconvert.LogNormal {
+			fmt.Println(fName)
+		}
+
+		return
+	}
+
+	var estimates []fileEstimate
+
 	for _, file := range files {
 		switch {
 		case opts.Meta:
 			ret, err := conv.Meta(file.Path)
 			if err != nil {
-				fmt.Println(err)
+				printError(opts.LogLevel, err)
 				os.Exit(1)
 			}
 
-			if opts.Cover {
-				fmt.Println(ret)
-			} else if opts.Comment {
-				fmt.Println(ret)
+			if err := printMeta(opts, file, ret); err != nil {
+				printError(opts.LogLevel, err)
+				os.Exit(1)
+			}
+
+			continue
+		case opts.Estimate:
+			est, err := conv.Estimate(file.Path, file.Stat, estimateSamples)
+			if err != nil {
+				printError(opts.LogLevel, err)
+				os.Exit(1)
+			}
+
+			estimates = append(estimates, fileEstimate{file, est})
+
+			continue
+		case opts.Info:
+			if err := printInfo(conv, file); err != nil {
+				printError(opts.LogLevel, err)
+				os.Exit(1)
 			}
 
 			continue
 		case opts.Cover:
 			if err := conv.Cover(file.Path, file.Stat); err != nil {
-				fmt.Println(err)
+				printError(opts.LogLevel, err)
 				os.Exit(1)
 			}
 
 			continue
 		case opts.Thumbnail:
 			if err = conv.Thumbnail(file.Path, file.Stat); err != nil {
-				fmt.Println(err)
+				printError(opts.LogLevel, err)
 				os.Exit(1)
 			}
 
 			continue
 		}
 
-		if err := conv.Convert(file.Path, file.Stat); err != nil {
-			fmt.Println(err)
+		conv.Opts, err = sidecarOptions(file.Path)
+		if err != nil {
+			printError(opts.LogLevel, err)
 			os.Exit(1)
 		}
+
+		if _, err := conv.Convert(file.Path, file.Stat); err != nil {
+			printError(opts.LogLevel, err)
+			os.Exit(1)
+		}
+	}
+
+	if tty {
+		fmt.Fprintf(os.Stderr, "\r")
 	}
 
-	fmt.Fprintf(os.Stderr, "\r")
+	if opts.Estimate {
+		printEstimates(estimates)
+	}
+
+	if metricsFile != "" {
+		if err := writeMetrics(conv.Stats(), metricsFile); err != nil {
+			printError(opts.LogLevel, err)
+			os.Exit(1)
+		}
+	}
 }
 
 // parseFlags parses command line flags.
 func parseFlags() (cbconvert.Options, []string) {
-	opts := cbconvert.Options{}
+	// options without a flag in the selected command keep the library defaults
+	opts := cbconvert.NewOptions()
 	var args []string
-
-	convert := flag.NewFlagSet("convert", flag.ExitOnError)
-	convert.IntVar(&opts.Width, "width", 0, "Image width")
-	convert.IntVar(&opts.Height, "height", 0, "Image height")
-	convert.BoolVar(&opts.Fit, "fit", false, "Best fit for required width and height")
-	convert.StringVar(&opts.Format, "format", "jpeg", "Image format, valid values are jpeg, png, tiff, bmp, webp, avif, jxl")
-	convert.StringVar(&opts.Archive, "archive", "zip", "Archive format, valid values are zip, tar")
-	convert.IntVar(&opts.Quality, "quality", 75, "Image quality")
-	convert.IntVar(&opts.Filter, "filter", 2, "0=NearestNeighbor, 1=Box, 2=Linear, 3=MitchellNetravali, 4=CatmullRom, 6=Gaussian, 7=Lanczos")
-	convert.BoolVar(&opts.NoCover, "no-cover", false, "Do not convert the cover image")
-	convert.BoolVar(&opts.NoRGB, "no-rgb", false, "Do not convert images that have RGB colorspace")
-	convert.BoolVar(&opts.NoNonImage, "no-nonimage", false, "Remove non-image files from the archive")
-	convert.BoolVar(&opts.NoConvert, "no-convert", false, "Do not transform or convert images")
-	convert.BoolVar(&opts.Grayscale, "grayscale", false, "Convert images to grayscale (monochromatic)")
-	convert.IntVar(&opts.Rotate, "rotate", 0, "Rotate images, valid values are 0, 90, 180, 270")
-	convert.IntVar(&opts.Brightness, "brightness", 0, "Adjust the brightness of the images, must be in the range (-100, 100)")
-	convert.IntVar(&opts.Contrast, "contrast", 0, "Adjust the contrast of the images, must be in the range (-100, 100)")
-	convert.StringVar(&opts.Suffix, "suffix", "", "Add suffix to file basename")
-	convert.StringVar(&opts.OutDir, "outdir", ".", "Output directory")
-	convert.IntVar(&opts.Size, "size", 0, "Process only files larger than size (in MB)")
-	convert.BoolVar(&opts.Recursive, "recursive", false, "Process subdirectories recursively")
-	convert.BoolVar(&opts.Quiet, "quiet", false, "Hide console output")
-
-	cover := flag.NewFlagSet("cover", flag.ExitOnError)
-	cover.IntVar(&opts.Width, "width", 0, "Image width")
-	cover.IntVar(&opts.Height, "height", 0, "Image height")
-	cover.BoolVar(&opts.Fit, "fit", false, "Best fit for required width and height")
-	cover.StringVar(&opts.Format, "format", "jpeg", "Image format, valid values are jpeg, png, tiff, bmp, webp, avif")
-	cover.IntVar(&opts.Quality, "quality", 75, "Image quality")
-	cover.IntVar(&opts.Filter, "filter", 2, "0=NearestNeighbor, 1=Box, 2=Linear, 3=MitchellNetravali, 4=CatmullRom, 6=Gaussian, 7=Lanczos")
-	cover.StringVar(&opts.OutDir, "outdir", ".", "Output directory")
-	cover.IntVar(&opts.Size, "size", 0, "Process only files larger than size (in MB)")
-	cover.BoolVar(&opts.Recursive, "recursive", false, "Process subdirectories recursively")
-	cover.BoolVar(&opts.Quiet, "quiet", false, "Hide console output")
-
-	thumbnail := flag.NewFlagSet("thumbnail", flag.ExitOnError)
-	thumbnail.IntVar(&opts.Width, "width", 0, "Image width")
-	thumbnail.IntVar(&opts.Height, "height", 0, "Image height")
-	thumbnail.BoolVar(&opts.Fit, "fit", false, "Best fit for required width and height")
-	thumbnail.IntVar(&opts.Filter, "filter", 2, "0=NearestNeighbor, 1=Box, 2=Linear, 3=MitchellNetravali, 4=CatmullRom, 6=Gaussian, 7=Lanczos")
-	thumbnail.StringVar(&opts.OutDir, "outdir", ".", "Output directory")
-	thumbnail.StringVar(&opts.OutFile, "outfile", "", "Output file")
-	thumbnail.IntVar(&opts.Size, "size", 0, "Process only files larger than size (in MB)")
-	thumbnail.BoolVar(&opts.Recursive, "recursive", false, "Process subdirectories recursively")
-	thumbnail.BoolVar(&opts.Quiet, "quiet", false, "Hide console output")
-
-	meta := flag.NewFlagSet("meta", flag.ExitOnError)
-	meta.BoolVar(&opts.Cover, "cover", false, "Print cover name")
-	meta.BoolVar(&opts.Comment, "comment", false, "Print zip comment")
-	meta.StringVar(&opts.CommentBody, "comment-body", "", "Set zip comment")
-	meta.StringVar(&opts.FileAdd, "file-add", "", "Add file to archive")
-	meta.StringVar(&opts.FileRemove, "file-remove", "", "Remove file from archive (glob pattern, i.e. *.xml)")
-
-	flag.NewFlagSet("version", flag.ExitOnError)
-
-	flag.Usage = func() {
-		fmt.Fprintf(os.Stderr, "Usage: %s <command> [<flags>] [file1 dir1 ... fileOrDirN]\n\n", filepath.Base(os.Args[0]))
-		fmt.Fprintf(os.Stderr, "\nCommands:\n")
-		fmt.Fprintf(os.Stderr, "\n  convert\n    \tConvert archive or document\n\n")
-		order := []string{"width", "height", "fit", "format", "archive", "quality", "filter", "no-cover", "no-rgb",
-			"no-nonimage", "no-convert", "grayscale", "rotate", "brightness", "contrast", "suffix", "outdir", "size", "recursive", "quiet"}
-		for _, name := range order {
-			f := convert.Lookup(name)
-			fmt.Fprintf(os.Stderr, "    --%s\n    \t", f.Name)
-			fmt.Fprintf(os.Stderr, "%v (default %q)\n", f.Usage, f.DefValue)
-		}
-		fmt.Fprintf(os.Stderr, "\n  cover\n    \tExtract cover\n\n")
-		order = []string{"width", "height", "fit", "format", "quality", "filter", "outdir", "size", "recursive", "quiet"}
-		for _, name := range order {
-			f := cover.Lookup(name)
-			fmt.Fprintf(os.Stderr, "    --%s\n    \t", f.Name)
-			fmt.Fprintf(os.Stderr, "%v (default %q)\n", f.Usage, f.DefValue)
-		}
-		fmt.Fprintf(os.Stderr, "\n  thumbnail\n    \tExtract cover thumbnail (freedesktop spec.)\n\n")
-		order = []string{"width", "height", "fit", "filter", "outdir", "outfile", "size", "recursive", "quiet"}
-		for _, name := range order {
-			f := thumbnail.Lookup(name)
-			fmt.Fprintf(os.Stderr, "    --%s\n    \t", f.Name)
-			fmt.Fprintf(os.Stderr, "%v (default %q)\n", f.Usage, f.DefValue)
-		}
-		fmt.Fprintf(os.Stderr, "\n  meta\n    \tCBZ metadata\n\n")
-		order = []string{"cover", "comment", "comment-body", "file-add", "file-remove"}
-		for _, name := range order {
-			f := meta.Lookup(name)
-			fmt.Fprintf(os.Stderr, "    --%s\n    \t", f.Name)
-			fmt.Fprintf(os.Stderr, "%v (default %q)\n", f.Usage, f.DefValue)
-		}
-		fmt.Fprintf(os.Stderr, "\n  version\n    \tPrint version\n\n")
+	var quiet, verbose, debug bool
+	var logLevel string
+
+	// messages are translated to the locale from the environment, --lang applies to the messages printed after it
+	_ = setLocale(envLocale())
+
+	app := cli.New(filepath.Base(os.Args[0]), "[<flags>] [file1 dir1 ... fileOrDirN]")
+	app.Default = "convert"
+	app.EnvPrefix = "CBCONVERT_"
+	app.Translate = tr
+
+	app.Shared(func(fs *flag.FlagSet) {
+		fs.Func("lang", "Language of messages, valid values are en and de, locale from LC_ALL, LC_MESSAGES or LANG if empty", setLocale)
+	})
+
+	app.Shared(func(fs *flag.FlagSet) {
+		fs.IntVar(&opts.Width, "width", 0, "Image width")
+		fs.IntVar(&opts.Height, "height", 0, "Image height")
+		fs.BoolVar(&opts.Fit, "fit", false, "Best fit for required width and height")
+		fs.IntVar(&opts.Filter, "filter", 2, "0=NearestNeighbor, 1=Box, 2=Linear, 3=MitchellNetravali, 4=CatmullRom, 5=Gaussian, 6=Lanczos")
+		fs.StringVar(&opts.Resizer, "resizer", "bild", "Resize implementation, valid values are bild and draw (golang.org/x/image/draw separable kernels, faster for cubic and Lanczos filters)")
+	}, "convert", "cover", "thumbnail", "estimate", "batchdir")
+
+	app.Shared(func(fs *flag.FlagSet) {
+		fs.StringVar(&opts.Format, "format", "jpeg", "Image format, valid values are jpeg, png, tiff, bmp, webp, avif, jxl, auto (png for flat colors, webp otherwise)")
+		fs.StringVar(&opts.Fallback, "fallback", "", "Fallback image formats tried in order if encoding of a page fails, i.e. webp,jpeg, the conversion fails if empty")
+		fs.IntVar(&opts.Quality, "quality", 75, "Image quality")
+		fs.BoolVar(&opts.AdaptiveQuality, "adaptive-quality", false, "Adaptive quality, quality of each page is chosen between quality-min and quality-max by the page complexity (detail)")
+		fs.IntVar(&opts.QualityMin, "quality-min", 50, "Minimum quality for adaptive quality, used for flat pages")
+		fs.IntVar(&opts.QualityMax, "quality-max", 90, "Maximum quality for adaptive quality, used for detailed pages")
+		fs.IntVar(&opts.TargetSize, "target-size", 0, "Target average page size (in KB), quality is chosen per book from sampled pages before conversion (two-pass), 0 disables")
+		fs.BoolVar(&opts.NoConvert, "no-convert", false, "Do not transform or convert images")
+		fs.BoolVar(&opts.Grayscale, "grayscale", false, "Convert images to grayscale (monochromatic)")
+		fs.IntVar(&opts.GrayscaleAuto, "grayscale-auto", 0, "Convert images to grayscale only if mean saturation (in percent) is below the threshold, 0 disables")
+		fs.IntVar(&opts.GrayDepth, "gray-depth", 8, "Gray levels of grayscale pages, valid values are 8 and 4 (16 levels with dithering, 4-bit PNG for Kindle e-ink), 4 implies grayscale")
+		fs.Float64Var(&opts.Gamma, "gamma", 0, "Gamma correction, values above 1 darken midtones (1.8 for Kindle e-ink), 0 disables")
+		fs.StringVar(&opts.Alpha, "alpha", "preserve", "Alpha policy, valid values are preserve (flatten only for formats without alpha) and flatten")
+		fs.StringVar(&opts.Background, "background", "ffffff", "Background color for flattened transparent images, i.e. ffffff")
+		fs.IntVar(&opts.Rotate, "rotate", 0, "Rotate images, valid values are 0, 90, 180, 270")
+		fs.IntVar(&opts.Brightness, "brightness", 0, "Adjust the brightness of the images, must be in the range (-100, 100)")
+		fs.IntVar(&opts.Contrast, "contrast", 0, "Adjust the contrast of the images, must be in the range (-100, 100)")
+	}, "convert", "estimate", "batchdir")
+
+	app.Shared(func(fs *flag.FlagSet) {
+		fs.StringVar(&opts.OutDir, "outdir", ".", "Output directory")
+	}, "convert", "cover", "thumbnail", "montage", "meta")
+
+	app.Shared(func(fs *flag.FlagSet) {
+		fs.BoolVar(&quiet, "quiet", false, "Hide console output, only errors are printed (-q)")
+		fs.BoolVar(&quiet, "q", false, "Same as --quiet")
+		fs.BoolVar(&verbose, "verbose", false, "Print processed files (-v), -vv prints debug messages")
+		fs.BoolVar(&verbose, "v", false, "Same as --verbose")
+		fs.BoolVar(&debug, "vv", false, "Same as --log-level debug")
+		fs.StringVar(&logLevel, "log-level", "", "Log level, valid values are silent, errors, normal, verbose, debug")
+	}, "convert", "cover", "thumbnail", "montage", "batchdir", "mktest")
+
+	app.Shared(func(fs *flag.FlagSet) {
+		fs.IntVar(&opts.Size, "size", 0, "Process only files larger than size (in MB)")
+		fs.BoolVar(&opts.Recursive, "recursive", false, "Process subdirectories recursively")
+	}, "convert", "cover", "thumbnail", "montage", "info", "estimate")
+
+	app.Shared(func(fs *flag.FlagSet) {
+		fs.BoolVar(&opts.Mmap, "mmap", false, "Read local archives through memory-mapped files (64-bit Unix only, regular reads are used elsewhere)")
+		fs.StringVar(&opts.Password, "password", "", "Password of the protected input archives, AES encrypted ZIP and RAR archives require 7z, unrar or unar command")
+		fs.IntVar(&opts.MaxEntries, "max-entries", 10000, "Maximum number of archive entries, archives with more entries are rejected, 0 disables")
+		fs.IntVar(&opts.MaxEntrySize, "max-entry-size", 512, "Maximum uncompressed size of archive entry (in MB), 0 disables")
+		fs.IntVar(&opts.MaxDepth, "max-depth", 32, "Maximum directory depth of archive entry names, 0 disables")
+		fs.IntVar(&opts.MaxPagePixels, "max-page-pixels", 100, "Maximum number of pixels of rendered document page (in megapixels), documents with larger pages are rejected, 0 disables")
+	}, "convert", "cover", "thumbnail", "montage", "info", "estimate", "batchdir")
+
+	convertFlags := func(fs *flag.FlagSet) {
+		fs.BoolVar(&opts.AutoWidth, "auto-width", false, "Downscale pages wider than the median page width of the file, spreads to twice the median, --width is the maximum")
+		fs.StringVar(&opts.Archive, "archive", "zip", "Archive format, valid values are zip, tar, tar.gz, tar.zst, tar.xz, 7z (requires 7z command), mobi, epub (fixed-layout EPUB3), none (pages are written to directory named after the input)")
+		fs.BoolVar(&opts.RightToLeft, "rtl", false, "Right-to-left reading direction of the EPUB output (manga), also set by ComicInfo.xml Manga YesAndRightToLeft")
+		fs.BoolVar(&opts.Reproducible, "reproducible", false, "Reproducible output, timestamps and permissions are fixed so the same input gives identical archive")
+		fs.BoolVar(&opts.TarNormalize, "tar-normalize", false, "Normalize ownership, permissions and modification times in TAR headers")
+		fs.BoolVar(&opts.TarPAX, "tar-pax", false, "Write TAR headers in PAX format (long and UTF-8 names)")
+		fs.StringVar(&opts.Compression, "compression", "", "ZIP compression rules per extension, i.e. \"jpg=store,xml=deflate,*=deflate\", images are stored by default, store or deflate applies to all entries")
+		fs.IntVar(&opts.ZipLevel, "zip-level", 0, "ZIP deflate level, 1 (fastest) to 9 (best), 0 is the default level (6)")
+		fs.BoolVar(&opts.NoCover, "no-cover", false, "Do not convert the cover image")
+		fs.BoolVar(&opts.NoRGB, "no-rgb", false, "Do not convert images that have RGB colorspace")
+		fs.StringVar(&opts.Metadata, "metadata", "strip", "Image metadata policy, valid values are strip, orientation (apply EXIF orientation), keep (also copy EXIF/XMP to JPEG, PNG and WEBP)")
+		fs.BoolVar(&opts.Salvage, "salvage", false, "Salvage damaged JPEG images, incomplete scans are dropped or the part that can not be decoded is filled with gray")
+		fs.StringVar(&opts.Animation, "animation", "first", "Animated GIF/WEBP pages policy, valid values are first (first frame), keep (copy unmodified), expand (each frame as a page)")
+		fs.IntVar(&opts.TileHeight, "tile-height", 0, "Split pages taller than height into tiles (in pixels), i.e. long webtoon strips, pages that exceed the format limit (16383 for WEBP, 65535 for JPEG) are always split")
+		fs.BoolVar(&opts.NoNonImage, "no-nonimage", false, "Remove non-image files from the archive, ComicInfo.xml is kept")
+		fs.BoolVar(&opts.ComicInfo, "comicinfo", false, "Write ComicInfo.xml with the page table and chapter bookmarks, existing file is updated")
+		fs.BoolVar(&opts.ComicInfoName, "comicinfo-name", false, "Write ComicInfo.xml with Series, Number, Volume and Year parsed from the input file name, i.e. \"Series v02 #012 (2019)\", existing values are kept")
+		fs.BoolVar(&opts.NoComicInfo, "no-comicinfo", false, "Remove ComicInfo.xml from the archive, by default it is kept and its page table is updated for the converted pages")
+		fs.StringVar(&opts.Suffix, "suffix", "", "Add suffix to file basename")
+		fs.StringVar(&opts.AppendTo, "append", "", "Append converted pages to the existing CBZ archive, pages are numbered after the existing pages (renumbered if needed)")
+		fs.StringVar(&opts.Overwrite, "overwrite", "overwrite", "Existing output policy, valid values are overwrite, skip (input is not converted), rename (number is appended to the name)")
+		fs.Float64Var(&opts.WriteLimit, "write-limit", 0, "Limit write rate to the output directory (in MB/s), i.e. on network shares, 0 disables")
+		fs.StringVar(&opts.PostCmd, "post-cmd", "", "Command executed for each output file, placeholders {input}, {output}, {name} and {dir} are replaced")
+		fs.BoolVar(&opts.DeleteOriginal, "delete-original", false, "Delete the input after successful conversion, it is moved to the trash (XDG Trash, Recycle Bin)")
+		fs.BoolVar(&opts.Permanent, "permanent", false, "Delete the input permanently instead of moving it to the trash, see --delete-original")
+		fs.BoolVar(&opts.EmbedOptions, "embed-options", false, "Record the options in the converted archive (cbconvert.json), see --reuse-options")
+		fs.StringVar(&reuseOptions, "reuse-options", "", "Apply options recorded in the archive converted with --embed-options, flags on the command line take precedence")
+		fs.StringVar(&opts.CommentTemplate, "comment-template", "", "ZIP comment template, placeholders {version}, {input}, {date} and {hash} (options hash) are replaced")
+		fs.IntVar(&opts.Workers, "workers", 0, "Number of concurrent image conversions, number of CPUs + 1 if zero")
+		fs.DurationVar(&opts.PageTimeout, "page-timeout", 0, "Timeout for decoding and converting a page (i.e. 30s), conversion of the file fails, 0 disables")
+		fs.DurationVar(&opts.FileTimeout, "file-timeout", 0, "Timeout for converting a file (i.e. 10m), 0 disables")
+		fs.BoolVar(&opts.Checkpoint, "checkpoint", false, "On interrupt keep the converted pages, the next run with the same input and options resumes the archive or document")
+		fs.StringVar(&metricsFile, "metrics-file", "", "Write conversion statistics in Prometheus text format to file (i.e. node_exporter textfile collector)")
+		fs.BoolVar(&dbusProgress, "dbus-progress", false, "Report progress to the desktop taskbar or dock over D-Bus (LauncherEntry API), the launcher is matched by cbconvert.desktop")
+		fs.IntVar(&opts.Sample, "sample", 0, "Number of randomly selected pages written as before/after pairs for review")
+		fs.StringVar(&opts.SampleDir, "sample-dir", "", "Review directory for sampled pages, a subdirectory is created per file, output directory is used if empty")
+		fs.StringVar(&opts.AltText, "alt-text", "", "Sidecar JSON file with page descriptions (alt text), placeholders {name} and {dir} of the input are replaced")
 	}
 
+	app.Add("convert", "Convert archive or document", []string{"c", "cv"}, convertFlags).Order = []string{"width", "height", "fit", "auto-width", "format", "fallback", "archive", "rtl", "compression", "zip-level",
+		"reproducible", "tar-normalize", "tar-pax", "quality", "adaptive-quality", "quality-min", "quality-max", "target-size", "filter", "resizer", "no-cover", "no-rgb", "animation", "tile-height", "no-nonimage", "no-convert", "comicinfo", "comicinfo-name", "no-comicinfo", "fetch", "comicvine-key", "grayscale",
+		"grayscale-auto", "gray-depth", "gamma", "alpha", "background", "metadata", "salvage", "rotate", "brightness", "contrast", "suffix", "append", "overwrite", "write-limit", "outdir", "size", "recursive", "mmap", "password", "max-entries", "max-entry-size", "max-depth", "max-page-pixels", "quiet", "verbose", "log-level",
+		"workers", "page-timeout", "file-timeout", "checkpoint", "post-cmd", "delete-original", "permanent", "comment-template", "embed-options", "reuse-options", "alt-text", "sample", "sample-dir", "metrics-file", "dbus-progress", "lang"}
+
+	app.Shared(func(fs *flag.FlagSet) {
+		fs.BoolVar(&opts.Fetch, "fetch", false, "Fetch ComicInfo.xml fields from ComicVine by series, number and year of the existing file or the file name, requires --comicvine-key")
+		fs.StringVar(&opts.ComicVineKey, "comicvine-key", "", "ComicVine API key, i.e. set once with CBCONVERT_COMICVINE_KEY environment variable")
+	}, "convert", "batchdir", "meta")
+
+	app.Shared(func(fs *flag.FlagSet) {
+		fs.StringVar(&opts.CoverPage, "cover-page", "", "Document page used as cover, valid values are page number (starting from 1) and auto (first page that is not blank), first page if empty")
+	}, "cover", "thumbnail", "montage")
+
+	app.Add("cover", "Extract cover", []string{"co"}, func(fs *flag.FlagSet) {
+		fs.StringVar(&opts.Format, "format", "jpeg", "Image format, valid values are jpeg, png, tiff, bmp, webp, avif")
+		fs.IntVar(&opts.Quality, "quality", 75, "Image quality")
+	}).Order = []string{"width", "height", "fit", "format", "quality", "filter", "resizer", "cover-page", "outdir", "size", "recursive", "mmap", "password", "max-entries", "max-entry-size", "max-depth", "max-page-pixels", "quiet", "verbose", "log-level", "lang"}
+
+	app.Add("thumbnail", "Extract cover thumbnail (freedesktop spec.)", []string{"t", "thumb"}, func(fs *flag.FlagSet) {
+		fs.StringVar(&opts.OutFile, "outfile", "", "Output file")
+		fs.BoolVar(&opts.ThumbnailEnhance, "enhance", false, "Sharpen thumbnail and raise contrast, for low resolution covers")
+	}).Order = []string{"width", "height", "fit", "filter", "resizer", "cover-page", "outdir", "outfile", "enhance", "size", "recursive", "mmap", "password", "max-entries", "max-entry-size", "max-depth", "max-page-pixels", "quiet", "verbose", "log-level", "lang"}
+
+	app.Add("montage", "Compose covers into a poster wall montage", []string{"mo"}, func(fs *flag.FlagSet) {
+		fs.IntVar(&opts.MontageColumns, "columns", 0, "Number of columns, square grid if zero")
+		fs.IntVar(&opts.MontageCellWidth, "cell-width", 200, "Cell width, covers are fitted into the cell")
+		fs.IntVar(&opts.MontageCellHeight, "cell-height", 0, "Cell height, 1.5 times the cell width if zero")
+		fs.BoolVar(&opts.MontageLabels, "labels", false, "Draw file names under the covers")
+		fs.StringVar(&opts.Background, "background", "ffffff", "Background color, i.e. ffffff")
+		fs.StringVar(&opts.Format, "format", "jpeg", "Image format, valid values are jpeg, png, tiff, bmp, webp, avif")
+		fs.IntVar(&opts.Quality, "quality", 75, "Image quality")
+		fs.IntVar(&opts.Filter, "filter", 2, "0=NearestNeighbor, 1=Box, 2=Linear, 3=MitchellNetravali, 4=CatmullRom, 5=Gaussian, 6=Lanczos")
+		fs.StringVar(&opts.Resizer, "resizer", "bild", "Resize implementation, valid values are bild and draw (golang.org/x/image/draw separable kernels, faster for cubic and Lanczos filters)")
+		fs.StringVar(&opts.OutFile, "outfile", "", "Output file, montage.<ext> in the output directory if empty")
+	}).Order = []string{"columns", "cell-width", "cell-height", "labels", "background", "format", "quality", "filter", "resizer", "cover-page", "outdir", "outfile", "size", "recursive", "mmap", "password", "max-entries", "max-entry-size", "max-depth", "max-page-pixels", "quiet", "verbose", "log-level", "lang"}
+
+	app.Shared(func(fs *flag.FlagSet) {
+		fs.BoolVar(&jsonOutput, "json", false, "Print output as JSON, one object per file and line")
+	}, "meta", "info")
+
+	app.Add("meta", "Archive metadata, CBR and CB7 archives are converted to CBZ when modified", []string{"m"}, func(fs *flag.FlagSet) {
+		fs.BoolVar(&opts.Cover, "cover", false, "Print cover name")
+		fs.BoolVar(&opts.Comment, "comment", false, "Print zip comment")
+		fs.StringVar(&opts.CommentBody, "comment-body", "", "Set zip comment")
+		fs.StringVar(&opts.FileAdd, "file-add", "", "Add file to archive")
+		fs.StringVar(&opts.FileRemove, "file-remove", "", "Remove file from archive (glob pattern, i.e. *.xml)")
+		fs.StringVar(&opts.FileGet, "file-get", "", "Print file from archive to stdout, i.e. ComicInfo.xml")
+		fs.StringVar(&opts.FileGetAll, "file-get-all", "", "Extract files from archive to output directory (glob pattern, i.e. *.xml)")
+		fs.BoolVar(&opts.Validate, "validate", false, "Validate ComicInfo.xml against the known schema versions, unknown elements and invalid values are printed")
+		fs.BoolVar(&opts.Fix, "fix", false, "Fix common ComicInfo.xml problems found with --validate (date formats, Manga and BlackAndWhite values)")
+		fs.BoolVar(&opts.NormalizeNames, "normalize-names", false, "Strip folder prefixes and lowercase extensions of the entry names, images are not re-encoded")
+		fs.BoolVar(&opts.Renumber, "renumber", false, "Rename images to numbers in natural order, i.e. 000.jpg, images are not re-encoded")
+		fs.StringVar(&opts.EntryTime, "entry-time", "", "Set modification time of the entries, valid values are RFC3339 time (i.e. 2024-01-01T00:00:00Z), now and reproducible (1980-01-01)")
+		fs.StringVar(&opts.CoverDir, "cover-dir", "", "Directory with cover images named after the series and issue number (i.e. SeriesName - 012.jpg), covers of the matching archives are replaced")
+		fs.BoolVar(&opts.CoverInsert, "cover-insert", false, "Insert the matching cover from --cover-dir as the first page instead of replacing the cover")
+		fs.StringVar(&opts.CoverSet, "cover-set", "", "Add image as the first page (cover), it is named so that natural sort places it first and ComicInfo.xml pages are updated")
+		fs.StringVar(&opts.SetTitle, "set-title", "", "Set Title in ComicInfo.xml, the file is created if there is none, images are not touched")
+		fs.StringVar(&opts.SetSeries, "set-series", "", "Set Series in ComicInfo.xml, the file is created if there is none, images are not touched")
+		fs.StringVar(&opts.SetNumber, "set-number", "", "Set Number in ComicInfo.xml, the file is created if there is none, images are not touched")
+		fs.StringVar(&opts.SetWriter, "set-writer", "", "Set Writer in ComicInfo.xml, the file is created if there is none, images are not touched")
+	}).Order = []string{"cover", "comment", "comment-body", "file-add", "file-remove", "file-get", "file-get-all", "validate", "fix", "normalize-names", "renumber", "entry-time", "cover-dir", "cover-insert", "cover-set",
+		"set-title", "set-series", "set-number", "set-writer", "fetch", "comicvine-key", "json", "outdir", "lang"}
+
+	app.Add("info", "Print archive or document information", []string{"i"}, func(fs *flag.FlagSet) {
+		fs.BoolVar(&infoPages, "pages", false, "List pages with size and dimensions")
+	}).Order = []string{"pages", "json", "size", "recursive", "mmap", "password", "max-entries", "max-entry-size", "max-depth", "max-page-pixels", "lang"}
+
+	app.Add("estimate", "Estimate output size by converting sample pages", []string{"e"}, func(fs *flag.FlagSet) {
+		fs.IntVar(&estimateSamples, "samples", 5, "Number of sampled pages per file, all pages if zero")
+	}).Order = []string{"width", "height", "fit", "format", "fallback", "quality", "adaptive-quality", "quality-min", "quality-max", "target-size", "filter", "resizer", "no-convert", "grayscale", "grayscale-auto", "gray-depth", "gamma", "alpha", "background", "rotate", "brightness", "contrast",
+		"samples", "size", "recursive", "mmap", "password", "max-entries", "max-entry-size", "max-depth", "max-page-pixels", "lang"}
+
+	batchCmd := app.Add("batchdir", "Convert everything in input directory to output directory keeping the structure, JSON logs are written to stdout",
+		nil, convertFlags)
+	batchCmd.UsageLine = "[<flags>] <input dir> <output dir>"
+	batchCmd.Order = []string{"width", "height", "fit", "auto-width", "format", "fallback", "archive", "rtl", "compression", "zip-level", "reproducible", "tar-normalize", "tar-pax", "quality", "adaptive-quality", "quality-min", "quality-max", "target-size", "filter",
+		"no-cover", "no-rgb", "animation", "tile-height", "no-nonimage", "no-convert", "comicinfo", "comicinfo-name", "no-comicinfo", "fetch", "comicvine-key", "grayscale", "grayscale-auto", "gray-depth", "gamma", "alpha", "background", "metadata", "salvage", "rotate",
+		"brightness", "contrast", "suffix", "append", "overwrite", "write-limit", "mmap", "password", "max-entries", "max-entry-size", "max-depth", "max-page-pixels", "quiet", "verbose", "log-level", "workers", "page-timeout", "file-timeout", "checkpoint", "post-cmd", "delete-original", "permanent", "comment-template", "embed-options", "reuse-options", "alt-text", "sample", "sample-dir", "metrics-file", "dbus-progress", "lang"}
+
+	mktestCmd := app.Add("mktest", "Write test archive with synthesized pages, for reproducible bug reports", nil, func(fs *flag.FlagSet) {
+		fs.IntVar(&mktestPages, "pages", 20, "Number of pages")
+		fs.StringVar(&mktestSize, "size", "1800x2700", "Page size, i.e. 1800x2700")
+		fs.StringVar(&mktestPattern, "type", "gradient", "Page pattern, valid values are gradient, noise and text")
+		fs.Uint64Var(&mktestSeed, "seed", 1, "Random seed, the same seed writes the same archive")
+		fs.StringVar(&mktestFormat, "format", "png", "Image format, valid values are jpeg, png, tiff, bmp, webp, avif")
+		fs.IntVar(&opts.Quality, "quality", 75, "Image quality")
+	})
+	mktestCmd.UsageLine = "[<flags>] <output file>"
+	mktestCmd.Order = []string{"pages", "size", "type", "seed", "format", "quality", "quiet", "verbose", "log-level", "lang"}
+
+	app.Add("formats", "Print image formats, archives and documents available in this build", nil, nil)
+
+	app.Add("selftest", "Check image formats, archives and documents available in this build, each check runs in a separate process", nil, nil)
+
+	app.Add("version", "Print version", nil, nil)
+
+	flag.Usage = app.Usage
+
 	if len(os.Args) < 2 {
 		flag.Usage()
-		fmt.Fprintf(os.Stderr, "no command\n")
+		fmt.Fprintf(os.Stderr, "%s\n", tr("no command"))
+		os.Exit(1)
+	}
+
+	cmd, cmdArgs, err := app.Parse(os.Args[1:])
+	if err != nil {
+		flag.Usage()
+		fmt.Fprintf(os.Stderr, "%v\n", err)
 		os.Exit(1)
 	}
 
-	pipe := piped()
+	if reuseOptions != "" {
+		preset, err := cbconvert.New(opts).ReadOptions(reuseOptions)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(1)
+		}
+
+		// flags set on the command line or with environment variables are applied over the preset
+		explicit := make(map[string]string)
+		cmd.Flags.Visit(func(f *flag.Flag) {
+			explicit[f.Name] = f.Value.String()
+		})
+
+		opts = opts.Preset(preset)
+		for name, value := range explicit {
+			_ = cmd.Flags.Set(name, value)
+		}
+	}
+
+	pipe := piped() && cmd.Name != "batchdir" && cmd.Name != "mktest"
 	if pipe {
 		args = lines(os.Stdin)
+	} else {
+		args = cmdArgs
 	}
 
-	switch os.Args[1] {
-	case "convert":
-		_ = convert.Parse(os.Args[2:])
-		if !pipe {
-			args = convert.Args()
+	// @list.txt arguments are replaced with paths from the list file
+	if cmd.Name != "batchdir" && cmd.Name != "mktest" {
+		args, err = cbconvert.ExpandArgs(args)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(1)
 		}
+	}
+
+	opts.LogLevel = cbconvert.LogNormal
+	switch {
+	case logLevel != "":
+		opts.LogLevel, err = cbconvert.ParseLogLevel(logLevel)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(1)
+		}
+	case debug:
+		opts.LogLevel = cbconvert.LogDebug
+	case verbose:
+		opts.LogLevel = cbconvert.LogVerbose
+	case quiet:
+		opts.LogLevel = cbconvert.LogErrors
+	}
+
+	sidecarOptions = func(fileName string) (cbconvert.Options, error) {
+		data, err := os.ReadFile(fileName + sidecarExt)
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				return opts, nil
+			}
+
+			return opts, err
+		}
+
+		values := make(map[string]any)
+		if err := toml.Unmarshal(data, &values); err != nil {
+			return opts, fmt.Errorf("%s: %w", fileName+sidecarExt, err)
+		}
+
+		// flags are bound to opts, restore the command line options
+		saved := opts
+		defer func() {
+			opts = saved
+		}()
+
+		if err := cmd.SetValues(values, fileName+sidecarExt); err != nil {
+			return saved, err
+		}
+
+		return opts, nil
+	}
+
+	switch cmd.Name {
 	case "cover":
 		opts.Cover = true
-		_ = cover.Parse(os.Args[2:])
-		if !pipe {
-			args = cover.Args()
-		}
 	case "thumbnail":
 		opts.Thumbnail = true
-		_ = thumbnail.Parse(os.Args[2:])
-		if !pipe {
-			args = thumbnail.Args()
-		}
+	case "montage":
+		opts.Montage = true
 	case "meta":
 		opts.Meta = true
-		_ = meta.Parse(os.Args[2:])
-		if !pipe {
-			args = meta.Args()
-		}
+	case "info":
+		opts.Info = true
+	case "estimate":
+		opts.Estimate = true
 	case "version":
 		opts.Version = true
+	case "formats":
+		formats = true
+	case "selftest":
+		selftest = true
+	case "mktest":
+		// flags are also accepted after the output file, i.e. mktest out.cbz --pages 20
+		if len(args) > 1 {
+			_ = cmd.Flags.Parse(args[1:])
+			args = append(args[:1], cmd.Flags.Args()...)
+		}
+
+		if len(args) != 1 {
+			cmd.Flags.Usage()
+			_, _ = fmt.Fprintf(os.Stderr, "%s\n", tr("output file is required"))
+			os.Exit(1)
+		}
+
+		mktest = true
+		opts.Format = mktestFormat
+	case "batchdir":
+		if len(args) != 2 {
+			cmd.Flags.Usage()
+			_, _ = fmt.Fprintf(os.Stderr, "%s\n", tr("input and output directory are required"))
+			os.Exit(batchFatal)
+		}
+
+		batch = true
+		opts.Recursive = true
+		opts.OutDir = args[1]
+		args = args[:1]
 	}
 
-	if len(args) == 0 && !opts.Version {
-		flag.Usage()
-		_, _ = fmt.Fprintf(os.Stderr, "no arguments\n")
+	if len(args) == 0 && !opts.Version && !formats && !selftest {
+		cmd.Flags.Usage()
+		_, _ = fmt.Fprintf(os.Stderr, "%s\n", tr("no arguments"))
 		os.Exit(1)
 	}
 
 	return opts, args
 }
 
+// printFormats prints image formats, archives and documents available in this build.
+func printFormats() {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "NAME\tREAD\tWRITE\tEXTENSIONS\tMODULE\n")
+
+	yesNo := func(b bool) string {
+		if b {
+			return "yes"
+		}
+
+		return "no"
+	}
+
+	codecs := cbconvert.SupportedInputs()
+	for _, c := range cbconvert.SupportedFormats() {
+		if c.Name == "auto" {
+			codecs = append(codecs, c)
+		}
+	}
+
+	for _, c := range codecs {
+		module := strings.TrimSpace(c.Module + " " + c.Version)
+		if module == "" {
+			module = "-"
+		}
+
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", c.Name, yesNo(c.Decode), yesNo(c.Encode), strings.Join(c.Extensions, " "), module)
+	}
+
+	_ = w.Flush()
+}
+
+// metaJSON type, output of meta command for a file with --json.
+type metaJSON struct {
+	File string `json:"file"`
+	// Cover name, with --cover
+	Cover *string `json:"cover,omitempty"`
+	// ZIP comment, with --comment
+	Comment *string `json:"comment,omitempty"`
+	// File contents (base64), with --file-get
+	Data []byte `json:"data,omitempty"`
+	// Extracted files, with --file-get-all
+	Files []string `json:"files,omitempty"`
+	// Printed lines, i.e. validation issues or renamed entries
+	Lines []string `json:"lines,omitempty"`
+}
+
+// printMeta prints output of meta command, with --json as single line JSON object.
+func printMeta(opts cbconvert.Options, file cbconvert.File, ret any) error {
+	if jsonOutput {
+		out := metaJSON{File: file.Path}
+
+		switch ret := ret.(type) {
+		case []byte:
+			out.Data = ret
+		case []string:
+			if opts.FileGetAll != "" {
+				out.Files = ret
+			} else {
+				out.Lines = ret
+			}
+		case string:
+			switch {
+			case opts.Cover:
+				out.Cover = &ret
+			case opts.Comment:
+				out.Comment = &ret
+			}
+		}
+
+		return printJSON(out)
+	}
+
+	switch ret := ret.(type) {
+	case []byte:
+		_, _ = os.Stdout.Write(ret)
+	case []string:
+		for _, name := range ret {
+			fmt.Println(name)
+		}
+	default:
+		if opts.Cover || opts.Comment {
+			fmt.Println(ret)
+		}
+	}
+
+	return nil
+}
+
+// infoJSON type, output of info command for a file with --json.
+type infoJSON struct {
+	File string `json:"file"`
+	// Size in bytes
+	Size int64 `json:"size"`
+	// Number of pages
+	Pages int `json:"pages"`
+	// Median page width, with --pages
+	MedianWidth int `json:"median_width,omitempty"`
+	// List of pages, with --pages
+	List []pageJSON `json:"list,omitempty"`
+}
+
+// pageJSON type, page in the info output.
+type pageJSON struct {
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+}
+
+// printJSON prints v as single line JSON.
+func printJSON(v any) error {
+	if err := json.NewEncoder(os.Stdout).Encode(v); err != nil {
+		return fmt.Errorf("printJSON: %w", err)
+	}
+
+	return nil
+}
+
+// printInfo prints number of pages, and optionally list of pages.
+func printInfo(conv *cbconvert.Converter, file cbconvert.File) error {
+	if !infoPages {
+		count, err := conv.PageCount(file.Path, file.Stat)
+		if err != nil {
+			return err
+		}
+
+		if jsonOutput {
+			return printJSON(infoJSON{File: file.Path, Size: file.Stat.Size(), Pages: count})
+		}
+
+		fmt.Printf("%s\t%s\t%d pages\n", file.Path, file.SizeHuman, count)
+
+		return nil
+	}
+
+	pages, err := conv.ListPages(file.Path, file.Stat, true)
+	if err != nil {
+		return err
+	}
+
+	if jsonOutput {
+		out := infoJSON{File: file.Path, Size: file.Stat.Size(), Pages: len(pages), MedianWidth: cbconvert.MedianWidth(pages)}
+		for _, page := range pages {
+			out.List = append(out.List, pageJSON{Name: page.Name, Size: page.Size, Width: page.Width, Height: page.Height})
+		}
+
+		return printJSON(out)
+	}
+
+	fmt.Printf("%s\t%s\t%d pages, median width %d\n", file.Path, file.SizeHuman, len(pages), cbconvert.MedianWidth(pages))
+	for _, page := range pages {
+		fmt.Printf("  %s\t%d\t%dx%d\n", page.Name, page.Size, page.Width, page.Height)
+	}
+
+	return nil
+}
+
+// fileEstimate type.
+type fileEstimate struct {
+	File     cbconvert.File
+	Estimate cbconvert.Estimate
+}
+
+// printEstimates prints table with projected sizes and totals.
+func printEstimates(estimates []fileEstimate) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "FILE\tPAGES\tSAMPLES\tINPUT\tESTIMATE\tRATIO\n")
+
+	var input, output int64
+	for _, e := range estimates {
+		input += e.Estimate.InputSize
+		output += e.Estimate.OutputSize
+
+		fmt.Fprintf(w, "%s\t%d\t%d\t%s\t%s\t%s\n", e.File.Path, e.Estimate.Pages, e.Estimate.Samples,
+			humanize.IBytes(uint64(e.Estimate.InputSize)), humanize.IBytes(uint64(e.Estimate.OutputSize)), percent(e.Estimate.OutputSize, e.Estimate.InputSize))
+	}
+
+	fmt.Fprintf(w, "TOTAL\t\t\t%s\t%s\t%s\n", humanize.IBytes(uint64(input)), humanize.IBytes(uint64(output)), percent(output, input))
+	_ = w.Flush()
+}
+
+// percent returns a as percentage of b.
+func percent(a, b int64) string {
+	if b == 0 {
+		return "-"
+	}
+
+	return fmt.Sprintf("%.0f%%", float64(a)*100/float64(b))
+}
+
+// writeMetrics writes stats in Prometheus text format, file is replaced atomically so collectors never read partial file.
+func writeMetrics(stats cbconvert.Stats, fileName string) error {
+	tmp := fileName + ".tmp"
+
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+
+	if err = stats.WritePrometheus(f); err != nil {
+		_ = f.Close()
+
+		return err
+	}
+
+	if err = f.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, fileName)
+}
+
+// printError prints error if errors are enabled with log level.
+func printError(level cbconvert.LogLevel, err error) {
+	if level >= cbconvert.LogErrors {
+		fmt.Println(err)
+	}
+}
+
 // piped checks if we have a piped stdin.
 func piped() bool {
 	f, err := os.Stdin.Stat()
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/gen2brain/cbconvert"
+)
+
+// selftestEnv is set to the check name when the executable is started for a single check.
+const selftestEnv = "CBCONVERT_SELFTEST"
+
+// runSelfTests runs every check in a separate process, so a crash in a native library is reported as failure.
+// It returns the exit code.
+func runSelfTests() int {
+	exe, err := os.Executable()
+	if err != nil {
+		exe = ""
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "CHECK\tRESULT\tERROR\n")
+
+	failed := 0
+	for _, test := range cbconvert.SelfTests() {
+		var err error
+		if exe != "" {
+			err = selftestProcess(exe, test.Name)
+		} else {
+			err = test.Run()
+		}
+
+		result, msg := "pass", ""
+		if err != nil {
+			failed++
+			result, msg = "FAIL", err.Error()
+		}
+
+		fmt.Fprintf(w, "%s\t%s\t%s\n", test.Name, result, msg)
+	}
+
+	_ = w.Flush()
+
+	if failed > 0 {
+		fmt.Fprintf(os.Stderr, tr("%d check(s) failed")+"\n", failed)
+
+		return 1
+	}
+
+	return 0
+}
+
+// selftestProcess starts the executable for the check, the Go runtime fatal error or the first line of the error output is returned.
+func selftestProcess(exe, name string) error {
+	var stderr bytes.Buffer
+
+	cmd := exec.Command(exe)
+	cmd.Env = append(os.Environ(), selftestEnv+"="+name)
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		lines := strings.Split(strings.TrimSpace(stderr.String()), "\n")
+		for _, line := range lines {
+			if strings.HasPrefix(line, "fatal error:") {
+				return fmt.Errorf("%s", line)
+			}
+		}
+
+		if lines[0] != "" {
+			return fmt.Errorf("%s", lines[0])
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+// runSelfTest runs the check in this process. It returns the exit code.
+func runSelfTest(name string) int {
+	for _, test := range cbconvert.SelfTests() {
+		if test.Name != name {
+			continue
+		}
+
+		if err := test.Run(); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+
+			return 1
+		}
+
+		return 0
+	}
+
+	fmt.Fprintf(os.Stderr, tr("unknown check %q")+"\n", name)
+
+	return 1
+}
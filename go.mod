@@ -14,6 +14,8 @@ require (
 	github.com/gen2brain/jpegxl v0.4.2
 	github.com/gen2brain/webp v0.5.1
 	github.com/jsummers/gobmp v0.0.0-20230614200233-a9de23ed2e25
+	github.com/klauspost/compress v1.17.11
+	github.com/ulikunitz/xz v0.5.12
 	golang.org/x/image v0.21.0
 	golang.org/x/sync v0.8.0
 )
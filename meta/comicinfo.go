@@ -0,0 +1,364 @@
+// Package meta reads, writes and validates ComicRack metadata (ComicInfo.xml). It depends only on the standard library,
+// so metadata tools can use it without the image codecs and document renderers of cbconvert.
+package meta
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"reflect"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// ComicInfo type, ComicRack metadata (ComicInfo.xml).
+type ComicInfo struct {
+	XMLName     xml.Name `xml:"ComicInfo"`
+	Title       string   `xml:"Title,omitempty"`
+	Series      string   `xml:"Series,omitempty"`
+	Number      string   `xml:"Number,omitempty"`
+	Volume      int      `xml:"Volume,omitempty"`
+	Summary     string   `xml:"Summary,omitempty"`
+	Notes       string   `xml:"Notes,omitempty"`
+	Year        int      `xml:"Year,omitempty"`
+	Month       int      `xml:"Month,omitempty"`
+	Day         int      `xml:"Day,omitempty"`
+	Writer      string   `xml:"Writer,omitempty"`
+	Penciller   string   `xml:"Penciller,omitempty"`
+	Inker       string   `xml:"Inker,omitempty"`
+	Colorist    string   `xml:"Colorist,omitempty"`
+	Letterer    string   `xml:"Letterer,omitempty"`
+	CoverArtist string   `xml:"CoverArtist,omitempty"`
+	Editor      string   `xml:"Editor,omitempty"`
+	Publisher   string   `xml:"Publisher,omitempty"`
+	Genre       string   `xml:"Genre,omitempty"`
+	Web         string   `xml:"Web,omitempty"`
+	PageCount   int      `xml:"PageCount,omitempty"`
+	LanguageISO string   `xml:"LanguageISO,omitempty"`
+	Manga       string   `xml:"Manga,omitempty"`
+	Extra       []Any    `xml:",any"`
+	Pages       []Page   `xml:"Pages>Page,omitempty"`
+}
+
+// Page type, page in the ComicInfo page table.
+type Page struct {
+	// Page index in the archive
+	Image int `xml:"Image,attr"`
+	// Page type, i.e. FrontCover, Story
+	Type string `xml:"Type,attr,omitempty"`
+	// Double page spread
+	DoublePage bool `xml:"DoublePage,attr,omitempty"`
+	// Size in bytes
+	ImageSize int64 `xml:"ImageSize,attr,omitempty"`
+	// Bookmark, i.e. chapter title
+	Bookmark string `xml:"Bookmark,attr,omitempty"`
+	// Image width
+	ImageWidth int `xml:"ImageWidth,attr,omitempty"`
+	// Image height
+	ImageHeight int `xml:"ImageHeight,attr,omitempty"`
+}
+
+// Any type, preserves unknown elements.
+type Any struct {
+	XMLName xml.Name
+	Attrs   []xml.Attr `xml:",any,attr"`
+	Inner   string     `xml:",innerxml"`
+}
+
+// reInteger matches the first integer in the value, integers of ComicInfo.xml are parsed leniently.
+var reInteger = regexp.MustCompile(`\d+`)
+
+// pageTable type, the page table element.
+type pageTable struct {
+	XMLName xml.Name `xml:"Pages"`
+	Page    []Page   `xml:"Page"`
+}
+
+// field type, simple element of ComicInfo.
+type field struct {
+	name  string
+	value string
+}
+
+// Parse parses ComicInfo.xml. Integers are parsed leniently, i.e. 2021-03-15 is year 2021 and v02 is volume 2,
+// unknown elements are returned in Extra.
+func Parse(data []byte) (ComicInfo, error) {
+	elements, err := parseElements(data)
+	if err != nil {
+		return ComicInfo{}, fmt.Errorf("Parse: %w", err)
+	}
+
+	info := ComicInfo{XMLName: xml.Name{Local: "ComicInfo"}}
+	v := reflect.ValueOf(&info).Elem()
+
+	for _, e := range elements {
+		if e.name == "Pages" {
+			for _, attrs := range e.pages {
+				var page Page
+				for _, attr := range attrs {
+					setField(reflect.ValueOf(&page).Elem(), attr.Name.Local, attr.Value)
+				}
+
+				info.Pages = append(info.Pages, page)
+			}
+
+			continue
+		}
+
+		if setField(v, e.name, e.value) {
+			continue
+		}
+
+		var extra Any
+		if err := xml.Unmarshal(data[e.start:e.end], &extra); err != nil {
+			return ComicInfo{}, fmt.Errorf("Parse: %s: %w", e.name, err)
+		}
+
+		info.Extra = append(info.Extra, extra)
+	}
+
+	return info, nil
+}
+
+// setField sets the field of v with the xml name, it reports false if there is no such field.
+func setField(v reflect.Value, name, value string) bool {
+	for i := range v.NumField() {
+		if tag, _, _ := strings.Cut(v.Type().Field(i).Tag.Get("xml"), ","); tag != name {
+			continue
+		}
+
+		f := v.Field(i)
+		switch f.Kind() {
+		case reflect.String:
+			f.SetString(value)
+		case reflect.Int, reflect.Int64:
+			n, _ := strconv.ParseInt(reInteger.FindString(value), 10, 64)
+			f.SetInt(n)
+		case reflect.Bool:
+			b, _ := strconv.ParseBool(value)
+			f.SetBool(b)
+		default:
+			return false
+		}
+
+		return true
+	}
+
+	return false
+}
+
+// simpleFields returns simple elements of info in the schema order, value is empty for the omitted elements.
+func simpleFields(info ComicInfo) []field {
+	v := reflect.ValueOf(info)
+
+	fields := make([]field, 0, v.NumField())
+	for i := range v.NumField() {
+		name, _, _ := strings.Cut(v.Type().Field(i).Tag.Get("xml"), ",")
+
+		var value string
+		switch f := v.Field(i); f.Kind() {
+		case reflect.String:
+			value = f.String()
+		case reflect.Int:
+			if f.Int() != 0 {
+				value = strconv.FormatInt(f.Int(), 10)
+			}
+		default:
+			// XMLName, Extra and Pages
+			continue
+		}
+
+		fields = append(fields, field{name, value})
+	}
+
+	return fields
+}
+
+// Marshal returns ComicInfo.xml data with the fields of info, new file is created if data is empty.
+// Existing data is edited in place: changed elements are replaced, removed elements are dropped and new elements
+// are added before the page table. Unknown elements, comments, root attributes and unchanged values
+// (i.e. year 2021-03-15) are kept as they are.
+func Marshal(data []byte, info ComicInfo) ([]byte, error) {
+	if len(bytes.TrimSpace(data)) == 0 {
+		data = []byte(xml.Header + "<ComicInfo>\n</ComicInfo>")
+	}
+
+	old, err := Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("Marshal: %w", err)
+	}
+
+	elements, err := parseElements(data)
+	if err != nil {
+		return nil, fmt.Errorf("Marshal: %w", err)
+	}
+
+	present := func(name string) bool {
+		return slices.ContainsFunc(elements, func(e element) bool {
+			return e.name == name
+		})
+	}
+
+	// separator of the elements, i.e. newline and indentation of the first element
+	sep := "\n  "
+	if len(elements) > 0 {
+		prev := data[:elements[0].start]
+		i := bytes.LastIndexByte(prev, '\n')
+		sep = ""
+		if i >= 0 && len(bytes.TrimSpace(prev[i:])) == 0 {
+			sep = string(prev[i:])
+		}
+	}
+
+	changed := make(map[string]string)
+	var added []string
+
+	oldFields := simpleFields(old)
+	for i, f := range simpleFields(info) {
+		if f.value == oldFields[i].value {
+			continue
+		}
+
+		changed[f.name] = f.value
+		if f.value != "" && !present(f.name) {
+			added = append(added, elementXML(f.name, f.value))
+		}
+	}
+
+	var pages string
+	if !slices.Equal(old.Pages, info.Pages) && len(info.Pages) > 0 {
+		out, err := xml.MarshalIndent(pageTable{Page: info.Pages}, strings.TrimLeft(sep, "\n"), "  ")
+		if err != nil {
+			return nil, fmt.Errorf("Marshal: %w", err)
+		}
+
+		pages = strings.TrimLeft(string(out), " \t")
+	}
+
+	var out bytes.Buffer
+	last := int64(0)
+	done := make(map[string]bool)
+
+	for _, e := range elements {
+		if e.name == "Pages" && len(added) > 0 {
+			out.Write(data[last:e.start])
+			for _, a := range added {
+				out.WriteString(a + sep)
+			}
+
+			last, added = e.start, nil
+		}
+
+		var replacement string
+		if e.name == "Pages" {
+			if slices.Equal(old.Pages, info.Pages) {
+				continue
+			}
+
+			replacement = pages
+		} else {
+			value, ok := changed[e.name]
+			if !ok {
+				continue
+			}
+
+			if value != "" {
+				replacement = elementXML(e.name, value)
+			}
+		}
+
+		// duplicates of the replaced element are removed
+		if done[e.name] {
+			replacement = ""
+		}
+		done[e.name] = true
+
+		chunk := data[last:e.start]
+		if replacement == "" {
+			// the line of the removed element
+			if i := bytes.LastIndexByte(chunk, '\n'); i >= 0 && len(bytes.TrimSpace(chunk[i:])) == 0 {
+				chunk = chunk[:i]
+			}
+		}
+
+		out.Write(chunk)
+		out.WriteString(replacement)
+		last = e.end
+	}
+
+	if pages != "" && !present("Pages") {
+		added = append(added, pages)
+	}
+
+	if len(added) == 0 {
+		out.Write(data[last:])
+
+		return out.Bytes(), nil
+	}
+
+	if len(elements) > 0 {
+		// after the last element
+		end := elements[len(elements)-1].end
+		out.Write(data[last:end])
+		for _, a := range added {
+			out.WriteString(sep + a)
+		}
+		out.Write(data[end:])
+
+		return out.Bytes(), nil
+	}
+
+	// root without elements
+	end, err := rootEnd(data)
+	if err != nil {
+		return nil, fmt.Errorf("Marshal: %w", err)
+	}
+
+	selfClosing := bytes.HasSuffix(data[:end], []byte("/>"))
+	if selfClosing {
+		out.Write(data[:end-2])
+		out.WriteByte('>')
+	} else {
+		out.Write(data[:end])
+	}
+
+	for _, a := range added {
+		out.WriteString(sep + a)
+	}
+
+	switch {
+	case selfClosing:
+		out.WriteString("\n</ComicInfo>")
+	case !bytes.HasPrefix(data[end:], []byte("\n")):
+		out.WriteByte('\n')
+	}
+	out.Write(data[end:])
+
+	return out.Bytes(), nil
+}
+
+// rootEnd returns offset of the end of the root start tag.
+func rootEnd(data []byte) (int64, error) {
+	d := xml.NewDecoder(bytes.NewReader(data))
+
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return 0, fmt.Errorf("rootEnd: %w", err)
+		}
+
+		if _, ok := tok.(xml.StartElement); ok {
+			return d.InputOffset(), nil
+		}
+	}
+}
+
+// elementXML returns the element with escaped value.
+func elementXML(name, value string) string {
+	var buf bytes.Buffer
+	_ = xml.EscapeText(&buf, []byte(value))
+
+	return "<" + name + ">" + buf.String() + "</" + name + ">"
+}
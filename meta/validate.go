@@ -0,0 +1,291 @@
+package meta
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// schemas are elements of the ComicInfo.xml schema versions, each version extends the previous one.
+var schemas = []struct {
+	version  string
+	elements []string
+}{
+	{"1.0", []string{"Title", "Series", "Number", "Count", "Volume", "AlternateSeries", "AlternateNumber", "AlternateCount",
+		"Summary", "Notes", "Year", "Month", "Writer", "Penciller", "Inker", "Colorist", "Letterer", "CoverArtist", "Editor",
+		"Publisher", "Imprint", "Genre", "Web", "PageCount", "LanguageISO", "Format", "BlackAndWhite", "Manga", "Pages"}},
+	{"2.0", []string{"Day", "Characters", "Teams", "Locations", "ScanInformation", "StoryArc", "SeriesGroup", "AgeRating",
+		"CommunityRating", "MainCharacterOrTeam", "Review"}},
+	{"2.1", []string{"Translator", "Tags", "StoryArcNumber", "GTIN"}},
+}
+
+// enums are valid values of the enumerated elements and page attributes.
+var enums = map[string][]string{
+	"BlackAndWhite": {"Unknown", "No", "Yes"},
+	"Manga":         {"Unknown", "No", "Yes", "YesAndRightToLeft"},
+	"AgeRating": {"Unknown", "Adults Only 18+", "Early Childhood", "Everyone", "Everyone 10+", "G", "Kids to Adults", "M",
+		"MA15+", "Mature 17+", "PG", "R18+", "Rating Pending", "Teen", "X18+"},
+	"Type": {"FrontCover", "InnerCover", "Roundup", "Story", "Advertisement", "Editorial", "Letters", "Preview", "BackCover",
+		"Other", "Deleted"},
+}
+
+// ints are elements and page attributes with integer values.
+var ints = []string{"Count", "Volume", "AlternateCount", "Year", "Month", "Day", "PageCount", "Image", "ImageSize",
+	"ImageWidth", "ImageHeight"}
+
+// flags are common non-standard values of Manga and BlackAndWhite, keys are lowercase without separators.
+var flags = map[string]string{
+	"unknown": "Unknown", "no": "No", "n": "No", "false": "No", "0": "No",
+	"yes": "Yes", "y": "Yes", "true": "Yes", "1": "Yes",
+	"yesandrighttoleft": "YesAndRightToLeft", "righttoleft": "YesAndRightToLeft", "rtl": "YesAndRightToLeft", "yesrtl": "YesAndRightToLeft",
+}
+
+// reDate matches dates in the Year element, i.e. 2021-03-15, 2021/03 or 2021-03-15T00:00:00Z.
+var reDate = regexp.MustCompile(`^(\d{4})(?:[-/.](\d{1,2})(?:[-/.](\d{1,2}))?)?(?:[T ].*)?$`)
+
+// element type, top-level element of ComicInfo.xml and its position in the data.
+type element struct {
+	name  string
+	value string
+	// Offsets of the start and the end of the element
+	start, end int64
+	// Attributes of the Page elements, for Pages
+	pages [][]xml.Attr
+}
+
+// parseElements returns top-level elements of ComicInfo.xml.
+func parseElements(data []byte) ([]element, error) {
+	var elements []element
+	var current *element
+
+	d := xml.NewDecoder(bytes.NewReader(data))
+	depth := 0
+	root := false
+
+	for {
+		offset := d.InputOffset()
+
+		tok, err := d.Token()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+
+			return nil, fmt.Errorf("parseElements: %w", err)
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			depth++
+
+			switch depth {
+			case 1:
+				if t.Name.Local != "ComicInfo" {
+					return nil, fmt.Errorf("parseElements: root element is %s, expected ComicInfo", t.Name.Local)
+				}
+
+				root = true
+			case 2:
+				current = &element{name: t.Name.Local, start: offset}
+			case 3:
+				if current.name == "Pages" && t.Name.Local == "Page" {
+					current.pages = append(current.pages, t.Attr)
+				}
+			}
+		case xml.CharData:
+			if depth == 2 {
+				current.value += string(t)
+			}
+		case xml.EndElement:
+			if depth == 2 {
+				current.end = d.InputOffset()
+				current.value = strings.TrimSpace(current.value)
+				elements = append(elements, *current)
+			}
+
+			depth--
+		}
+	}
+
+	if !root {
+		return nil, fmt.Errorf("parseElements: no ComicInfo element")
+	}
+
+	return elements, nil
+}
+
+// schemaVersion returns the schema version that defines the element, or empty string for unknown elements.
+func schemaVersion(name string) string {
+	for _, schema := range schemas {
+		if slices.Contains(schema.elements, name) {
+			return schema.version
+		}
+	}
+
+	return ""
+}
+
+// check checks the value of the element or page attribute, it returns the problem or empty string.
+func check(name, value string) string {
+	if slices.Contains(ints, name) {
+		n, err := strconv.Atoi(value)
+		switch {
+		case err != nil:
+			return fmt.Sprintf("%s: invalid integer %q", name, value)
+		case name == "Month" && (n < 1 || n > 12), name == "Day" && (n < 1 || n > 31):
+			return fmt.Sprintf("%s: %d is out of range", name, n)
+		}
+	}
+
+	if values, ok := enums[name]; ok && !slices.Contains(values, value) {
+		return fmt.Sprintf("%s: invalid value %q", name, value)
+	}
+
+	if name == "CommunityRating" {
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil || f < 0 || f > 5 {
+			return fmt.Sprintf("%s: invalid rating %q, expected 0 to 5", name, value)
+		}
+	}
+
+	return ""
+}
+
+// fixElement returns elements that replace the element with a fixed value, or nil if it can not be fixed.
+// Dates in the Year element are split into Year, Month and Day, if they are not set.
+func fixElement(e element, elements []element) map[string]string {
+	has := func(name string) bool {
+		return slices.ContainsFunc(elements, func(e element) bool {
+			return e.name == name
+		})
+	}
+
+	switch e.name {
+	case "Manga", "BlackAndWhite":
+		key := strings.ToLower(strings.NewReplacer(" ", "", "-", "", "_", "").Replace(e.value))
+		if value, ok := flags[key]; ok && slices.Contains(enums[e.name], value) {
+			return map[string]string{e.name: value}
+		}
+	case "Year":
+		m := reDate.FindStringSubmatch(e.value)
+		if m == nil {
+			break
+		}
+
+		fixed := map[string]string{"Year": m[1]}
+		if month, _ := strconv.Atoi(m[2]); month >= 1 && month <= 12 && !has("Month") {
+			fixed["Month"] = strconv.Itoa(month)
+		}
+		if day, _ := strconv.Atoi(m[3]); day >= 1 && day <= 31 && !has("Day") {
+			fixed["Day"] = strconv.Itoa(day)
+		}
+
+		return fixed
+	case "Month":
+		for m := time.January; m <= time.December; m++ {
+			if strings.EqualFold(e.value, m.String()) || strings.EqualFold(e.value, m.String()[:3]) {
+				return map[string]string{"Month": strconv.Itoa(int(m))}
+			}
+		}
+	case "Count", "Volume", "AlternateCount", "Day", "PageCount":
+		// leading zeros and plus sign are valid, but i.e. "v02" or "#3" are not
+		value := strings.TrimLeft(strings.TrimSpace(e.value), "vV#")
+		if n, err := strconv.Atoi(value); err == nil && check(e.name, strconv.Itoa(n)) == "" {
+			return map[string]string{e.name: strconv.Itoa(n)}
+		}
+	}
+
+	return nil
+}
+
+// Validate checks ComicInfo.xml against the known schema versions. It returns the problems, the schema version
+// of the used elements and, with fix, the data where problems that can be fixed are fixed. Unknown elements are kept.
+func Validate(data []byte, fix bool) ([]string, string, []byte, error) {
+	elements, err := parseElements(data)
+	if err != nil {
+		return nil, "", nil, fmt.Errorf("Validate: %w", err)
+	}
+
+	var issues []string
+	var out bytes.Buffer
+	version := schemas[0].version
+	last := int64(0)
+
+	for _, e := range elements {
+		v := schemaVersion(e.name)
+		if v == "" {
+			issues = append(issues, fmt.Sprintf("unknown element %s", e.name))
+
+			continue
+		}
+
+		if v > version {
+			version = v
+		}
+
+		if e.name == "Pages" {
+			for idx, attrs := range e.pages {
+				for _, attr := range attrs {
+					if issue := check(attr.Name.Local, attr.Value); issue != "" {
+						issues = append(issues, fmt.Sprintf("Page %d: %s", idx, issue))
+					}
+				}
+			}
+
+			continue
+		}
+
+		issue := check(e.name, e.value)
+		if issue == "" {
+			continue
+		}
+
+		fixed := fixElement(e, elements)
+		if !fix || fixed == nil {
+			issues = append(issues, issue)
+
+			continue
+		}
+
+		// the element is replaced, other elements are indented as the replaced one
+		indent := data[bytes.LastIndexByte(data[:e.start], '\n')+1 : e.start]
+		if len(bytes.TrimSpace(indent)) != 0 {
+			indent = nil
+		}
+
+		out.Write(data[last:e.start])
+		for i, name := range []string{e.name, "Month", "Day"} {
+			value, ok := fixed[name]
+			if !ok || (i > 0 && name == e.name) {
+				continue
+			}
+
+			if i > 0 {
+				out.WriteByte('\n')
+				out.Write(indent)
+			}
+
+			out.WriteString("<" + name + ">")
+			_ = xml.EscapeText(&out, []byte(value))
+			out.WriteString("</" + name + ">")
+		}
+
+		last = e.end
+		issues = append(issues, fmt.Sprintf("fixed %s", issue))
+	}
+
+	if !fix {
+		return issues, version, nil, nil
+	}
+
+	out.Write(data[last:])
+
+	return issues, version, out.Bytes(), nil
+}